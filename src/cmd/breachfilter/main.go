@@ -0,0 +1,90 @@
+// Command breachfilter builds the breached passwords filter that is loaded from BREACHED_PASSWORDS_FILTER.
+//
+// The input is the Pwned Passwords SHA-1 list, one "HASH:COUNT" per line, or a plain password list with -plain:
+//
+//	go run ./cmd/breachfilter -input pwned-passwords-sha1-ordered-by-count.txt -output breached.bloom -min-count 10
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"persephone/pkg/core"
+	"strconv"
+	"strings"
+)
+
+var input = flag.String("input", "", "breached password list")
+var output = flag.String("output", "breached.bloom", "location of the filter to write")
+var plain = flag.Bool("plain", false, "input contains plain passwords instead of SHA-1 hashes")
+var minCount = flag.Uint64("min-count", 0, "skip the hashes seen less than min-count times, ignored with -plain")
+var fpRate = flag.Float64("fp-rate", 0.001, "false positive rate of the filter")
+
+// readDigests calls fn with the digest of every accepted line of the input.
+func readDigests(path string, fn func(digest [sha1.Size]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if *plain {
+			fn(sha1.Sum([]byte(text)))
+			continue
+		}
+		hash, count, _ := strings.Cut(text, ":")
+		if *minCount > 0 && count != "" {
+			n, err := strconv.ParseUint(count, 10, 64)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid count %q", line, count)
+			}
+			if n < *minCount {
+				continue
+			}
+		}
+		var digest [sha1.Size]byte
+		if n, err := hex.Decode(digest[:], []byte(hash)); err != nil || n != sha1.Size {
+			return fmt.Errorf("line %d: invalid SHA-1 hash %q", line, hash)
+		}
+		fn(digest)
+	}
+	return scanner.Err()
+}
+
+func main() {
+	flag.Parse()
+	if *input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// count first, the filter is sized for the accepted lines.
+	var count uint64
+	if err := readDigests(*input, func([sha1.Size]byte) { count++ }); err != nil {
+		log.Fatal(err)
+	}
+	filter := core.NewBloomFilter(count, *fpRate)
+	if err := readDigests(*input, filter.Add); err != nil {
+		log.Fatal(err)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	size, err := filter.WriteTo(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote %d passwords to %s (%d bytes).\n", count, *output, size)
+}
@@ -16,22 +16,58 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/user/delete": {
-            "delete": {
-                "description": "Deletes a user based on the provided JWT token.\nBearer {JWT} | Whitelist: Anyone that already logged in once.",
+        "/api/admin/audit": {
+            "get": {
+                "description": "Lists the audit records of the admins, newest first, filtered by the admin and the user the action is on. Viewing the log is recorded in it too.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "User"
+                    "Admin"
+                ],
+                "summary": "Get Audit Log",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the admin",
+                        "name": "actorId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the user the action is on",
+                        "name": "userId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Count of records, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Delete User",
                 "responses": {
                     "200": {
-                        "description": "User successfully deleted.",
+                        "description": "Audit records",
                         "schema": {
-                            "$ref": "#/definitions/core.UserDeleteResponse"
+                            "$ref": "#/definitions/core.AuditLogResponse"
                         }
                     },
                     "400": {
-                        "description": "Bad request, may occur if the JWT token is invalid or expired",
+                        "description": "Invalid filter, cursor or limit",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
@@ -45,39 +81,142 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/login": {
-            "post": {
-                "description": "Handles the HTTP request for user login.\nBearer {JWT} | Whitelist: None. Body is not required if Authorization header is set.",
-                "consumes": [
-                    "application/json"
-                ],
+        "/api/admin/users": {
+            "get": {
+                "description": "Lists the users matching the filters, newest first. Email and username match a part of the value case-insensitively, banned matches the active bans only. The search is recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Admin"
                 ],
-                "summary": "Handle user login",
+                "summary": "Search Users",
                 "parameters": [
                     {
-                        "description": "Login form data",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Part of the email",
+                        "name": "email",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Part of the username",
+                        "name": "username",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Role",
+                        "name": "role",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Banned",
+                        "name": "banned",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Verified",
+                        "name": "verified",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Flagged as a possible spammer",
+                        "name": "spammer",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signed up at or after, RFC 3339",
+                        "name": "signedUpAfter",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signed up before, RFC 3339",
+                        "name": "signedUpBefore",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Count of users, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Users",
                         "schema": {
-                            "$ref": "#/definitions/core.UserLoginRequest"
+                            "$ref": "#/definitions/core.AdminUsersResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter, cursor or limit",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
                         }
                     }
+                }
+            }
+        },
+        "/api/admin/users/{id}": {
+            "get": {
+                "description": "Returns the user with the count of the active sessions, the reports received and filed, the ban history and the latest spam checks. The view is recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get User",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successful login",
+                        "description": "User",
                         "schema": {
-                            "$ref": "#/definitions/core.UserLoginResponse"
+                            "$ref": "#/definitions/core.AdminUserDetailResponse"
                         }
                     },
-                    "400": {
-                        "description": "Bad request or unauthorized",
+                    "404": {
+                        "description": "User does not exist",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
@@ -91,9 +230,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/signup": {
+        "/api/admin/users/{id}/impersonate": {
             "post": {
-                "description": "Handles the HTTP request for user signup.\nBearer {JWT} | Whitelist: None.",
+                "description": "Issues a token of the user that expires in 15 minutes and can not be refreshed. The token names the admin in the act claim. It can not change the password, the email, the username, the phone number, the 2FA, the identities, the API keys or the sessions, and can not delete the user. Admins can not impersonate themselves or other admins. The token has no session, so the logout does not end it. It is revoked once the admin is not an admin anymore, or by the forced logout of the user. Issuing the token and every request made with it are recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users, not impersonation tokens.",
                 "consumes": [
                     "application/json"
                 ],
@@ -101,29 +240,55 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "User"
+                    "Admin"
                 ],
-                "summary": "Handle user signup",
+                "summary": "Impersonate User",
                 "parameters": [
                     {
-                        "description": "Signup form data",
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reason",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/core.UserSignupRequest"
+                            "$ref": "#/definitions/core.AdminImpersonateRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successful signup",
+                        "description": "Impersonation token",
                         "schema": {
-                            "$ref": "#/definitions/core.GetUserDataResponse"
+                            "$ref": "#/definitions/core.AdminImpersonateResponse"
                         }
                     },
                     "400": {
-                        "description": "Bad request or user already exists",
+                        "description": "Reason is missing",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "User is the admin, or another admin",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
@@ -137,13 +302,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/update": {
+        "/api/admin/users/{id}/logout": {
             "post": {
-                "description": "Handles the request to update a user's email or username.\nBearer {JWT} | Whitelist: Anyone that already logged in once.",
+                "description": "Revokes every session, token, refresh token and API key of the user. The logout is recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "User"
+                    "Admin"
                 ],
-                "summary": "Update User",
+                "summary": "Force Logout",
                 "parameters": [
                     {
                         "type": "string",
@@ -153,30 +321,70 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "User update data",
-                        "name": "userUpdateRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/core.UserUpdateRequest"
-                        }
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Updated user data",
+                        "description": "User",
                         "schema": {
-                            "$ref": "#/definitions/core.UserUpdateResponse"
+                            "$ref": "#/definitions/core.AdminUser"
                         }
                     },
-                    "400": {
-                        "description": "Bad request, may occur if the request is invalid, or user cant update username or email for now",
+                    "404": {
+                        "description": "User does not exist",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
                     },
-                    "401": {
-                        "description": "Unauthorized, may occur if the JWT token is invalid or expired",
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/admin/users/{id}/password-reset": {
+            "post": {
+                "description": "Signs the user out of every session, revokes the API keys and emails a password reset link. The user can not log in with the password until it is reset with the link, logins with the identity providers still work. The reset is recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Force Password Reset",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User",
+                        "schema": {
+                            "$ref": "#/definitions/core.AdminUser"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
@@ -190,9 +398,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/world/getCountries": {
-            "post": {
-                "description": "Returns a paginated list of countries.",
+        "/api/admin/users/{id}/role": {
+            "put": {
+                "description": "Changes the role of the user and signs the user out of every session, so the tokens with the old role are rejected. Admins can not change their own role. ADMIN and MODERATOR users get their role in the tokens only after enabling 2FA. The change is recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.",
                 "consumes": [
                     "application/json"
                 ],
@@ -200,24 +408,61 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "World Data"
+                    "Admin"
+                ],
+                "summary": "Change Role",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Role",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.AdminUserRoleRequest"
+                        }
+                    }
                 ],
-                "summary": "Get Countries",
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "User after the change",
                         "schema": {
-                            "$ref": "#/definitions/core.GetCountriesResponse"
+                            "$ref": "#/definitions/core.AdminUser"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Invalid role",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Own role can not be changed",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
                     },
                     "500": {
-                        "description": "Internal Server Error",
+                        "description": "Internal server error",
                         "schema": {
                             "$ref": "#/definitions/core.ErrorResponse"
                         }
@@ -225,9 +470,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/world/getStates": {
-            "post": {
-                "description": "Get states",
+        "/api/moderation/reports/{id}": {
+            "put": {
+                "description": "Changes the status of a report. Confirming a report awards 5 points to the reporter and deducts 25 points from the reported user, or the author of the reported review or reply. Changing the status of a confirmed report takes them back.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
                 "consumes": [
                     "application/json"
                 ],
@@ -235,146 +480,4782 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "World Data"
+                    "Moderation"
                 ],
-                "summary": "Get states",
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                "summary": "Set Report Status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Report ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New status",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorReportStatusRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Report",
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorReportStatusResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid status",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Report does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/moderation/reviews/{id}/remove": {
+            "post": {
+                "description": "Deletes the review with its replies, and deducts 20 points from the author. The points the votes of the review earned are taken back by the periodic reputation sync.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Remove Review",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Review ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reason",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorReviewRemoveRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Reputation of the author",
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorReputationResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Review does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/moderation/spam": {
+            "get": {
+                "description": "Returns the 100 flagged users with the highest spam scores, for the moderators to review.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Get Spam Queue",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Flagged users",
+                        "schema": {
+                            "$ref": "#/definitions/core.SpamQueueResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/moderation/users/{id}/bans": {
+            "get": {
+                "description": "Returns the bans and the suspensions of the user, the latest first.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Get Ban History",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Ban history of the user",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserBansResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Bans the user permanently, or suspends the user until expiresAt. The active ban of the user is replaced. Banned users can not log in, and their tokens and API keys are rejected with the USER_BANNED or USER_SUSPENDED error code. Admins can not be banned, moderators only by admins. The bans issued by admins are recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Ban User",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reason and end of the suspension",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorBanRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Issued ban",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserBan"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request, or expiresAt is in the past",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "User can not be banned by the moderator",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/moderation/users/{id}/bans/lift": {
+            "post": {
+                "description": "Lifts the active ban or suspension of the user, it is kept in the history with the reason. Same as the bans, only admins lift the bans of the moderators. The lifts by admins are recorded in the audit log.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Lift Ban",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Reason",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorBanLiftRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Ban history of the user",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserBansResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Ban of the user can not be lifted by the moderator",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist or is not banned",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/moderation/users/{id}/reputation": {
+            "post": {
+                "description": "Adds the points to the reputation of the user, or deducts them if negative. The adjustment is recorded in the ledger with the reason and the moderator.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Adjust Reputation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Points and reason",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorReputationAdjustRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "New reputation",
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorReputationResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/moderation/users/{id}/spam": {
+            "get": {
+                "description": "Returns the spam score of the user, the reasons, the override of the moderators and the latest 50 checks.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Get Spam Score",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Spam score",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserSpamResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Sets the possible_spammer flag of the user regardless of the score, or returns it to the automatic scoring if possibleSpammer is null.\nBearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Moderation"
+                ],
+                "summary": "Override Spam Flag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Flag",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.ModeratorSpamOverrideRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Spam score",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserSpamResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/notifications": {
+            "get": {
+                "description": "Lists the notifications of the user, latest first, with the count of the unread notifications in total and by type. Pass nextCursor as cursor to get the next page.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notification"
+                ],
+                "summary": "List notifications",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only the unread notifications",
+                        "name": "unread",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Notifications",
+                        "schema": {
+                            "$ref": "#/definitions/core.NotificationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or limit",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/notifications/preferences": {
+            "get": {
+                "description": "Lists the types of the notifications the user turned off.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notification"
+                ],
+                "summary": "Get notification preferences",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Preferences",
+                        "schema": {
+                            "$ref": "#/definitions/core.NotificationPreferencesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replaces the types of the notifications the user turned off. Send an empty list to receive every notification.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notification"
+                ],
+                "summary": "Update notification preferences",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Disabled types",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.NotificationPreferencesRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Preferences",
+                        "schema": {
+                            "$ref": "#/definitions/core.NotificationPreferencesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown type",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/notifications/read": {
+            "post": {
+                "description": "Marks the notifications with the ids read, at most 100, or every notification if all is set. Returns the unread counts.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Notification"
+                ],
+                "summary": "Mark notifications read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Notifications",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.NotificationReadRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Unread counts",
+                        "schema": {
+                            "$ref": "#/definitions/core.NotificationUnreadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/notifications/stream": {
+            "get": {
+                "description": "Streams the new notifications of the user as Server-Sent Events. The stream starts with an unread event with the unread counts, then sends a notification event for every new notification. Notifications created while the client reconnects are not replayed, list them with GET /api/notifications. The stream is closed when the token expires or is revoked, reconnect with a new token.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Notification"
+                ],
+                "summary": "Stream notifications",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Notification events",
+                        "schema": {
+                            "$ref": "#/definitions/core.Notification"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/places/{id}": {
+            "patch": {
+                "description": "Edits the name, cuisine, opening hours, phone and website of a place with a merge patch. Requires the edit_places reputation privilege, moderators, admins and editors can always edit.\nBearer {JWT} | Whitelist: ACTIVE tokens with the edit_places privilege.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Place"
+                ],
+                "summary": "Edit place",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Place id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Merge patch of the place",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.PlacePatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Updated place",
+                        "schema": {
+                            "$ref": "#/definitions/core.Place"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid value",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Reputation does not unlock the privilege",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Place does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Content type is not a merge patch",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user": {
+            "patch": {
+                "description": "Updates the email, username, phone number, display name, bio and location of the user with a JSON Merge Patch (RFC 7396). Absent members are not changed, only the changed columns are written. Email and username have cooldowns, a new email is held as pending until the link sent to it is confirmed, changing the phone number requires verifying it again.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/merge-patch+json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Patch User",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Merge patch of the user",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Updated user data",
+                        "schema": {
+                            "$ref": "#/definitions/core.GetUserDataResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid value, inconsistent location, taken email, username or phone number, or cooldown",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Email, username or phone number change with an impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Content type is not a merge patch",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "An email change is just requested",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/2fa/confirm": {
+            "post": {
+                "description": "Verifies a code for the enrolled TOTP secret and enables 2FA. Returns the recovery codes once. All other sessions are ended, and new session and refresh tokens are returned.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Confirm 2FA",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Code from the authenticator app",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "2FA is enabled",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorConfirmResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "2FA is already enabled or not enrolled",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid code",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many wrong codes",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/2fa/disable": {
+            "post": {
+                "description": "Disables 2FA after verifying the password and a TOTP or recovery code. Not allowed for ADMIN and MODERATOR roles.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Disable 2FA",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Password and code",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorDisableRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "2FA is disabled",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorDisableResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "2FA is not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong password or invalid code",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "2FA is required for the role of the user",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many wrong codes",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/2fa/enroll": {
+            "post": {
+                "description": "Creates a new TOTP secret and returns its otpauth URI. 2FA is enabled after a code from the authenticator app is confirmed at /api/user/2fa/confirm.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Enroll 2FA",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "New TOTP secret",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorEnrollResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "2FA is already enabled",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/2fa/recovery-codes": {
+            "post": {
+                "description": "Verifies a TOTP code, deletes the old recovery codes and returns new ones.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Regenerate recovery codes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Code from the authenticator app",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorCodeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "New recovery codes",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserTwoFactorRecoveryCodesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "2FA is not enabled",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid code",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many wrong codes",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/api-keys": {
+            "get": {
+                "description": "Lists the API keys of the logged-in user with their usage counters and last used timestamps. Keys themselves are not returned.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List API keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "API keys",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserAPIKeysResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Creates an API key with the given scopes and expiry. The key is returned only once, send it as \"Authorization: ApiKey {key}\" to the routes that accept the scope. Keys act with the role of their owner.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Create API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Label, scopes and expiry of the key",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserAPIKeyCreateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created key",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserAPIKeyCreateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown scope, invalid expiry, or too many keys",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/api-keys/{id}": {
+            "delete": {
+                "description": "Revokes the API key, revoked keys stay in the listing with their usage counters.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Revoke API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "API key id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Key is revoked",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserAPIKeyRevokeResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Key does not exist or is already revoked",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/avatar": {
+            "put": {
+                "description": "Uploads a JPEG, PNG or WebP avatar of at most 5 MB as the avatar field of a multipart form. The type is detected from the content, EXIF metadata is stripped and square thumbnails of 64, 256 and 512 pixels are stored. The previous avatar is removed.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Upload Avatar",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "JPEG, PNG or WebP image",
+                        "name": "avatar",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "URLs of the thumbnails",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserAvatarResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing, corrupt or too large image",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "File is larger than 5 MB",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Image is not JPEG, PNG or WebP",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes the avatar of the user and its thumbnails.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Delete Avatar",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Avatar is null",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserAvatarResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/delete": {
+            "delete": {
+                "description": "Deletes a user based on the provided JWT token. Logging in within the grace period restores the account.\nBearer {JWT} | Whitelist: Anyone that already logged in once.",
+                "tags": [
+                    "User"
+                ],
+                "summary": "Delete User",
+                "responses": {
+                    "200": {
+                        "description": "User successfully deleted.",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserDeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, may occur if the JWT token is invalid or expired",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/dietary-profile": {
+            "get": {
+                "description": "Returns the diet restrictions, favourite cuisines, allergens, preferred language and distance unit of the user.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Get dietary profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dietary profile",
+                        "schema": {
+                            "$ref": "#/definitions/core.DietaryProfile"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replaces the dietary profile of the user. Favourite cuisines are values of the OSM cuisine tag, they are stored in lower case with underscores. The recommended places serve the diet restrictions, and avoid the gluten and milk allergens; OSM has no tags for the other allergens.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Update dietary profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Dietary profile",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.DietaryProfile"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Dietary profile",
+                        "schema": {
+                            "$ref": "#/definitions/core.DietaryProfile"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid profile",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/email/confirm": {
+            "post": {
+                "description": "Changes the email of the user to the new email of a pending change, with the token in the link sent to the new email. The link is valid for 24 hours, only the latest requested change can be confirmed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Confirm email change",
+                "parameters": [
+                    {
+                        "description": "Confirm token",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserEmailChangeTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Email is changed",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserEmailChangeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Token is invalid or expired, or the new email is taken",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/email/revert": {
+            "post": {
+                "description": "Keeps or restores the old email of an email change with the token in the link sent to the old email. The pending changes are cancelled, a confirmed change is undone, and every session of the user is revoked. The link is valid for 7 days.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Revert email change",
+                "parameters": [
+                    {
+                        "description": "Revert token",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserEmailChangeTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Email is reverted",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserEmailChangeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Token is invalid or expired, or the old email is taken",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/export": {
+            "get": {
+                "description": "Lists the data exports of the user with their status, the latest first.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List data exports",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Data exports",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserDataExportsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Enqueues an export of the personal data of the user. The archive is built in the background, the download link is emailed and expires in 48 hours. One export can be requested every 24 hours.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Request data export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Export is enqueued",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserDataExport"
+                        }
+                    },
+                    "429": {
+                        "description": "An export is already requested in the last 24 hours",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/export/download": {
+            "get": {
+                "description": "Downloads the archive of a data export with the token in the emailed link.",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Download data export",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Download token",
+                        "name": "token",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ZIP archive",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "Token is invalid or expired",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/feed": {
+            "get": {
+                "description": "Returns the latest reviews and replies of the users the user follows, newest first. Pass nextCursor as cursor to get the next page.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Get feed",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Feed",
+                        "schema": {
+                            "$ref": "#/definitions/core.FeedResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or limit",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/login": {
+            "post": {
+                "description": "Handles the HTTP request for user login. With session=cookie the session token is set in an HttpOnly cookie with a CSRF cookie instead of the body, the next requests are authenticated with the cookie and the unsafe ones must send the CSRF cookie in the X-CSRF-Token header. Logging in with the cookie and no body refreshes the cookies.\nBearer {JWT} or the session cookie | Whitelist: None. Body is not required if Authorization header or the session cookie is set.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Handle user login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "cookie to use the cookie session mode",
+                        "name": "session",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Login form data",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful login",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Password is correct, user has 2FA enabled. Exchange the login token at /api/user/login/2fa",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginTwoFactorResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request or unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong password, or the token is revoked or does not belong to a session",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "User is banned, or an admin requires a password reset",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/login/2fa": {
+            "post": {
+                "description": "Second step of the login for users with 2FA enabled. Verifies the TOTP code or a recovery code, and returns the user data with an active session token.\nBearer {JWT} | Whitelist: WAITING_LOGIN token returned by /api/user/login.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Login with 2FA",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Login token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "cookie to use the cookie session mode, see /api/user/login",
+                        "name": "session",
+                        "in": "query"
+                    },
+                    {
+                        "description": "TOTP code or recovery code",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginTwoFactorRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful login",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, or the token is not a login token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid code or revoked token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many wrong codes",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/logout": {
+            "post": {
+                "description": "Revokes the session of the token and removes the session cookies, the cookies are removed even if the token is not valid anymore. The web apps in the cookie session mode must log out with this endpoint, they can not remove the HttpOnly session cookie themselves. Tokens without a session, such as the signup and the impersonation tokens, are not revoked, they expire on their own.\nBearer {JWT} or the session cookie with the X-CSRF-Token header | Whitelist: None.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Logout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "CSRF token, required with the session cookie",
+                        "name": "X-CSRF-Token",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Logged out",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLogoutResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "CSRF token does not match",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/oidc": {
+            "get": {
+                "description": "Lists the external identity providers linked to the logged-in user.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List linked identities",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Linked identities",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserIdentitiesResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/oidc/{provider}": {
+            "delete": {
+                "description": "Unlinks the external identity of the provider from the logged-in user.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Unlink identity",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Identity is unlinked",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserIdentityUnlinkResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Identity of the provider is not linked",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/oidc/{provider}/callback": {
+            "get": {
+                "description": "Requires the persephone_oidc cookie set when the flow started. Exchanges the authorization code, verifies the ID token, and either logs the user in (same response as /api/user/login) or links the identity to the user that started the linking.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "OIDC callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Authorization code",
+                        "name": "code",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "State",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful login",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Identity is linked",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserIdentitiesResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "User has 2FA enabled, exchange the login token at /api/user/login/2fa",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserLoginTwoFactorResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid or expired state, the flow is started in another browser, or the provider returned an error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid ID token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown provider, or the identity is not linked to any account",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Identity is linked to another account",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Identity provider is not reachable",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/oidc/{provider}/link": {
+            "post": {
+                "description": "Returns the authorization URL of the identity provider, and sets the persephone_oidc cookie that binds the linking to the browser. The identity returned to the callback is linked to the logged-in user.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Start OIDC account linking",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Authorization URL",
+                        "schema": {
+                            "$ref": "#/definitions/core.OIDCAuthorizationResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown provider",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Identity provider is not reachable",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/oidc/{provider}/login": {
+            "get": {
+                "description": "Returns the authorization URL of the identity provider, and sets the persephone_oidc cookie that binds the login to the browser. After the user authorizes, the provider redirects to /api/user/oidc/{provider}/callback. Only identities linked to an account can log in.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Start OIDC login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Provider name",
+                        "name": "provider",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Authorization URL",
+                        "schema": {
+                            "$ref": "#/definitions/core.OIDCAuthorizationResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown provider",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Identity provider is not reachable",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/password": {
+            "post": {
+                "description": "Changes the password of the user. Requires the current password, and the new password must not be one of the last used passwords. Returns a new session token and refresh token, all other sessions are ended.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Change password",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Current and new password",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPasswordChangeRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Password changed, contains the new tokens",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPasswordChangeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, new password is invalid, breached or reused",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Current password is wrong",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/password/forgot": {
+            "post": {
+                "description": "Sends a password reset link to the email if a user with the email exists. Response is the same whether the email exists or not, the link is sent in background. A new link is sent a minute after the last one at the earliest. Limited to 10 requests an hour per IP, per /64 for IPv6.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Request password reset",
+                "parameters": [
+                    {
+                        "description": "Email of the account",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPasswordForgotRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Reset link is sent if the email exists",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPasswordForgotResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many requests",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/password/reset": {
+            "post": {
+                "description": "Sets a new password with the token sent by /api/user/password/forgot. Tokens are single use, and all existing session and refresh tokens are revoked.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Reset password",
+                "parameters": [
+                    {
+                        "description": "Reset token and the new password",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPasswordResetRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Password is reset",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPasswordResetResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, invalid or breached password, or invalid/expired token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/phone/verify": {
+            "post": {
+                "description": "Verifies the phone number of the logged-in user with the last code sent to it. A code is burned after 5 wrong guesses. Phone verification is tracked separately from the email verification.\nBearer {JWT} | Whitelist: Anyone that already logged in once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Verify phone number",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Code sent to the phone number",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPhoneVerificationRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Phone number is verified",
+                        "schema": {
+                            "$ref": "#/definitions/core.GetUserDataResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid or expired code, or the number is verified by another user",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many wrong codes, request a new one",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/phone/verify/send": {
+            "post": {
+                "description": "Sends a one-time code to the phone number of the logged-in user. Codes expire in 10 minutes, a new code can be requested after a minute, and at most 5 codes are sent to a user or a phone number in an hour. Requesting a new code invalidates the previous one.\nBearer {JWT} | Whitelist: Anyone that already logged in once.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Send phone verification code",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Code is sent",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserPhoneVerificationSendResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Phone number is already verified, or the user has none",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many codes are requested",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "SMS could not be sent",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/places/recommended": {
+            "get": {
+                "description": "Lists the places around the location that serve the diet restrictions of the user and avoid the gluten and milk allergens, from their OSM diet tags. Places of the favourite cuisines come first, then the closest. The location is the city of the user if lat and lon are not set. The distances are in the distance unit of the user.\nBearer {JWT} or ApiKey {key} with the places:read scope | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Recommend places",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token or API key",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Latitude",
+                        "name": "lat",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Longitude",
+                        "name": "lon",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Radius in kilometres, 5 by default, at most 50",
+                        "name": "radius",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Text in the name of the place",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Count of places, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Recommended places",
+                        "schema": {
+                            "$ref": "#/definitions/core.PlaceRecommendationsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid location, radius or limit",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/profile/privacy": {
+            "get": {
+                "description": "Lists the fields the user hid from the public profile.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Get profile privacy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Privacy settings",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserProfilePrivacyResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replaces the fields the user hid from the public profile. Send an empty list to make the whole profile public.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Update profile privacy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "Hidden fields",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserProfilePrivacyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Privacy settings",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserProfilePrivacyResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown field",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/reputation": {
+            "get": {
+                "description": "Returns the reputation of the user, the privileges it unlocks, the badges and the latest 50 events of the ledger. Points of the votes are added periodically.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Get Reputation",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Reputation of the user",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserReputationResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/sessions": {
+            "get": {
+                "description": "Lists the devices the logged-in user has an active session on, most recently seen first.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List sessions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Active sessions",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserSessionsResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/sessions/{id}": {
+            "delete": {
+                "description": "Logs the device of the session out. Revoking the current session logs the caller out.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Revoke session",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Session id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Session is revoked",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserSessionRevokeResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Session does not exist or is already revoked",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/signup": {
+            "post": {
+                "description": "Handles the HTTP request for user signup.\nBearer {JWT} | Whitelist: None.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Handle user signup",
+                "parameters": [
+                    {
+                        "description": "Signup form data",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserSignupRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successful signup",
+                        "schema": {
+                            "$ref": "#/definitions/core.GetUserDataResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request or user already exists",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/update": {
+            "post": {
+                "description": "Handles the request to update a user's email or username. A new email is held as pending until the link sent to it is confirmed, the old email gets a link to revert the change.\nBearer {JWT} | Whitelist: Anyone that already logged in once.",
+                "tags": [
+                    "User"
+                ],
+                "summary": "Update User",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "User update data",
+                        "name": "userUpdateRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/core.UserUpdateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Updated user data",
+                        "schema": {
+                            "$ref": "#/definitions/core.UserUpdateResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request, may occur if the request is invalid, or user cant update username or email for now",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized, may occur if the JWT token is invalid or expired",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Impersonation token",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "An email change is just requested",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/username-available": {
+            "get": {
+                "description": "Checks the username with the signup rules before the form is submitted: letters and digits only, 5 to 24 characters, not reserved, and not used by another user in any case. Returns up to 5 available suggestions if it can not be used. Limited to 30 checks a minute per IP, per /64 for IPv6.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Check username availability",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "u",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Availability of the username",
+                        "schema": {
+                            "$ref": "#/definitions/core.UsernameAvailabilityResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Username is missing",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many checks",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/users/{username}": {
+            "get": {
+                "description": "Returns the public projection of the user, without the fields the user hid. Private fields such as the email and the phone number are never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Get public profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Public profile",
+                        "schema": {
+                            "$ref": "#/definitions/core.PublicUserProfile"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/users/{username}/follow": {
+            "put": {
+                "description": "Follows the user, the reviews and replies of the user are added to the feed. Following a user twice is a no-op.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Follow User",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Followed",
+                        "schema": {
+                            "$ref": "#/definitions/core.FollowResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Users cannot follow themselves",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Unfollows the user, the reviews and replies of the user are removed from the feed. Unfollowing a user that is not followed is a no-op.\nBearer {JWT} | Whitelist: ACTIVE tokens.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "Unfollow User",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "JWT token",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Unfollowed",
+                        "schema": {
+                            "$ref": "#/definitions/core.FollowResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/users/{username}/followers": {
+            "get": {
+                "description": "Lists the followers of the user, latest first, with the count of followers. Pass nextCursor as cursor to get the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List followers",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Followers",
+                        "schema": {
+                            "$ref": "#/definitions/core.FollowListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or limit",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/users/{username}/following": {
+            "get": {
+                "description": "Lists the users the user follows, latest first, with their count. Pass nextCursor as cursor to get the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "User"
+                ],
+                "summary": "List followees",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Username",
+                        "name": "username",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Followees",
+                        "schema": {
+                            "$ref": "#/definitions/core.FollowListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or limit",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "User does not exist",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/world/getCountries": {
+            "post": {
+                "description": "Returns a paginated list of countries.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "World Data"
+                ],
+                "summary": "Get Countries",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/core.GetCountriesResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/core.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/world/getStates": {
+            "post": {
+                "description": "Get states",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "World Data"
+                ],
+                "summary": "Get states",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/core.GetStatesResponse"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "core.CountryInDB": {
+        }
+    },
+    "definitions": {
+        "core.APIKey": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "label": {
+                    "type": "string"
+                },
+                "lastUsedAt": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "revokedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "usageCount": {
+                    "type": "integer"
+                }
+            }
+        },
+        "core.AdminImpersonateRequest": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string",
+                    "maxLength": 500,
+                    "minLength": 3
+                }
+            }
+        },
+        "core.AdminImpersonateResponse": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.AdminReport": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "userId": {
+                    "description": "UserID is the reported user for the filed reports, the reporter for the received reports.",
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.AdminUser": {
+            "type": "object",
+            "properties": {
+                "banned": {
+                    "type": "boolean"
+                },
+                "bannedUntil": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "deletedAt": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "lastLoginAt": {
+                    "type": "string"
+                },
+                "passwordResetRequired": {
+                    "type": "boolean"
+                },
+                "possibleSpammer": {
+                    "type": "boolean"
+                },
+                "reputation": {
+                    "type": "integer"
+                },
+                "role": {
+                    "type": "string"
+                },
+                "spamScore": {
+                    "type": "integer"
+                },
+                "twoFactorEnabled": {
+                    "type": "boolean"
+                },
+                "username": {
+                    "type": "string"
+                },
+                "verified": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.AdminUserDetailResponse": {
+            "type": "object",
+            "properties": {
+                "activeSessions": {
+                    "type": "integer"
+                },
+                "bans": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserBan"
+                    }
+                },
+                "reportsFiled": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.AdminReport"
+                    }
+                },
+                "reportsReceived": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.AdminReport"
+                    }
+                },
+                "spam": {
+                    "$ref": "#/definitions/core.UserSpamResponse"
+                },
+                "user": {
+                    "$ref": "#/definitions/core.AdminUser"
+                }
+            }
+        },
+        "core.AdminUserRoleRequest": {
+            "type": "object",
+            "required": [
+                "role"
+            ],
+            "properties": {
+                "role": {
+                    "description": "One of ADMIN, MODERATOR, EDITOR, USER and GUEST.",
+                    "type": "string",
+                    "enum": [
+                        "ADMIN",
+                        "MODERATOR",
+                        "EDITOR",
+                        "USER",
+                        "GUEST"
+                    ]
+                }
+            }
+        },
+        "core.AdminUsersResponse": {
+            "type": "object",
+            "properties": {
+                "nextCursor": {
+                    "type": "string"
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.AdminUser"
+                    }
+                }
+            }
+        },
+        "core.AuditLogResponse": {
+            "type": "object",
+            "properties": {
+                "nextCursor": {
+                    "type": "string"
+                },
+                "records": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.AuditRecord"
+                    }
+                }
+            }
+        },
+        "core.AuditRecord": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "actorId": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "details": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "id": {
+                    "type": "string"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "targetUserId": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.AvatarURLs": {
+            "type": "object",
+            "properties": {
+                "large": {
+                    "description": "512x512 thumbnail.",
+                    "type": "string"
+                },
+                "medium": {
+                    "description": "256x256 thumbnail.",
+                    "type": "string"
+                },
+                "small": {
+                    "description": "64x64 thumbnail.",
+                    "type": "string"
+                }
+            }
+        },
+        "core.CountryInDB": {
+            "type": "object",
+            "properties": {
+                "capital": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "currency_name": {
+                    "type": "string"
+                },
+                "currency_symbol": {
+                    "type": "string"
+                },
+                "emoji": {
+                    "type": "string"
+                },
+                "emojiU": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "iso2": {
+                    "type": "string"
+                },
+                "iso3": {
+                    "type": "string"
+                },
+                "latitude": {
+                    "type": "number"
+                },
+                "longitude": {
+                    "type": "number"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "native": {
+                    "type": "string"
+                },
+                "numeric_code": {
+                    "type": "string"
+                },
+                "phonecode": {
+                    "type": "string"
+                },
+                "region": {
+                    "type": "string"
+                },
+                "subregion": {
+                    "type": "string"
+                },
+                "timezone_id": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "tld": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.DietaryProfile": {
+            "type": "object",
+            "properties": {
+                "allergens": {
+                    "description": "One of gluten, crustaceans, eggs, fish, peanuts, soy, milk, tree_nuts, celery, mustard, sesame, sulphites, lupin\nand molluscs.",
+                    "type": "array",
+                    "maxItems": 14,
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "dietRestrictions": {
+                    "description": "One of vegan, vegetarian, halal, kosher and gluten_free.",
+                    "type": "array",
+                    "maxItems": 5,
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "distanceUnit": {
+                    "description": "km or mi, km by default.",
+                    "type": "string",
+                    "enum": [
+                        "km",
+                        "mi"
+                    ]
+                },
+                "favouriteCuisines": {
+                    "type": "array",
+                    "maxItems": 20,
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "preferredLanguage": {
+                    "description": "BCP 47 language tag, such as tr or en-GB.",
+                    "type": "string"
+                }
+            }
+        },
+        "core.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is USER_BANNED or USER_SUSPENDED for the requests of banned users, omitted for the other errors.",
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "description": "ExpiresAt is the end of the suspension for USER_SUSPENDED.",
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.FeedItem": {
+            "type": "object",
+            "properties": {
+                "authorUsername": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "helpfulCount": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "placeId": {
+                    "type": "string"
+                },
+                "placeName": {
+                    "type": "string"
+                },
+                "reviewId": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.FeedResponse": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.FeedItem"
+                    }
+                },
+                "nextCursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.FollowListResponse": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "nextCursor": {
+                    "type": "string"
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.FollowUser"
+                    }
+                }
+            }
+        },
+        "core.FollowResponse": {
+            "type": "object",
+            "properties": {
+                "followersCount": {
+                    "type": "integer"
+                },
+                "following": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.FollowUser": {
+            "type": "object",
+            "properties": {
+                "followedAt": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.GetCountriesResponse": {
+            "type": "object",
+            "properties": {
+                "countries": {
+                    "description": "Countries represents a list of countries",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.CountryInDB"
+                    }
+                },
+                "resultCount": {
+                    "description": "ResultCount represents Query result count",
+                    "type": "integer"
+                },
+                "totalCount": {
+                    "description": "TotalCount represents countries count in the database",
+                    "type": "integer"
+                },
+                "totalPages": {
+                    "description": "TotalPages represents total number of pages, calculated by dividing TotalCount by PageSize in the GetCountriesRequest",
+                    "type": "integer"
+                }
+            }
+        },
+        "core.GetStatesResponse": {
+            "type": "object",
+            "properties": {
+                "resultCount": {
+                    "type": "integer"
+                },
+                "states": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.State"
+                    }
+                },
+                "totalCount": {
+                    "type": "integer"
+                },
+                "totalPages": {
+                    "type": "integer"
+                }
+            }
+        },
+        "core.GetUserDataResponse": {
+            "type": "object",
+            "properties": {
+                "refreshToken": {
+                    "description": "Refresh token of the session, logging in with it continues the session. Empty for the tokens without a session.",
+                    "type": "string"
+                },
+                "sessionToken": {
+                    "description": "Session token for the user.",
+                    "type": "string"
+                },
+                "user": {
+                    "description": "User information.",
+                    "type": "object",
+                    "properties": {
+                        "avatar": {
+                            "description": "URLs of the avatar thumbnails, null if the user has no avatar.",
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/core.AvatarURLs"
+                                }
+                            ]
+                        },
+                        "banned": {
+                            "description": "Flag indicating if the user is banned.",
+                            "type": "boolean"
+                        },
+                        "bio": {
+                            "description": "Bio of the user, empty if not set.",
+                            "type": "string"
+                        },
+                        "createdAt": {
+                            "description": "Timestamp indicating when the user was created.",
+                            "type": "string"
+                        },
+                        "displayName": {
+                            "description": "Display name of the user, empty if not set.",
+                            "type": "string"
+                        },
+                        "email": {
+                            "description": "Email of the user.",
+                            "type": "string"
+                        },
+                        "emailLastUpdatedAt": {
+                            "description": "Timestamp indicating when the email was last updated.",
+                            "type": "string"
+                        },
+                        "id": {
+                            "description": "User ID.",
+                            "type": "string"
+                        },
+                        "lastLoginAt": {
+                            "description": "Timestamp indicating when the user last logged in.",
+                            "type": "string"
+                        },
+                        "location": {
+                            "description": "Location information of the user.",
+                            "type": "object",
+                            "properties": {
+                                "city": {
+                                    "description": "City where the user is located.",
+                                    "type": "string"
+                                },
+                                "country": {
+                                    "description": "Country where the user is located.",
+                                    "type": "string"
+                                },
+                                "state": {
+                                    "description": "State where the user is located.",
+                                    "type": "string"
+                                }
+                            }
+                        },
+                        "pendingEmail": {
+                            "description": "New email of the pending email change, null if there is none. It becomes the email once it is confirmed.",
+                            "type": "string"
+                        },
+                        "phoneNumber": {
+                            "description": "Phone number of the user.",
+                            "type": "string"
+                        },
+                        "phoneVerified": {
+                            "description": "Flag indicating if the phone number is verified with a code sent to it.",
+                            "type": "boolean"
+                        },
+                        "reputation": {
+                            "description": "Reputation of the user.",
+                            "type": "integer"
+                        },
+                        "role": {
+                            "description": "Role of the user.",
+                            "type": "string"
+                        },
+                        "updatedAt": {
+                            "description": "Timestamp indicating when the user was last updated.",
+                            "type": "string"
+                        },
+                        "username": {
+                            "description": "Username of the user.",
+                            "type": "string"
+                        },
+                        "usernameLastUpdatedAt": {
+                            "description": "Timestamp indicating when the username was last updated.",
+                            "type": "string"
+                        },
+                        "verified": {
+                            "description": "Flag indicating if the user is verified.",
+                            "type": "boolean"
+                        }
+                    }
+                }
+            }
+        },
+        "core.ModeratorBanLiftRequest": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "core.ModeratorBanRequest": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "expiresAt": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "core.ModeratorReportStatusRequest": {
+            "type": "object",
+            "required": [
+                "status"
+            ],
+            "properties": {
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "PENDING",
+                        "CONFIRMED",
+                        "REJECTED"
+                    ]
+                }
+            }
+        },
+        "core.ModeratorReportStatusResponse": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.ModeratorReputationAdjustRequest": {
+            "type": "object",
+            "required": [
+                "points",
+                "reason"
+            ],
+            "properties": {
+                "points": {
+                    "type": "integer",
+                    "maximum": 1000,
+                    "minimum": -1000
+                },
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "core.ModeratorReputationResponse": {
+            "type": "object",
+            "properties": {
+                "reputation": {
+                    "type": "integer"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.ModeratorReviewRemoveRequest": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string",
+                    "maxLength": 255
+                }
+            }
+        },
+        "core.ModeratorSpamOverrideRequest": {
+            "type": "object",
+            "properties": {
+                "possibleSpammer": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.Notification": {
+            "type": "object",
+            "properties": {
+                "actorUsername": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "readAt": {
+                    "type": "string"
+                },
+                "reviewId": {
+                    "type": "string"
+                },
+                "sourceId": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.NotificationPreferencesRequest": {
+            "type": "object",
+            "properties": {
+                "disabledTypes": {
+                    "description": "One of reply, vote and moderation.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.NotificationPreferencesResponse": {
+            "type": "object",
+            "properties": {
+                "disabledTypes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "types": {
+                    "description": "Types is every type of notification.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.NotificationReadRequest": {
+            "type": "object",
+            "properties": {
+                "all": {
+                    "type": "boolean"
+                },
+                "ids": {
+                    "type": "array",
+                    "maxItems": 100,
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.NotificationUnreadResponse": {
+            "type": "object",
+            "properties": {
+                "unreadCount": {
+                    "type": "integer"
+                },
+                "unreadCounts": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "core.NotificationsResponse": {
+            "type": "object",
+            "properties": {
+                "nextCursor": {
+                    "type": "string"
+                },
+                "notifications": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.Notification"
+                    }
+                },
+                "unreadCount": {
+                    "type": "integer"
+                },
+                "unreadCounts": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "core.OIDCAuthorizationResponse": {
+            "type": "object",
+            "properties": {
+                "authorizationUrl": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.Place": {
+            "type": "object",
+            "properties": {
+                "cuisine": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "openingHours": {
+                    "type": "string"
+                },
+                "phone": {
+                    "type": "string"
+                },
+                "website": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.PlacePatchRequest": {
+            "type": "object",
+            "properties": {
+                "cuisine": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "openingHours": {
+                    "type": "string"
+                },
+                "phone": {
+                    "type": "string"
+                },
+                "website": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.PlaceRecommendationsResponse": {
+            "type": "object",
+            "properties": {
+                "distanceUnit": {
+                    "type": "string"
+                },
+                "places": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.RecommendedPlace"
+                    }
+                },
+                "requiredDiets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "unfilteredAllergens": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.PublicProfileLocation": {
+            "type": "object",
+            "properties": {
+                "city": {
+                    "type": "string"
+                },
+                "country": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.PublicProfileReview": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "helpfulCount": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "placeId": {
+                    "type": "string"
+                },
+                "placeName": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.PublicUserProfile": {
+            "type": "object",
+            "properties": {
+                "badges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserBadge"
+                    }
+                },
+                "hiddenFields": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "joinedAt": {
+                    "type": "string"
+                },
+                "location": {
+                    "$ref": "#/definitions/core.PublicProfileLocation"
+                },
+                "recentReviews": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.PublicProfileReview"
+                    }
+                },
+                "reputation": {
+                    "type": "integer"
+                },
+                "reviewCount": {
+                    "type": "integer"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.RecommendedPlace": {
+            "type": "object",
+            "properties": {
+                "cuisines": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "diets": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "distance": {
+                    "type": "number"
+                },
+                "favouriteCuisine": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.SpamCheck": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "score": {
+                    "type": "integer"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "sourceId": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.SpamQueueResponse": {
+            "type": "object",
+            "properties": {
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserSpamStatus"
+                    }
+                }
+            }
+        },
+        "core.State": {
+            "type": "object",
+            "properties": {
+                "country_code": {
+                    "type": "string"
+                },
+                "country_id": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "latitude": {
+                    "type": "string"
+                },
+                "longitude": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserAPIKeyCreateRequest": {
+            "type": "object",
+            "required": [
+                "expiresAt",
+                "label",
+                "scopes"
+            ],
+            "properties": {
+                "expiresAt": {
+                    "description": "ExpiresAt is the expiry of the key, at most a year from now.\n\nrequired: true",
+                    "type": "string"
+                },
+                "label": {
+                    "description": "Label to tell the keys apart, e.g. the name of the integration.\n\nrequired: true",
+                    "type": "string",
+                    "maxLength": 64
+                },
+                "scopes": {
+                    "description": "Scopes of the key, world:read and places:read.\n\nrequired: true",
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.UserAPIKeyCreateResponse": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "description": "Key is shown only once, send it as \"Authorization: ApiKey {key}\".",
+                    "type": "string"
+                },
+                "label": {
+                    "type": "string"
+                },
+                "lastUsedAt": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "type": "string"
+                },
+                "revokedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "usageCount": {
+                    "type": "integer"
+                }
+            }
+        },
+        "core.UserAPIKeyRevokeResponse": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserAPIKeysResponse": {
+            "type": "object",
+            "properties": {
+                "keys": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.APIKey"
+                    }
+                }
+            }
+        },
+        "core.UserAvatarResponse": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "description": "Avatar is null if the user has no avatar.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/core.AvatarURLs"
+                        }
+                    ]
+                }
+            }
+        },
+        "core.UserBadge": {
+            "type": "object",
+            "properties": {
+                "awardedAt": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserBan": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "liftReason": {
+                    "type": "string"
+                },
+                "liftedAt": {
+                    "type": "string"
+                },
+                "liftedBy": {
+                    "type": "string"
+                },
+                "moderatorId": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserBansResponse": {
+            "type": "object",
+            "properties": {
+                "bans": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserBan"
+                    }
+                }
+            }
+        },
+        "core.UserDataExport": {
+            "type": "object",
+            "properties": {
+                "completedAt": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "expiresAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserDataExportsResponse": {
+            "type": "object",
+            "properties": {
+                "exports": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserDataExport"
+                    }
+                }
+            }
+        },
+        "core.UserDeleteResponse": {
+            "type": "object",
+            "properties": {
+                "restorableUntil": {
+                    "description": "RestorableUntil is the end of the grace period, logging in before it restores the account.",
+                    "type": "string"
+                },
+                "success": {
+                    "description": "Success indicates if the user was deleted successfully.\nRequired: true",
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserEmailChangeResponse": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserEmailChangeTokenRequest": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "description": "Token from the confirm or the revert link.\n\nrequired: true",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserIdentitiesResponse": {
+            "type": "object",
+            "properties": {
+                "identities": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserIdentity"
+                    }
+                }
+            }
+        },
+        "core.UserIdentity": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "lastLoginAt": {
+                    "type": "string"
+                },
+                "provider": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserIdentityUnlinkResponse": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserLoginRequest": {
+            "type": "object",
+            "required": [
+                "password"
+            ],
+            "properties": {
+                "email": {
+                    "description": "Email of the user.",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "Password of the user.\n\nrequired: true",
+                    "type": "string"
+                },
+                "test": {
+                    "description": "Test flag to indicate if it's a test.",
+                    "type": "boolean"
+                },
+                "username": {
+                    "description": "Username of the user.",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserLoginResponse": {
+            "type": "object",
+            "properties": {
+                "refreshToken": {
+                    "description": "Refresh token of the session, logging in with it continues the session. Empty for the tokens without a session.",
+                    "type": "string"
+                },
+                "sessionToken": {
+                    "description": "Session token for the user.",
+                    "type": "string"
+                },
+                "user": {
+                    "description": "User information.",
+                    "type": "object",
+                    "properties": {
+                        "avatar": {
+                            "description": "URLs of the avatar thumbnails, null if the user has no avatar.",
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/core.AvatarURLs"
+                                }
+                            ]
+                        },
+                        "banned": {
+                            "description": "Flag indicating if the user is banned.",
+                            "type": "boolean"
+                        },
+                        "bio": {
+                            "description": "Bio of the user, empty if not set.",
+                            "type": "string"
+                        },
+                        "createdAt": {
+                            "description": "Timestamp indicating when the user was created.",
+                            "type": "string"
+                        },
+                        "displayName": {
+                            "description": "Display name of the user, empty if not set.",
+                            "type": "string"
+                        },
+                        "email": {
+                            "description": "Email of the user.",
+                            "type": "string"
+                        },
+                        "emailLastUpdatedAt": {
+                            "description": "Timestamp indicating when the email was last updated.",
+                            "type": "string"
+                        },
+                        "id": {
+                            "description": "User ID.",
+                            "type": "string"
+                        },
+                        "lastLoginAt": {
+                            "description": "Timestamp indicating when the user last logged in.",
+                            "type": "string"
+                        },
+                        "location": {
+                            "description": "Location information of the user.",
+                            "type": "object",
+                            "properties": {
+                                "city": {
+                                    "description": "City where the user is located.",
+                                    "type": "string"
+                                },
+                                "country": {
+                                    "description": "Country where the user is located.",
+                                    "type": "string"
+                                },
+                                "state": {
+                                    "description": "State where the user is located.",
+                                    "type": "string"
+                                }
+                            }
+                        },
+                        "pendingEmail": {
+                            "description": "New email of the pending email change, null if there is none. It becomes the email once it is confirmed.",
+                            "type": "string"
+                        },
+                        "phoneNumber": {
+                            "description": "Phone number of the user.",
+                            "type": "string"
+                        },
+                        "phoneVerified": {
+                            "description": "Flag indicating if the phone number is verified with a code sent to it.",
+                            "type": "boolean"
+                        },
+                        "reputation": {
+                            "description": "Reputation of the user.",
+                            "type": "integer"
+                        },
+                        "role": {
+                            "description": "Role of the user.",
+                            "type": "string"
+                        },
+                        "updatedAt": {
+                            "description": "Timestamp indicating when the user was last updated.",
+                            "type": "string"
+                        },
+                        "username": {
+                            "description": "Username of the user.",
+                            "type": "string"
+                        },
+                        "usernameLastUpdatedAt": {
+                            "description": "Timestamp indicating when the username was last updated.",
+                            "type": "string"
+                        },
+                        "verified": {
+                            "description": "Flag indicating if the user is verified.",
+                            "type": "boolean"
+                        }
+                    }
+                }
+            }
+        },
+        "core.UserLoginTwoFactorRequest": {
             "type": "object",
             "properties": {
-                "capital": {
-                    "type": "string"
-                },
-                "currency": {
-                    "type": "string"
-                },
-                "currency_name": {
-                    "type": "string"
-                },
-                "currency_symbol": {
-                    "type": "string"
-                },
-                "emoji": {
-                    "type": "string"
-                },
-                "emojiU": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "integer"
-                },
-                "iso2": {
-                    "type": "string"
-                },
-                "iso3": {
-                    "type": "string"
-                },
-                "latitude": {
-                    "type": "number"
-                },
-                "longitude": {
-                    "type": "number"
-                },
-                "name": {
-                    "type": "string"
-                },
-                "native": {
-                    "type": "string"
-                },
-                "numeric_code": {
-                    "type": "string"
-                },
-                "phonecode": {
-                    "type": "string"
-                },
-                "region": {
-                    "type": "string"
-                },
-                "subregion": {
+                "code": {
+                    "description": "6 digit code from the authenticator app.",
                     "type": "string"
                 },
-                "timezone_id": {
-                    "type": "array",
-                    "items": {
-                        "type": "integer"
-                    }
-                },
-                "tld": {
+                "recoveryCode": {
+                    "description": "One of the recovery codes, each recovery code can be used once.",
                     "type": "string"
                 }
             }
         },
-        "core.ErrorResponse": {
+        "core.UserLoginTwoFactorResponse": {
             "type": "object",
             "properties": {
-                "error": {
+                "loginToken": {
+                    "description": "LoginToken is a short-lived WAITING_LOGIN token, send it as a Bearer token to /api/user/login/2fa.",
                     "type": "string"
                 },
-                "request_id": {
-                    "type": "string"
+                "twoFactorRequired": {
+                    "type": "boolean"
                 }
             }
         },
-        "core.GetCountriesResponse": {
+        "core.UserLogoutResponse": {
             "type": "object",
             "properties": {
-                "countries": {
-                    "description": "Countries represents a list of countries",
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/core.CountryInDB"
-                    }
-                },
-                "resultCount": {
-                    "description": "ResultCount represents Query result count",
-                    "type": "integer"
-                },
-                "totalCount": {
-                    "description": "TotalCount represents countries count in the database",
-                    "type": "integer"
-                },
-                "totalPages": {
-                    "description": "TotalPages represents total number of pages, calculated by dividing TotalCount by PageSize in the GetCountriesRequest",
-                    "type": "integer"
+                "success": {
+                    "type": "boolean"
                 }
             }
         },
-        "core.GetStatesResponse": {
+        "core.UserPasswordChangeRequest": {
             "type": "object",
+            "required": [
+                "currentPassword",
+                "newPassword"
+            ],
             "properties": {
-                "resultCount": {
-                    "type": "integer"
-                },
-                "states": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/core.State"
-                    }
-                },
-                "totalCount": {
-                    "type": "integer"
+                "currentPassword": {
+                    "description": "Current password of the user.\n\nrequired: true",
+                    "type": "string"
                 },
-                "totalPages": {
-                    "type": "integer"
+                "newPassword": {
+                    "description": "New password of the user, must not be one of the recently used passwords.\n\nrequired: true",
+                    "type": "string"
                 }
             }
         },
-        "core.GetUserDataResponse": {
+        "core.UserPasswordChangeResponse": {
             "type": "object",
             "properties": {
                 "refreshToken": {
-                    "description": "Refresh token for the user.",
+                    "description": "Refresh token of the session, logging in with it continues the session. Empty for the tokens without a session.",
                     "type": "string"
                 },
                 "sessionToken": {
@@ -385,14 +5266,30 @@ const docTemplate = `{
                     "description": "User information.",
                     "type": "object",
                     "properties": {
+                        "avatar": {
+                            "description": "URLs of the avatar thumbnails, null if the user has no avatar.",
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/core.AvatarURLs"
+                                }
+                            ]
+                        },
                         "banned": {
                             "description": "Flag indicating if the user is banned.",
                             "type": "boolean"
                         },
+                        "bio": {
+                            "description": "Bio of the user, empty if not set.",
+                            "type": "string"
+                        },
                         "createdAt": {
                             "description": "Timestamp indicating when the user was created.",
                             "type": "string"
                         },
+                        "displayName": {
+                            "description": "Display name of the user, empty if not set.",
+                            "type": "string"
+                        },
                         "email": {
                             "description": "Email of the user.",
                             "type": "string"
@@ -427,10 +5324,18 @@ const docTemplate = `{
                                 }
                             }
                         },
+                        "pendingEmail": {
+                            "description": "New email of the pending email change, null if there is none. It becomes the email once it is confirmed.",
+                            "type": "string"
+                        },
                         "phoneNumber": {
                             "description": "Phone number of the user.",
                             "type": "string"
                         },
+                        "phoneVerified": {
+                            "description": "Flag indicating if the phone number is verified with a code sent to it.",
+                            "type": "boolean"
+                        },
                         "reputation": {
                             "description": "Reputation of the user.",
                             "type": "integer"
@@ -459,147 +5364,242 @@ const docTemplate = `{
                 }
             }
         },
-        "core.State": {
+        "core.UserPasswordForgotRequest": {
             "type": "object",
+            "required": [
+                "email"
+            ],
             "properties": {
-                "country_code": {
+                "email": {
+                    "description": "Email of the user.\n\nrequired: true\nformat: email",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserPasswordForgotResponse": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserPasswordResetRequest": {
+            "type": "object",
+            "required": [
+                "password",
+                "token"
+            ],
+            "properties": {
+                "password": {
+                    "description": "New password of the user.\n\nrequired: true",
+                    "type": "string"
+                },
+                "token": {
+                    "description": "Token from the password reset link.\n\nrequired: true",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserPasswordResetResponse": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserPatchRequest": {
+            "type": "object",
+            "properties": {
+                "bio": {
+                    "type": "string"
+                },
+                "displayName": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "location": {
+                    "type": "object"
+                },
+                "phoneNumber": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserPhoneVerificationRequest": {
+            "type": "object",
+            "required": [
+                "code"
+            ],
+            "properties": {
+                "code": {
+                    "description": "required: true",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserPhoneVerificationSendResponse": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "description": "ExpiresAt is the expiry of the sent code.",
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserProfilePrivacyRequest": {
+            "type": "object",
+            "properties": {
+                "hiddenFields": {
+                    "description": "One of reputation, joinDate, location, reviewCount and recentReviews.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.UserProfilePrivacyResponse": {
+            "type": "object",
+            "properties": {
+                "fields": {
+                    "description": "Fields is every field that can be hidden.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "hiddenFields": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "core.UserReputationEvent": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "eventType": {
+                    "type": "string"
+                },
+                "points": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "sourceId": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserReputationPrivilege": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "threshold": {
+                    "type": "integer"
+                },
+                "unlocked": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserReputationResponse": {
+            "type": "object",
+            "properties": {
+                "badges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserBadge"
+                    }
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserReputationEvent"
+                    }
+                },
+                "privileges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserReputationPrivilege"
+                    }
+                },
+                "reputation": {
+                    "type": "integer"
+                }
+            }
+        },
+        "core.UserSession": {
+            "type": "object",
+            "properties": {
+                "browser": {
+                    "type": "string"
+                },
+                "country": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "current": {
+                    "description": "Current is true for the session of the token the list is requested with.",
+                    "type": "boolean"
+                },
+                "deviceType": {
                     "type": "string"
                 },
-                "country_id": {
-                    "type": "integer"
+                "expiresAt": {
+                    "type": "string"
                 },
                 "id": {
-                    "type": "integer"
+                    "type": "string"
                 },
-                "latitude": {
+                "ip": {
                     "type": "string"
                 },
-                "longitude": {
+                "lastSeenAt": {
                     "type": "string"
                 },
-                "name": {
+                "method": {
+                    "type": "string"
+                },
+                "os": {
                     "type": "string"
                 }
             }
         },
-        "core.UserDeleteResponse": {
+        "core.UserSessionRevokeResponse": {
             "type": "object",
             "properties": {
                 "success": {
-                    "description": "Success indicates if the user was deleted successfully.\nRequired: true",
-                    "type": "boolean"
-                }
-            }
-        },
-        "core.UserLoginRequest": {
-            "type": "object",
-            "required": [
-                "password"
-            ],
-            "properties": {
-                "email": {
-                    "description": "Email of the user.",
-                    "type": "string"
-                },
-                "password": {
-                    "description": "Password of the user.\n\nrequired: true",
-                    "type": "string"
-                },
-                "test": {
-                    "description": "Test flag to indicate if it's a test.",
                     "type": "boolean"
-                },
-                "username": {
-                    "description": "Username of the user.",
-                    "type": "string"
                 }
             }
         },
-        "core.UserLoginResponse": {
+        "core.UserSessionsResponse": {
             "type": "object",
             "properties": {
-                "refreshToken": {
-                    "description": "Refresh token for the user.",
-                    "type": "string"
-                },
-                "sessionToken": {
-                    "description": "Session token for the user.",
-                    "type": "string"
-                },
-                "user": {
-                    "description": "User information.",
-                    "type": "object",
-                    "properties": {
-                        "banned": {
-                            "description": "Flag indicating if the user is banned.",
-                            "type": "boolean"
-                        },
-                        "createdAt": {
-                            "description": "Timestamp indicating when the user was created.",
-                            "type": "string"
-                        },
-                        "email": {
-                            "description": "Email of the user.",
-                            "type": "string"
-                        },
-                        "emailLastUpdatedAt": {
-                            "description": "Timestamp indicating when the email was last updated.",
-                            "type": "string"
-                        },
-                        "id": {
-                            "description": "User ID.",
-                            "type": "string"
-                        },
-                        "lastLoginAt": {
-                            "description": "Timestamp indicating when the user last logged in.",
-                            "type": "string"
-                        },
-                        "location": {
-                            "description": "Location information of the user.",
-                            "type": "object",
-                            "properties": {
-                                "city": {
-                                    "description": "City where the user is located.",
-                                    "type": "string"
-                                },
-                                "country": {
-                                    "description": "Country where the user is located.",
-                                    "type": "string"
-                                },
-                                "state": {
-                                    "description": "State where the user is located.",
-                                    "type": "string"
-                                }
-                            }
-                        },
-                        "phoneNumber": {
-                            "description": "Phone number of the user.",
-                            "type": "string"
-                        },
-                        "reputation": {
-                            "description": "Reputation of the user.",
-                            "type": "integer"
-                        },
-                        "role": {
-                            "description": "Role of the user.",
-                            "type": "string"
-                        },
-                        "updatedAt": {
-                            "description": "Timestamp indicating when the user was last updated.",
-                            "type": "string"
-                        },
-                        "username": {
-                            "description": "Username of the user.",
-                            "type": "string"
-                        },
-                        "usernameLastUpdatedAt": {
-                            "description": "Timestamp indicating when the username was last updated.",
-                            "type": "string"
-                        },
-                        "verified": {
-                            "description": "Flag indicating if the user is verified.",
-                            "type": "boolean"
-                        }
+                "sessions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.UserSession"
                     }
                 }
             }
@@ -646,6 +5646,159 @@ const docTemplate = `{
                 }
             }
         },
+        "core.UserSpamResponse": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/core.SpamCheck"
+                    }
+                },
+                "override": {
+                    "type": "boolean"
+                },
+                "overrideBy": {
+                    "type": "string"
+                },
+                "possibleSpammer": {
+                    "type": "boolean"
+                },
+                "reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "score": {
+                    "type": "integer"
+                },
+                "scoredAt": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserSpamStatus": {
+            "type": "object",
+            "properties": {
+                "override": {
+                    "type": "boolean"
+                },
+                "overrideBy": {
+                    "type": "string"
+                },
+                "possibleSpammer": {
+                    "type": "boolean"
+                },
+                "reasons": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "score": {
+                    "type": "integer"
+                },
+                "scoredAt": {
+                    "type": "string"
+                },
+                "userId": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserTwoFactorCodeRequest": {
+            "type": "object",
+            "required": [
+                "code"
+            ],
+            "properties": {
+                "code": {
+                    "description": "6 digit code from the authenticator app.\n\nrequired: true",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserTwoFactorConfirmResponse": {
+            "type": "object",
+            "properties": {
+                "recoveryCodes": {
+                    "description": "RecoveryCodes are shown only once.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "refreshToken": {
+                    "type": "string"
+                },
+                "sessionToken": {
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserTwoFactorDisableRequest": {
+            "type": "object",
+            "required": [
+                "password"
+            ],
+            "properties": {
+                "code": {
+                    "description": "6 digit code from the authenticator app.",
+                    "type": "string"
+                },
+                "password": {
+                    "description": "Current password of the user.\n\nrequired: true",
+                    "type": "string"
+                },
+                "recoveryCode": {
+                    "description": "One of the recovery codes, if the authenticator app is lost.",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserTwoFactorDisableResponse": {
+            "type": "object",
+            "properties": {
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "core.UserTwoFactorEnrollResponse": {
+            "type": "object",
+            "properties": {
+                "otpauthUri": {
+                    "description": "OTPAuthURI is the otpauth:// URI of the secret, to be rendered as a QR code.",
+                    "type": "string"
+                },
+                "secret": {
+                    "description": "Secret is the base32 encoded TOTP secret, for manual entry.",
+                    "type": "string"
+                }
+            }
+        },
+        "core.UserTwoFactorRecoveryCodesResponse": {
+            "type": "object",
+            "properties": {
+                "recoveryCodes": {
+                    "description": "RecoveryCodes are shown only once, old recovery codes are not valid anymore.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
         "core.UserUpdateRequest": {
             "type": "object",
             "properties": {
@@ -667,7 +5820,7 @@ const docTemplate = `{
             "type": "object",
             "properties": {
                 "refreshToken": {
-                    "description": "Refresh token for the user.",
+                    "description": "Refresh token of the session, logging in with it continues the session. Empty for the tokens without a session.",
                     "type": "string"
                 },
                 "sessionToken": {
@@ -678,14 +5831,30 @@ const docTemplate = `{
                     "description": "User information.",
                     "type": "object",
                     "properties": {
+                        "avatar": {
+                            "description": "URLs of the avatar thumbnails, null if the user has no avatar.",
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/core.AvatarURLs"
+                                }
+                            ]
+                        },
                         "banned": {
                             "description": "Flag indicating if the user is banned.",
                             "type": "boolean"
                         },
+                        "bio": {
+                            "description": "Bio of the user, empty if not set.",
+                            "type": "string"
+                        },
                         "createdAt": {
                             "description": "Timestamp indicating when the user was created.",
                             "type": "string"
                         },
+                        "displayName": {
+                            "description": "Display name of the user, empty if not set.",
+                            "type": "string"
+                        },
                         "email": {
                             "description": "Email of the user.",
                             "type": "string"
@@ -720,10 +5889,18 @@ const docTemplate = `{
                                 }
                             }
                         },
+                        "pendingEmail": {
+                            "description": "New email of the pending email change, null if there is none. It becomes the email once it is confirmed.",
+                            "type": "string"
+                        },
                         "phoneNumber": {
                             "description": "Phone number of the user.",
                             "type": "string"
                         },
+                        "phoneVerified": {
+                            "description": "Flag indicating if the phone number is verified with a code sent to it.",
+                            "type": "boolean"
+                        },
                         "reputation": {
                             "description": "Reputation of the user.",
                             "type": "integer"
@@ -751,6 +5928,26 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "core.UsernameAvailabilityResponse": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "boolean"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "suggestions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -108,6 +108,11 @@ ALTER TABLE places_reports
     ADD FOREIGN KEY (place_id) REFERENCES places (id);
 ALTER TABLE places_reports
     ADD FOREIGN KEY (report_id) REFERENCES reports (id);
+-- the diet:* tags of the place that are yes, only or limited, without the prefix, see PlaceDiets.
+ALTER TABLE "places"
+    ADD COLUMN IF NOT EXISTS "diets" TEXT[] NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS places_diets ON places USING gin (diets);
+CREATE INDEX IF NOT EXISTS places_location ON places (latitude, longitude);
 
 
 CREATE TABLE IF NOT EXISTS "users"
@@ -120,7 +125,7 @@ CREATE TABLE IF NOT EXISTS "users"
     "password"                 VARCHAR(64)              NOT NULL,
     "created_at"               TIMESTAMP WITH TIME ZONE          DEFAULT NOW() NOT NULL,
     "updated_at"               TIMESTAMP WITH TIME ZONE          DEFAULT NOW() NOT NULL,
-    "phone_number"             VARCHAR(20) UNIQUE       NOT NULL,
+    "phone_number"             VARCHAR(20)              NOT NULL,
     "role"                     VARCHAR(25)              NOT NULL,
     "place_id"                 UUID                              DEFAULT NULL,
     "banned"                   BOOLEAN                  NOT NULL DEFAULT false,
@@ -135,14 +140,405 @@ CREATE TABLE IF NOT EXISTS "users"
     "last_login_at"            TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     "possible_spammer"         BOOLEAN                  NOT NULL DEFAULT false
 );
+-- token_version is bumped to revoke every session and refresh token of the user at once.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "token_version" INTEGER NOT NULL DEFAULT 0;
+-- two factor authentication, secret is set at enrollment and totp_enabled after the first code is confirmed.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "totp_secret"          VARCHAR(64)              DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "totp_enabled"         BOOLEAN         NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS "totp_last_used_step"  BIGINT          NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS "totp_failed_attempts" SMALLINT        NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS "totp_locked_until"    TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+-- password hashes are PHC strings of argon2id (or bcrypt for the older ones), see passwordhash.go.
+ALTER TABLE "users"
+    ALTER COLUMN "password" TYPE VARCHAR(255);
+-- phone numbers are verified with SMS codes, separately from the email verification.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "phone_verified"    BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS "phone_verified_at" TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+-- only the verified phone numbers are unique, the number is taken away from the unverified users when a user verifies
+-- it, see phone.go.
+ALTER TABLE "users"
+    DROP CONSTRAINT IF EXISTS users_phone_number_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_number_verified ON users (phone_number) WHERE phone_verified;
+CREATE INDEX IF NOT EXISTS users_phone_number ON users (phone_number);
+-- fields of the public profile the user hid, see profile.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "profile_hidden_fields" TEXT[] NOT NULL DEFAULT '{}';
+-- deleted accounts are restorable until the grace period is over, then they are anonymized, see deletion.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "deleted_at"    TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "anonymized_at" TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+-- optional display name and bio, set with PATCH /api/user, see userpatch.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "display_name" VARCHAR(50)  DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "bio"          VARCHAR(280) DEFAULT NULL;
+-- key of the avatar in the object storage, the thumbnails are stored next to it, see avatar.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "avatar_key" VARCHAR(255) DEFAULT NULL;
+-- the reputation is the sum of the points of the reputation_events of the user, see reputation.go.
+ALTER TABLE "users"
+    ALTER COLUMN "reputation" TYPE INTEGER;
+-- end of the active ban, NULL for permanent bans. The history of the bans is in user_bans, see bans.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "banned_until" TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+-- spam score of the user, the highest score of the recent spam_checks. spam_override is set by the moderators, it
+-- replaces the automatic possible_spammer flag until it is cleared, see spam.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "signup_ip"        inet                              DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "spam_score"       SMALLINT                 NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS "spam_reasons"     TEXT[]                   NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS "spam_scored_at"   TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "spam_override"    BOOLEAN                           DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "spam_override_by" UUID                              DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "spam_override_at" TIMESTAMP WITH TIME ZONE          DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS users_signup_ip ON users USING gist (signup_ip inet_ops);
+CREATE INDEX IF NOT EXISTS users_email_domain ON users (LOWER(split_part(email, '@', 2)));
+CREATE INDEX IF NOT EXISTS users_possible_spammer ON users (spam_score) WHERE possible_spammer;
+-- usernames are unique case-insensitively, see username.go. Older usernames may differ only in case, all but the first
+-- of them are marked and left out of the unique index until they are changed.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "username_case_duplicate" BOOLEAN NOT NULL DEFAULT false;
+UPDATE "users" u
+SET "username_case_duplicate" = true
+WHERE NOT u."username_case_duplicate"
+  AND EXISTS (SELECT 1
+              FROM "users" o
+              WHERE LOWER(o."username") = LOWER(u."username")
+                AND (o."created_at", o."id") < (u."created_at", u."id"));
+DROP INDEX IF EXISTS users_username_lower;
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_lower_unique ON users (LOWER(username)) WHERE NOT username_case_duplicate;
+-- types of the notifications the user turned off, see notifications.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "notification_disabled_types" TEXT[] NOT NULL DEFAULT '{}';
+-- dietary profile, used to filter and rank the recommended places, see dietary.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "diet_restrictions"  TEXT[]      NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS "favourite_cuisines" TEXT[]      NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS "allergens"          TEXT[]      NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS "preferred_language" VARCHAR(35)          DEFAULT NULL,
+    ADD COLUMN IF NOT EXISTS "distance_unit"      VARCHAR(2)  NOT NULL DEFAULT 'km';
+-- set by an admin, the password login is rejected until the password is reset, see admin.go.
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS "password_reset_required" BOOLEAN NOT NULL DEFAULT false;
 ALTER TABLE "users"
     ADD FOREIGN KEY ("place_id") REFERENCES "places" ("id");
+-- the location is erased at the anonymization, see deletion.go.
+ALTER TABLE "users"
+    ALTER COLUMN "city" DROP NOT NULL,
+    ALTER COLUMN "country" DROP NOT NULL,
+    ALTER COLUMN "state" DROP NOT NULL;
 ALTER TABLE "users"
     ADD FOREIGN KEY ("city") REFERENCES "cities" ("id");
 ALTER TABLE "users"
     ADD FOREIGN KEY ("country") REFERENCES "countries" ("id");
 ALTER TABLE "users"
     ADD FOREIGN KEY ("state") REFERENCES "states" ("id");
+-- the roles are compared in upper case, the rows of the old signups stored them in lower case.
+UPDATE "users"
+SET "role" = UPPER("role")
+WHERE "role" <> UPPER("role");
+
+CREATE TABLE IF NOT EXISTS "password_reset_tokens"
+(
+    "id"         UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"    UUID                     NOT NULL,
+    "token_hash" CHAR(64) UNIQUE          NOT NULL, -- sha256 of the emailed token, plain token is never stored.
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "used_at"    TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_password_reset_tokens_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- pending, confirmed and reverted changes of the email, see emailchange.go. At most one change of a user is pending.
+CREATE TABLE IF NOT EXISTS "email_changes"
+(
+    "id"                 UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"            UUID                     NOT NULL,
+    "old_email"          VARCHAR(255)             NOT NULL,
+    "new_email"          VARCHAR(255)             NOT NULL,
+    "confirm_token_hash" CHAR(64) UNIQUE          NOT NULL, -- sha256 of the token sent to the new email.
+    "revert_token_hash"  CHAR(64) UNIQUE          NOT NULL, -- sha256 of the token sent to the old email.
+    "created_at"         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "confirm_expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    "revert_expires_at"  TIMESTAMP WITH TIME ZONE NOT NULL,
+    "confirmed_at"       TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "reverted_at"        TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "cancelled_at"       TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_email_changes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS email_changes_pending ON email_changes (user_id)
+    WHERE confirmed_at IS NULL AND reverted_at IS NULL AND cancelled_at IS NULL;
+CREATE INDEX IF NOT EXISTS email_changes_user_id ON email_changes (user_id, created_at);
+
+-- actions of the admins, see admin.go. Kept when the users are deleted.
+CREATE TABLE IF NOT EXISTS "admin_audit_log"
+(
+    "id"             UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "actor_id"       UUID                              DEFAULT NULL,
+    "target_user_id" UUID                              DEFAULT NULL,
+    "action"         VARCHAR(64)              NOT NULL,
+    "details"        JSONB                    NOT NULL DEFAULT '{}',
+    "ip"             inet                              DEFAULT NULL,
+    "created_at"     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_admin_audit_log_actor FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL,
+    CONSTRAINT fk_admin_audit_log_target FOREIGN KEY (target_user_id) REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS admin_audit_log_created_at ON admin_audit_log (created_at, id);
+CREATE INDEX IF NOT EXISTS admin_audit_log_actor_id ON admin_audit_log (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_user_id ON admin_audit_log (target_user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS "password_history"
+(
+    "user_id"       UUID                     NOT NULL,
+    "password_hash" VARCHAR(255)             NOT NULL,
+    "created_at"    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp(),
+    CONSTRAINT fk_password_history_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS password_history_user_id_idx ON password_history (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS "recovery_codes"
+(
+    "id"         UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"    UUID                     NOT NULL,
+    "code_hash"  CHAR(64)                 NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "used_at"    TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_recovery_codes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- external identities (OpenID Connect) linked to the users, subject is unique per provider.
+CREATE TABLE IF NOT EXISTS "user_identities"
+(
+    "id"            UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"       UUID                     NOT NULL,
+    "provider"      VARCHAR(32)              NOT NULL,
+    "subject"       VARCHAR(255)             NOT NULL,
+    "email"         VARCHAR(255)             NOT NULL DEFAULT '',
+    "created_at"    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "last_login_at" TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_user_identities_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT user_identities_provider_subject UNIQUE (provider, subject),
+    CONSTRAINT user_identities_user_provider UNIQUE (user_id, provider)
+);
+
+-- pending OIDC logins and links, state is sent to the provider and only its hash is stored.
+CREATE TABLE IF NOT EXISTS "oidc_login_states"
+(
+    "state_hash"    CHAR(64) PRIMARY KEY,
+    "provider"      VARCHAR(32)              NOT NULL,
+    "code_verifier" VARCHAR(128)             NOT NULL,
+    "nonce"         VARCHAR(64)              NOT NULL,
+    "user_id"       UUID                              DEFAULT NULL,
+    "created_at"    TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "expires_at"    TIMESTAMP WITH TIME ZONE NOT NULL,
+    CONSTRAINT fk_oidc_login_states_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+-- hash of the binding cookie of the browser that started the flow, the callback must present the cookie, see
+-- oidclogin.go.
+ALTER TABLE "oidc_login_states"
+    ADD COLUMN IF NOT EXISTS "binding_hash" CHAR(64) NOT NULL DEFAULT '';
+
+-- API keys of the partner and internal integrations, only the hash of the key is stored.
+CREATE TABLE IF NOT EXISTS "api_keys"
+(
+    "id"           UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "owner_id"     UUID                     NOT NULL,
+    "label"        VARCHAR(64)              NOT NULL,
+    "prefix"       VARCHAR(16)              NOT NULL,
+    "key_hash"     CHAR(64)                 NOT NULL UNIQUE,
+    "scopes"       TEXT[]                   NOT NULL,
+    "expires_at"   TIMESTAMP WITH TIME ZONE NOT NULL,
+    "created_at"   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "last_used_at" TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "usage_count"  BIGINT                   NOT NULL DEFAULT 0,
+    "revoked_at"   TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_api_keys_owner FOREIGN KEY (owner_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS api_keys_owner_id ON api_keys (owner_id);
+
+-- one-time codes sent to the phone numbers, only the keyed hash of the code is stored.
+CREATE TABLE IF NOT EXISTS "phone_verification_codes"
+(
+    "id"           UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"      UUID                     NOT NULL,
+    "phone_number" VARCHAR(20)              NOT NULL,
+    "code_hash"    CHAR(64)                 NOT NULL,
+    "attempts"     SMALLINT                 NOT NULL DEFAULT 0,
+    "created_at"   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "expires_at"   TIMESTAMP WITH TIME ZONE NOT NULL,
+    "used_at"      TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_phone_verification_codes_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS phone_verification_codes_user_id ON phone_verification_codes (user_id, created_at);
+CREATE INDEX IF NOT EXISTS phone_verification_codes_phone_number ON phone_verification_codes (phone_number, created_at);
+
+-- every login of the users, the id is the session id in the ACTIVE tokens. Revoking the row logs the device out.
+CREATE TABLE IF NOT EXISTS "login_history"
+(
+    "id"           UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"      UUID                     NOT NULL,
+    "ip"           inet                              DEFAULT NULL,
+    "user_agent"   VARCHAR(512)             NOT NULL DEFAULT '',
+    "browser"      VARCHAR(32)              NOT NULL,
+    "os"           VARCHAR(32)              NOT NULL,
+    "device_type"  VARCHAR(16)              NOT NULL,
+    "device_hash"  CHAR(64)                 NOT NULL,
+    "country"      VARCHAR(2)               NOT NULL DEFAULT '',
+    "method"       VARCHAR(16)              NOT NULL,
+    "created_at"   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "last_seen_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "expires_at"   TIMESTAMP WITH TIME ZONE NOT NULL,
+    "revoked_at"   TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_login_history_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS login_history_user_id ON login_history (user_id, last_seen_at);
+
+-- personal data exports, built in the background by ProcessDataExports. The archive is stored until expires_at.
+CREATE TABLE IF NOT EXISTS "data_exports"
+(
+    "id"           UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"      UUID                     NOT NULL,
+    "status"       VARCHAR(16)              NOT NULL,
+    "token_hash"   CHAR(64) UNIQUE                   DEFAULT NULL,
+    "file_path"    TEXT                     NOT NULL DEFAULT '',
+    "size"         BIGINT                   NOT NULL DEFAULT 0,
+    "error"        TEXT                              DEFAULT NULL,
+    "created_at"   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "started_at"   TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "completed_at" TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "expires_at"   TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_data_exports_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS data_exports_user_id ON data_exports (user_id, created_at);
+CREATE INDEX IF NOT EXISTS data_exports_status ON data_exports (status, created_at);
+
+-- ledger of the reputation points of the users, rows are never updated. Points of the votes are recorded by
+-- SyncReputation as the difference with the points already recorded for the review or the reply.
+CREATE TABLE IF NOT EXISTS "reputation_events"
+(
+    "id"         BIGSERIAL PRIMARY KEY,
+    "user_id"    UUID                     NOT NULL,
+    "event_type" VARCHAR(32)              NOT NULL,
+    "points"     INTEGER                  NOT NULL,
+    "source_id"  UUID                              DEFAULT NULL,
+    "actor_id"   UUID                              DEFAULT NULL,
+    "reason"     VARCHAR(255)             NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_reputation_events_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_reputation_events_actor FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS reputation_events_user_id ON reputation_events (user_id, created_at);
+CREATE INDEX IF NOT EXISTS reputation_events_source ON reputation_events (event_type, source_id);
+
+-- bans and suspensions issued by the moderators, a user has at most one ban with lifted_at NULL. users.banned and
+-- users.banned_until mirror it, so the token checks do not read this table.
+CREATE TABLE IF NOT EXISTS "user_bans"
+(
+    "id"           UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"      UUID                     NOT NULL,
+    "moderator_id" UUID                              DEFAULT NULL,
+    "reason"       VARCHAR(255)             NOT NULL,
+    "created_at"   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "expires_at"   TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "lifted_at"    TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    "lifted_by"    UUID                              DEFAULT NULL,
+    "lift_reason"  VARCHAR(255)                      DEFAULT NULL,
+    CONSTRAINT fk_user_bans_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_bans_moderator FOREIGN KEY (moderator_id) REFERENCES users (id) ON DELETE SET NULL,
+    CONSTRAINT fk_user_bans_lifted_by FOREIGN KEY (lifted_by) REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS user_bans_user_id ON user_bans (user_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS user_bans_active ON user_bans (user_id) WHERE lifted_at IS NULL;
+
+-- spam checks of the signups, the reviews and the replies, see spam.go.
+CREATE TABLE IF NOT EXISTS "spam_checks"
+(
+    "id"         BIGSERIAL PRIMARY KEY,
+    "user_id"    UUID                     NOT NULL,
+    "source"     VARCHAR(16)              NOT NULL,
+    "source_id"  UUID                              DEFAULT NULL,
+    "score"      SMALLINT                 NOT NULL,
+    "reasons"    TEXT[]                   NOT NULL DEFAULT '{}',
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    CONSTRAINT fk_spam_checks_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS spam_checks_user_id ON spam_checks (user_id, created_at);
+CREATE INDEX IF NOT EXISTS spam_checks_source_id ON spam_checks (source_id);
+
+-- badges are granted once the reputation reaches their threshold and are kept if it drops.
+CREATE TABLE IF NOT EXISTS "user_badges"
+(
+    "user_id"    UUID                     NOT NULL,
+    "badge"      VARCHAR(32)              NOT NULL,
+    "awarded_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, badge),
+    CONSTRAINT fk_user_badges_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS "user_follows"
+(
+    "follower_id" UUID                     NOT NULL,
+    "followee_id" UUID                     NOT NULL,
+    "created_at"  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CONSTRAINT user_follows_not_self CHECK (follower_id <> followee_id),
+    CONSTRAINT fk_user_follows_follower FOREIGN KEY (follower_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_follows_followee FOREIGN KEY (followee_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_follows_followee ON user_follows (followee_id, created_at);
+
+-- materialized feed of the users that follow more than feedInboxFollowingThreshold users, refreshed by
+-- RefreshFeedInboxes; the feed of everyone else is read from the reviews and replies of the followees.
+CREATE TABLE IF NOT EXISTS "feed_inbox"
+(
+    "user_id"    UUID                     NOT NULL,
+    "kind"       VARCHAR(8)               NOT NULL,
+    "item_id"    UUID                     NOT NULL,
+    "author_id"  UUID                     NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (user_id, kind, item_id),
+    CONSTRAINT fk_feed_inbox_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS feed_inbox_user_created_at ON feed_inbox (user_id, created_at);
+
+-- review_id is the review the notification links to, source_id the reply, review or report that caused it.
+CREATE TABLE IF NOT EXISTS "notifications"
+(
+    "id"         UUID PRIMARY KEY                  DEFAULT uuid_generate_v4(),
+    "user_id"    UUID                     NOT NULL,
+    "type"       VARCHAR(16)              NOT NULL,
+    "actor_id"   UUID                              DEFAULT NULL,
+    "review_id"  UUID                              DEFAULT NULL,
+    "source_id"  UUID                              DEFAULT NULL,
+    "message"    VARCHAR(512)             NOT NULL,
+    "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    "read_at"    TIMESTAMP WITH TIME ZONE          DEFAULT NULL,
+    CONSTRAINT fk_notifications_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_notifications_actor FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS notifications_user_created_at ON notifications (user_id, created_at);
+CREATE INDEX IF NOT EXISTS notifications_unread ON notifications (user_id) WHERE read_at IS NULL;
+-- a reply is notified once, NotifyNewReplies scans the replies of the last hour every minute.
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_reply ON notifications (user_id, type, source_id) WHERE type = 'reply';
+
+-- every new notification is published on the notifications channel, the instances LISTEN to it to push the
+-- notifications of their connected users, see NotificationHub.
+CREATE OR REPLACE FUNCTION notify_notification() RETURNS trigger AS
+$$
+BEGIN
+    PERFORM pg_notify('notifications', json_build_object('userId', NEW.user_id, 'id', NEW.id)::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS notifications_notify ON notifications;
+CREATE TRIGGER notifications_notify
+    AFTER INSERT
+    ON notifications
+    FOR EACH ROW
+EXECUTE FUNCTION notify_notification();
 
 CREATE TABLE IF NOT EXISTS "user_reports"
 (
@@ -180,6 +576,7 @@ ALTER TABLE "reviews"
     ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
 ALTER TABLE "reviews"
     ADD FOREIGN KEY ("place_id") REFERENCES "places" ("id");
+CREATE INDEX IF NOT EXISTS reviews_user_id ON reviews (user_id, created_at);
 
 CREATE TABLE IF NOT EXISTS "review_reports"
 (
@@ -211,6 +608,7 @@ ALTER TABLE "review_reply"
     ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
 ALTER TABLE "review_reply"
     ADD FOREIGN KEY ("review_id") REFERENCES "reviews" ("id");
+CREATE INDEX IF NOT EXISTS review_reply_user_id ON review_reply (user_id, created_at);
 
 CREATE TABLE IF NOT EXISTS "review_reply_reports"
 (
@@ -71,6 +71,48 @@ func init() {
 		log.Printf("error scheduling cron: %v", err)
 		// dont panic, just log it
 	}
+	_, err = s.Every(1).Hour().SingletonMode().Do(func() {
+		core.AnonymizeDeletedUsers(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
+	_, err = s.Every(1).Minute().SingletonMode().Do(func() {
+		core.ProcessDataExports(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
+	_, err = s.Every(15).Minutes().SingletonMode().Do(func() {
+		core.SyncReputation(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
+	_, err = s.Every(1).Minute().SingletonMode().Do(func() {
+		core.LiftExpiredBans(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
+	_, err = s.Every(1).Minute().SingletonMode().Do(func() {
+		core.ScoreNewContent(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
+	_, err = s.Every(1).Minute().SingletonMode().Do(func() {
+		core.RefreshFeedInboxes(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
+	_, err = s.Every(1).Minute().SingletonMode().Do(func() {
+		core.NotifyNewReplies(db)
+	})
+	if err != nil {
+		log.Printf("error scheduling cron: %v", err)
+	}
 	fmt.Println("Launching backend...")
 	go func() {
 		backend.LaunchBackend()
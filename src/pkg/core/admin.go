@@ -0,0 +1,834 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/exp/slices"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AuditLogTableName           = "admin_audit_log"
+	AuditLogIDDBField           = "id"
+	AuditLogActorIDDBField      = "actor_id"
+	AuditLogTargetUserIDDBField = "target_user_id"
+	AuditLogActionDBField       = "action"
+	AuditLogDetailsDBField      = "details"
+	AuditLogIPDBField           = "ip"
+	AuditLogCreatedAtDBField    = "created_at"
+)
+
+// actions of the audit records.
+const (
+	auditActionUsersSearch   = "users.search"
+	auditActionUserView      = "users.view"
+	auditActionRoleChange    = "users.role"
+	auditActionForceLogout   = "users.logout"
+	auditActionPasswordReset = "users.password_reset"
+	auditActionBan           = "users.ban"
+	auditActionBanLift       = "users.ban_lift"
+	auditActionAuditView     = "audit.view"
+)
+
+// adminRoles can use the admin endpoints.
+var adminRoles = []string{roleAdmin}
+
+// userRoles are the roles an admin can give to a user.
+var userRoles = []string{roleAdmin, roleModerator, roleEditor, roleUser, roleGuest}
+
+func NewAdminHandler() http.Handler {
+	r := chi.NewRouter()
+	var usersTracer = AssignTracer("/users", "ADMIN", "/users")
+	var auditTracer = AssignTracer("/audit", "ADMIN", "/audit")
+	r.With(usersTracer, JWTWhitelist([]string{tokenStatusActive}, adminRoles)).Route("/users", func(r chi.Router) {
+		r.Get("/", AdminUsersHandler)
+		r.Get("/{id}", AdminUserHandler)
+		r.Put("/{id}/role", AdminUserRoleHandler)
+		r.Post("/{id}/logout", AdminUserLogoutHandler)
+		r.Post("/{id}/password-reset", AdminUserPasswordResetHandler)
+		r.Post("/{id}/impersonate", AdminUserImpersonateHandler)
+	})
+	r.With(auditTracer, JWTWhitelist([]string{tokenStatusActive}, adminRoles)).Get("/audit", AdminAuditLogHandler)
+	return r
+}
+
+// auditInsert returns the insert of an audit record of an action of the actor, on the target user if it is not
+// empty. Run it in the transaction of the action, so the record is written only if the action is.
+func (s Server) auditInsert(r *http.Request, actorID string, targetID string, action string, details map[string]interface{}) (squirrel.InsertBuilder, error) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return squirrel.InsertBuilder{}, err
+	}
+	var target, ip interface{}
+	if targetID != "" {
+		target = targetID
+	}
+	if clientIP := ClientIP(r); clientIP != nil {
+		ip = clientIP.String()
+	}
+	return s.StmtBuilder.Insert(AuditLogTableName).
+		Columns(AuditLogActorIDDBField, AuditLogTargetUserIDDBField, AuditLogActionDBField, AuditLogDetailsDBField, AuditLogIPDBField).
+		Values(actorID, target, action, string(encoded), ip), nil
+}
+
+// RecordAudit writes an audit record of an action that does not change anything, like viewing the users.
+func (s Server) RecordAudit(r *http.Request, actorID string, targetID string, action string, details map[string]interface{}) error {
+	insert, err := s.auditInsert(r, actorID, targetID, action, details)
+	if err != nil {
+		return err
+	}
+	_, err = s.ExecuteSQL(insert)
+	return err
+}
+
+// recordAuditTx writes an audit record in the transaction of the action.
+func (s Server) recordAuditTx(ctx context.Context, tx pgx.Tx, r *http.Request, actorID string, targetID string, action string, details map[string]interface{}) error {
+	insert, err := s.auditInsert(r, actorID, targetID, action, details)
+	if err != nil {
+		return err
+	}
+	sql, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// AdminUser is a user as the admins see it. Banned is true only if the ban is active.
+//
+// swagger:model AdminUser
+type AdminUser struct {
+	ID                    string     `json:"id"`
+	Email                 string     `json:"email"`
+	Username              string     `json:"username"`
+	Role                  string     `json:"role"`
+	Banned                bool       `json:"banned"`
+	BannedUntil           *time.Time `json:"bannedUntil"`
+	Verified              bool       `json:"verified"`
+	PossibleSpammer       bool       `json:"possibleSpammer"`
+	SpamScore             int        `json:"spamScore"`
+	Reputation            int64      `json:"reputation"`
+	TwoFactorEnabled      bool       `json:"twoFactorEnabled"`
+	PasswordResetRequired bool       `json:"passwordResetRequired"`
+	CreatedAt             time.Time  `json:"createdAt"`
+	LastLoginAt           time.Time  `json:"lastLoginAt"`
+	DeletedAt             *time.Time `json:"deletedAt"`
+}
+
+var adminUserColumns = []string{
+	UserIDDBField,
+	UserEmailDBField,
+	UserUsernameDBField,
+	UserRoleDBField,
+	UserBannedDBField,
+	UserBannedUntilDBField,
+	UserVerifiedDBField,
+	UserPossibleSpammerDBField,
+	UserSpamScoreDBField,
+	UserReputationDBField,
+	UserTOTPEnabledDBField,
+	UserPasswordResetRequiredDBField,
+	UserCreatedAtDBField,
+	UserLastLoginAtDBField,
+	UserDeletedAtDBField,
+}
+
+func scanAdminUser(rows pgx.Rows) (AdminUser, error) {
+	var user AdminUser
+	err := rows.Scan(&user.ID, &user.Email, &user.Username, &user.Role, &user.Banned, &user.BannedUntil, &user.Verified,
+		&user.PossibleSpammer, &user.SpamScore, &user.Reputation, &user.TwoFactorEnabled, &user.PasswordResetRequired,
+		&user.CreatedAt, &user.LastLoginAt, &user.DeletedAt)
+	user.Banned = IsBanActive(user.Banned, user.BannedUntil, time.Now())
+	return user, err
+}
+
+// AdminUserFilter is the filter of the users search. Email and Username match a part of the value case-insensitively,
+// the nil filters are not applied.
+type AdminUserFilter struct {
+	Email           string
+	Username        string
+	Role            string
+	Banned          *bool
+	Verified        *bool
+	PossibleSpammer *bool
+	SignedUpAfter   *time.Time
+	SignedUpBefore  *time.Time
+}
+
+// readOptionalBool reads a true or false query parameter, nil if it is not set.
+func readOptionalBool(r *http.Request, name string) (*bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, InvalidAdminFilterError(name)
+	}
+	return &b, nil
+}
+
+// readOptionalTime reads an RFC 3339 query parameter, nil if it is not set.
+func readOptionalTime(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, InvalidAdminFilterError(name)
+	}
+	return &t, nil
+}
+
+// ReadAdminUserFilter reads the filter of the users search from the query parameters.
+func ReadAdminUserFilter(r *http.Request) (AdminUserFilter, error) {
+	query := r.URL.Query()
+	filter := AdminUserFilter{
+		Email:    strings.TrimSpace(query.Get("email")),
+		Username: strings.TrimSpace(query.Get("username")),
+		Role:     strings.ToUpper(strings.TrimSpace(query.Get("role"))),
+	}
+	if filter.Role != "" && !slices.Contains(userRoles, filter.Role) {
+		return filter, InvalidAdminFilterError("role")
+	}
+	var err error
+	if filter.Banned, err = readOptionalBool(r, "banned"); err != nil {
+		return filter, err
+	}
+	if filter.Verified, err = readOptionalBool(r, "verified"); err != nil {
+		return filter, err
+	}
+	if filter.PossibleSpammer, err = readOptionalBool(r, "spammer"); err != nil {
+		return filter, err
+	}
+	if filter.SignedUpAfter, err = readOptionalTime(r, "signedUpAfter"); err != nil {
+		return filter, err
+	}
+	if filter.SignedUpBefore, err = readOptionalTime(r, "signedUpBefore"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// auditDetails returns the filters that are set, for the audit record of the search.
+func (f AdminUserFilter) auditDetails() map[string]interface{} {
+	details := map[string]interface{}{}
+	for name, value := range map[string]string{"email": f.Email, "username": f.Username, "role": f.Role} {
+		if value != "" {
+			details[name] = value
+		}
+	}
+	for name, value := range map[string]*bool{"banned": f.Banned, "verified": f.Verified, "spammer": f.PossibleSpammer} {
+		if value != nil {
+			details[name] = *value
+		}
+	}
+	for name, value := range map[string]*time.Time{"signedUpAfter": f.SignedUpAfter, "signedUpBefore": f.SignedUpBefore} {
+		if value != nil {
+			details[name] = *value
+		}
+	}
+	return details
+}
+
+// adminUsersQuery returns the page of the users matching the filter after the cursor, newest first.
+func adminUsersQuery(sb squirrel.StatementBuilderType, filter AdminUserFilter, cursor *pageCursor, limit uint64) squirrel.SelectBuilder {
+	query := sb.Select(adminUserColumns...).
+		From(UserTableName).
+		OrderBy(fmt.Sprintf("%s DESC", UserCreatedAtDBField), fmt.Sprintf("%s DESC", UserIDDBField)).
+		Limit(limit + 1)
+	if filter.Email != "" {
+		query = query.Where(squirrel.ILike{UserEmailDBField: ContainsPattern(filter.Email)})
+	}
+	if filter.Username != "" {
+		query = query.Where(squirrel.ILike{UserUsernameDBField: ContainsPattern(filter.Username)})
+	}
+	if filter.Role != "" {
+		// the filter is in upper case, the rows written before the roles were normalised may not be.
+		query = query.Where(fmt.Sprintf("UPPER(%s) = ?", UserRoleDBField), filter.Role)
+	}
+	if filter.Banned != nil {
+		active := fmt.Sprintf("(%s AND (%s IS NULL OR %s > NOW()))", UserBannedDBField, UserBannedUntilDBField, UserBannedUntilDBField)
+		if !*filter.Banned {
+			active = "NOT " + active
+		}
+		query = query.Where(active)
+	}
+	if filter.Verified != nil {
+		query = query.Where(squirrel.Eq{UserVerifiedDBField: *filter.Verified})
+	}
+	if filter.PossibleSpammer != nil {
+		query = query.Where(squirrel.Eq{UserPossibleSpammerDBField: *filter.PossibleSpammer})
+	}
+	if filter.SignedUpAfter != nil {
+		query = query.Where(squirrel.GtOrEq{UserCreatedAtDBField: *filter.SignedUpAfter})
+	}
+	if filter.SignedUpBefore != nil {
+		query = query.Where(squirrel.Lt{UserCreatedAtDBField: *filter.SignedUpBefore})
+	}
+	if cursor != nil {
+		query = query.Where(fmt.Sprintf("(%s, %s) < (?, ?)", UserCreatedAtDBField, UserIDDBField), cursor.CreatedAt, cursor.ID)
+	}
+	return query
+}
+
+// AdminUsersResponse is a page of the users, NextCursor is empty on the last page.
+//
+// swagger:model AdminUsersResponse
+type AdminUsersResponse struct {
+	Users      []AdminUser `json:"users"`
+	NextCursor string      `json:"nextCursor"`
+}
+
+// AdminUsersHandler searches the users.
+//
+//	@Summary					Search Users
+//	@Description				Lists the users matching the filters, newest first. Email and username match a part of the value case-insensitively, banned matches the active bans only. The search is recorded in the audit log.
+//	@Tags						Admin
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						email			query		string				false	"Part of the email"
+//	@Param						username		query		string				false	"Part of the username"
+//	@Param						role			query		string				false	"Role"
+//	@Param						banned			query		bool				false	"Banned"
+//	@Param						verified		query		bool				false	"Verified"
+//	@Param						spammer			query		bool				false	"Flagged as a possible spammer"
+//	@Param						signedUpAfter	query		string				false	"Signed up at or after, RFC 3339"
+//	@Param						signedUpBefore	query		string				false	"Signed up before, RFC 3339"
+//	@Param						cursor			query		string				false	"nextCursor of the previous page"
+//	@Param						limit			query		int					false	"Count of users, 20 by default, at most 100"
+//	@Success					200				{object}	AdminUsersResponse	"Users"
+//	@Failure					400				{object}	ErrorResponse		"Invalid filter, cursor or limit"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/admin/users [get]
+func AdminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	filter, err := ReadAdminUserFilter(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	cursor, limit, err := readPageParams(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.RecordAudit(r, jwtContents.UUID, "", auditActionUsersSearch, filter.auditDetails()); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.QuerySQL(adminUsersQuery(s.StmtBuilder, filter, cursor, limit))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	response := AdminUsersResponse{Users: make([]AdminUser, 0, limit)}
+	for rows.Next() {
+		if uint64(len(response.Users)) == limit {
+			last := response.Users[len(response.Users)-1]
+			response.NextCursor = pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+			break
+		}
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		response.Users = append(response.Users, user)
+	}
+	if err = rows.Err(); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
+
+// AdminReport is a report filed about a user or by a user.
+type AdminReport struct {
+	ID string `json:"id"`
+	// UserID is the reported user for the filed reports, the reporter for the received reports.
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Reason   string `json:"reason"`
+	Status   string `json:"status"`
+}
+
+// AdminUserDetailResponse is a user with the report history, the bans and the spam checks.
+//
+// swagger:model AdminUserDetailResponse
+type AdminUserDetailResponse struct {
+	User            AdminUser        `json:"user"`
+	ActiveSessions  int64            `json:"activeSessions"`
+	ReportsReceived []AdminReport    `json:"reportsReceived"`
+	ReportsFiled    []AdminReport    `json:"reportsFiled"`
+	Bans            []UserBan        `json:"bans"`
+	Spam            UserSpamResponse `json:"spam"`
+}
+
+// getUserReports returns the reports about the user, or the reports filed by the user if filed is set.
+func (s Server) getUserReports(uid string, filed bool) ([]AdminReport, error) {
+	field, other := UserReportUserIDDBField, UserReportReporterIDDBField
+	if filed {
+		field, other = UserReportReporterIDDBField, UserReportUserIDDBField
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(
+		fmt.Sprintf("%s.%s", ReportTableName, ReportIDDBField),
+		fmt.Sprintf("%s.%s", UserTableName, UserIDDBField),
+		fmt.Sprintf("%s.%s", UserTableName, UserUsernameDBField),
+		fmt.Sprintf("%s.%s", ReportTableName, ReportReasonDBField),
+		fmt.Sprintf("%s.%s", ReportTableName, ReportStatusDBField)).
+		From(UserReportTableName).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", ReportTableName, ReportTableName, ReportIDDBField, UserReportTableName, UserReportReportIDDBField)).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", UserTableName, UserTableName, UserIDDBField, UserReportTableName, other)).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", UserReportTableName, field): uid}))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reports := make([]AdminReport, 0)
+	for rows.Next() {
+		var report AdminReport
+		if err = rows.Scan(&report.ID, &report.UserID, &report.Username, &report.Reason, &report.Status); err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// GetAdminUser returns the user, UserDoesNotExistError if there is no such user.
+func (s Server) GetAdminUser(uid string) (AdminUser, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(adminUserColumns...).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return AdminUser{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return AdminUser{}, UserDoesNotExistError
+	}
+	return scanAdminUser(rows)
+}
+
+// AdminUserHandler returns a user with the report history, the bans and the spam checks.
+//
+//	@Summary					Get User
+//	@Description				Returns the user with the count of the active sessions, the reports received and filed, the ban history and the latest spam checks. The view is recorded in the audit log.
+//	@Tags						Admin
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Param						id				path		string					true	"User ID"
+//	@Success					200				{object}	AdminUserDetailResponse	"User"
+//	@Failure					404				{object}	ErrorResponse			"User does not exist"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/admin/users/{id} [get]
+func AdminUserHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	var response AdminUserDetailResponse
+	response.User, err = s.GetAdminUser(uid)
+	if err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.RecordAudit(r, jwtContents.UUID, uid, auditActionUserView, nil); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select("COUNT(*)").
+		From(LoginHistoryTableName).
+		Where(squirrel.Eq{LoginHistoryUserIDDBField: uid, LoginHistoryRevokedAtDBField: nil}).
+		Where(squirrel.Gt{LoginHistoryExpiresAtDBField: time.Now()}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if rows.Next() {
+		err = rows.Scan(&response.ActiveSessions)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if response.ReportsReceived, err = s.getUserReports(uid, false); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if response.ReportsFiled, err = s.getUserReports(uid, true); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if response.Bans, err = s.GetUserBans(uid); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if response.Spam, err = s.getUserSpam(uid); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
+
+// AdminUserRoleRequest sets the role of a user.
+//
+// swagger:model AdminUserRoleRequest
+type AdminUserRoleRequest struct {
+	// One of ADMIN, MODERATOR, EDITOR, USER and GUEST.
+	Role string `json:"role" validate:"required,oneof=ADMIN MODERATOR EDITOR USER GUEST"`
+}
+
+// adminUserAction runs the action on the user in a transaction with its audit record, and writes the user after it.
+// The action returns the details of the audit record.
+func adminUserAction(w http.ResponseWriter, r *http.Request, auditAction string, action func(ctx context.Context, tx pgx.Tx, s *Server, actorID string, uid string) (map[string]interface{}, int, error)) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	details, status, err := action(to, tx, s, jwtContents.UUID, uid)
+	if err != nil {
+		s.LogError(err, status)
+		return
+	}
+	if err = s.recordAuditTx(to, tx, r, jwtContents.UUID, uid, auditAction, details); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	user, err := s.GetAdminUser(uid)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(user, http.StatusOK)
+}
+
+// forceLogoutAction signs the user out of every session, the status is the HTTP status code of the error.
+func forceLogoutAction(ctx context.Context, tx pgx.Tx, s *Server, uid string) (int, error) {
+	if err := s.ForceLogout(ctx, tx, uid); err == UserDoesNotExistError {
+		return http.StatusNotFound, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// revokeAPIKeys revokes every API key of the user, they are not bound to a session so the logout does not end them.
+func revokeAPIKeys(ctx context.Context, tx pgx.Tx, s *Server, uid string) (int64, error) {
+	sql, args, err := s.StmtBuilder.Update(APIKeyTableName).
+		Set(APIKeyRevokedAtDBField, time.Now()).
+		Where(squirrel.Eq{APIKeyOwnerIDDBField: uid, APIKeyRevokedAtDBField: nil}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// AdminUserRoleHandler changes the role of a user.
+//
+//	@Summary					Change Role
+//	@Description				Changes the role of the user and signs the user out of every session, so the tokens with the old role are rejected. Admins can not change their own role. ADMIN and MODERATOR users get their role in the tokens only after enabling 2FA. The change is recorded in the audit log.
+//	@Tags						Admin
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Param						id				path		string					true	"User ID"
+//	@Param						body			body		AdminUserRoleRequest	true	"Role"
+//	@Success					200				{object}	AdminUser				"User after the change"
+//	@Failure					400				{object}	ErrorResponse			"Invalid role"
+//	@Failure					403				{object}	ErrorResponse			"Own role can not be changed"
+//	@Failure					404				{object}	ErrorResponse			"User does not exist"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/admin/users/{id}/role [put]
+func AdminUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	adminUserAction(w, r, auditActionRoleChange, func(ctx context.Context, tx pgx.Tx, s *Server, actorID string, uid string) (map[string]interface{}, int, error) {
+		var req AdminUserRoleRequest
+		if err := s.Bind(&req); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		req.Role = strings.ToUpper(req.Role)
+		if err := s.Validator.Struct(req); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if uid == actorID {
+			return nil, http.StatusForbidden, CannotChangeOwnRoleError
+		}
+		var previous string
+		sql, args, err := s.StmtBuilder.Select(UserRoleDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}).Suffix("FOR UPDATE").ToSql()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if err = tx.QueryRow(ctx, sql, args...).Scan(&previous); err == pgx.ErrNoRows {
+			return nil, http.StatusNotFound, UserDoesNotExistError
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		sql, args, err = s.StmtBuilder.Update(UserTableName).
+			Set(UserRoleDBField, req.Role).
+			Set(UserUpdatedAtDBField, time.Now()).
+			Where(squirrel.Eq{UserIDDBField: uid}).
+			ToSql()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if status, err := forceLogoutAction(ctx, tx, s, uid); err != nil {
+			return nil, status, err
+		}
+		return map[string]interface{}{"from": previous, "to": req.Role}, http.StatusOK, nil
+	})
+}
+
+// AdminUserLogoutHandler signs a user out of every session.
+//
+//	@Summary					Force Logout
+//	@Description				Revokes every session, token, refresh token and API key of the user. The logout is recorded in the audit log.
+//	@Tags						Admin
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Param						id				path		string			true	"User ID"
+//	@Success					200				{object}	AdminUser		"User"
+//	@Failure					404				{object}	ErrorResponse	"User does not exist"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/admin/users/{id}/logout [post]
+func AdminUserLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	adminUserAction(w, r, auditActionForceLogout, func(ctx context.Context, tx pgx.Tx, s *Server, actorID string, uid string) (map[string]interface{}, int, error) {
+		if status, err := forceLogoutAction(ctx, tx, s, uid); err != nil {
+			return nil, status, err
+		}
+		revoked, err := revokeAPIKeys(ctx, tx, s, uid)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return map[string]interface{}{"apiKeysRevoked": revoked}, http.StatusOK, nil
+	})
+}
+
+// AdminUserPasswordResetHandler makes a user reset the password.
+//
+//	@Summary					Force Password Reset
+//	@Description				Signs the user out of every session, revokes the API keys and emails a password reset link. The user can not log in with the password until it is reset with the link, logins with the identity providers still work. The reset is recorded in the audit log.
+//	@Tags						Admin
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Param						id				path		string			true	"User ID"
+//	@Success					200				{object}	AdminUser		"User"
+//	@Failure					404				{object}	ErrorResponse	"User does not exist"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/admin/users/{id}/password-reset [post]
+func AdminUserPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	adminUserAction(w, r, auditActionPasswordReset, func(ctx context.Context, tx pgx.Tx, s *Server, actorID string, uid string) (map[string]interface{}, int, error) {
+		var email string
+		sql, args, err := s.StmtBuilder.Update(UserTableName).
+			Set(UserPasswordResetRequiredDBField, true).
+			Set(UserUpdatedAtDBField, time.Now()).
+			Where(squirrel.Eq{UserIDDBField: uid, UserDeletedAtDBField: nil}).
+			Suffix(fmt.Sprintf("RETURNING %s", UserEmailDBField)).
+			ToSql()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if err = tx.QueryRow(ctx, sql, args...).Scan(&email); err == pgx.ErrNoRows {
+			return nil, http.StatusNotFound, UserDoesNotExistError
+		} else if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if status, err := forceLogoutAction(ctx, tx, s, uid); err != nil {
+			return nil, status, err
+		}
+		revoked, err := revokeAPIKeys(ctx, tx, s, uid)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		// the link is sent before the commit, a failed commit only leaves an unused link.
+		if err = s.SendPasswordResetLink(uid, email); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return map[string]interface{}{"apiKeysRevoked": revoked}, http.StatusOK, nil
+	})
+}
+
+// AuditRecord is an action of an admin.
+//
+// swagger:model AuditRecord
+type AuditRecord struct {
+	ID           string                 `json:"id"`
+	ActorID      *string                `json:"actorId"`
+	TargetUserID *string                `json:"targetUserId"`
+	Action       string                 `json:"action"`
+	Details      map[string]interface{} `json:"details"`
+	IP           *string                `json:"ip"`
+	CreatedAt    time.Time              `json:"createdAt"`
+}
+
+// AuditLogResponse is a page of the audit log, NextCursor is empty on the last page.
+//
+// swagger:model AuditLogResponse
+type AuditLogResponse struct {
+	Records    []AuditRecord `json:"records"`
+	NextCursor string        `json:"nextCursor"`
+}
+
+// AdminAuditLogHandler lists the audit log.
+//
+//	@Summary					Get Audit Log
+//	@Description				Lists the audit records of the admins, newest first, filtered by the admin and the user the action is on. Viewing the log is recorded in it too.
+//	@Tags						Admin
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						actorId			query		string				false	"ID of the admin"
+//	@Param						userId			query		string				false	"ID of the user the action is on"
+//	@Param						cursor			query		string				false	"nextCursor of the previous page"
+//	@Param						limit			query		int					false	"Count of records, 20 by default, at most 100"
+//	@Success					200				{object}	AuditLogResponse	"Audit records"
+//	@Failure					400				{object}	ErrorResponse		"Invalid filter, cursor or limit"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/admin/audit [get]
+func AdminAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	cursor, limit, err := readPageParams(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	query := s.StmtBuilder.Select(
+		AuditLogIDDBField,
+		AuditLogActorIDDBField,
+		AuditLogTargetUserIDDBField,
+		AuditLogActionDBField,
+		AuditLogDetailsDBField,
+		fmt.Sprintf("host(%s)", AuditLogIPDBField),
+		AuditLogCreatedAtDBField).
+		From(AuditLogTableName).
+		OrderBy(fmt.Sprintf("%s DESC", AuditLogCreatedAtDBField), fmt.Sprintf("%s DESC", AuditLogIDDBField)).
+		Limit(limit + 1)
+	details := map[string]interface{}{}
+	for param, field := range map[string]string{"actorId": AuditLogActorIDDBField, "userId": AuditLogTargetUserIDDBField} {
+		v := r.URL.Query().Get(param)
+		if v == "" {
+			continue
+		}
+		if _, err = uuid.Parse(v); err != nil {
+			s.LogError(InvalidAdminFilterError(param), http.StatusBadRequest)
+			return
+		}
+		query = query.Where(squirrel.Eq{field: v})
+		details[param] = v
+	}
+	if cursor != nil {
+		query = query.Where(fmt.Sprintf("(%s, %s) < (?, ?)", AuditLogCreatedAtDBField, AuditLogIDDBField), cursor.CreatedAt, cursor.ID)
+	}
+	if err = s.RecordAudit(r, jwtContents.UUID, "", auditActionAuditView, details); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.QuerySQL(query)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	response := AuditLogResponse{Records: make([]AuditRecord, 0, limit)}
+	for rows.Next() {
+		if uint64(len(response.Records)) == limit {
+			last := response.Records[len(response.Records)-1]
+			response.NextCursor = pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+			break
+		}
+		var record AuditRecord
+		if err = rows.Scan(&record.ID, &record.ActorID, &record.TargetUserID, &record.Action, &record.Details, &record.IP, &record.CreatedAt); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		response.Records = append(response.Records, record)
+	}
+	if err = rows.Err(); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
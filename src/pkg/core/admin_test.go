@@ -0,0 +1,64 @@
+package core
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainsPattern(t *testing.T) {
+	assert.Equal(t, "%ali%", ContainsPattern("ali"))
+	assert.Equal(t, `%100\%\_off\\%`, ContainsPattern(`100%_off\`))
+}
+
+func TestReadAdminUserFilter(t *testing.T) {
+	filter, err := ReadAdminUserFilter(httptest.NewRequest("GET", "/users", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, AdminUserFilter{}, filter)
+	assert.Empty(t, filter.auditDetails())
+	filter, err = ReadAdminUserFilter(httptest.NewRequest("GET", "/users?email=%20gmail%20&role=moderator&banned=true&spammer=0&signedUpAfter=2024-05-01T00:00:00Z", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "gmail", filter.Email)
+	assert.Equal(t, roleModerator, filter.Role)
+	assert.True(t, *filter.Banned)
+	assert.Nil(t, filter.Verified)
+	assert.False(t, *filter.PossibleSpammer)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), *filter.SignedUpAfter)
+	assert.Equal(t, map[string]interface{}{
+		"email":         "gmail",
+		"role":          roleModerator,
+		"banned":        true,
+		"spammer":       false,
+		"signedUpAfter": *filter.SignedUpAfter,
+	}, filter.auditDetails())
+	for param, query := range map[string]string{
+		"role":           "role=OWNER",
+		"banned":         "banned=maybe",
+		"verified":       "verified=yes",
+		"signedUpBefore": "signedUpBefore=2024-05-01",
+	} {
+		_, err = ReadAdminUserFilter(httptest.NewRequest("GET", "/users?"+query, nil))
+		assert.Equal(t, InvalidAdminFilterError(param), err, query)
+	}
+}
+
+func TestAdminUsersQuery(t *testing.T) {
+	sb := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	sql, args, err := adminUsersQuery(sb, AdminUserFilter{}, nil, 20).ToSql()
+	assert.Nil(t, err)
+	assert.NotContains(t, sql, "WHERE")
+	assert.True(t, strings.HasSuffix(sql, "ORDER BY created_at DESC, id DESC LIMIT 21"), sql)
+	assert.Empty(t, args)
+	banned := false
+	cursor := pageCursor{CreatedAt: time.Now(), ID: "9b2a4c1e-3f55-4e8e-9a8a-1c2d3e4f5a6b"}
+	sql, args, err = adminUsersQuery(sb, AdminUserFilter{Username: "ali", Role: roleUser, Banned: &banned}, &cursor, 20).ToSql()
+	assert.Nil(t, err)
+	assert.Contains(t, sql, "username ILIKE $1")
+	assert.Contains(t, sql, "UPPER(role) = $2")
+	assert.Contains(t, sql, "NOT (banned AND (banned_until IS NULL OR banned_until > NOW()))")
+	assert.Contains(t, sql, "(created_at, id) < ($3, $4)")
+	assert.Equal(t, []interface{}{"%ali%", roleUser, cursor.CreatedAt, cursor.ID}, args)
+}
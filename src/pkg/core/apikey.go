@@ -0,0 +1,412 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	APIKeyTableName          = "api_keys"
+	APIKeyIDDBField          = "id"
+	APIKeyOwnerIDDBField     = "owner_id"
+	APIKeyLabelDBField       = "label"
+	APIKeyPrefixDBField      = "prefix"
+	APIKeyHashDBField        = "key_hash"
+	APIKeyScopesDBField      = "scopes"
+	APIKeyExpiresAtDBField   = "expires_at"
+	APIKeyCreatedAtDBField   = "created_at"
+	APIKeyLastUsedAtDBField  = "last_used_at"
+	APIKeyUsageCountDBField  = "usage_count"
+	APIKeyRevokedAtDBField   = "revoked_at"
+	APIKeyAuthorizationToken = "ApiKey "
+)
+
+// API key scopes. Every route that accepts API keys names the scope it requires with ScopeWhitelist, add the new
+// scopes to apiKeyScopes as well.
+const (
+	scopeWorldRead  = "world:read"
+	scopePlacesRead = "places:read"
+)
+
+var apiKeyScopes = []string{scopeWorldRead, scopePlacesRead}
+
+const (
+	// apiKeyPrefix is prepended to every key, so leaked keys are easy to find with secret scanners.
+	apiKeyPrefix = "psp_"
+	// apiKeyDisplayLength is the count of the leading characters of the key that are stored in plain text, to tell
+	// the keys apart in the listings.
+	apiKeyDisplayLength = 12
+	apiKeyMaxLifetime   = 365 * 24 * time.Hour
+	apiKeyMaxPerUser    = 20
+)
+
+// APIKeyAuth is the API key the request is authenticated with, see ScopeWhitelist.
+type APIKeyAuth struct {
+	ID      string
+	OwnerID string
+	Role    string
+	Scopes  []string
+}
+
+// APIKey represents an API key of the user, the key itself is never returned after the creation.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	UsageCount int64      `json:"usageCount"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// UserAPIKeyCreateRequest represents the data required to create an API key.
+//
+// swagger:model UserAPIKeyCreateRequest
+type UserAPIKeyCreateRequest struct {
+	// Label to tell the keys apart, e.g. the name of the integration.
+	//
+	// required: true
+	Label string `json:"label" validate:"required,max=64" binding:"required"`
+	// Scopes of the key, world:read and places:read.
+	//
+	// required: true
+	Scopes []string `json:"scopes" validate:"required,min=1" binding:"required"`
+	// ExpiresAt is the expiry of the key, at most a year from now.
+	//
+	// required: true
+	ExpiresAt time.Time `json:"expiresAt" validate:"required" binding:"required"`
+}
+
+// UserAPIKeyCreateResponse contains the created key.
+//
+// swagger:model UserAPIKeyCreateResponse
+type UserAPIKeyCreateResponse struct {
+	APIKey
+	// Key is shown only once, send it as "Authorization: ApiKey {key}".
+	Key string `json:"key"`
+}
+
+// UserAPIKeysResponse lists the API keys of the user.
+//
+// swagger:model UserAPIKeysResponse
+type UserAPIKeysResponse struct {
+	Keys []APIKey `json:"keys"`
+}
+
+// UserAPIKeyRevokeResponse represents the response of a successful revoke.
+//
+// swagger:model UserAPIKeyRevokeResponse
+type UserAPIKeyRevokeResponse struct {
+	Success bool `json:"success"`
+}
+
+// GenerateAPIKey returns a new API key, the part of it that is stored in plain text, and its hash.
+func GenerateAPIKey() (string, string, string, error) {
+	token, _, err := GenerateSecureToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key := apiKeyPrefix + token
+	return key, key[:apiKeyDisplayLength], HashToken(key), nil
+}
+
+// AuthenticateAPIKey looks up the key, and counts the usage of it. Revoked, expired and unknown keys are rejected
+// with InvalidAPIKeyError.
+func (s Server) AuthenticateAPIKey(key string) (APIKeyAuth, error) {
+	updateQuery := s.StmtBuilder.Update(APIKeyTableName).
+		Set(APIKeyUsageCountDBField, squirrel.Expr(APIKeyUsageCountDBField+" + 1")).
+		Set(APIKeyLastUsedAtDBField, time.Now()).
+		Where(squirrel.Eq{APIKeyHashDBField: HashToken(key), APIKeyRevokedAtDBField: nil}).
+		Where(squirrel.Gt{APIKeyExpiresAtDBField: time.Now()}).
+		// keys of the deleted accounts work again only if the account is restored.
+		Where(fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s IS NULL)", APIKeyOwnerIDDBField, UserIDDBField, UserTableName, UserDeletedAtDBField)).
+		Suffix(fmt.Sprintf("RETURNING %s, %s, %s", APIKeyIDDBField, APIKeyOwnerIDDBField, APIKeyScopesDBField))
+	rows, err := s.QuerySQL(updateQuery)
+	if err != nil {
+		return APIKeyAuth{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return APIKeyAuth{}, InvalidAPIKeyError
+	}
+	var auth APIKeyAuth
+	if err = rows.Scan(&auth.ID, &auth.OwnerID, &auth.Scopes); err != nil {
+		return APIKeyAuth{}, err
+	}
+	rows.Close()
+	if err = s.CheckUserBan(auth.OwnerID); err != nil {
+		return APIKeyAuth{}, err
+	}
+	role, totpEnabled, err := s.GetUserRoleAndTwoFactor(auth.OwnerID)
+	if err != nil {
+		return APIKeyAuth{}, err
+	}
+	// keys act with the role the owner would get in a session token.
+	auth.Role = TokenRole(role, totpEnabled)
+	return auth, nil
+}
+
+// ScopeWhitelist accepts the requests authenticated with an API key that has the scope, and whose owner has one of
+// the userRole, the authenticated key is assigned to Server.APIKey. Every other request is passed to
+// JWTWhitelist(tokenStatus, userRole).
+func ScopeWhitelist(scope string, tokenStatus []string, userRole []string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		jwtWhitelisted := JWTWhitelist(tokenStatus, userRole)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, APIKeyAuthorizationToken) {
+				jwtWhitelisted.ServeHTTP(w, r)
+				return
+			}
+			server := r.Context().Value(ServerKeyString).(*Server)
+			auth, err := server.AuthenticateAPIKey(strings.TrimPrefix(header, APIKeyAuthorizationToken))
+			if err == InvalidAPIKeyError {
+				server.LogError(err, http.StatusUnauthorized)
+				return
+			}
+			var banned UserBannedError
+			if errors.As(err, &banned) {
+				server.LogError(err, http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				server.LogError(err, http.StatusInternalServerError)
+				return
+			}
+			if !slices.Contains(auth.Scopes, scope) {
+				server.LogError(APIKeyScopeNotGrantedError(scope), http.StatusForbidden)
+				return
+			}
+			if userRole != nil && !slices.Contains(userRole, auth.Role) {
+				server.LogError(UserNotAllowedError, http.StatusForbidden)
+				return
+			}
+			server.APIKey = &auth
+			r = r.WithContext(context.WithValue(r.Context(), ServerKeyString, server))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestUserID returns the user of the request, the owner of the API key if the request is authenticated with one.
+func (s Server) RequestUserID() (string, error) {
+	if s.APIKey != nil {
+		return s.APIKey.OwnerID, nil
+	}
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		return "", err
+	}
+	return jwtContents.UUID, nil
+}
+
+// GetUserAPIKeys returns the API keys of the user, including the revoked and expired ones.
+func (s Server) GetUserAPIKeys(uid string) ([]APIKey, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(
+		APIKeyIDDBField,
+		APIKeyLabelDBField,
+		APIKeyPrefixDBField,
+		APIKeyScopesDBField,
+		APIKeyExpiresAtDBField,
+		APIKeyCreatedAtDBField,
+		APIKeyLastUsedAtDBField,
+		APIKeyUsageCountDBField,
+		APIKeyRevokedAtDBField).
+		From(APIKeyTableName).
+		Where(squirrel.Eq{APIKeyOwnerIDDBField: uid}).
+		OrderBy(APIKeyCreatedAtDBField + " DESC"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		var key APIKey
+		if err = rows.Scan(&key.ID, &key.Label, &key.Prefix, &key.Scopes, &key.ExpiresAt, &key.CreatedAt, &key.LastUsedAt, &key.UsageCount, &key.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// UserAPIKeyCreateHandler creates an API key for the logged-in user.
+//
+//	@Summary					Create API key
+//	@Description				Creates an API key with the given scopes and expiry. The key is returned only once, send it as "Authorization: ApiKey {key}" to the routes that accept the scope. Keys act with the role of their owner.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						body			body		UserAPIKeyCreateRequest		true	"Label, scopes and expiry of the key"
+//	@Success					201				{object}	UserAPIKeyCreateResponse	"Created key"
+//	@Failure					400				{object}	ErrorResponse				"Unknown scope, invalid expiry, or too many keys"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/api-keys [post]
+func UserAPIKeyCreateHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserAPIKeyCreateRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			s.LogError(InvalidAPIKeyScopeError(scope), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if !req.ExpiresAt.After(time.Now()) || req.ExpiresAt.After(time.Now().Add(apiKeyMaxLifetime)) {
+		s.LogError(InvalidAPIKeyExpiryError(apiKeyMaxLifetime), http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select("COUNT(*)").
+		From(APIKeyTableName).
+		Where(squirrel.Eq{APIKeyOwnerIDDBField: jwtContents.UUID, APIKeyRevokedAtDBField: nil}).
+		Where(squirrel.Gt{APIKeyExpiresAtDBField: time.Now()}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var activeKeys int
+	if rows.Next() {
+		err = rows.Scan(&activeKeys)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if activeKeys >= apiKeyMaxPerUser {
+		s.LogError(TooManyAPIKeysError(apiKeyMaxPerUser), http.StatusBadRequest)
+		return
+	}
+	key, prefix, keyHash, err := GenerateAPIKey()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	insertQuery := s.StmtBuilder.Insert(APIKeyTableName).
+		Columns(APIKeyOwnerIDDBField, APIKeyLabelDBField, APIKeyPrefixDBField, APIKeyHashDBField, APIKeyScopesDBField, APIKeyExpiresAtDBField).
+		Values(jwtContents.UUID, req.Label, prefix, keyHash, scopes, req.ExpiresAt).
+		Suffix(fmt.Sprintf("RETURNING %s, %s", APIKeyIDDBField, APIKeyCreatedAtDBField))
+	rows, err = s.QuerySQL(insertQuery)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	response := UserAPIKeyCreateResponse{
+		APIKey: APIKey{Label: req.Label, Prefix: prefix, Scopes: scopes, ExpiresAt: req.ExpiresAt},
+		Key:    key,
+	}
+	if rows.Next() {
+		err = rows.Scan(&response.ID, &response.CreatedAt)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusCreated)
+}
+
+// UserAPIKeysHandler lists the API keys of the logged-in user.
+//
+//	@Summary					List API keys
+//	@Description				Lists the API keys of the logged-in user with their usage counters and last used timestamps. Keys themselves are not returned.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Success					200				{object}	UserAPIKeysResponse	"API keys"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/user/api-keys [get]
+func UserAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	keys, err := s.GetUserAPIKeys(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserAPIKeysResponse{Keys: keys}, http.StatusOK)
+}
+
+// UserAPIKeyRevokeHandler revokes an API key of the logged-in user.
+//
+//	@Summary					Revoke API key
+//	@Description				Revokes the API key, revoked keys stay in the listing with their usage counters.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						id				path		string						true	"API key id"
+//	@Success					200				{object}	UserAPIKeyRevokeResponse	"Key is revoked"
+//	@Failure					404				{object}	ErrorResponse				"Key does not exist or is already revoked"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/api-keys/{id} [delete]
+func UserAPIKeyRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if _, err = uuid.Parse(id); err != nil {
+		s.LogError(APIKeyDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	res, err := s.ExecuteSQL(s.StmtBuilder.Update(APIKeyTableName).
+		Set(APIKeyRevokedAtDBField, time.Now()).
+		Where(squirrel.Eq{APIKeyIDDBField: id, APIKeyOwnerIDDBField: jwtContents.UUID, APIKeyRevokedAtDBField: nil}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(APIKeyDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	s.WriteResponse(UserAPIKeyRevokeResponse{Success: true}, http.StatusOK)
+}
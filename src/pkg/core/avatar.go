@@ -0,0 +1,420 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// avatarMaxBytes is the maximum size of an uploaded avatar, avatarMaxPixels the maximum width * height, so small
+	// files that decode into huge images are rejected before decoding.
+	avatarMaxBytes    = 5 << 20
+	avatarMaxPixels   = 40000000
+	avatarJPEGQuality = 85
+)
+
+// avatarSizes are the thumbnails generated for every avatar, the original is not stored.
+var avatarSizes = []struct {
+	Name string
+	Size int
+}{
+	{"small", 64},
+	{"medium", 256},
+	{"large", 512},
+}
+
+// AvatarImage is a thumbnail of an avatar.
+type AvatarImage struct {
+	Size        string
+	ContentType string
+	Data        []byte
+}
+
+// AvatarURLs are the public URLs of the thumbnails of an avatar.
+//
+// swagger:model AvatarURLs
+type AvatarURLs struct {
+	// 64x64 thumbnail.
+	Small string `json:"small"`
+	// 256x256 thumbnail.
+	Medium string `json:"medium"`
+	// 512x512 thumbnail.
+	Large string `json:"large"`
+}
+
+// UserAvatarResponse is returned when the avatar is uploaded or deleted.
+//
+// swagger:model UserAvatarResponse
+type UserAvatarResponse struct {
+	// Avatar is null if the user has no avatar.
+	Avatar *AvatarURLs `json:"avatar"`
+}
+
+// SniffImageType returns the content type of the image from its magic bytes, the Content-Type sent by the client is
+// not trusted. Returns an empty string for anything other than JPEG, PNG and WebP.
+func SniffImageType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "image/webp"
+	}
+	return ""
+}
+
+// ProcessAvatar decodes the image and returns its square thumbnails, cropped from the center. The thumbnails are
+// encoded again, so EXIF and every other metadata is stripped; the EXIF orientation of JPEG images is applied first.
+// Opaque images are encoded as JPEG, the images with transparency as PNG. ext is the extension of the thumbnails.
+func ProcessAvatar(data []byte) (images []AvatarImage, ext string, err error) {
+	contentType := SniffImageType(data)
+	if contentType == "" {
+		return nil, "", UnsupportedImageTypeError
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", InvalidImageError
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > avatarMaxPixels {
+		return nil, "", ImageDimensionsTooLargeError
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", InvalidImageError
+	}
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegEXIFOrientation(data)
+	}
+	// the center square is the same before and after the orientation is applied, so the small thumbnails are
+	// oriented instead of the original.
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	crop := image.Rect(0, 0, side, side).Add(bounds.Min).Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+	opaque := true
+	if o, ok := src.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+	ext = "png"
+	if opaque {
+		ext = "jpg"
+	}
+	for _, size := range avatarSizes {
+		thumbnail := image.NewNRGBA(image.Rect(0, 0, size.Size, size.Size))
+		draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), src, crop, draw.Src, nil)
+		oriented := orientImage(thumbnail, orientation)
+		var buf bytes.Buffer
+		avatar := AvatarImage{Size: size.Name}
+		if opaque {
+			avatar.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, oriented, &jpeg.Options{Quality: avatarJPEGQuality})
+		} else {
+			avatar.ContentType = "image/png"
+			err = png.Encode(&buf, oriented)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		avatar.Data = buf.Bytes()
+		images = append(images, avatar)
+	}
+	return images, ext, nil
+}
+
+// jpegEXIFOrientation returns the orientation tag of the EXIF metadata of the JPEG image, 1 (as stored) if there is
+// none.
+func jpegEXIFOrientation(data []byte) int {
+	if !bytes.HasPrefix(data, []byte("\xff\xd8")) {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return 1
+		}
+		marker := data[i+1]
+		// the metadata segments are before the start of scan.
+		if marker == 0xda || marker == 0xd9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag (0x0112) of the first IFD of the TIFF structure of the EXIF segment.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[entry+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientImage returns the square image as it is displayed with the EXIF orientation.
+func orientImage(img *image.NRGBA, orientation int) *image.NRGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	n := img.Bounds().Dx()
+	oriented := image.NewNRGBA(img.Bounds())
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			// (sx, sy) is the pixel of the stored image displayed at (x, y).
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = n - 1 - x
+			case 3:
+				sx, sy = n-1-x, n-1-y
+			case 4:
+				sy = n - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, n-1-x
+			case 7:
+				sx, sy = n-1-y, n-1-x
+			case 8:
+				sx, sy = n-1-y, x
+			}
+			oriented.SetNRGBA(x, y, img.NRGBAAt(sx, sy))
+		}
+	}
+	return oriented
+}
+
+// avatarObjectKey returns the key of the thumbnail of the avatar, the key in users.avatar_key has no size.
+func avatarObjectKey(key string, size string) string {
+	dot := strings.LastIndex(key, ".")
+	return key[:dot] + "-" + size + key[dot:]
+}
+
+// AvatarURLs returns the URLs of the thumbnails of the avatar, nil if the user has no avatar.
+func (s Server) AvatarURLs(key *string) *AvatarURLs {
+	if key == nil || *key == "" {
+		return nil
+	}
+	return &AvatarURLs{
+		Small:  s.Storage.URL(avatarObjectKey(*key, "small")),
+		Medium: s.Storage.URL(avatarObjectKey(*key, "medium")),
+		Large:  s.Storage.URL(avatarObjectKey(*key, "large")),
+	}
+}
+
+// DeleteAvatarObjects removes the thumbnails of the avatar from the storage.
+func DeleteAvatarObjects(ctx context.Context, storage ObjectStorage, key string) error {
+	for _, size := range avatarSizes {
+		if err := storage.Delete(ctx, avatarObjectKey(key, size.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAvatarPart returns the avatar field of the multipart form, the other fields are skipped.
+func readAvatarPart(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, MissingAvatarError
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "avatar" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, avatarMaxBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > avatarMaxBytes {
+			return nil, AvatarTooLargeError
+		}
+		return data, nil
+	}
+}
+
+// replaceAvatar sets the avatar of the user and removes the thumbnails of the previous avatar.
+func (s Server) replaceAvatar(uid string, key *string) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserAvatarKeyDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return err
+	}
+	var previous *string
+	if rows.Next() {
+		err = rows.Scan(&previous)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserAvatarKeyDBField, key).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: uid})); err != nil {
+		return err
+	}
+	if previous != nil && *previous != "" {
+		// the new avatar is saved already, a leftover thumbnail is not worth failing the request.
+		if err = DeleteAvatarObjects(context.Background(), s.Storage, *previous); err != nil {
+			s.Logger.Error(fmt.Sprintf("error deleting previous avatar %s: %v", *previous, err))
+		}
+	}
+	return nil
+}
+
+// UserAvatarUploadHandler replaces the avatar of the user.
+//
+//	@Summary					Upload Avatar
+//	@Description				Uploads a JPEG, PNG or WebP avatar of at most 5 MB as the avatar field of a multipart form. The type is detected from the content, EXIF metadata is stripped and square thumbnails of 64, 256 and 512 pixels are stored. The previous avatar is removed.
+//	@Tags						User
+//	@Accept						multipart/form-data
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						avatar			formData	file				true	"JPEG, PNG or WebP image"
+//	@Success					200				{object}	UserAvatarResponse	"URLs of the thumbnails"
+//	@Failure					400				{object}	ErrorResponse		"Missing, corrupt or too large image"
+//	@Failure					413				{object}	ErrorResponse		"File is larger than 5 MB"
+//	@Failure					415				{object}	ErrorResponse		"Image is not JPEG, PNG or WebP"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/user/avatar [put]
+func UserAvatarUploadHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	// room for the multipart boundaries and the other fields.
+	r.Body = http.MaxBytesReader(w, r.Body, avatarMaxBytes+64<<10)
+	data, err := readAvatarPart(r)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) || err == AvatarTooLargeError {
+			s.LogError(AvatarTooLargeError, http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	images, ext, err := ProcessAvatar(data)
+	if err == UnsupportedImageTypeError {
+		s.LogError(err, http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	// every upload has a new key, so the cached thumbnails of the previous avatar are never shown.
+	key := fmt.Sprintf("avatars/%s/%s.%s", jwtContents.UUID, uuid.NewString(), ext)
+	to, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, avatar := range images {
+		if err = s.Storage.Put(to, avatarObjectKey(key, avatar.Size), avatar.ContentType, avatar.Data); err != nil {
+			_ = DeleteAvatarObjects(to, s.Storage, key)
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = s.replaceAvatar(jwtContents.UUID, &key); err != nil {
+		_ = DeleteAvatarObjects(to, s.Storage, key)
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.WriteResponse(UserAvatarResponse{Avatar: s.AvatarURLs(&key)}, http.StatusOK); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+}
+
+// UserAvatarDeleteHandler removes the avatar of the user.
+//
+//	@Summary					Delete Avatar
+//	@Description				Removes the avatar of the user and its thumbnails.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Success					200				{object}	UserAvatarResponse	"Avatar is null"
+//	@Failure					400				{object}	ErrorResponse		"Invalid token"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/user/avatar [delete]
+func UserAvatarDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.replaceAvatar(jwtContents.UUID, nil); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.WriteResponse(UserAvatarResponse{}, http.StatusOK); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// halfImage returns an image with the left half red and the right half blue.
+func halfImage(width int, height int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, A: alpha})
+			} else {
+				img.SetNRGBA(x, y, color.NRGBA{B: 255, A: alpha})
+			}
+		}
+	}
+	return img
+}
+
+// withEXIFOrientation inserts an EXIF segment with the orientation after the start of image marker of the JPEG.
+func withEXIFOrientation(data []byte, orientation uint16) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry, 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(append(tiff, entry...), 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+	return append(append(append([]byte{0xff, 0xd8}, header...), segment...), data[2:]...)
+}
+
+func TestSniffImageType(t *testing.T) {
+	assert.Equal(t, "image/jpeg", SniffImageType([]byte("\xff\xd8\xff\xe0zort")))
+	assert.Equal(t, "image/png", SniffImageType([]byte("\x89PNG\r\n\x1a\nzort")))
+	assert.Equal(t, "image/webp", SniffImageType([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")))
+	assert.Equal(t, "", SniffImageType([]byte("GIF89a")))
+	assert.Equal(t, "", SniffImageType([]byte("RIFF")))
+}
+
+func TestProcessAvatar(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, halfImage(300, 200, 255)))
+	images, ext, err := ProcessAvatar(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "jpg", ext)
+	assert.Len(t, images, len(avatarSizes))
+	for i, avatar := range images {
+		assert.Equal(t, avatarSizes[i].Name, avatar.Size)
+		assert.Equal(t, "image/jpeg", avatar.ContentType)
+		config, format, err := image.DecodeConfig(bytes.NewReader(avatar.Data))
+		assert.Nil(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, avatarSizes[i].Size, config.Width)
+		assert.Equal(t, avatarSizes[i].Size, config.Height)
+	}
+	buf.Reset()
+	assert.Nil(t, png.Encode(&buf, halfImage(64, 64, 128)))
+	images, ext, err = ProcessAvatar(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "png", ext)
+	assert.Equal(t, "image/png", images[0].ContentType)
+	_, _, err = ProcessAvatar([]byte("GIF89a"))
+	assert.Equal(t, UnsupportedImageTypeError, err)
+	_, _, err = ProcessAvatar(buf.Bytes()[:40])
+	assert.Equal(t, InvalidImageError, err)
+	// a header claiming 10000x10000 pixels is rejected before decoding.
+	header := append([]byte{}, buf.Bytes()[:33]...)
+	binary.BigEndian.PutUint32(header[16:], 10000)
+	binary.BigEndian.PutUint32(header[20:], 10000)
+	binary.BigEndian.PutUint32(header[29:], crc32.ChecksumIEEE(header[12:29]))
+	_, _, err = ProcessAvatar(header)
+	assert.Equal(t, ImageDimensionsTooLargeError, err)
+}
+
+func TestProcessAvatarEXIF(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&buf, halfImage(100, 100, 255), nil))
+	data := withEXIFOrientation(buf.Bytes(), 6)
+	assert.Equal(t, 6, jpegEXIFOrientation(data))
+	assert.Equal(t, 1, jpegEXIFOrientation(buf.Bytes()))
+	images, _, err := ProcessAvatar(data)
+	assert.Nil(t, err)
+	large := images[len(images)-1]
+	assert.NotContains(t, string(large.Data), "Exif")
+	img, err := jpeg.Decode(bytes.NewReader(large.Data))
+	assert.Nil(t, err)
+	// rotated 90 degrees clockwise, the red left half is at the top.
+	r, _, b, _ := img.At(256, 50).RGBA()
+	assert.Greater(t, r, b)
+	r, _, b, _ = img.At(256, 460).RGBA()
+	assert.Greater(t, b, r)
+}
+
+func TestOrientImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	marker := color.NRGBA{R: 255, A: 255}
+	img.SetNRGBA(0, 0, marker)
+	// the top left pixel is displayed at these positions.
+	for orientation, position := range map[int]image.Point{1: {0, 0}, 2: {1, 0}, 3: {1, 1}, 4: {0, 1}, 5: {0, 0}, 6: {1, 0}, 7: {1, 1}, 8: {0, 1}} {
+		assert.Equal(t, marker, orientImage(img, orientation).NRGBAAt(position.X, position.Y), "orientation %d", orientation)
+	}
+}
+
+func TestAvatarObjectKey(t *testing.T) {
+	assert.Equal(t, "avatars/zort/1234-small.jpg", avatarObjectKey("avatars/zort/1234.jpg", "small"))
+}
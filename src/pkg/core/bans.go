@@ -0,0 +1,505 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Moderators ban users permanently, or suspend them until expires_at, with a reason. Every ban is kept in user_bans as
+// the history of the user; the active one, if any, has lifted_at NULL and is mirrored in users.banned and
+// users.banned_until, which CheckTokenRevoked and the login handlers read. LiftExpiredBans lifts the suspensions once
+// they are over, they are not enforced past banned_until in the meantime.
+
+const (
+	UserBanTableName          = "user_bans"
+	UserBanIDDBField          = "id"
+	UserBanUserIDDBField      = "user_id"
+	UserBanModeratorIDDBField = "moderator_id"
+	UserBanReasonDBField      = "reason"
+	UserBanCreatedAtDBField   = "created_at"
+	UserBanExpiresAtDBField   = "expires_at"
+	UserBanLiftedAtDBField    = "lifted_at"
+	UserBanLiftedByDBField    = "lifted_by"
+	UserBanLiftReasonDBField  = "lift_reason"
+)
+
+// lift reasons of the bans lifted without a moderator request.
+const (
+	userBanLiftReasonExpired  = "expired"
+	userBanLiftReasonReplaced = "replaced by a new ban"
+)
+
+// IsBanActive reports whether the users.banned and users.banned_until of a user ban them at now.
+func IsBanActive(banned bool, bannedUntil *time.Time, now time.Time) bool {
+	return banned && (bannedUntil == nil || bannedUntil.After(now))
+}
+
+// banError returns the UserBannedError of the active ban of the user.
+func (s Server) banError(uid string, bannedUntil *time.Time) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserBanReasonDBField).
+		From(UserBanTableName).
+		Where(squirrel.Eq{UserBanUserIDDBField: uid, UserBanLiftedAtDBField: nil}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	banned := UserBannedError{ExpiresAt: bannedUntil}
+	if rows.Next() {
+		if err = rows.Scan(&banned.Reason); err != nil {
+			return err
+		}
+	}
+	return banned
+}
+
+// CheckUserBan returns UserBannedError if the user is banned. The login handlers call it before issuing a session,
+// the tokens are checked by CheckTokenRevoked.
+func (s Server) CheckUserBan(uid string) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserBannedDBField, UserBannedUntilDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return err
+	}
+	var banned bool
+	var bannedUntil *time.Time
+	if rows.Next() {
+		err = rows.Scan(&banned, &bannedUntil)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if !IsBanActive(banned, bannedUntil, time.Now()) {
+		return nil
+	}
+	return s.banError(uid, bannedUntil)
+}
+
+// UserBan is a ban or a suspension of a user. Bans without ExpiresAt are permanent, lifted bans have LiftedAt.
+//
+// swagger:model UserBan
+type UserBan struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"userId"`
+	ModeratorID *string    `json:"moderatorId"`
+	Reason      string     `json:"reason"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	LiftedAt    *time.Time `json:"liftedAt"`
+	LiftedBy    *string    `json:"liftedBy"`
+	LiftReason  *string    `json:"liftReason"`
+}
+
+// liftActiveBan lifts the active ban of the user, if any. liftedBy is nil for the bans lifted automatically. Returns
+// whether the user had an active ban.
+func liftActiveBan(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, uid string, liftedBy *string, reason string) (bool, error) {
+	sql, args, err := stmtBuilder.Update(UserBanTableName).
+		Set(UserBanLiftedAtDBField, time.Now()).
+		Set(UserBanLiftedByDBField, liftedBy).
+		Set(UserBanLiftReasonDBField, reason).
+		Where(squirrel.Eq{UserBanUserIDDBField: uid, UserBanLiftedAtDBField: nil}).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+	tag, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// checkBanTarget locks the user and returns UserNotAllowedError if the moderator can not ban the user or lift the ban.
+// Users with the ADMIN role can not be banned, and MODERATOR users only by admins.
+func checkBanTarget(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, uid string, moderatorRole string) error {
+	sql, args, err := stmtBuilder.Select(UserRoleDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	var role string
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&role); err == pgx.ErrNoRows {
+		return UserDoesNotExistError
+	} else if err != nil {
+		return err
+	}
+	role, moderatorRole = strings.ToUpper(role), strings.ToUpper(moderatorRole)
+	if role == roleAdmin || (role == roleModerator && moderatorRole != roleAdmin) {
+		return UserNotAllowedError
+	}
+	return nil
+}
+
+// IssueBan bans the user until expiresAt, or permanently if it is nil, replacing the active ban. UserNotAllowedError is
+// returned if the moderator can not ban the user, see checkBanTarget.
+func IssueBan(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, uid string, moderatorID string, moderatorRole string, reason string, expiresAt *time.Time) (UserBan, error) {
+	if err := checkBanTarget(ctx, tx, stmtBuilder, uid, moderatorRole); err != nil {
+		return UserBan{}, err
+	}
+	if _, err := liftActiveBan(ctx, tx, stmtBuilder, uid, &moderatorID, userBanLiftReasonReplaced); err != nil {
+		return UserBan{}, err
+	}
+	ban := UserBan{UserID: uid, ModeratorID: &moderatorID, Reason: reason, ExpiresAt: expiresAt}
+	sql, args, err := stmtBuilder.Insert(UserBanTableName).
+		Columns(UserBanUserIDDBField, UserBanModeratorIDDBField, UserBanReasonDBField, UserBanExpiresAtDBField).
+		Values(uid, moderatorID, reason, expiresAt).
+		Suffix(fmt.Sprintf("RETURNING %s, %s", UserBanIDDBField, UserBanCreatedAtDBField)).
+		ToSql()
+	if err != nil {
+		return UserBan{}, err
+	}
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&ban.ID, &ban.CreatedAt); err != nil {
+		return UserBan{}, err
+	}
+	sql, args, err = stmtBuilder.Update(UserTableName).
+		Set(UserBannedDBField, true).
+		Set(UserBannedUntilDBField, expiresAt).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		ToSql()
+	if err != nil {
+		return UserBan{}, err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return ban, err
+}
+
+// LiftBan lifts the active ban of the user, UserNotBannedError is returned if there is none. The moderators that can
+// not ban the user can not lift the ban either, UserNotAllowedError is returned for them; see checkBanTarget.
+func LiftBan(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, uid string, moderatorID string, moderatorRole string, reason string) error {
+	if err := checkBanTarget(ctx, tx, stmtBuilder, uid, moderatorRole); err != nil {
+		return err
+	}
+	lifted, err := liftActiveBan(ctx, tx, stmtBuilder, uid, &moderatorID, reason)
+	if err != nil {
+		return err
+	}
+	if !lifted {
+		return UserNotBannedError
+	}
+	sql, args, err := stmtBuilder.Update(UserTableName).
+		Set(UserBannedDBField, false).
+		Set(UserBannedUntilDBField, nil).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// LiftExpiredBans lifts the suspensions that are over, it is scheduled in main.go.
+func LiftExpiredBans(db *pgxpool.Pool) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	tx, err := db.Begin(to)
+	if err != nil {
+		log.Printf("error lifting expired bans: %v", err)
+		return
+	}
+	defer tx.Rollback(to)
+	now := time.Now()
+	sql, args, err := stmtBuilder.Update(UserBanTableName).
+		Set(UserBanLiftedAtDBField, now).
+		Set(UserBanLiftReasonDBField, userBanLiftReasonExpired).
+		Where(squirrel.Eq{UserBanLiftedAtDBField: nil}).
+		Where(squirrel.LtOrEq{UserBanExpiresAtDBField: now}).
+		ToSql()
+	if err != nil {
+		log.Printf("error lifting expired bans: %v", err)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		log.Printf("error lifting expired bans: %v", err)
+		return
+	}
+	sql, args, err = stmtBuilder.Update(UserTableName).
+		Set(UserBannedDBField, false).
+		Set(UserBannedUntilDBField, nil).
+		Where(squirrel.Eq{UserBannedDBField: true}).
+		Where(squirrel.LtOrEq{UserBannedUntilDBField: now}).
+		ToSql()
+	if err != nil {
+		log.Printf("error lifting expired bans: %v", err)
+		return
+	}
+	tag, err := tx.Exec(to, sql, args...)
+	if err != nil {
+		log.Printf("error lifting expired bans: %v", err)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		log.Printf("error lifting expired bans: %v", err)
+		return
+	}
+	if tag.RowsAffected() > 0 {
+		log.Printf("lifted %d expired bans", tag.RowsAffected())
+	}
+}
+
+// GetUserBans returns the bans of the user, the latest first.
+func (s Server) GetUserBans(uid string) ([]UserBan, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(
+		UserBanIDDBField,
+		UserBanUserIDDBField,
+		UserBanModeratorIDDBField,
+		UserBanReasonDBField,
+		UserBanCreatedAtDBField,
+		UserBanExpiresAtDBField,
+		UserBanLiftedAtDBField,
+		UserBanLiftedByDBField,
+		UserBanLiftReasonDBField).
+		From(UserBanTableName).
+		Where(squirrel.Eq{UserBanUserIDDBField: uid}).
+		OrderBy(fmt.Sprintf("%s DESC", UserBanCreatedAtDBField)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := make([]UserBan, 0)
+	for rows.Next() {
+		var ban UserBan
+		if err = rows.Scan(&ban.ID, &ban.UserID, &ban.ModeratorID, &ban.Reason, &ban.CreatedAt, &ban.ExpiresAt, &ban.LiftedAt, &ban.LiftedBy, &ban.LiftReason); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+// ModeratorBanRequest bans a user until ExpiresAt, or permanently if it is omitted.
+//
+// swagger:model ModeratorBanRequest
+type ModeratorBanRequest struct {
+	Reason    string     `json:"reason" validate:"required,max=255"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// ModeratorBanLiftRequest is the reason a ban is lifted.
+//
+// swagger:model ModeratorBanLiftRequest
+type ModeratorBanLiftRequest struct {
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// UserBansResponse is the ban history of a user.
+//
+// swagger:model UserBansResponse
+type UserBansResponse struct {
+	Bans []UserBan `json:"bans"`
+}
+
+// moderatedUserID returns the id in the path, or UserDoesNotExistError if it is not a UUID.
+func moderatedUserID(r *http.Request) (string, error) {
+	uid := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(uid); err != nil {
+		return "", UserDoesNotExistError
+	}
+	return uid, nil
+}
+
+// ModeratorBanHandler bans or suspends a user.
+//
+//	@Summary					Ban User
+//	@Description				Bans the user permanently, or suspends the user until expiresAt. The active ban of the user is replaced. Banned users can not log in, and their tokens and API keys are rejected with the USER_BANNED or USER_SUSPENDED error code. Admins can not be banned, moderators only by admins. The bans issued by admins are recorded in the audit log.
+//	@Tags						Moderation
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						id				path		string				true	"User ID"
+//	@Param						body			body		ModeratorBanRequest	true	"Reason and end of the suspension"
+//	@Success					201				{object}	UserBan				"Issued ban"
+//	@Failure					400				{object}	ErrorResponse		"Invalid request, or expiresAt is in the past"
+//	@Failure					403				{object}	ErrorResponse		"User can not be banned by the moderator"
+//	@Failure					404				{object}	ErrorResponse		"User does not exist"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/moderation/users/{id}/bans [post]
+func ModeratorBanHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	var req ModeratorBanRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		s.LogError(BanExpiryInPastError, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	ban, err := IssueBan(to, tx, s.StmtBuilder, uid, jwtContents.UUID, jwtContents.Role, req.Reason, req.ExpiresAt)
+	switch {
+	case err == UserDoesNotExistError:
+		s.LogError(err, http.StatusNotFound)
+		return
+	case err == UserNotAllowedError:
+		s.LogError(err, http.StatusForbidden)
+		return
+	case err != nil:
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// the bans of the admins are in the audit log with their other actions.
+	if jwtContents.Role == roleAdmin {
+		details := map[string]interface{}{"banId": ban.ID, "reason": req.Reason, "expiresAt": req.ExpiresAt}
+		if err = s.recordAuditTx(to, tx, r, jwtContents.UUID, uid, auditActionBan, details); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(ban, http.StatusCreated)
+}
+
+// ModeratorBanLiftHandler lifts the active ban of a user.
+//
+//	@Summary					Lift Ban
+//	@Description				Lifts the active ban or suspension of the user, it is kept in the history with the reason. Same as the bans, only admins lift the bans of the moderators. The lifts by admins are recorded in the audit log.
+//	@Tags						Moderation
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Param						id				path		string					true	"User ID"
+//	@Param						body			body		ModeratorBanLiftRequest	true	"Reason"
+//	@Success					200				{object}	UserBansResponse		"Ban history of the user"
+//	@Failure					400				{object}	ErrorResponse			"Invalid request"
+//	@Failure					403				{object}	ErrorResponse			"Ban of the user can not be lifted by the moderator"
+//	@Failure					404				{object}	ErrorResponse			"User does not exist or is not banned"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/moderation/users/{id}/bans/lift [post]
+func ModeratorBanLiftHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	var req ModeratorBanLiftRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	err = LiftBan(to, tx, s.StmtBuilder, uid, jwtContents.UUID, jwtContents.Role, req.Reason)
+	switch {
+	case err == UserNotBannedError, err == UserDoesNotExistError:
+		s.LogError(err, http.StatusNotFound)
+		return
+	case err == UserNotAllowedError:
+		s.LogError(err, http.StatusForbidden)
+		return
+	case err != nil:
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if jwtContents.Role == roleAdmin {
+		if err = s.recordAuditTx(to, tx, r, jwtContents.UUID, uid, auditActionBanLift, map[string]interface{}{"reason": req.Reason}); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	bans, err := s.GetUserBans(uid)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserBansResponse{Bans: bans}, http.StatusOK)
+}
+
+// ModeratorBansHandler returns the ban history of a user.
+//
+//	@Summary					Get Ban History
+//	@Description				Returns the bans and the suspensions of the user, the latest first.
+//	@Tags						Moderation
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						id				path		string				true	"User ID"
+//	@Success					200				{object}	UserBansResponse	"Ban history of the user"
+//	@Failure					404				{object}	ErrorResponse		"User does not exist"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/moderation/users/{id}/bans [get]
+func ModeratorBansHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	bans, err := s.GetUserBans(uid)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserBansResponse{Bans: bans}, http.StatusOK)
+}
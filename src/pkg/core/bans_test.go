@@ -0,0 +1,43 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsBanActive(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	assert.False(t, IsBanActive(false, nil, now))
+	assert.False(t, IsBanActive(false, &future, now))
+	assert.True(t, IsBanActive(true, nil, now))
+	assert.True(t, IsBanActive(true, &future, now))
+	assert.False(t, IsBanActive(true, &past, now))
+	assert.False(t, IsBanActive(true, &now, now))
+}
+
+func TestUserBannedErrorResponse(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	response := NewErrorResponse(UserBannedError{Reason: "spam", ExpiresAt: &expiresAt}, "req")
+	assert.Equal(t, errorCodeUserSuspended, response.Code)
+	assert.Equal(t, "user is suspended until 2030-01-02T03:04:05Z: spam", response.Error)
+	body, err := json.Marshal(response)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `"expiresAt":"2030-01-02T03:04:05Z"`)
+	response = NewErrorResponse(fmt.Errorf("login: %w", UserBannedError{Reason: "spam"}), "req")
+	assert.Equal(t, errorCodeUserBanned, response.Code)
+	assert.Nil(t, response.ExpiresAt)
+	body, err = json.Marshal(NewErrorResponse(UserDoesNotExistError, "req"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(body), `"code"`)
+	assert.NotContains(t, string(body), `"expiresAt"`)
+}
+
+func TestTokenErrorStatus(t *testing.T) {
+	assert.Equal(t, http.StatusForbidden, TokenErrorStatus(UserBannedError{Reason: "spam"}))
+	assert.Equal(t, http.StatusUnauthorized, TokenErrorStatus(TokenRevokedError))
+}
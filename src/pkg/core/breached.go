@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+)
+
+// BloomFilter is a set of SHA-1 digests of breached passwords, that answers "possibly in the set" or "definitely not
+// in the set". It is built offline from a breached-password corpus with cmd/breachfilter, and loaded at startup, so
+// password checks need no network.
+//
+// Bit positions are derived from the digest itself with double hashing, digests are already uniformly distributed.
+type BloomFilter struct {
+	// m is the count of bits, k is the count of bit positions per digest.
+	m    uint64
+	k    uint32
+	bits []uint64
+}
+
+// bloomFilterMagic starts every serialized filter, the last byte is the format version.
+var bloomFilterMagic = [8]byte{'P', 'S', 'P', 'B', 'L', 'O', 'M', 1}
+
+// NewBloomFilter returns an empty filter sized for n digests with the false positive rate fpRate.
+func NewBloomFilter(n uint64, fpRate float64) *BloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &BloomFilter{m: m, k: k, bits: make([]uint64, (m+63)/64)}
+}
+
+func (b *BloomFilter) positions(digest [sha1.Size]byte, fn func(bit uint64) bool) {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	for i := uint64(0); i < uint64(b.k); i++ {
+		if !fn((h1 + i*h2) % b.m) {
+			return
+		}
+	}
+}
+
+// Add adds the SHA-1 digest of a password to the filter.
+func (b *BloomFilter) Add(digest [sha1.Size]byte) {
+	b.positions(digest, func(bit uint64) bool {
+		b.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+// Contains reports whether the SHA-1 digest is possibly in the filter.
+func (b *BloomFilter) Contains(digest [sha1.Size]byte) bool {
+	found := true
+	b.positions(digest, func(bit uint64) bool {
+		found = b.bits[bit/64]&(1<<(bit%64)) != 0
+		return found
+	})
+	return found
+}
+
+// ContainsPassword reports whether the password is possibly breached.
+func (b *BloomFilter) ContainsPassword(password string) bool {
+	return b.Contains(sha1.Sum([]byte(password)))
+}
+
+// WriteTo serializes the filter.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	buf := bufio.NewWriter(w)
+	var written int64
+	header := make([]byte, 0, 20)
+	header = append(header, bloomFilterMagic[:]...)
+	header = binary.BigEndian.AppendUint64(header, b.m)
+	header = binary.BigEndian.AppendUint32(header, b.k)
+	n, err := buf.Write(header)
+	written += int64(n)
+	if err != nil {
+		return written, err
+	}
+	word := make([]byte, 8)
+	for _, bits := range b.bits {
+		binary.LittleEndian.PutUint64(word, bits)
+		n, err = buf.Write(word)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, buf.Flush()
+}
+
+// ReadBloomFilter reads a filter serialized with WriteTo.
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	buf := bufio.NewReader(r)
+	header := make([]byte, 20)
+	if _, err := io.ReadFull(buf, header); err != nil {
+		return nil, err
+	}
+	if [8]byte(header[:8]) != bloomFilterMagic {
+		return nil, InvalidBloomFilterError
+	}
+	b := &BloomFilter{m: binary.BigEndian.Uint64(header[8:16]), k: binary.BigEndian.Uint32(header[16:20])}
+	if b.m == 0 || b.k == 0 {
+		return nil, InvalidBloomFilterError
+	}
+	b.bits = make([]uint64, (b.m+63)/64)
+	word := make([]byte, 8)
+	for i := range b.bits {
+		if _, err := io.ReadFull(buf, word); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+				return nil, InvalidBloomFilterError
+			}
+			return nil, err
+		}
+		b.bits[i] = binary.LittleEndian.Uint64(word)
+	}
+	return b, nil
+}
+
+var (
+	breachedPasswordsOnce   sync.Once
+	breachedPasswordsFilter *BloomFilter
+)
+
+// BreachedPasswords returns the filter at BREACHED_PASSWORDS_FILTER in the environment (or in the .env file), it is
+// read once. Returns nil if the variable is not set or the filter can not be read, the breached password check is
+// skipped then.
+func BreachedPasswords() *BloomFilter {
+	breachedPasswordsOnce.Do(func() {
+		path := os.Getenv("BREACHED_PASSWORDS_FILTER")
+		if path == "" {
+			log.Printf("BREACHED_PASSWORDS_FILTER is not set, passwords are not checked against breached passwords")
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("could not open the breached passwords filter: %v", err)
+			return
+		}
+		defer file.Close()
+		breachedPasswordsFilter, err = ReadBloomFilter(file)
+		if err != nil {
+			log.Printf("could not read the breached passwords filter: %v", err)
+		}
+	})
+	return breachedPasswordsFilter
+}
+
+// CheckPasswordNotBreached returns BreachedPasswordError if the password is in the breached passwords filter. Call it
+// after the passwordSpec validation of every new password.
+func CheckPasswordNotBreached(password string) error {
+	filter := BreachedPasswords()
+	if filter != nil && filter.ContainsPassword(password) {
+		return BreachedPasswordError
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter := NewBloomFilter(1000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.Add(sha1.Sum([]byte(fmt.Sprintf("breached-%d", i))))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.ContainsPassword(fmt.Sprintf("breached-%d", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.ContainsPassword(fmt.Sprintf("safe-%d", i)) {
+			falsePositives++
+		}
+	}
+	// 10 expected, leave room for the randomness.
+	assert.Less(t, falsePositives, 50)
+}
+
+func TestBloomFilterSerialization(t *testing.T) {
+	filter := NewBloomFilter(10, 0.01)
+	filter.Add(sha1.Sum([]byte(TestPassword)))
+	var buf bytes.Buffer
+	_, err := filter.WriteTo(&buf)
+	assert.Nil(t, err)
+	read, err := ReadBloomFilter(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, filter, read)
+	assert.True(t, read.ContainsPassword(TestPassword))
+	_, err = ReadBloomFilter(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Equal(t, InvalidBloomFilterError, err)
+	_, err = ReadBloomFilter(bytes.NewReader(append([]byte("NOTBLOOM"), buf.Bytes()[8:]...)))
+	assert.Equal(t, InvalidBloomFilterError, err)
+}
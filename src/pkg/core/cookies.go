@@ -0,0 +1,192 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/Masterminds/squirrel"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// In the cookie session mode the session token is kept in an HttpOnly cookie instead of the storage of the web app,
+// and GetJWTData reads it from the cookie if the request has no Authorization header. The mode is asked for with
+// ?session=cookie on the requests that return a session token, such as the login, and kept while the requests are
+// authenticated with the cookie.
+//
+// The unsafe requests authenticated with the cookie must send the value of the CSRF cookie in the X-CSRF-Token header,
+// see CSRFProtect. The CSRF token is derived from the session token, so a CSRF cookie set by another site or a
+// subdomain does not match.
+const (
+	sessionCookieName = "persephone_session"
+	csrfCookieName    = "persephone_csrf"
+	csrfHeaderName    = "X-CSRF-Token"
+	sessionModeParam  = "session"
+	sessionModeCookie = "cookie"
+)
+
+// CSRFToken returns the CSRF token of the session token.
+func CSRFToken(sessionToken string) string {
+	mac := hmac.New(sha256.New, []byte(JWT_ENCRYPT_KEY))
+	mac.Write([]byte("csrf|" + sessionToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionCookieAuthKey marks the requests that had no Authorization header and carried a valid session cookie, see
+// CSRFProtect. The handlers replace the Authorization header before GetUser, so it can not be checked there.
+type sessionCookieAuthKey struct{}
+
+// SessionCookieMode reports whether the session token of the response must be set in the cookies instead of the body.
+// It is asked for with ?session=cookie, and kept while the requests are authenticated with the session cookie. A
+// request with an Authorization header is not in the cookie session mode even if the browser sent a cookie.
+func SessionCookieMode(r *http.Request) bool {
+	if r.URL.Query().Get(sessionModeParam) == sessionModeCookie {
+		return true
+	}
+	cookieAuth, _ := r.Context().Value(sessionCookieAuthKey{}).(bool)
+	return cookieAuth
+}
+
+// sessionCookieAuthenticated reports whether the request has no Authorization header and a valid session token in
+// the cookie. The token may still be revoked, the handlers check it.
+func sessionCookieAuthenticated(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	if _, ok := sessionCookieToken(r); !ok {
+		return false
+	}
+	_, err := Server{Request: r}.GetJWTData()
+	return err == nil
+}
+
+// sessionCookieToken returns the session token in the cookie of the request.
+func sessionCookieToken(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// SetSessionCookies sets the session cookie and its CSRF cookie. The CSRF cookie is readable by the web app, it sends
+// the value back in the X-CSRF-Token header.
+func SetSessionCookies(w http.ResponseWriter, sessionToken string) {
+	maxAge := int(tokenDurationActive.Seconds())
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionToken,
+		Path:     "/api",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    CSRFToken(sessionToken),
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSessionCookies removes the session cookie and its CSRF cookie.
+func ClearSessionCookies(w http.ResponseWriter) {
+	for name, path := range map[string]string{sessionCookieName: "/api", csrfCookieName: "/"} {
+		http.SetCookie(w, &http.Cookie{Name: name, Path: path, MaxAge: -1, HttpOnly: name == sessionCookieName, Secure: true, SameSite: http.SameSiteLaxMode})
+	}
+}
+
+// CheckCSRF returns CSRFTokenMismatchError if the request is authenticated with the session cookie, is not safe, and
+// the X-CSRF-Token header is not the CSRF token of the session. Requests with an Authorization header are not sent by
+// the browsers on their own, so they are not checked.
+func CheckCSRF(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return nil
+	}
+	if r.Header.Get("Authorization") != "" {
+		return nil
+	}
+	token, ok := sessionCookieToken(r)
+	if !ok {
+		return nil
+	}
+	if !hmac.Equal([]byte(r.Header.Get(csrfHeaderName)), []byte(CSRFToken(token))) {
+		return CSRFTokenMismatchError
+	}
+	return nil
+}
+
+// CSRFProtect rejects the unsafe requests authenticated with the session cookie that fail CheckCSRF, and marks the
+// requests authenticated with the session cookie for SessionCookieMode.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := CheckCSRF(r); err != nil {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			server.LogError(err, http.StatusForbidden)
+			return
+		}
+		if sessionCookieAuthenticated(r) {
+			r = r.WithContext(context.WithValue(r.Context(), sessionCookieAuthKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SessionCookieOrigins returns the origins of the web apps allowed to send the session cookie cross-origin, from the
+// comma separated SESSION_COOKIE_ORIGINS. Empty if the web app is served from the same origin as the API.
+func SessionCookieOrigins() []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("SESSION_COOKIE_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// UserLogoutResponse is returned after the logout.
+//
+// swagger:model UserLogoutResponse
+type UserLogoutResponse struct {
+	Success bool `json:"success"`
+}
+
+// UserLogoutHandler ends the session of the token and removes the session cookies.
+//
+//	@Summary					Logout
+//	@Description				Revokes the session of the token and removes the session cookies, the cookies are removed even if the token is not valid anymore. The web apps in the cookie session mode must log out with this endpoint, they can not remove the HttpOnly session cookie themselves. Tokens without a session, such as the signup and the impersonation tokens, are not revoked, they expire on their own.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} or the session cookie with the X-CSRF-Token header | Whitelist: None.
+//	@Param						Authorization	header		string				false	"JWT token"
+//	@Param						X-CSRF-Token	header		string				false	"CSRF token, required with the session cookie"
+//	@Success					200				{object}	UserLogoutResponse	"Logged out"
+//	@Failure					403				{object}	ErrorResponse		"CSRF token does not match"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/user/logout [post]
+func UserLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	// the cookies are removed even if the token is expired or revoked, the web app can not remove them.
+	jwtContents, err := s.GetJWTData()
+	if err == nil && jwtContents.SessionID != "" {
+		_, err = s.ExecuteSQL(s.StmtBuilder.Update(LoginHistoryTableName).
+			Set(LoginHistoryRevokedAtDBField, time.Now()).
+			Where(squirrel.Eq{LoginHistoryIDDBField: jwtContents.SessionID, LoginHistoryUserIDDBField: jwtContents.UUID, LoginHistoryRevokedAtDBField: nil}))
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	ClearSessionCookies(s.Writer)
+	s.WriteResponse(UserLogoutResponse{Success: true}, http.StatusOK)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionCookies(t *testing.T) {
+	w := httptest.NewRecorder()
+	SetSessionCookies(w, "token")
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 2)
+	session, csrf := cookies[0], cookies[1]
+	assert.Equal(t, sessionCookieName, session.Name)
+	assert.Equal(t, "token", session.Value)
+	assert.True(t, session.HttpOnly)
+	assert.True(t, session.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, session.SameSite)
+	assert.Equal(t, csrfCookieName, csrf.Name)
+	assert.Equal(t, CSRFToken("token"), csrf.Value)
+	assert.False(t, csrf.HttpOnly)
+	assert.NotEqual(t, CSRFToken("token"), CSRFToken("other token"))
+	w = httptest.NewRecorder()
+	ClearSessionCookies(w)
+	for _, cookie := range w.Result().Cookies() {
+		assert.Equal(t, -1, cookie.MaxAge, cookie.Name)
+	}
+}
+
+func TestSessionCookieMode(t *testing.T) {
+	assert.False(t, SessionCookieMode(httptest.NewRequest("POST", "/login", nil)))
+	assert.True(t, SessionCookieMode(httptest.NewRequest("POST", "/login?session=cookie", nil)))
+	token, err := SignToken("user", roleUser, tokenStatusActive, 1, time.Minute)
+	assert.Nil(t, err)
+	mode := func(cookie string, authorization string) bool {
+		r := httptest.NewRequest("GET", "/sessions", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		var cookieMode bool
+		CSRFProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the handlers replace the Authorization header before writing the user.
+			r.Header.Set("Authorization", "Bearer new token")
+			cookieMode = SessionCookieMode(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return cookieMode
+	}
+	assert.True(t, mode(token, ""))
+	assert.False(t, mode(token, "Bearer "+token))
+	assert.False(t, mode("stale token", ""))
+	assert.False(t, mode("", ""))
+}
+
+func TestCheckCSRF(t *testing.T) {
+	request := func(method string, cookie bool, authorization string, csrf string) *http.Request {
+		r := httptest.NewRequest(method, "/api/user", nil)
+		if cookie {
+			r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "token"})
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		if csrf != "" {
+			r.Header.Set(csrfHeaderName, csrf)
+		}
+		return r
+	}
+	assert.Nil(t, CheckCSRF(request("GET", true, "", "")))
+	assert.Nil(t, CheckCSRF(request("POST", false, "", "")))
+	assert.Nil(t, CheckCSRF(request("POST", true, "Bearer token", "")))
+	assert.Nil(t, CheckCSRF(request("DELETE", true, "", CSRFToken("token"))))
+	assert.Equal(t, CSRFTokenMismatchError, CheckCSRF(request("POST", true, "", "")))
+	assert.Equal(t, CSRFTokenMismatchError, CheckCSRF(request("PATCH", true, "", CSRFToken("other token"))))
+}
+
+func TestGetJWTDataFromCookie(t *testing.T) {
+	token, err := SignToken("user", roleUser, tokenStatusActive, 1, time.Minute)
+	assert.Nil(t, err)
+	r := httptest.NewRequest("GET", "/", nil)
+	_, err = Server{Request: r}.GetJWTData()
+	assert.Equal(t, NoAuthorizationHeaderError, err)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	fields, err := Server{Request: r}.GetJWTData()
+	assert.Nil(t, err)
+	assert.Equal(t, "user", fields.UUID)
+	assert.Equal(t, token, fields.Token)
+}
+
+func TestSessionCookieOrigins(t *testing.T) {
+	defer os.Unsetenv("SESSION_COOKIE_ORIGINS")
+	os.Setenv("SESSION_COOKIE_ORIGINS", "")
+	assert.Empty(t, SessionCookieOrigins())
+	os.Setenv("SESSION_COOKIE_ORIGINS", " https://app.example.com, ,https://www.example.com")
+	assert.Equal(t, []string{"https://app.example.com", "https://www.example.com"}, SessionCookieOrigins())
+}
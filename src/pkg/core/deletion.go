@@ -0,0 +1,250 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deleted accounts are kept for the grace period with users.deleted_at set, logging in restores them (see
+// StartSession). After the grace period AnonymizeDeletedUsers turns the account into a tombstone: the row is kept so
+// the reviews, replies and reports still reference it, but every personal data is removed and the reviews and replies
+// are shown under the anonymous username.
+
+// defaultAccountDeletionGracePeriod is used if ACCOUNT_DELETION_GRACE_DAYS is not set.
+const defaultAccountDeletionGracePeriod = 30 * 24 * time.Hour
+
+// AccountDeletionGracePeriod returns the time a deleted account can be restored by logging in, set in days with
+// ACCOUNT_DELETION_GRACE_DAYS in the environment (or in the .env file).
+func AccountDeletionGracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		return defaultAccountDeletionGracePeriod
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// AnonymizedUserFields returns the placeholders of the unique columns of an anonymized user, derived from the id so
+// they do not collide. The email is in the .invalid TLD, it can never be delivered or signed up with.
+func AnonymizedUserFields(uid string) (email string, username string, phoneNumber string) {
+	hex := strings.ReplaceAll(uid, "-", "")
+	// usernames are at most 24 characters, phone numbers 20.
+	return fmt.Sprintf("deleted-%s@deleted.invalid", uid), "deleted_" + hex[:16], "x" + hex[:19]
+}
+
+// AnonymizeDeletedUsers anonymizes the users whose grace period is over, it is scheduled in main.go.
+func AnonymizeDeletedUsers(db *pgxpool.Pool) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	sql, args, err := stmtBuilder.Select(UserIDDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserAnonymizedAtDBField: nil}).
+		Where(squirrel.Lt{UserDeletedAtDBField: time.Now().Add(-AccountDeletionGracePeriod())}).
+		ToSql()
+	if err != nil {
+		log.Printf("error anonymizing deleted users: %v", err)
+		return
+	}
+	rows, err := db.Query(to, sql, args...)
+	if err != nil {
+		log.Printf("error anonymizing deleted users: %v", err)
+		return
+	}
+	var uids []string
+	for rows.Next() {
+		var uid string
+		if err = rows.Scan(&uid); err != nil {
+			break
+		}
+		uids = append(uids, uid)
+	}
+	rows.Close()
+	if err != nil {
+		log.Printf("error anonymizing deleted users: %v", err)
+		return
+	}
+	for _, uid := range uids {
+		// one user at a time, a failure must not roll back the others.
+		if err = anonymizeUser(to, db, stmtBuilder, uid); err != nil {
+			log.Printf("error anonymizing deleted user %s: %v", uid, err)
+		}
+	}
+	if len(uids) > 0 {
+		log.Printf("anonymized %d deleted users", len(uids))
+	}
+}
+
+// anonymizedUserColumns returns the values the user's columns are set to at the anonymization.
+func anonymizedUserColumns(uid string) map[string]interface{} {
+	email, username, phoneNumber := AnonymizedUserFields(uid)
+	return map[string]interface{}{
+		UserEmailDBField:                     email,
+		UserUsernameDBField:                  username,
+		UserPhoneNumberDBField:               phoneNumber,
+		UserPhoneVerifiedDBField:             false,
+		UserPhoneVerifiedAtDBField:           nil,
+		UserDisplayNameDBField:               nil,
+		UserBioDBField:                       nil,
+		UserAvatarKeyDBField:                 nil,
+		UserPasswordDBField:                  "",
+		UserSessionTokenDBField:              "",
+		UserRefreshTokenDBField:              "",
+		UserLastLoginIPDBField:               nil,
+		UserSignupIPDBField:                  nil,
+		UserSpamReasonsDBField:               []string{},
+		UserPlaceIDDBField:                   nil,
+		UserTOTPSecretDBField:                nil,
+		UserTOTPEnabledDBField:               false,
+		UserProfileHiddenFieldsDBField:       profileFields,
+		UserNotificationDisabledTypesDBField: []string{},
+		UserDietRestrictionsDBField:          []string{},
+		UserFavouriteCuisinesDBField:         []string{},
+		UserAllergensDBField:                 []string{},
+		UserPreferredLanguageDBField:         nil,
+		UserDistanceUnitDBField:              distanceUnitKilometres,
+		UserTokenVersionDBField:              squirrel.Expr(fmt.Sprintf("%s + 1", UserTokenVersionDBField)),
+		UserAnonymizedAtDBField:              time.Now(),
+		UserUpdatedAtDBField:                 time.Now(),
+		UserCityDBField:                      nil,
+		UserStateDBField:                     nil,
+		UserCountryDBField:                   nil,
+	}
+}
+
+// keptUserColumns are the columns of the users that are kept at the anonymization, they do not identify the user.
+var keptUserColumns = map[string]string{
+	UserIDDBField:                    "the reviews and the replies reference the tombstone",
+	UserRoleDBField:                  "not personal",
+	UserReputationDBField:            "earned by the kept reviews",
+	UserVerifiedDBField:              "not personal",
+	UserCreatedAtDBField:             "age of the kept reviews' author",
+	UserUpdatedAtDBField:             "set to the anonymization time",
+	UserLastLoginAtDBField:           "timestamp only",
+	UserEmailLastUpdatedAtDBField:    "timestamp only",
+	UserUsernameLastUpdatedAtDBField: "timestamp only",
+}
+
+// personalDataTables are the tables with the personal data of the user, the rows are deleted at the anonymization if
+// any of the fields is the user.
+var personalDataTables = map[string][]string{
+	DataExportTableName:         {DataExportUserIDDBField},
+	PasswordResetTokenTableName: {PasswordResetTokenUserIDDBField},
+	EmailChangeTableName:        {EmailChangeUserIDDBField},
+	PasswordHistoryTableName:    {PasswordHistoryUserIDDBField},
+	RecoveryCodeTableName:       {RecoveryCodeUserIDDBField},
+	UserIdentityTableName:       {UserIdentityUserIDDBField},
+	OIDCStateTableName:          {OIDCStateUserIDDBField},
+	APIKeyTableName:             {APIKeyOwnerIDDBField},
+	PhoneCodeTableName:          {PhoneCodeUserIDDBField},
+	LoginHistoryTableName:       {LoginHistoryUserIDDBField},
+	SpamCheckTableName:          {SpamCheckUserIDDBField},
+	UserFollowTableName:         {UserFollowFollowerIDDBField, UserFollowFolloweeIDDBField},
+	FeedInboxTableName:          {FeedInboxUserIDDBField, FeedInboxAuthorIDDBField},
+	NotificationTableName:       {NotificationUserIDDBField},
+	UserBadgeTableName:          {UserBadgeUserIDDBField},
+}
+
+// keptUserDataTables are the tables with the user's rows that are kept at the anonymization, they reference the
+// tombstone.
+var keptUserDataTables = map[string]string{
+	ReviewsTable:             "reviews are public content, shown as written by a deleted user",
+	ReviewReplyTable:         "replies are public content, shown as written by a deleted user",
+	UserReportTableName:      "reports are moderation records",
+	UserBanTableName:         "bans are moderation records",
+	ReputationEventTableName: "reputation events are earned by the kept reviews",
+}
+
+func anonymizeUser(ctx context.Context, db *pgxpool.Pool, stmtBuilder squirrel.StatementBuilderType, uid string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	var avatarKey *string
+	sql, args, err := stmtBuilder.Select(UserAvatarKeyDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	if err != nil {
+		return err
+	}
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&avatarKey); err != nil {
+		return err
+	}
+	// the deleted_at condition skips the users that logged in since the select.
+	sql, args, err = stmtBuilder.Update(UserTableName).
+		SetMap(anonymizedUserColumns(uid)).
+		Where(squirrel.Eq{UserIDDBField: uid, UserAnonymizedAtDBField: nil}).
+		Where(squirrel.NotEq{UserDeletedAtDBField: nil}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return nil
+	}
+	if err = removeDataExportFiles(ctx, tx, stmtBuilder, uid); err != nil {
+		return err
+	}
+	for table, userIDFields := range personalDataTables {
+		if err = deleteUserRows(ctx, tx, stmtBuilder, table, userIDFields, uid); err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+	if avatarKey != nil {
+		return DeleteAvatarObjects(ctx, NewObjectStorage(), *avatarKey)
+	}
+	return nil
+}
+
+// removeDataExportFiles removes the archives of the user's data exports, their rows are deleted after.
+func removeDataExportFiles(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, uid string) error {
+	sql, args, err := stmtBuilder.Select(DataExportFilePathDBField).
+		From(DataExportTableName).
+		Where(squirrel.Eq{DataExportUserIDDBField: uid}).
+		Where(squirrel.NotEq{DataExportFilePathDBField: ""}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err = rows.Scan(&path); err != nil {
+			return err
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func deleteUserRows(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, table string, userIDFields []string, uid string) error {
+	where := squirrel.Or{}
+	for _, field := range userIDFields {
+		where = append(where, squirrel.Eq{field: uid})
+	}
+	sql, args, err := stmtBuilder.Delete(table).Where(where).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAnonymizedUserFields(t *testing.T) {
+	uid := uuid.NewString()
+	email, username, phoneNumber := AnonymizedUserFields(uid)
+	assert.Contains(t, email, uid)
+	assert.LessOrEqual(t, len(username), 24)
+	assert.LessOrEqual(t, len(phoneNumber), 20)
+	otherEmail, otherUsername, otherPhoneNumber := AnonymizedUserFields(uuid.NewString())
+	assert.NotEqual(t, email, otherEmail)
+	assert.NotEqual(t, username, otherUsername)
+	assert.NotEqual(t, phoneNumber, otherPhoneNumber)
+}
+
+func TestAccountDeletionGracePeriod(t *testing.T) {
+	t.Setenv("ACCOUNT_DELETION_GRACE_DAYS", "")
+	assert.Equal(t, defaultAccountDeletionGracePeriod, AccountDeletionGracePeriod())
+	t.Setenv("ACCOUNT_DELETION_GRACE_DAYS", "7")
+	assert.Equal(t, 7*24*time.Hour, AccountDeletionGracePeriod())
+	t.Setenv("ACCOUNT_DELETION_GRACE_DAYS", "-1")
+	assert.Equal(t, defaultAccountDeletionGracePeriod, AccountDeletionGracePeriod())
+}
+
+func TestAnonymizationCoversDataExport(t *testing.T) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	uid := uuid.NewString()
+	columns := anonymizedUserColumns(uid)
+	userColumn := regexp.MustCompile(UserTableName + `\.(\w+)`)
+	for _, query := range dataExportQueries(stmtBuilder, uid) {
+		if query.table != UserTableName {
+			_, erased := personalDataTables[query.table]
+			_, kept := keptUserDataTables[query.table]
+			assert.True(t, erased || kept, "%s (%s) is exported but not erased", query.name, query.table)
+			continue
+		}
+		sql, _, err := query.query.ToSql()
+		assert.Nil(t, err)
+		for _, match := range userColumn.FindAllStringSubmatch(sql, -1) {
+			_, erased := columns[match[1]]
+			_, kept := keptUserColumns[match[1]]
+			assert.True(t, erased || kept, "%s.%s is exported but not erased", UserTableName, match[1])
+		}
+	}
+	for table := range personalDataTables {
+		_, kept := keptUserDataTables[table]
+		assert.False(t, kept, table)
+	}
+}
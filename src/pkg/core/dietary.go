@@ -0,0 +1,473 @@
+package core
+
+import (
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// diet restrictions of the users, they are the keys of the OSM diet:* tags.
+const (
+	dietVegan       = "vegan"
+	dietVegetarian  = "vegetarian"
+	dietHalal       = "halal"
+	dietKosher      = "kosher"
+	dietGlutenFree  = "gluten_free"
+	dietLactoseFree = "lactose_free"
+)
+
+var dietRestrictions = []string{dietVegan, dietVegetarian, dietHalal, dietKosher, dietGlutenFree}
+
+// osmDietValues are the values of the diet:* tags of the places that serve the diet: yes, only, or limited for the
+// places with a few options.
+var osmDietValues = []string{"yes", "only", "limited"}
+
+// allergens are the 14 allergens of the EU food information regulation.
+var allergens = []string{
+	"gluten", "crustaceans", "eggs", "fish", "peanuts", "soy", "milk",
+	"tree_nuts", "celery", "mustard", "sesame", "sulphites", "lupin", "molluscs",
+}
+
+// allergenDiets are the diets of the places that avoid an allergen. OSM has no tag for the other allergens, places
+// are not filtered by them.
+var allergenDiets = map[string]string{
+	"gluten": dietGlutenFree,
+	"milk":   dietLactoseFree,
+}
+
+// distance units of the users.
+const (
+	distanceUnitKilometres = "km"
+	distanceUnitMiles      = "mi"
+	milesPerKilometre      = 0.621371
+)
+
+const (
+	// recommendationRadiusDefault is the radius of the recommended places in kilometres, recommendationRadiusMax
+	// its maximum.
+	recommendationRadiusDefault = 5
+	recommendationRadiusMax     = 50
+	earthRadiusKilometres       = 6371
+	kilometresPerDegree         = 111.32
+)
+
+// cuisinePattern is the format of the values of the OSM cuisine tag.
+var cuisinePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// DietaryProfile is the food preferences of the user. Favourite cuisines are values of the OSM cuisine tag, such as
+// turkish or kebab.
+//
+// swagger:model DietaryProfile
+type DietaryProfile struct {
+	// One of vegan, vegetarian, halal, kosher and gluten_free.
+	DietRestrictions  []string `json:"dietRestrictions" validate:"max=5,dive,oneof=vegan vegetarian halal kosher gluten_free"`
+	FavouriteCuisines []string `json:"favouriteCuisines" validate:"max=20,dive,min=1,max=32"`
+	// One of gluten, crustaceans, eggs, fish, peanuts, soy, milk, tree_nuts, celery, mustard, sesame, sulphites, lupin
+	// and molluscs.
+	Allergens []string `json:"allergens" validate:"max=14,dive,oneof=gluten crustaceans eggs fish peanuts soy milk tree_nuts celery mustard sesame sulphites lupin molluscs"`
+	// BCP 47 language tag, such as tr or en-GB.
+	PreferredLanguage *string `json:"preferredLanguage,omitempty" validate:"omitempty,bcp47_language_tag"`
+	// km or mi, km by default.
+	DistanceUnit string `json:"distanceUnit" validate:"omitempty,oneof=km mi"`
+}
+
+// RecommendedPlace is a place that serves the diets of the user. Distance is in the distance unit of the user.
+type RecommendedPlace struct {
+	ID               string   `json:"id"`
+	Name             string   `json:"name"`
+	Cuisines         []string `json:"cuisines"`
+	Diets            []string `json:"diets"`
+	Distance         float64  `json:"distance"`
+	FavouriteCuisine bool     `json:"favouriteCuisine"`
+}
+
+// PlaceRecommendationsResponse lists the recommended places. RequiredDiets are the diets every place serves,
+// UnfilteredAllergens the allergens of the user that places can not be filtered by.
+//
+// swagger:model PlaceRecommendationsResponse
+type PlaceRecommendationsResponse struct {
+	Places              []RecommendedPlace `json:"places"`
+	DistanceUnit        string             `json:"distanceUnit"`
+	RequiredDiets       []string           `json:"requiredDiets"`
+	UnfilteredAllergens []string           `json:"unfilteredAllergens"`
+}
+
+// PlaceDiets returns the diets the place serves from its OSM diet:* tags, sorted. Vegan places serve vegetarians too.
+func PlaceDiets(tags []Tag) []string {
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		diet, ok := strings.CutPrefix(tag.Key, "diet:")
+		if !ok || diet == "" {
+			continue
+		}
+		for _, value := range osmDietValues {
+			if strings.EqualFold(strings.TrimSpace(tag.Value), value) {
+				seen[diet] = true
+				break
+			}
+		}
+	}
+	if seen[dietVegan] {
+		seen[dietVegetarian] = true
+	}
+	diets := make([]string, 0, len(seen))
+	for diet := range seen {
+		diets = append(diets, diet)
+	}
+	sort.Strings(diets)
+	return diets
+}
+
+// SplitCuisines splits the OSM cuisine tag, it is a list separated by semicolons.
+func SplitCuisines(cuisine string) []string {
+	cuisines := make([]string, 0)
+	for _, c := range strings.Split(cuisine, ";") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			cuisines = append(cuisines, c)
+		}
+	}
+	return cuisines
+}
+
+// NormalizeCuisines formats the cuisines like the OSM cuisine tag, lower case with underscores, and removes the
+// duplicates. The second return value lists the cuisines that can not be formatted.
+func NormalizeCuisines(cuisines []string) ([]string, []string) {
+	seen := make(map[string]bool, len(cuisines))
+	normalized := make([]string, 0, len(cuisines))
+	var invalid []string
+	for _, c := range cuisines {
+		c = strings.Join(strings.Fields(strings.ToLower(c)), "_")
+		if !cuisinePattern.MatchString(c) {
+			invalid = append(invalid, c)
+			continue
+		}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		normalized = append(normalized, c)
+	}
+	return normalized, invalid
+}
+
+// normalizeDietaryList removes the duplicates of the values and sorts them in the order of the known values.
+func normalizeDietaryList(values []string, known []string) []string {
+	selected := make(map[string]bool, len(values))
+	for _, v := range values {
+		selected[v] = true
+	}
+	normalized := make([]string, 0, len(values))
+	for _, v := range known {
+		if selected[v] {
+			normalized = append(normalized, v)
+		}
+	}
+	return normalized
+}
+
+// RequiredDiets returns the diets the places must serve for the profile, and the allergens places can not be
+// filtered by.
+func RequiredDiets(profile DietaryProfile) (diets []string, unfiltered []string) {
+	seen := make(map[string]bool)
+	for _, diet := range profile.DietRestrictions {
+		seen[diet] = true
+	}
+	unfiltered = make([]string, 0)
+	for _, allergen := range profile.Allergens {
+		if diet, ok := allergenDiets[allergen]; ok {
+			seen[diet] = true
+		} else {
+			unfiltered = append(unfiltered, allergen)
+		}
+	}
+	diets = make([]string, 0, len(seen))
+	for diet := range seen {
+		diets = append(diets, diet)
+	}
+	sort.Strings(diets)
+	return diets, unfiltered
+}
+
+// ConvertDistance converts the kilometres to the unit, rounded to 100 metres or a tenth of a mile.
+func ConvertDistance(kilometres float64, unit string) float64 {
+	if unit == distanceUnitMiles {
+		kilometres *= milesPerKilometre
+	}
+	return math.Round(kilometres*10) / 10
+}
+
+// GetDietaryProfile returns the dietary profile of the user.
+func (s Server) GetDietaryProfile(uid string) (DietaryProfile, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.
+		Select(UserDietRestrictionsDBField, UserFavouriteCuisinesDBField, UserAllergensDBField, UserPreferredLanguageDBField, UserDistanceUnitDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return DietaryProfile{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return DietaryProfile{}, UserDoesNotExistError
+	}
+	var profile DietaryProfile
+	if err = rows.Scan(&profile.DietRestrictions, &profile.FavouriteCuisines, &profile.Allergens, &profile.PreferredLanguage, &profile.DistanceUnit); err != nil {
+		return DietaryProfile{}, err
+	}
+	return profile, nil
+}
+
+// readRecommendationLocation reads the lat, lon and radius query parameters. ok is false if lat and lon are not set.
+func readRecommendationLocation(r *http.Request) (lat float64, lon float64, radius float64, ok bool, err error) {
+	query := r.URL.Query()
+	radius = recommendationRadiusDefault
+	if v := query.Get("radius"); v != "" {
+		radius, err = strconv.ParseFloat(v, 64)
+		if err != nil || radius <= 0 || radius > recommendationRadiusMax {
+			return 0, 0, 0, false, InvalidRadiusError
+		}
+	}
+	latValue, lonValue := query.Get("lat"), query.Get("lon")
+	if latValue == "" && lonValue == "" {
+		return 0, 0, radius, false, nil
+	}
+	lat, err = strconv.ParseFloat(latValue, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, 0, false, InvalidCoordinatesError
+	}
+	lon, err = strconv.ParseFloat(lonValue, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, 0, false, InvalidCoordinatesError
+	}
+	return lat, lon, radius, true, nil
+}
+
+// userCityLocation returns the coordinates of the city of the user, ok is false if the user has no city.
+func (s Server) userCityLocation(uid string) (lat float64, lon float64, ok bool, err error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.
+		Select(fmt.Sprintf("%s.%s", CityTable, CityLatitudeDBField), fmt.Sprintf("%s.%s", CityTable, CityLongitudeDBField)).
+		From(UserTableName).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", CityTable, CityTable, CityIDDBField, UserTableName, UserCityDBField)).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", UserTableName, UserIDDBField): uid}))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, 0, false, rows.Err()
+	}
+	if err = rows.Scan(&lat, &lon); err != nil {
+		return 0, 0, false, err
+	}
+	return lat, lon, true, nil
+}
+
+// recommendationsQuery selects the places within the radius in kilometres that serve the diets, and whose name
+// contains the search text if it is set. Places of the favourite cuisines come first, then the closest.
+func recommendationsQuery(stmtBuilder squirrel.StatementBuilderType, lat float64, lon float64, radius float64, diets []string, cuisines []string, search string, limit uint64) squirrel.SelectBuilder {
+	// haversine distance, the bounding box lets the location index skip the far places.
+	distance := fmt.Sprintf("%[1]d * 2 * asin(sqrt(power(sin(radians(%[2]s - ?) / 2), 2) + cos(radians(?)) * cos(radians(%[2]s)) * power(sin(radians(%[3]s - ?) / 2), 2)))",
+		earthRadiusKilometres, RestaurantLatitudeDBField, RestaurantLongitudeDBField)
+	if cuisines == nil {
+		cuisines = []string{}
+	}
+	latDelta := radius / kilometresPerDegree
+	lonDelta := radius / (kilometresPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	places := stmtBuilder.
+		Select(RestaurantIDDBField, RestaurantNameDBField, fmt.Sprintf("COALESCE(%s, '')", RestaurantCuisineDBField), RestaurantDietsDBField).
+		Column(squirrel.Expr(distance+" AS distance", lat, lat, lon)).
+		Column(squirrel.Expr(fmt.Sprintf("regexp_split_to_array(lower(COALESCE(%s, '')), '\\s*;\\s*') && ?::text[] AS favourite", RestaurantCuisineDBField), cuisines)).
+		From(RestaurantsTable).
+		Where(squirrel.And{
+			squirrel.GtOrEq{RestaurantLatitudeDBField: lat - latDelta},
+			squirrel.LtOrEq{RestaurantLatitudeDBField: lat + latDelta},
+			squirrel.GtOrEq{RestaurantLongitudeDBField: lon - lonDelta},
+			squirrel.LtOrEq{RestaurantLongitudeDBField: lon + lonDelta},
+		})
+	if len(diets) > 0 {
+		places = places.Where(fmt.Sprintf("%s @> ?::text[]", RestaurantDietsDBField), diets)
+	}
+	if search != "" {
+		places = places.Where(squirrel.ILike{RestaurantNameDBField: ContainsPattern(search)})
+	}
+	return stmtBuilder.Select("*").
+		FromSelect(places, "candidates").
+		Where(squirrel.LtOrEq{"distance": radius}).
+		OrderBy("favourite DESC", "distance", RestaurantIDDBField).
+		Limit(limit)
+}
+
+// UserDietaryProfileHandler returns the dietary profile of the user.
+//
+//	@Summary					Get dietary profile
+//	@Description				Returns the diet restrictions, favourite cuisines, allergens, preferred language and distance unit of the user.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Success					200				{object}	DietaryProfile	"Dietary profile"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/user/dietary-profile [get]
+func UserDietaryProfileHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	profile, err := s.GetDietaryProfile(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(profile, http.StatusOK)
+}
+
+// UserDietaryProfileUpdateHandler replaces the dietary profile of the user.
+//
+//	@Summary					Update dietary profile
+//	@Description				Replaces the dietary profile of the user. Favourite cuisines are values of the OSM cuisine tag, they are stored in lower case with underscores. The recommended places serve the diet restrictions, and avoid the gluten and milk allergens; OSM has no tags for the other allergens.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Param						body			body		DietaryProfile	true	"Dietary profile"
+//	@Success					200				{object}	DietaryProfile	"Dietary profile"
+//	@Failure					400				{object}	ErrorResponse	"Invalid profile"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/user/dietary-profile [put]
+func UserDietaryProfileUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req DietaryProfile
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	profile := DietaryProfile{
+		DietRestrictions:  normalizeDietaryList(req.DietRestrictions, dietRestrictions),
+		Allergens:         normalizeDietaryList(req.Allergens, allergens),
+		PreferredLanguage: req.PreferredLanguage,
+		DistanceUnit:      req.DistanceUnit,
+	}
+	var invalid []string
+	profile.FavouriteCuisines, invalid = NormalizeCuisines(req.FavouriteCuisines)
+	if len(invalid) > 0 {
+		s.LogError(InvalidCuisineError(invalid[0]), http.StatusBadRequest)
+		return
+	}
+	if profile.DistanceUnit == "" {
+		profile.DistanceUnit = distanceUnitKilometres
+	}
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserDietRestrictionsDBField, profile.DietRestrictions).
+		Set(UserFavouriteCuisinesDBField, profile.FavouriteCuisines).
+		Set(UserAllergensDBField, profile.Allergens).
+		Set(UserPreferredLanguageDBField, profile.PreferredLanguage).
+		Set(UserDistanceUnitDBField, profile.DistanceUnit).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(profile, http.StatusOK)
+}
+
+// UserPlaceRecommendationsHandler recommends places for the dietary profile of the user.
+//
+//	@Summary					Recommend places
+//	@Description				Lists the places around the location that serve the diet restrictions of the user and avoid the gluten and milk allergens, from their OSM diet tags. Places of the favourite cuisines come first, then the closest. The location is the city of the user if lat and lon are not set. The distances are in the distance unit of the user.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} or ApiKey {key} with the places:read scope | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string							true	"JWT token or API key"
+//	@Param						lat				query		number							false	"Latitude"
+//	@Param						lon				query		number							false	"Longitude"
+//	@Param						radius			query		number							false	"Radius in kilometres, 5 by default, at most 50"
+//	@Param						q				query		string							false	"Text in the name of the place"
+//	@Param						limit			query		int								false	"Count of places, 20 by default, at most 100"
+//	@Success					200				{object}	PlaceRecommendationsResponse	"Recommended places"
+//	@Failure					400				{object}	ErrorResponse					"Invalid location, radius or limit"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/user/places/recommended [get]
+func UserPlaceRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	uid, err := s.RequestUserID()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	limit, err := readPageLimit(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	lat, lon, radius, ok, err := readRecommendationLocation(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		lat, lon, ok, err = s.userCityLocation(uid)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			s.LogError(LocationRequiredError, http.StatusBadRequest)
+			return
+		}
+	}
+	profile, err := s.GetDietaryProfile(uid)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	diets, unfiltered := RequiredDiets(profile)
+	rows, err := s.QuerySQL(recommendationsQuery(s.StmtBuilder, lat, lon, radius, diets, profile.FavouriteCuisines, strings.TrimSpace(r.URL.Query().Get("q")), limit))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	response := PlaceRecommendationsResponse{Places: make([]RecommendedPlace, 0), DistanceUnit: profile.DistanceUnit, RequiredDiets: diets, UnfilteredAllergens: unfiltered}
+	for rows.Next() {
+		var place RecommendedPlace
+		var cuisine string
+		if err = rows.Scan(&place.ID, &place.Name, &cuisine, &place.Diets, &place.Distance, &place.FavouriteCuisine); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		place.Cuisines = SplitCuisines(cuisine)
+		place.Distance = ConvertDistance(place.Distance, profile.DistanceUnit)
+		response.Places = append(response.Places, place)
+	}
+	if err = rows.Err(); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlaceDiets(t *testing.T) {
+	tags := []Tag{
+		{Key: "amenity", Value: "restaurant"},
+		{Key: "cuisine", Value: "turkish;kebab"},
+		{Key: "diet:vegan", Value: "limited"},
+		{Key: "diet:halal", Value: "Yes"},
+		{Key: "diet:kosher", Value: "no"},
+		{Key: "diet:gluten_free", Value: "only"},
+		{Key: "diet:", Value: "yes"},
+	}
+	assert.Equal(t, []string{"gluten_free", "halal", "vegan", "vegetarian"}, PlaceDiets(tags))
+	assert.Empty(t, PlaceDiets([]Tag{{Key: "name", Value: "Lokanta"}}))
+}
+
+func TestCuisines(t *testing.T) {
+	assert.Equal(t, []string{"turkish", "kebab"}, SplitCuisines("Turkish; kebab;"))
+	assert.Empty(t, SplitCuisines(""))
+	normalized, invalid := NormalizeCuisines([]string{"Turkish", "ice  cream", "turkish", "döner"})
+	assert.Equal(t, []string{"turkish", "ice_cream"}, normalized)
+	assert.Equal(t, []string{"döner"}, invalid)
+}
+
+func TestRequiredDiets(t *testing.T) {
+	diets, unfiltered := RequiredDiets(DietaryProfile{
+		DietRestrictions: []string{dietVegan, dietHalal},
+		Allergens:        []string{"milk", "peanuts", "gluten"},
+	})
+	assert.Equal(t, []string{dietGlutenFree, dietHalal, dietLactoseFree, dietVegan}, diets)
+	assert.Equal(t, []string{"peanuts"}, unfiltered)
+	diets, unfiltered = RequiredDiets(DietaryProfile{})
+	assert.Empty(t, diets)
+	assert.NotNil(t, unfiltered)
+	assert.Equal(t, []string{dietVegan, dietGlutenFree}, normalizeDietaryList([]string{dietGlutenFree, dietVegan, dietVegan}, dietRestrictions))
+}
+
+func TestConvertDistance(t *testing.T) {
+	assert.Equal(t, 1.2, ConvertDistance(1.234, distanceUnitKilometres))
+	assert.Equal(t, 6.2, ConvertDistance(10, distanceUnitMiles))
+}
+
+func TestReadRecommendationLocation(t *testing.T) {
+	_, _, radius, ok, err := readRecommendationLocation(httptest.NewRequest("GET", "/places/recommended", nil))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, float64(recommendationRadiusDefault), radius)
+	lat, lon, radius, ok, err := readRecommendationLocation(httptest.NewRequest("GET", "/places/recommended?lat=41.01&lon=28.97&radius=2.5", nil))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []float64{41.01, 28.97, 2.5}, []float64{lat, lon, radius})
+	for query, expected := range map[string]error{
+		"lat=41.01":                 InvalidCoordinatesError,
+		"lat=91&lon=28.97":          InvalidCoordinatesError,
+		"lat=41.01&lon=east":        InvalidCoordinatesError,
+		"lat=41.01&lon=28&radius=0": InvalidRadiusError,
+		"radius=51":                 InvalidRadiusError,
+	} {
+		_, _, _, _, err = readRecommendationLocation(httptest.NewRequest("GET", "/places/recommended?"+query, nil))
+		assert.Equal(t, expected, err, query)
+	}
+}
+
+func TestRecommendationsQuery(t *testing.T) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	sql, args, err := recommendationsQuery(stmtBuilder, 41.01, 28.97, 5, []string{dietVegan}, nil, "100%_kebab", 20).ToSql()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(sql, "SELECT * FROM (SELECT id, name"), sql)
+	assert.Contains(t, sql, "diets @> $9::text[]")
+	assert.Contains(t, sql, "name ILIKE $10")
+	assert.True(t, strings.HasSuffix(sql, "WHERE distance <= $11 ORDER BY favourite DESC, distance, id LIMIT 20"), sql)
+	assert.Len(t, args, 11)
+	assert.Equal(t, []string{}, args[3])
+	assert.Equal(t, `%100\%\_kebab%`, args[9])
+}
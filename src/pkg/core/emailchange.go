@@ -0,0 +1,331 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"time"
+)
+
+const (
+	EmailChangeTableName               = "email_changes"
+	EmailChangeIDDBField               = "id"
+	EmailChangeUserIDDBField           = "user_id"
+	EmailChangeOldEmailDBField         = "old_email"
+	EmailChangeNewEmailDBField         = "new_email"
+	EmailChangeConfirmTokenHashDBField = "confirm_token_hash"
+	EmailChangeRevertTokenHashDBField  = "revert_token_hash"
+	EmailChangeCreatedAtDBField        = "created_at"
+	EmailChangeConfirmExpiresAtDBField = "confirm_expires_at"
+	EmailChangeRevertExpiresAtDBField  = "revert_expires_at"
+	EmailChangeConfirmedAtDBField      = "confirmed_at"
+	EmailChangeRevertedAtDBField       = "reverted_at"
+	EmailChangeCancelledAtDBField      = "cancelled_at"
+)
+
+const (
+	// emailChangeConfirmDuration is how long the link sent to the new email can confirm the change.
+	emailChangeConfirmDuration = 24 * time.Hour
+	// emailChangeRevertDuration is how long the link sent to the old email can revert the change, confirmed or not.
+	emailChangeRevertDuration = 7 * 24 * time.Hour
+	// emailChangeRequestInterval is the least time between two email change requests, each one sends two emails.
+	emailChangeRequestInterval = time.Minute
+)
+
+// pendingEmailChange is the condition of the email changes that are neither confirmed nor reverted nor replaced by a
+// newer request. An expired one is still pending until it is replaced, it can not be confirmed.
+var pendingEmailChange = squirrel.Eq{
+	EmailChangeConfirmedAtDBField: nil,
+	EmailChangeRevertedAtDBField:  nil,
+	EmailChangeCancelledAtDBField: nil,
+}
+
+// cancelPendingEmailChanges cancels the pending email changes of the user, in the transaction.
+func (s Server) cancelPendingEmailChanges(ctx context.Context, tx pgx.Tx, uid string) error {
+	sql, args, err := s.StmtBuilder.Update(EmailChangeTableName).
+		Set(EmailChangeCancelledAtDBField, time.Now()).
+		Where(squirrel.Eq{EmailChangeUserIDDBField: uid}).
+		Where(pendingEmailChange).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// RequestEmailChange holds the change of the email of the user as pending, replacing the previous pending change. A
+// confirm link is sent to the new email and a revert link to the old one, users.email is not changed until the
+// change is confirmed. The returned status is the HTTP status code of the error.
+func (s Server) RequestEmailChange(uid string, oldEmail string, newEmail string) (int, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(fmt.Sprintf("MAX(%s)", EmailChangeCreatedAtDBField)).
+		From(EmailChangeTableName).
+		Where(squirrel.Eq{EmailChangeUserIDDBField: uid}))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	var lastRequestedAt *time.Time
+	if rows.Next() {
+		err = rows.Scan(&lastRequestedAt)
+	}
+	rows.Close()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if lastRequestedAt != nil && time.Since(*lastRequestedAt) < emailChangeRequestInterval {
+		return http.StatusTooManyRequests, EmailChangeRateLimitedError(lastRequestedAt.Add(emailChangeRequestInterval))
+	}
+	confirmToken, confirmTokenHash, err := GenerateSecureToken()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	revertToken, revertTokenHash, err := GenerateSecureToken()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer tx.Rollback(to)
+	if err = s.cancelPendingEmailChanges(to, tx, uid); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	now := time.Now()
+	sql, args, err := s.StmtBuilder.Insert(EmailChangeTableName).
+		Columns(
+			EmailChangeUserIDDBField,
+			EmailChangeOldEmailDBField,
+			EmailChangeNewEmailDBField,
+			EmailChangeConfirmTokenHashDBField,
+			EmailChangeRevertTokenHashDBField,
+			EmailChangeCreatedAtDBField,
+			EmailChangeConfirmExpiresAtDBField,
+			EmailChangeRevertExpiresAtDBField).
+		Values(uid, oldEmail, newEmail, confirmTokenHash, revertTokenHash, now, now.Add(emailChangeConfirmDuration), now.Add(emailChangeRevertDuration)).
+		ToSql()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err = tx.Commit(to); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	confirmBody := fmt.Sprintf("The email of your account is being changed to this address. Follow the link below in %s to confirm it:\n\n%s/confirm-email?token=%s\n\nIf it was not you, you can ignore this email, the email of the account will not change.",
+		emailChangeConfirmDuration, AppBaseURL(), confirmToken)
+	revertBody := fmt.Sprintf("A change of the email of your account to %s is requested. It is changed once the new address confirms it.\n\nIf it was not you, follow the link below in %s to keep this address and sign out of every session, then change your password:\n\n%s/revert-email?token=%s",
+		newEmail, emailChangeRevertDuration, AppBaseURL(), revertToken)
+	go func(mailer Mailer) {
+		if err := mailer.Send(newEmail, "Confirm your new email", confirmBody); err != nil {
+			s.Logger.Error(err.Error())
+		}
+		if err := mailer.Send(oldEmail, "Your email is being changed", revertBody); err != nil {
+			s.Logger.Error(err.Error())
+		}
+	}(s.Mailer)
+	return http.StatusOK, nil
+}
+
+// UserEmailChangeTokenRequest represents the token of an emailed email change link.
+//
+// swagger:model UserEmailChangeTokenRequest
+type UserEmailChangeTokenRequest struct {
+	// Token from the confirm or the revert link.
+	//
+	// required: true
+	Token string `json:"token" validate:"required" binding:"required"`
+}
+
+// UserEmailChangeResponse represents the response of a confirmed or reverted email change, Email is the email of
+// the user after it.
+//
+// swagger:model UserEmailChangeResponse
+type UserEmailChangeResponse struct {
+	Success bool   `json:"success"`
+	Email   string `json:"email"`
+}
+
+// UserEmailChangeConfirmHandler confirms a pending email change with the token sent to the new email.
+//
+//	@Summary		Confirm email change
+//	@Description	Changes the email of the user to the new email of a pending change, with the token in the link sent to the new email. The link is valid for 24 hours, only the latest requested change can be confirmed.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		UserEmailChangeTokenRequest	true	"Confirm token"
+//	@Success		200		{object}	UserEmailChangeResponse		"Email is changed"
+//	@Failure		400		{object}	ErrorResponse				"Token is invalid or expired, or the new email is taken"
+//	@Failure		500		{object}	ErrorResponse				"Internal server error"
+//	@Router			/api/user/email/confirm [post]
+func UserEmailChangeConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	var req UserEmailChangeTokenRequest
+	if err := s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err := s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	now := time.Now()
+	// mark the change as confirmed in the same statement we look it up, so the same token can not be used twice concurrently.
+	sql, args, err := s.StmtBuilder.Update(EmailChangeTableName).
+		Set(EmailChangeConfirmedAtDBField, now).
+		Where(squirrel.Eq{EmailChangeConfirmTokenHashDBField: HashToken(req.Token)}).
+		Where(pendingEmailChange).
+		Where(squirrel.Gt{EmailChangeConfirmExpiresAtDBField: now}).
+		Suffix(fmt.Sprintf("RETURNING %s, %s, %s", EmailChangeUserIDDBField, EmailChangeOldEmailDBField, EmailChangeNewEmailDBField)).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var uid, oldEmail, newEmail string
+	if err = tx.QueryRow(to, sql, args...).Scan(&uid, &oldEmail, &newEmail); err == pgx.ErrNoRows {
+		s.LogError(InvalidEmailChangeTokenError, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// the new email could be taken by a signup since the request.
+	if taken, err := s.IsUserFieldTaken(UserEmailDBField, newEmail, uid); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	} else if taken {
+		s.LogError(EmailAlreadyExistsError, http.StatusBadRequest)
+		return
+	}
+	// the email condition skips the changes requested before another change of the email.
+	sql, args, err = s.StmtBuilder.Update(UserTableName).
+		Set(UserEmailDBField, newEmail).
+		Set(UserEmailLastUpdatedAtDBField, now).
+		Set(UserUpdatedAtDBField, now).
+		Where(squirrel.Eq{UserIDDBField: uid, UserEmailDBField: oldEmail, UserDeletedAtDBField: nil}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	res, err := tx.Exec(to, sql, args...)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(InvalidEmailChangeTokenError, http.StatusBadRequest)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserEmailChangeResponse{Success: true, Email: newEmail}, http.StatusOK)
+}
+
+// UserEmailChangeRevertHandler reverts an email change with the token sent to the old email, and signs the user out
+// of every session since the change may be made with a stolen session.
+//
+//	@Summary		Revert email change
+//	@Description	Keeps or restores the old email of an email change with the token in the link sent to the old email. The pending changes are cancelled, a confirmed change is undone, and every session of the user is revoked. The link is valid for 7 days.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		UserEmailChangeTokenRequest	true	"Revert token"
+//	@Success		200		{object}	UserEmailChangeResponse		"Email is reverted"
+//	@Failure		400		{object}	ErrorResponse				"Token is invalid or expired, or the old email is taken"
+//	@Failure		500		{object}	ErrorResponse				"Internal server error"
+//	@Router			/api/user/email/revert [post]
+func UserEmailChangeRevertHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	var req UserEmailChangeTokenRequest
+	if err := s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err := s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	now := time.Now()
+	// a replaced change can still be reverted, every request sends its own revert link to the old email.
+	sql, args, err := s.StmtBuilder.Update(EmailChangeTableName).
+		Set(EmailChangeRevertedAtDBField, now).
+		Where(squirrel.Eq{EmailChangeRevertTokenHashDBField: HashToken(req.Token), EmailChangeRevertedAtDBField: nil}).
+		Where(squirrel.Gt{EmailChangeRevertExpiresAtDBField: now}).
+		Suffix(fmt.Sprintf("RETURNING %s, %s, %s IS NOT NULL", EmailChangeUserIDDBField, EmailChangeOldEmailDBField, EmailChangeConfirmedAtDBField)).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var uid, oldEmail string
+	var confirmed bool
+	if err = tx.QueryRow(to, sql, args...).Scan(&uid, &oldEmail, &confirmed); err == pgx.ErrNoRows {
+		s.LogError(InvalidEmailChangeTokenError, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.cancelPendingEmailChanges(to, tx, uid); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	update := s.StmtBuilder.Update(UserTableName).
+		Set(UserUpdatedAtDBField, now).
+		Where(squirrel.Eq{UserIDDBField: uid})
+	if confirmed {
+		// the old email could be taken by a signup since the change.
+		if taken, err := s.IsUserFieldTaken(UserEmailDBField, oldEmail, uid); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		} else if taken {
+			s.LogError(EmailAlreadyExistsError, http.StatusBadRequest)
+			return
+		}
+		update = update.Set(UserEmailDBField, oldEmail)
+	}
+	sql, args, err = update.Suffix(fmt.Sprintf("RETURNING %s", UserEmailDBField)).ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var email string
+	if err = tx.QueryRow(to, sql, args...).Scan(&email); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.ForceLogout(to, tx, uid); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserEmailChangeResponse{Success: true, Email: email}, http.StatusOK)
+}
@@ -40,7 +40,254 @@ var InvalidJWTTokenExpiredError = errors.New("invalid JWT, token has expired")
 
 var InvalidJWTGeneral = errors.New("invalid JWT")
 
+var TokenRevokedError = errors.New("token is revoked, please login again")
+
+var InvalidPasswordResetTokenError = errors.New("password reset token is invalid or expired")
+
+var TwoFactorRequiredError = errors.New("two factor authentication is required, verify your code at /api/user/login/2fa")
+
+var TwoFactorNotLoginTokenError = errors.New("token is not a two factor login token")
+
+var TwoFactorAlreadyEnabledError = errors.New("two factor authentication is already enabled")
+
+var TwoFactorNotEnrolledError = errors.New("two factor authentication is not enrolled, enroll first")
+
+var TwoFactorNotEnabledError = errors.New("two factor authentication is not enabled")
+
+var TwoFactorRequiredForRoleError = func(role string) error {
+	return fmt.Errorf("two factor authentication can not be disabled for role %s", role)
+}
+
+var InvalidTwoFactorCodeError = errors.New("two factor code is invalid")
+
+var errTwoFactorLocked = errors.New("too many wrong two factor codes")
+
+var TwoFactorLockedError = func(lockedUntil time.Time) error {
+	return fmt.Errorf("%w, try again after %s", errTwoFactorLocked, lockedUntil.Sub(time.Now()).Round(time.Second))
+}
+
+var OIDCProviderNotFoundError = func(provider string) error {
+	return fmt.Errorf("identity provider %s is not configured", provider)
+}
+
+var OIDCProviderError = func(provider string, reason string) error {
+	return fmt.Errorf("identity provider %s: %s", provider, reason)
+}
+
+var InvalidOIDCIDTokenError = func(reason string) error {
+	return fmt.Errorf("invalid ID token: %s", reason)
+}
+
+var InvalidOIDCStateError = errors.New("login state is invalid or expired, start the login again")
+
+var OIDCIdentityNotLinkedError = func(provider string) error {
+	return fmt.Errorf("no account is linked to this %s identity, sign up and link it from the account settings", provider)
+}
+
+var OIDCIdentityLinkedToAnotherUserError = errors.New("identity is already linked to another account")
+
+var OIDCProviderAlreadyLinkedError = func(provider string) error {
+	return fmt.Errorf("another %s identity is already linked to the account, unlink it first", provider)
+}
+
+var WrongCurrentPasswordError = errors.New("current password is wrong")
+
+var PasswordReusedError = func(historyLength int) error {
+	return fmt.Errorf("new password must be different from your last %d passwords", historyLength)
+}
+
+var InvalidAPIKeyError = errors.New("API key is invalid, expired or revoked")
+
+var APIKeyDoesNotExistError = errors.New("API key does not exist")
+
+var APIKeyScopeNotGrantedError = func(scope string) error {
+	return fmt.Errorf("API key is not granted the %s scope", scope)
+}
+
+var InvalidAPIKeyScopeError = func(scope string) error {
+	return fmt.Errorf("unknown API key scope %s", scope)
+}
+
+var InvalidAPIKeyExpiryError = func(maxLifetime time.Duration) error {
+	return fmt.Errorf("API key expiry must be in the future, and at most %s from now", maxLifetime)
+}
+
+var TooManyAPIKeysError = func(max int) error {
+	return fmt.Errorf("a user can have at most %d active API keys, revoke the unused ones", max)
+}
+
+var PhoneAlreadyVerifiedError = errors.New("phone number is already verified")
+var PhoneNumberRequiredError = errors.New("add a phone number first")
+
+var InvalidPhoneCodeError = errors.New("phone verification code is invalid or expired")
+
+var PhoneCodeAttemptsExceededError = errors.New("too many wrong phone verification codes, request a new code")
+
+var PhoneCodeRateLimitedError = func(retryAt time.Time) error {
+	return fmt.Errorf("too many phone verification codes are requested, try again after %s", retryAt.Sub(time.Now()).Round(time.Second))
+}
+
+var WrongPasswordError = errors.New("password is wrong")
+
+var UnknownPasswordHashError = errors.New("unknown password hash format")
+
+var BreachedPasswordError = errors.New("password appears in a known data breach and is likely to be guessed, choose another password")
+
+var InvalidBloomFilterError = errors.New("invalid or truncated bloom filter")
+
+var SessionRevokedError = errors.New("session is revoked, please login again")
+
+var SessionDoesNotExistError = errors.New("session does not exist")
+
+var SessionTokenRequiredError = errors.New("token does not belong to a session, please login again")
+
+var DataExportRateLimitedError = func(retryAt time.Time) error {
+	return fmt.Errorf("a data export is already requested, try again after %s", retryAt.Sub(time.Now()).Round(time.Second))
+}
+
+var InvalidDataExportTokenError = errors.New("data export does not exist or is expired")
+
+var UnsupportedMergePatchMediaTypeError = errors.New("unsupported media type, use application/merge-patch+json")
+
+var FieldNotNullableError = func(field string) error {
+	return fmt.Errorf("%s can not be null", field)
+}
+
+var InvalidDisplayTextError = func(field string, maxLength int) error {
+	return fmt.Errorf("%s must be at most %d characters without control characters", field, maxLength)
+}
+
+var InvalidLocationError = errors.New("city, state and country do not match")
+
+var UnsupportedImageTypeError = errors.New("image must be JPEG, PNG or WebP")
+
+var InvalidImageError = errors.New("image is corrupt or truncated")
+
+var ImageDimensionsTooLargeError = fmt.Errorf("image must have at most %d pixels", avatarMaxPixels)
+
+var AvatarTooLargeError = fmt.Errorf("avatar must be at most %d MB", avatarMaxBytes>>20)
+
+var MissingAvatarError = errors.New("avatar field is missing in the multipart form")
+
+var ReputationPrivilegeRequiredError = func(privilege string) error {
+	for _, p := range reputationPrivileges {
+		if p.Name == privilege {
+			return fmt.Errorf("%s requires a reputation of at least %d", privilege, p.Threshold)
+		}
+	}
+	return fmt.Errorf("%s is not a reputation privilege", privilege)
+}
+
+var PlaceDoesNotExistError = errors.New("place does not exist")
+
+var InvalidWebsiteError = errors.New("website must be an http or https URL")
+
+var ReviewDoesNotExistError = errors.New("review does not exist")
+
+var ReportDoesNotExistError = errors.New("report does not exist")
+
+var CannotFollowSelfError = errors.New("users cannot follow themselves")
+
+var InvalidCursorError = errors.New("cursor is invalid")
+
+var InvalidPageLimitError = fmt.Errorf("limit must be between 1 and %d", pageLimitMax)
+
+var MissingNotificationIDsError = errors.New("ids or all must be set")
+
+var StreamingUnsupportedError = errors.New("streaming is not supported by the connection")
+
+var InvalidCuisineError = func(cuisine string) error {
+	return fmt.Errorf("%q is not a cuisine, cuisines are letters, digits and underscores", cuisine)
+}
+
+var InvalidCoordinatesError = errors.New("lat and lon must be valid coordinates")
+
+var LocationRequiredError = errors.New("lat and lon are required for users without a city")
+
+var InvalidRadiusError = fmt.Errorf("radius must be between 0 and %d kilometres", recommendationRadiusMax)
+
+var UsernameReservedError = errors.New("username is reserved")
+
+var MissingUsernameError = errors.New("u is required")
+
+var UsernameCheckRateLimitedError = func(retryAt time.Time) error {
+	return fmt.Errorf("too many username checks, try again after %s", retryAt.Sub(time.Now()).Round(time.Second))
+}
+
+var PasswordForgotRateLimitedError = func(retryAt time.Time) error {
+	return fmt.Errorf("too many password reset requests, try again after %s", retryAt.Sub(time.Now()).Round(time.Second))
+}
+
+var InvalidEmailChangeTokenError = errors.New("email change token is invalid or expired")
+
+var EmailChangeRateLimitedError = func(retryAt time.Time) error {
+	return fmt.Errorf("an email change is just requested, try again after %s", retryAt.Sub(time.Now()).Round(time.Second))
+}
+
+var PasswordResetRequiredError = errors.New("password must be reset, follow the link sent to your email")
+
+var CannotChangeOwnRoleError = errors.New("admins can not change their own role")
+
+var InvalidAdminFilterError = func(name string) error {
+	return fmt.Errorf("%s filter is invalid", name)
+}
+
+var ImpersonationNotAllowedError = errors.New("impersonation tokens can not be used for this action")
+
+var CannotImpersonateError = errors.New("admins can not impersonate themselves or other admins")
+
+var ImpersonationRevokedError = errors.New("impersonation is revoked, the actor is not an admin anymore")
+
+var CSRFTokenMismatchError = errors.New("X-CSRF-Token header does not match the CSRF cookie")
+
+var BanExpiryInPastError = errors.New("expiresAt must be in the future")
+
+var UserNotBannedError = errors.New("user is not banned")
+
+// error codes of ErrorResponse, set for the errors clients handle without parsing the message.
+const (
+	errorCodeUserBanned    = "USER_BANNED"
+	errorCodeUserSuspended = "USER_SUSPENDED"
+)
+
+// UserBannedError is returned when a banned user logs in or uses a token or an API key. Bans without ExpiresAt are
+// permanent, the others are suspensions.
+type UserBannedError struct {
+	Reason    string
+	ExpiresAt *time.Time
+}
+
+func (e UserBannedError) Error() string {
+	if e.ExpiresAt == nil {
+		return fmt.Sprintf("user is banned: %s", e.Reason)
+	}
+	return fmt.Sprintf("user is suspended until %s: %s", e.ExpiresAt.UTC().Format(time.RFC3339), e.Reason)
+}
+
+// Code returns errorCodeUserBanned for permanent bans and errorCodeUserSuspended for suspensions.
+func (e UserBannedError) Code() string {
+	if e.ExpiresAt == nil {
+		return errorCodeUserBanned
+	}
+	return errorCodeUserSuspended
+}
+
 type ErrorResponse struct {
 	Error     string `json:"error"`
 	RequestID string `json:"request_id"`
+	// Code is USER_BANNED or USER_SUSPENDED for the requests of banned users, omitted for the other errors.
+	Code string `json:"code,omitempty"`
+	// ExpiresAt is the end of the suspension for USER_SUSPENDED.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// NewErrorResponse returns the ErrorResponse of the error, with the code of the errors that have one.
+func NewErrorResponse(err error, requestID string) ErrorResponse {
+	response := ErrorResponse{Error: err.Error(), RequestID: requestID}
+	var banned UserBannedError
+	if errors.As(err, &banned) {
+		response.Code = banned.Code()
+		response.ExpiresAt = banned.ExpiresAt
+	}
+	return response
 }
@@ -0,0 +1,721 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DataExportTableName          = "data_exports"
+	DataExportIDDBField          = "id"
+	DataExportUserIDDBField      = "user_id"
+	DataExportStatusDBField      = "status"
+	DataExportTokenHashDBField   = "token_hash"
+	DataExportFilePathDBField    = "file_path"
+	DataExportSizeDBField        = "size"
+	DataExportErrorDBField       = "error"
+	DataExportCreatedAtDBField   = "created_at"
+	DataExportStartedAtDBField   = "started_at"
+	DataExportCompletedAtDBField = "completed_at"
+	DataExportExpiresAtDBField   = "expires_at"
+)
+
+// statuses of the data exports, pending -> running -> ready -> expired, or failed.
+const (
+	dataExportStatusPending = "pending"
+	dataExportStatusRunning = "running"
+	dataExportStatusReady   = "ready"
+	dataExportStatusFailed  = "failed"
+	dataExportStatusExpired = "expired"
+)
+
+const (
+	// dataExportDuration is the lifetime of the download link and the archive.
+	dataExportDuration = 48 * time.Hour
+	// dataExportInterval is the time between two exports of a user, failed exports do not count.
+	dataExportInterval = 24 * time.Hour
+	// dataExportBatchSize is the count of exports built in one run of ProcessDataExports.
+	dataExportBatchSize = 10
+	// running exports older than dataExportStaleDuration are assumed to be interrupted, and built again.
+	dataExportStaleDuration = time.Hour
+)
+
+// DataExportDir returns the directory the archives are stored in until they expire, set with DATA_EXPORT_DIR in the
+// environment (or in the .env file). Defaults to persephone-exports in the temporary directory.
+func DataExportDir() string {
+	if dir := os.Getenv("DATA_EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "persephone-exports")
+}
+
+// exportTable is a table of the archive, written both as JSON and CSV.
+type exportTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+	// Single tables have one row, written as a JSON object instead of an array.
+	Single bool
+}
+
+// exportValue converts the values pgx scans into values that are written the same in JSON and CSV.
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case [16]byte:
+		return uuid.UUID(v).String()
+	case netip.Prefix:
+		if v.IsSingleIP() {
+			return v.Addr().String()
+		}
+		return v.String()
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = exportValue(v[i])
+		}
+		return values
+	default:
+		return v
+	}
+}
+
+func exportCSVValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = exportCSVValue(v[i])
+		}
+		return strings.Join(values, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteTo writes <name>.json and <name>.csv to the archive.
+func (t exportTable) WriteTo(zw *zip.Writer) error {
+	records := make([]map[string]interface{}, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		record := make(map[string]interface{}, len(t.Columns))
+		for i, column := range t.Columns {
+			record[column] = exportValue(row[i])
+		}
+		records = append(records, record)
+	}
+	w, err := zw.Create(t.Name + ".json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if t.Single {
+		var record map[string]interface{}
+		if len(records) > 0 {
+			record = records[0]
+		}
+		err = encoder.Encode(record)
+	} else {
+		err = encoder.Encode(records)
+	}
+	if err != nil {
+		return err
+	}
+	w, err = zw.Create(t.Name + ".csv")
+	if err != nil {
+		return err
+	}
+	csvWriter := csv.NewWriter(w)
+	if err = csvWriter.Write(t.Columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		line := make([]string, len(t.Columns))
+		for i, column := range t.Columns {
+			line[i] = exportCSVValue(record[column])
+		}
+		if err = csvWriter.Write(line); err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+func queryExportTable(ctx context.Context, db *pgxpool.Pool, name string, query squirrel.SelectBuilder) (exportTable, error) {
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return exportTable{}, err
+	}
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return exportTable{}, err
+	}
+	defer rows.Close()
+	table := exportTable{Name: name}
+	for _, field := range rows.FieldDescriptions() {
+		table.Columns = append(table.Columns, field.Name)
+	}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return exportTable{}, err
+		}
+		table.Rows = append(table.Rows, values)
+	}
+	return table, rows.Err()
+}
+
+// dataExportREADME is the first file of the archive.
+const dataExportREADME = `This archive contains the personal data stored for your account, every file is both in JSON and CSV.
+
+profile           your account, without the password and the secrets
+login_history     every login, with the IP address and the device
+reviews           reviews you wrote
+review_replies    replies you wrote
+reports           reports you filed about other users
+identities        accounts of the identity providers linked to your account
+api_keys          API keys you created, without the keys
+reputation        every change of your reputation, with the reason
+badges            badges your reputation earned
+bans              bans and suspensions of your account
+following         users you follow
+notifications     notifications you received
+email_changes     changes of your email, without the links
+
+Votes on the reviews and the replies are only stored as counts, not per user, so they are not in the archive.
+`
+
+// dataExportQuery is a file of the archive. Table is the table the rows are read from, every exported table is either
+// erased or kept on purpose by anonymizeUser, see personalDataTables.
+type dataExportQuery struct {
+	name   string
+	table  string
+	single bool
+	query  squirrel.SelectBuilder
+}
+
+// dataExportQueries returns the queries of the files of the user's archive.
+func dataExportQueries(stmtBuilder squirrel.StatementBuilderType, uid string) []dataExportQuery {
+	users := func(field string) string {
+		return fmt.Sprintf("%s.%s", UserTableName, field)
+	}
+	return []dataExportQuery{
+		{"profile", UserTableName, true, stmtBuilder.
+			Select(
+				users(UserIDDBField),
+				users(UserEmailDBField),
+				users(UserUsernameDBField),
+				users(UserDisplayNameDBField),
+				users(UserBioDBField),
+				users(UserAvatarKeyDBField),
+				users(UserPhoneNumberDBField),
+				users(UserPhoneVerifiedDBField),
+				users(UserRoleDBField),
+				users(UserReputationDBField),
+				users(UserVerifiedDBField),
+				users(UserTOTPEnabledDBField),
+				users(UserProfileHiddenFieldsDBField),
+				users(UserNotificationDisabledTypesDBField),
+				users(UserDietRestrictionsDBField),
+				users(UserFavouriteCuisinesDBField),
+				users(UserAllergensDBField),
+				users(UserPreferredLanguageDBField),
+				users(UserDistanceUnitDBField),
+				fmt.Sprintf("%s.%s AS city", CityTable, CityNameDBField),
+				fmt.Sprintf("%s.%s AS state", StateTable, StateNameDBField),
+				fmt.Sprintf("%s.%s AS country", CountryTable, CountryNameDBField),
+				users(UserSignupIPDBField),
+				users(UserLastLoginIPDBField),
+				users(UserLastLoginAtDBField),
+				users(UserEmailLastUpdatedAtDBField),
+				users(UserUsernameLastUpdatedAtDBField),
+				users(UserCreatedAtDBField),
+				users(UserUpdatedAtDBField)).
+			From(UserTableName).
+			LeftJoin(fmt.Sprintf("%s on %s.%s = %s.%s", CityTable, CityTable, CityIDDBField, UserTableName, UserCityDBField)).
+			LeftJoin(fmt.Sprintf("%s on %s.%s = %s.%s", StateTable, StateTable, StateIDDBField, UserTableName, UserStateDBField)).
+			LeftJoin(fmt.Sprintf("%s on %s.%s = %s.%s", CountryTable, CountryTable, CountryIDDBField, UserTableName, UserCountryDBField)).
+			Where(squirrel.Eq{users(UserIDDBField): uid})},
+		{"login_history", LoginHistoryTableName, false, stmtBuilder.
+			Select(
+				LoginHistoryIDDBField,
+				LoginHistoryIPDBField,
+				LoginHistoryUserAgentDBField,
+				LoginHistoryBrowserDBField,
+				LoginHistoryOSDBField,
+				LoginHistoryDeviceTypeDBField,
+				LoginHistoryCountryDBField,
+				LoginHistoryMethodDBField,
+				LoginHistoryCreatedAtDBField,
+				LoginHistoryLastSeenAtDBField,
+				LoginHistoryRevokedAtDBField).
+			From(LoginHistoryTableName).
+			Where(squirrel.Eq{LoginHistoryUserIDDBField: uid}).
+			OrderBy(LoginHistoryCreatedAtDBField)},
+		{"reviews", ReviewsTable, false, stmtBuilder.
+			Select(
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewIDDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewPlaceIDDBField),
+				fmt.Sprintf("%s.%s AS place_name", RestaurantsTable, RestaurantNameDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewTitleDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewTextDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewHelpfulCountDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewDislikeCountDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewCreatedAtDBField),
+				fmt.Sprintf("%s.%s", ReviewsTable, ReviewUpdatedAtDBField)).
+			From(ReviewsTable).
+			LeftJoin(fmt.Sprintf("%s on %s.%s = %s.%s", RestaurantsTable, RestaurantsTable, RestaurantIDDBField, ReviewsTable, ReviewPlaceIDDBField)).
+			Where(squirrel.Eq{fmt.Sprintf("%s.%s", ReviewsTable, ReviewUserIDDBField): uid}).
+			OrderBy(fmt.Sprintf("%s.%s", ReviewsTable, ReviewCreatedAtDBField))},
+		{"review_replies", ReviewReplyTable, false, stmtBuilder.
+			Select(
+				ReviewReplyIDDBField,
+				ReviewReplyReviewIDDBField,
+				ReviewReplyTextDBField,
+				ReviewReplyHelpfulCountDBField,
+				ReviewReplyDislikeCountDBField,
+				ReviewReplyCreatedAtDBField,
+				ReviewReplyUpdatedAtDBField).
+			From(ReviewReplyTable).
+			Where(squirrel.Eq{ReviewReplyUserIDDBField: uid}).
+			OrderBy(ReviewReplyCreatedAtDBField)},
+		{"reports", UserReportTableName, false, stmtBuilder.
+			Select(
+				fmt.Sprintf("%s.%s", ReportTableName, ReportIDDBField),
+				fmt.Sprintf("%s.%s AS reported_user_id", UserReportTableName, UserReportUserIDDBField),
+				fmt.Sprintf("%s.%s", ReportTableName, ReportReasonDBField),
+				fmt.Sprintf("%s.%s", ReportTableName, ReportStatusDBField)).
+			From(UserReportTableName).
+			Join(fmt.Sprintf("%s on %s.%s = %s.%s", ReportTableName, ReportTableName, ReportIDDBField, UserReportTableName, UserReportReportIDDBField)).
+			Where(squirrel.Eq{fmt.Sprintf("%s.%s", UserReportTableName, UserReportReporterIDDBField): uid})},
+		{"identities", UserIdentityTableName, false, stmtBuilder.
+			Select(UserIdentityProviderDBField, UserIdentitySubjectDBField, UserIdentityEmailDBField, UserIdentityCreatedAtDBField, UserIdentityLastLoginAtDBField).
+			From(UserIdentityTableName).
+			Where(squirrel.Eq{UserIdentityUserIDDBField: uid})},
+		{"api_keys", APIKeyTableName, false, stmtBuilder.
+			Select(APIKeyIDDBField, APIKeyLabelDBField, APIKeyPrefixDBField, APIKeyScopesDBField, APIKeyUsageCountDBField, APIKeyLastUsedAtDBField, APIKeyExpiresAtDBField, APIKeyRevokedAtDBField, APIKeyCreatedAtDBField).
+			From(APIKeyTableName).
+			Where(squirrel.Eq{APIKeyOwnerIDDBField: uid})},
+		// the moderators who adjusted the reputation are not exported.
+		{"reputation", ReputationEventTableName, false, stmtBuilder.
+			Select(ReputationEventTypeDBField, ReputationEventPointsDBField, ReputationEventSourceIDDBField, ReputationEventReasonDBField, ReputationEventCreatedAtDBField).
+			From(ReputationEventTableName).
+			Where(squirrel.Eq{ReputationEventUserIDDBField: uid}).
+			OrderBy(ReputationEventCreatedAtDBField)},
+		{"badges", UserBadgeTableName, false, stmtBuilder.
+			Select(UserBadgeBadgeDBField, UserBadgeAwardedAtDBField).
+			From(UserBadgeTableName).
+			Where(squirrel.Eq{UserBadgeUserIDDBField: uid}).
+			OrderBy(UserBadgeAwardedAtDBField)},
+		{"bans", UserBanTableName, false, stmtBuilder.
+			Select(UserBanReasonDBField, UserBanCreatedAtDBField, UserBanExpiresAtDBField, UserBanLiftedAtDBField, UserBanLiftReasonDBField).
+			From(UserBanTableName).
+			Where(squirrel.Eq{UserBanUserIDDBField: uid}).
+			OrderBy(UserBanCreatedAtDBField)},
+		// the followers are the data of the users who follow, only the followees are exported.
+		{"following", UserFollowTableName, false, stmtBuilder.
+			Select(fmt.Sprintf("%s.%s", UserTableName, UserUsernameDBField), fmt.Sprintf("%s.%s", UserFollowTableName, UserFollowCreatedAtDBField)).
+			From(UserFollowTableName).
+			Join(fmt.Sprintf("%s on %s.%s = %s.%s", UserTableName, UserTableName, UserIDDBField, UserFollowTableName, UserFollowFolloweeIDDBField)).
+			Where(squirrel.Eq{fmt.Sprintf("%s.%s", UserFollowTableName, UserFollowFollowerIDDBField): uid}).
+			OrderBy(fmt.Sprintf("%s.%s", UserFollowTableName, UserFollowCreatedAtDBField))},
+		{"notifications", NotificationTableName, false, stmtBuilder.
+			Select(NotificationTypeDBField, NotificationMessageDBField, NotificationCreatedAtDBField, NotificationReadAtDBField).
+			From(NotificationTableName).
+			Where(squirrel.Eq{NotificationUserIDDBField: uid}).
+			OrderBy(NotificationCreatedAtDBField)},
+		{"email_changes", EmailChangeTableName, false, stmtBuilder.
+			Select(EmailChangeOldEmailDBField, EmailChangeNewEmailDBField, EmailChangeCreatedAtDBField, EmailChangeConfirmedAtDBField, EmailChangeRevertedAtDBField, EmailChangeCancelledAtDBField).
+			From(EmailChangeTableName).
+			Where(squirrel.Eq{EmailChangeUserIDDBField: uid}).
+			OrderBy(EmailChangeCreatedAtDBField)},
+	}
+}
+
+// BuildDataExport writes the archive of the user's personal data to w.
+func BuildDataExport(ctx context.Context, db *pgxpool.Pool, uid string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	readme, err := zw.Create("README.txt")
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(readme, dataExportREADME); err != nil {
+		return err
+	}
+	for _, q := range dataExportQueries(squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar), uid) {
+		table, err := queryExportTable(ctx, db, q.name, q.query)
+		if err != nil {
+			return fmt.Errorf("%s: %w", q.name, err)
+		}
+		table.Single = q.single
+		if err = table.WriteTo(zw); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// ProcessDataExports builds the pending exports and emails the download links, and removes the expired archives.
+// It is scheduled in main.go.
+func ProcessDataExports(db *pgxpool.Pool) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := expireDataExports(to, db, stmtBuilder); err != nil {
+		log.Printf("error expiring data exports: %v", err)
+	}
+	// claim the pending exports, and the interrupted ones, so they are not built twice.
+	sql, args, err := stmtBuilder.Update(DataExportTableName).
+		Set(DataExportStatusDBField, dataExportStatusRunning).
+		Set(DataExportStartedAtDBField, time.Now()).
+		Where(fmt.Sprintf("%s IN (SELECT %s FROM %s WHERE %s = ? OR (%s = ? AND %s < ?) ORDER BY %s LIMIT %d FOR UPDATE SKIP LOCKED)",
+			DataExportIDDBField, DataExportIDDBField, DataExportTableName,
+			DataExportStatusDBField, DataExportStatusDBField, DataExportStartedAtDBField,
+			DataExportCreatedAtDBField, dataExportBatchSize),
+			dataExportStatusPending, dataExportStatusRunning, time.Now().Add(-dataExportStaleDuration)).
+		Suffix(fmt.Sprintf("RETURNING %s, %s", DataExportIDDBField, DataExportUserIDDBField)).
+		ToSql()
+	if err != nil {
+		log.Printf("error processing data exports: %v", err)
+		return
+	}
+	rows, err := db.Query(to, sql, args...)
+	if err != nil {
+		log.Printf("error processing data exports: %v", err)
+		return
+	}
+	type claimedExport struct {
+		id  string
+		uid string
+	}
+	var claimed []claimedExport
+	for rows.Next() {
+		var export claimedExport
+		if err = rows.Scan(&export.id, &export.uid); err != nil {
+			break
+		}
+		claimed = append(claimed, export)
+	}
+	rows.Close()
+	if err != nil {
+		log.Printf("error processing data exports: %v", err)
+		return
+	}
+	mailer := NewMailer()
+	for _, export := range claimed {
+		if err = buildDataExport(to, db, stmtBuilder, mailer, export.id, export.uid); err != nil {
+			log.Printf("error building data export %s: %v", export.id, err)
+			sql, args, _ := stmtBuilder.Update(DataExportTableName).
+				Set(DataExportStatusDBField, dataExportStatusFailed).
+				Set(DataExportErrorDBField, err.Error()).
+				Set(DataExportCompletedAtDBField, time.Now()).
+				Where(squirrel.Eq{DataExportIDDBField: export.id}).
+				ToSql()
+			if _, err = db.Exec(to, sql, args...); err != nil {
+				log.Printf("error marking data export %s as failed: %v", export.id, err)
+			}
+		}
+	}
+}
+
+func buildDataExport(ctx context.Context, db *pgxpool.Pool, stmtBuilder squirrel.StatementBuilderType, mailer Mailer, id string, uid string) error {
+	dir := DataExportDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, id+".zip")
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	err = BuildDataExport(ctx, db, uid, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	token, tokenHash, err := GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(dataExportDuration)
+	sql, args, err := stmtBuilder.Update(DataExportTableName).
+		Set(DataExportStatusDBField, dataExportStatusReady).
+		Set(DataExportTokenHashDBField, tokenHash).
+		Set(DataExportFilePathDBField, path).
+		Set(DataExportSizeDBField, info.Size()).
+		Set(DataExportCompletedAtDBField, time.Now()).
+		Set(DataExportExpiresAtDBField, expiresAt).
+		Where(squirrel.Eq{DataExportIDDBField: id}).
+		Suffix(fmt.Sprintf("RETURNING (SELECT %s FROM %s WHERE %s = %s.%s)", UserEmailDBField, UserTableName, UserIDDBField, DataExportTableName, DataExportUserIDDBField)).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	var email string
+	if err = db.QueryRow(ctx, sql, args...).Scan(&email); err != nil {
+		os.Remove(path)
+		return err
+	}
+	link := fmt.Sprintf("%s/api/user/export/download?token=%s", AppBaseURL(), token)
+	body := fmt.Sprintf("The archive of your personal data is ready. Download it from the link below until %s:\n\n%s\n\nIf you did not request it, change your password.", expiresAt.UTC().Format(time.RFC1123), link)
+	if err = mailer.Send(email, "Your data export is ready", body); err != nil {
+		log.Printf("error sending data export %s link: %v", id, err)
+	}
+	return nil
+}
+
+func expireDataExports(ctx context.Context, db *pgxpool.Pool, stmtBuilder squirrel.StatementBuilderType) error {
+	sql, args, err := stmtBuilder.Select(DataExportIDDBField, DataExportFilePathDBField).
+		From(DataExportTableName).
+		Where(squirrel.Eq{DataExportStatusDBField: dataExportStatusReady}).
+		Where(squirrel.Lt{DataExportExpiresAtDBField: time.Now()}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id, path string
+		if err = rows.Scan(&id, &path); err != nil {
+			break
+		}
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("error removing expired data export %s: %v", path, err)
+		}
+		err = nil
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	sql, args, err = stmtBuilder.Update(DataExportTableName).
+		Set(DataExportStatusDBField, dataExportStatusExpired).
+		Set(DataExportTokenHashDBField, nil).
+		Set(DataExportFilePathDBField, "").
+		Where(squirrel.Eq{DataExportIDDBField: ids}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, sql, args...)
+	return err
+}
+
+// UserDataExport is a data export of the user.
+type UserDataExport struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Size        int64      `json:"size"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+}
+
+// UserDataExportsResponse lists the data exports of the user, the latest first.
+//
+// swagger:model UserDataExportsResponse
+type UserDataExportsResponse struct {
+	Exports []UserDataExport `json:"exports"`
+}
+
+// UserDataExportRequestHandler requests an archive of the user's personal data.
+//
+//	@Summary					Request data export
+//	@Description				Enqueues an export of the personal data of the user. The archive is built in the background, the download link is emailed and expires in 48 hours. One export can be requested every 24 hours.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Success					202				{object}	UserDataExport	"Export is enqueued"
+//	@Failure					429				{object}	ErrorResponse	"An export is already requested in the last 24 hours"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/user/export [post]
+func UserDataExportRequestHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(fmt.Sprintf("MAX(%s)", DataExportCreatedAtDBField)).
+		From(DataExportTableName).
+		Where(squirrel.Eq{DataExportUserIDDBField: jwtContents.UUID}).
+		Where(squirrel.NotEq{DataExportStatusDBField: dataExportStatusFailed}).
+		Where(squirrel.Gt{DataExportCreatedAtDBField: time.Now().Add(-dataExportInterval)}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var lastCreatedAt *time.Time
+	if rows.Next() {
+		err = rows.Scan(&lastCreatedAt)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if lastCreatedAt != nil {
+		s.LogError(DataExportRateLimitedError(lastCreatedAt.Add(dataExportInterval)), http.StatusTooManyRequests)
+		return
+	}
+	rows, err = s.QuerySQL(s.StmtBuilder.Insert(DataExportTableName).
+		Columns(DataExportUserIDDBField, DataExportStatusDBField).
+		Values(jwtContents.UUID, dataExportStatusPending).
+		Suffix(fmt.Sprintf("RETURNING %s, %s, %s", DataExportIDDBField, DataExportStatusDBField, DataExportCreatedAtDBField)))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var export UserDataExport
+	if rows.Next() {
+		err = rows.Scan(&export.ID, &export.Status, &export.CreatedAt)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(export, http.StatusAccepted)
+}
+
+// UserDataExportsHandler lists the data exports of the user.
+//
+//	@Summary					List data exports
+//	@Description				Lists the data exports of the user with their status, the latest first.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Success					200				{object}	UserDataExportsResponse	"Data exports"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/user/export [get]
+func UserDataExportsHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.
+		Select(DataExportIDDBField, DataExportStatusDBField, DataExportSizeDBField, DataExportCreatedAtDBField, DataExportCompletedAtDBField, DataExportExpiresAtDBField).
+		From(DataExportTableName).
+		Where(squirrel.Eq{DataExportUserIDDBField: jwtContents.UUID}).
+		OrderBy(DataExportCreatedAtDBField + " DESC"))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	exports := make([]UserDataExport, 0)
+	for rows.Next() {
+		var export UserDataExport
+		if err = rows.Scan(&export.ID, &export.Status, &export.Size, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		exports = append(exports, export)
+	}
+	s.WriteResponse(UserDataExportsResponse{Exports: exports}, http.StatusOK)
+}
+
+// UserDataExportDownloadHandler serves the archive of an export, the emailed token is the only credential.
+//
+//	@Summary		Download data export
+//	@Description	Downloads the archive of a data export with the token in the emailed link.
+//	@Tags			User
+//	@Produce		application/zip
+//	@Param			token	query		string			true	"Download token"
+//	@Success		200		{file}		file			"ZIP archive"
+//	@Failure		404		{object}	ErrorResponse	"Token is invalid or expired"
+//	@Failure		500		{object}	ErrorResponse	"Internal server error"
+//	@Router			/api/user/export/download [get]
+func UserDataExportDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		s.LogError(InvalidDataExportTokenError, http.StatusNotFound)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(DataExportFilePathDBField, DataExportCompletedAtDBField).
+		From(DataExportTableName).
+		Where(squirrel.Eq{DataExportTokenHashDBField: HashToken(token), DataExportStatusDBField: dataExportStatusReady}).
+		Where(squirrel.Gt{DataExportExpiresAtDBField: time.Now()}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var path string
+	var completedAt time.Time
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&path, &completedAt)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.LogError(InvalidDataExportTokenError, http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		s.LogError(InvalidDataExportTokenError, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	filename := "persephone-export-" + strconv.FormatInt(completedAt.Unix(), 10) + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, filename, completedAt, file)
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func readZipFile(t *testing.T, zr *zip.Reader, name string) []byte {
+	file, err := zr.Open(name)
+	assert.Nil(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	assert.Nil(t, err)
+	return content
+}
+
+func TestExportTableWriteTo(t *testing.T) {
+	id := uuid.New()
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("TRT", 3*60*60))
+	table := exportTable{
+		Name:    "login_history",
+		Columns: []string{"id", "ip", "scopes", "created_at", "revoked_at"},
+		Rows: [][]interface{}{
+			{[16]byte(id), netip.MustParsePrefix("203.0.113.7/32"), []interface{}{"a", "b"}, createdAt, nil},
+		},
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	assert.Nil(t, table.WriteTo(zw))
+	assert.Nil(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	var records []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(readZipFile(t, zr, "login_history.json"), &records))
+	assert.Len(t, records, 1)
+	assert.Equal(t, id.String(), records[0]["id"])
+	assert.Equal(t, "203.0.113.7", records[0]["ip"])
+	assert.Equal(t, []interface{}{"a", "b"}, records[0]["scopes"])
+	assert.Equal(t, "2024-01-02T00:04:05Z", records[0]["created_at"])
+	assert.Nil(t, records[0]["revoked_at"])
+	lines, err := csv.NewReader(bytes.NewReader(readZipFile(t, zr, "login_history.csv"))).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"id", "ip", "scopes", "created_at", "revoked_at"},
+		{id.String(), "203.0.113.7", "a;b", "2024-01-02T00:04:05Z", ""},
+	}, lines)
+}
+
+func TestExportTableWriteToSingle(t *testing.T) {
+	table := exportTable{Name: "profile", Columns: []string{"username"}, Rows: [][]interface{}{{TestUsername}}, Single: true}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	assert.Nil(t, table.WriteTo(zw))
+	assert.Nil(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	var profile map[string]interface{}
+	assert.Nil(t, json.Unmarshal(readZipFile(t, zr, "profile.json"), &profile))
+	assert.Equal(t, TestUsername, profile["username"])
+}
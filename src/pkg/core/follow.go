@@ -0,0 +1,658 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	UserFollowTableName         = "user_follows"
+	UserFollowFollowerIDDBField = "follower_id"
+	UserFollowFolloweeIDDBField = "followee_id"
+	UserFollowCreatedAtDBField  = "created_at"
+
+	FeedInboxTableName        = "feed_inbox"
+	FeedInboxUserIDDBField    = "user_id"
+	FeedInboxKindDBField      = "kind"
+	FeedInboxItemIDDBField    = "item_id"
+	FeedInboxAuthorIDDBField  = "author_id"
+	FeedInboxCreatedAtDBField = "created_at"
+)
+
+// kinds of the feed items.
+const (
+	feedKindReview = "review"
+	feedKindReply  = "reply"
+)
+
+// limits of the cursor paginated lists.
+const (
+	pageLimitDefault = 20
+	pageLimitMax     = 100
+)
+
+const (
+	// feedInboxFollowingThreshold is the count of followees above which the feed of a user is materialized in
+	// feed_inbox, the fan-out-on-read query of such users scans too many reviews.
+	feedInboxFollowingThreshold = 500
+	// feedInboxWindow is how old the items of the inbox can be, older pages are read from the followees.
+	feedInboxWindow = 7 * 24 * time.Hour
+)
+
+// pageCursor is the position of the last item of a page, the next page starts after it. Items are sorted by
+// created_at, then kind and id to break the ties.
+type pageCursor struct {
+	CreatedAt time.Time
+	Kind      string
+	ID        string
+}
+
+// String encodes the cursor, clients pass it back as is.
+func (c pageCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d|%s|%s", c.CreatedAt.UnixMicro(), c.Kind, c.ID)))
+}
+
+// ParsePageCursor decodes a cursor returned by String, InvalidCursorError if it was not.
+func ParsePageCursor(cursor string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageCursor{}, InvalidCursorError
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 3 {
+		return pageCursor{}, InvalidCursorError
+	}
+	micro, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return pageCursor{}, InvalidCursorError
+	}
+	if parts[1] != "" && parts[1] != feedKindReview && parts[1] != feedKindReply {
+		return pageCursor{}, InvalidCursorError
+	}
+	if _, err = uuid.Parse(parts[2]); err != nil {
+		return pageCursor{}, InvalidCursorError
+	}
+	return pageCursor{CreatedAt: time.UnixMicro(micro).UTC(), Kind: parts[1], ID: parts[2]}, nil
+}
+
+// readPageLimit reads the limit query parameter, pageLimitDefault if it is not set.
+func readPageLimit(r *http.Request) (uint64, error) {
+	l := r.URL.Query().Get("limit")
+	if l == "" {
+		return pageLimitDefault, nil
+	}
+	limit, err := strconv.ParseUint(l, 10, 64)
+	if err != nil || limit == 0 || limit > pageLimitMax {
+		return 0, InvalidPageLimitError
+	}
+	return limit, nil
+}
+
+// readPageParams reads the cursor and limit query parameters, the cursor is nil for the first page.
+func readPageParams(r *http.Request) (*pageCursor, uint64, error) {
+	limit, err := readPageLimit(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, err := ParsePageCursor(c)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &cursor, limit, nil
+	}
+	return nil, limit, nil
+}
+
+// FollowResponse is the follow state of a user.
+//
+// swagger:model FollowResponse
+type FollowResponse struct {
+	Following      bool  `json:"following"`
+	FollowersCount int64 `json:"followersCount"`
+}
+
+// FollowUser is a user in a followers or following list.
+type FollowUser struct {
+	Username   string    `json:"username"`
+	FollowedAt time.Time `json:"followedAt"`
+}
+
+// FollowListResponse is a page of followers or followees. Count is the size of the whole list, NextCursor is empty
+// on the last page.
+//
+// swagger:model FollowListResponse
+type FollowListResponse struct {
+	Users      []FollowUser `json:"users"`
+	Count      int64        `json:"count"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// FeedItem is a review or a reply of a followee. Title is the title of the review, the replied review for replies.
+type FeedItem struct {
+	Kind           string    `json:"kind"`
+	ID             string    `json:"id"`
+	AuthorUsername string    `json:"authorUsername"`
+	ReviewID       string    `json:"reviewId"`
+	PlaceID        string    `json:"placeId"`
+	PlaceName      string    `json:"placeName"`
+	Title          string    `json:"title"`
+	Text           string    `json:"text"`
+	HelpfulCount   int64     `json:"helpfulCount"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// FeedResponse is a page of the feed, NextCursor is empty on the last page.
+//
+// swagger:model FeedResponse
+type FeedResponse struct {
+	Items      []FeedItem `json:"items"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// followTarget returns the id of the user with the username, deleted users can not be followed.
+func (s Server) followTarget(username string) (string, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{
+		UserUsernameDBField:  username,
+		UserDeletedAtDBField: nil,
+	}))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", UserDoesNotExistError
+	}
+	var uid string
+	if err = rows.Scan(&uid); err != nil {
+		return "", err
+	}
+	return uid, nil
+}
+
+// followCount counts the followers (or the followees) of the user, the field is the column of the user.
+func (s Server) followCount(uid string, field string) (int64, error) {
+	other := UserFollowFollowerIDDBField
+	if field == UserFollowFollowerIDDBField {
+		other = UserFollowFolloweeIDDBField
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select("COUNT(*)").From(UserFollowTableName).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", UserTableName, UserTableName, UserIDDBField, UserFollowTableName, other)).
+		Where(squirrel.Eq{
+			fmt.Sprintf("%s.%s", UserFollowTableName, field):          uid,
+			fmt.Sprintf("%s.%s", UserTableName, UserDeletedAtDBField): nil,
+		}))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var count int64
+	if rows.Next() {
+		err = rows.Scan(&count)
+	}
+	return count, err
+}
+
+// GetFollowList returns a page of the followers of the user, or of its followees when following is set. Deleted
+// users are left out of the list and of the count.
+func (s Server) GetFollowList(uid string, following bool, cursor *pageCursor, limit uint64) (FollowListResponse, error) {
+	field, other := UserFollowFolloweeIDDBField, UserFollowFollowerIDDBField
+	if following {
+		field, other = UserFollowFollowerIDDBField, UserFollowFolloweeIDDBField
+	}
+	query := s.StmtBuilder.
+		Select(
+			fmt.Sprintf("%s.%s", UserTableName, UserIDDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserUsernameDBField),
+			fmt.Sprintf("%s.%s", UserFollowTableName, UserFollowCreatedAtDBField)).
+		From(UserFollowTableName).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", UserTableName, UserTableName, UserIDDBField, UserFollowTableName, other)).
+		Where(squirrel.Eq{
+			fmt.Sprintf("%s.%s", UserFollowTableName, field):          uid,
+			fmt.Sprintf("%s.%s", UserTableName, UserDeletedAtDBField): nil,
+		}).
+		OrderBy(
+			fmt.Sprintf("%s.%s DESC", UserFollowTableName, UserFollowCreatedAtDBField),
+			fmt.Sprintf("%s.%s DESC", UserTableName, UserIDDBField)).
+		Limit(limit + 1)
+	if cursor != nil {
+		query = query.Where(fmt.Sprintf("(%s.%s, %s.%s) < (?, ?)", UserFollowTableName, UserFollowCreatedAtDBField, UserTableName, UserIDDBField), cursor.CreatedAt, cursor.ID)
+	}
+	rows, err := s.QuerySQL(query)
+	if err != nil {
+		return FollowListResponse{}, err
+	}
+	response := FollowListResponse{Users: make([]FollowUser, 0, limit)}
+	var last pageCursor
+	for rows.Next() {
+		if uint64(len(response.Users)) == limit {
+			response.NextCursor = last.String()
+			break
+		}
+		var user FollowUser
+		if err = rows.Scan(&last.ID, &user.Username, &user.FollowedAt); err != nil {
+			rows.Close()
+			return FollowListResponse{}, err
+		}
+		last.CreatedAt = user.FollowedAt
+		response.Users = append(response.Users, user)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return FollowListResponse{}, err
+	}
+	response.Count, err = s.followCount(uid, field)
+	if err != nil {
+		return FollowListResponse{}, err
+	}
+	return response, nil
+}
+
+// feedQuery returns the items of the reviews and replies matching the filters, newest first, after the cursor.
+// Replies are shown with the place and the title of the replied review. Items of deleted users are left out.
+func feedQuery(reviewsFilter squirrel.Sqlizer, repliesFilter squirrel.Sqlizer, cursor *pageCursor, limit uint64) (string, []interface{}, error) {
+	reviews := squirrel.
+		Select(
+			fmt.Sprintf("'%s' AS kind", feedKindReview),
+			fmt.Sprintf("%s.%s AS id", ReviewsTable, ReviewIDDBField),
+			fmt.Sprintf("%s.%s AS username", UserTableName, UserUsernameDBField),
+			fmt.Sprintf("%s.%s AS review_id", ReviewsTable, ReviewIDDBField),
+			fmt.Sprintf("%s.%s AS place_id", ReviewsTable, ReviewPlaceIDDBField),
+			fmt.Sprintf("%s.%s AS place_name", RestaurantsTable, RestaurantNameDBField),
+			fmt.Sprintf("%s.%s AS title", ReviewsTable, ReviewTitleDBField),
+			fmt.Sprintf("%s.%s AS text", ReviewsTable, ReviewTextDBField),
+			fmt.Sprintf("COALESCE(%s.%s, 0) AS helpful_count", ReviewsTable, ReviewHelpfulCountDBField),
+			fmt.Sprintf("%s.%s AS created_at", ReviewsTable, ReviewCreatedAtDBField)).
+		From(ReviewsTable).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", UserTableName, UserTableName, UserIDDBField, ReviewsTable, ReviewUserIDDBField)).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", RestaurantsTable, RestaurantsTable, RestaurantIDDBField, ReviewsTable, ReviewPlaceIDDBField)).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", UserTableName, UserDeletedAtDBField): nil}).
+		Where(reviewsFilter)
+	replies := squirrel.
+		Select(
+			fmt.Sprintf("'%s' AS kind", feedKindReply),
+			fmt.Sprintf("%s.%s AS id", ReviewReplyTable, ReviewReplyIDDBField),
+			fmt.Sprintf("%s.%s AS username", UserTableName, UserUsernameDBField),
+			fmt.Sprintf("%s.%s AS review_id", ReviewReplyTable, ReviewReplyReviewIDDBField),
+			fmt.Sprintf("%s.%s AS place_id", ReviewsTable, ReviewPlaceIDDBField),
+			fmt.Sprintf("%s.%s AS place_name", RestaurantsTable, RestaurantNameDBField),
+			fmt.Sprintf("%s.%s AS title", ReviewsTable, ReviewTitleDBField),
+			fmt.Sprintf("%s.%s AS text", ReviewReplyTable, ReviewReplyTextDBField),
+			fmt.Sprintf("COALESCE(%s.%s, 0) AS helpful_count", ReviewReplyTable, ReviewReplyHelpfulCountDBField),
+			fmt.Sprintf("%s.%s AS created_at", ReviewReplyTable, ReviewReplyCreatedAtDBField)).
+		From(ReviewReplyTable).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", ReviewsTable, ReviewsTable, ReviewIDDBField, ReviewReplyTable, ReviewReplyReviewIDDBField)).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", UserTableName, UserTableName, UserIDDBField, ReviewReplyTable, ReviewReplyUserIDDBField)).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", RestaurantsTable, RestaurantsTable, RestaurantIDDBField, ReviewsTable, ReviewPlaceIDDBField)).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", UserTableName, UserDeletedAtDBField): nil}).
+		Where(repliesFilter)
+	// each side is bounded by the cursor too, so that the indexes on (user_id, created_at) are used.
+	if cursor != nil {
+		reviews = reviews.Where(squirrel.LtOrEq{fmt.Sprintf("%s.%s", ReviewsTable, ReviewCreatedAtDBField): cursor.CreatedAt})
+		replies = replies.Where(squirrel.LtOrEq{fmt.Sprintf("%s.%s", ReviewReplyTable, ReviewReplyCreatedAtDBField): cursor.CreatedAt})
+	}
+	reviewsSQL, reviewsArgs, err := reviews.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	repliesSQL, repliesArgs, err := replies.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+	args := append(reviewsArgs, repliesArgs...)
+	sql := fmt.Sprintf("SELECT kind, id, username, review_id, place_id, place_name, title, text, helpful_count, created_at FROM (%s UNION ALL %s) AS feed", reviewsSQL, repliesSQL)
+	if cursor != nil {
+		sql += " WHERE (created_at, kind, id) < (?, ?, ?)"
+		args = append(args, cursor.CreatedAt, cursor.Kind, cursor.ID)
+	}
+	sql += " ORDER BY created_at DESC, kind DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+	sql, err = squirrel.Dollar.ReplacePlaceholders(sql)
+	return sql, args, err
+}
+
+func (s Server) queryFeed(reviewsFilter squirrel.Sqlizer, repliesFilter squirrel.Sqlizer, cursor *pageCursor, limit uint64) ([]FeedItem, error) {
+	sql, args, err := feedQuery(reviewsFilter, repliesFilter, cursor, limit)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.QuerySQL(squirrel.Expr(sql, args...))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make([]FeedItem, 0, limit)
+	for rows.Next() {
+		var item FeedItem
+		if err = rows.Scan(&item.Kind, &item.ID, &item.AuthorUsername, &item.ReviewID, &item.PlaceID, &item.PlaceName, &item.Title, &item.Text, &item.HelpfulCount, &item.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// usesFeedInbox tells whether the page of the feed of the user is read from feed_inbox: the user follows more than
+// feedInboxFollowingThreshold users, the inbox was filled, and the page is within feedInboxWindow.
+func (s Server) usesFeedInbox(uid string, cursor *pageCursor) (bool, error) {
+	if cursor != nil && cursor.CreatedAt.Before(time.Now().Add(-feedInboxWindow)) {
+		return false, nil
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select().
+		Column(squirrel.Expr(fmt.Sprintf("(SELECT COUNT(*) FROM %s WHERE %s = ?)", UserFollowTableName, UserFollowFollowerIDDBField), uid)).
+		Column(squirrel.Expr(fmt.Sprintf("EXISTS (SELECT 1 FROM %s WHERE %s = ?)", FeedInboxTableName, FeedInboxUserIDDBField), uid)))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	var following int64
+	var filled bool
+	if rows.Next() {
+		if err = rows.Scan(&following, &filled); err != nil {
+			return false, err
+		}
+	}
+	return following > feedInboxFollowingThreshold && filled, rows.Err()
+}
+
+// GetFeed returns a page of the latest reviews and replies of the followees of the user.
+//
+// The feed is built on read from the reviews and replies of the followees. The feed of the users that follow more
+// than feedInboxFollowingThreshold users is read from feed_inbox instead, it lags RefreshFeedInboxes by up to a run.
+// A page the inbox can not fill, past feedInboxWindow, is read from the followees.
+func (s Server) GetFeed(uid string, cursor *pageCursor, limit uint64) (FeedResponse, error) {
+	followees := fmt.Sprintf("IN (SELECT %s FROM %s WHERE %s = ?)", UserFollowFolloweeIDDBField, UserFollowTableName, UserFollowFollowerIDDBField)
+	reviewsFilter := squirrel.Expr(fmt.Sprintf("%s.%s %s", ReviewsTable, ReviewUserIDDBField, followees), uid)
+	repliesFilter := squirrel.Expr(fmt.Sprintf("%s.%s %s", ReviewReplyTable, ReviewReplyUserIDDBField, followees), uid)
+	inbox, err := s.usesFeedInbox(uid, cursor)
+	if err != nil {
+		return FeedResponse{}, err
+	}
+	var items []FeedItem
+	if inbox {
+		inboxItems := fmt.Sprintf("IN (SELECT %s FROM %s WHERE %s = ? AND %s = ?)", FeedInboxItemIDDBField, FeedInboxTableName, FeedInboxUserIDDBField, FeedInboxKindDBField)
+		items, err = s.queryFeed(
+			squirrel.Expr(fmt.Sprintf("%s.%s %s", ReviewsTable, ReviewIDDBField, inboxItems), uid, feedKindReview),
+			squirrel.Expr(fmt.Sprintf("%s.%s %s", ReviewReplyTable, ReviewReplyIDDBField, inboxItems), uid, feedKindReply),
+			cursor, limit+1)
+		if err != nil {
+			return FeedResponse{}, err
+		}
+	}
+	if uint64(len(items)) <= limit {
+		items, err = s.queryFeed(reviewsFilter, repliesFilter, cursor, limit+1)
+		if err != nil {
+			return FeedResponse{}, err
+		}
+	}
+	response := FeedResponse{Items: items}
+	if uint64(len(items)) > limit {
+		response.Items = items[:limit]
+		last := response.Items[limit-1]
+		response.NextCursor = pageCursor{CreatedAt: last.CreatedAt, Kind: last.Kind, ID: last.ID}.String()
+	}
+	return response, nil
+}
+
+// RefreshFeedInboxes fills feed_inbox with the new reviews and replies of the followees of the users that follow
+// more than feedInboxFollowingThreshold users, and empties it of the items older than feedInboxWindow and of the
+// users under the threshold. It is scheduled in main.go.
+func RefreshFeedInboxes(db *pgxpool.Pool) {
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	since := time.Now().Add(-feedInboxWindow)
+	heavyFollowers := fmt.Sprintf("SELECT %[1]s FROM %[2]s GROUP BY %[1]s HAVING COUNT(*) > %[3]d",
+		UserFollowFollowerIDDBField, UserFollowTableName, feedInboxFollowingThreshold)
+	for _, q := range []struct{ kind, table, idField, userIDField, createdAtField string }{
+		{feedKindReview, ReviewsTable, ReviewIDDBField, ReviewUserIDDBField, ReviewCreatedAtDBField},
+		{feedKindReply, ReviewReplyTable, ReviewReplyIDDBField, ReviewReplyUserIDDBField, ReviewReplyCreatedAtDBField},
+	} {
+		sql := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s)
+SELECT f.%[7]s, ?, t.%[10]s, t.%[11]s, t.%[12]s FROM %[8]s f JOIN %[9]s t ON t.%[11]s = f.%[13]s
+WHERE f.%[7]s IN (%[14]s) AND t.%[12]s > ?
+ON CONFLICT (%[2]s, %[3]s, %[4]s) DO NOTHING`,
+			FeedInboxTableName, FeedInboxUserIDDBField, FeedInboxKindDBField, FeedInboxItemIDDBField, FeedInboxAuthorIDDBField, FeedInboxCreatedAtDBField,
+			UserFollowFollowerIDDBField, UserFollowTableName, q.table, q.idField, q.userIDField, q.createdAtField, UserFollowFolloweeIDDBField,
+			heavyFollowers)
+		sql, _ = squirrel.Dollar.ReplacePlaceholders(sql)
+		if _, err := db.Exec(to, sql, q.kind, since); err != nil {
+			log.Printf("error filling feed inboxes with %ss: %v", q.kind, err)
+			return
+		}
+	}
+	prune := fmt.Sprintf("DELETE FROM %s WHERE %s < $1 OR %s NOT IN (%s)",
+		FeedInboxTableName, FeedInboxCreatedAtDBField, FeedInboxUserIDDBField, heavyFollowers)
+	if _, err := db.Exec(to, prune, since); err != nil {
+		log.Printf("error pruning feed inboxes: %v", err)
+	}
+}
+
+// FollowUserHandler follows a user.
+//
+//	@Summary					Follow User
+//	@Description				Follows the user, the reviews and replies of the user are added to the feed. Following a user twice is a no-op.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Param						username		path		string			true	"Username"
+//	@Success					200				{object}	FollowResponse	"Followed"
+//	@Failure					400				{object}	ErrorResponse	"Users cannot follow themselves"
+//	@Failure					404				{object}	ErrorResponse	"User does not exist"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/users/{username}/follow [put]
+func FollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	followee, err := s.followTarget(chi.URLParam(r, "username"))
+	if err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if followee == jwtContents.UUID {
+		s.LogError(CannotFollowSelfError, http.StatusBadRequest)
+		return
+	}
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Insert(UserFollowTableName).
+		Columns(UserFollowFollowerIDDBField, UserFollowFolloweeIDDBField).
+		Values(jwtContents.UUID, followee).
+		Suffix(fmt.Sprintf("ON CONFLICT (%s, %s) DO NOTHING", UserFollowFollowerIDDBField, UserFollowFolloweeIDDBField))); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	count, err := s.followCount(followee, UserFollowFolloweeIDDBField)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(FollowResponse{Following: true, FollowersCount: count}, http.StatusOK)
+}
+
+// UnfollowUserHandler unfollows a user.
+//
+//	@Summary					Unfollow User
+//	@Description				Unfollows the user, the reviews and replies of the user are removed from the feed. Unfollowing a user that is not followed is a no-op.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Param						username		path		string			true	"Username"
+//	@Success					200				{object}	FollowResponse	"Unfollowed"
+//	@Failure					404				{object}	ErrorResponse	"User does not exist"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/users/{username}/follow [delete]
+func UnfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	followee, err := s.followTarget(chi.URLParam(r, "username"))
+	if err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	for _, q := range []squirrel.DeleteBuilder{
+		s.StmtBuilder.Delete(UserFollowTableName).Where(squirrel.Eq{
+			UserFollowFollowerIDDBField: jwtContents.UUID,
+			UserFollowFolloweeIDDBField: followee,
+		}),
+		// the inbox is refreshed only with new items, the items of the unfollowed user are removed now.
+		s.StmtBuilder.Delete(FeedInboxTableName).Where(squirrel.Eq{
+			FeedInboxUserIDDBField:   jwtContents.UUID,
+			FeedInboxAuthorIDDBField: followee,
+		}),
+	} {
+		sql, args, err := q.ToSql()
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		if _, err = tx.Exec(to, sql, args...); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	count, err := s.followCount(followee, UserFollowFolloweeIDDBField)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(FollowResponse{Following: false, FollowersCount: count}, http.StatusOK)
+}
+
+// followListHandler writes a page of the followers, or of the followees, of the user in the path.
+func followListHandler(w http.ResponseWriter, r *http.Request, following bool) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	cursor, limit, err := readPageParams(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := s.followTarget(chi.URLParam(r, "username"))
+	if err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	response, err := s.GetFollowList(uid, following, cursor, limit)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
+
+// FollowersHandler lists the followers of a user.
+//
+//	@Summary		List followers
+//	@Description	Lists the followers of the user, latest first, with the count of followers. Pass nextCursor as cursor to get the next page.
+//	@Tags			User
+//	@Produce		json
+//	@Param			username	path		string				true	"Username"
+//	@Param			cursor		query		string				false	"Cursor of the page"
+//	@Param			limit		query		int					false	"Page size, 20 by default, at most 100"
+//	@Success		200			{object}	FollowListResponse	"Followers"
+//	@Failure		400			{object}	ErrorResponse		"Invalid cursor or limit"
+//	@Failure		404			{object}	ErrorResponse		"User does not exist"
+//	@Failure		500			{object}	ErrorResponse		"Internal server error"
+//	@Router			/api/users/{username}/followers [get]
+func FollowersHandler(w http.ResponseWriter, r *http.Request) {
+	followListHandler(w, r, false)
+}
+
+// FollowingHandler lists the users a user follows.
+//
+//	@Summary		List followees
+//	@Description	Lists the users the user follows, latest first, with their count. Pass nextCursor as cursor to get the next page.
+//	@Tags			User
+//	@Produce		json
+//	@Param			username	path		string				true	"Username"
+//	@Param			cursor		query		string				false	"Cursor of the page"
+//	@Param			limit		query		int					false	"Page size, 20 by default, at most 100"
+//	@Success		200			{object}	FollowListResponse	"Followees"
+//	@Failure		400			{object}	ErrorResponse		"Invalid cursor or limit"
+//	@Failure		404			{object}	ErrorResponse		"User does not exist"
+//	@Failure		500			{object}	ErrorResponse		"Internal server error"
+//	@Router			/api/users/{username}/following [get]
+func FollowingHandler(w http.ResponseWriter, r *http.Request) {
+	followListHandler(w, r, true)
+}
+
+// UserFeedHandler returns the feed of the user.
+//
+//	@Summary					Get feed
+//	@Description				Returns the latest reviews and replies of the users the user follows, newest first. Pass nextCursor as cursor to get the next page.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Param						cursor			query		string			false	"Cursor of the page"
+//	@Param						limit			query		int				false	"Page size, 20 by default, at most 100"
+//	@Success					200				{object}	FeedResponse	"Feed"
+//	@Failure					400				{object}	ErrorResponse	"Invalid cursor or limit"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/user/feed [get]
+func UserFeedHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	cursor, limit, err := readPageParams(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	feed, err := s.GetFeed(jwtContents.UUID, cursor, limit)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(feed, http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"encoding/base64"
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageCursor(t *testing.T) {
+	cursor := pageCursor{CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC), Kind: feedKindReply, ID: "9b2a4c1e-3f55-4e8e-9a8a-1c2d3e4f5a6b"}
+	parsed, err := ParsePageCursor(cursor.String())
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, parsed)
+	// follow lists have no kind.
+	cursor.Kind = ""
+	parsed, err = ParsePageCursor(cursor.String())
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, parsed)
+	for _, invalid := range []string{
+		"",
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("1714566600000000|review")),
+		base64.RawURLEncoding.EncodeToString([]byte("yesterday|review|9b2a4c1e-3f55-4e8e-9a8a-1c2d3e4f5a6b")),
+		base64.RawURLEncoding.EncodeToString([]byte("1714566600000000|photo|9b2a4c1e-3f55-4e8e-9a8a-1c2d3e4f5a6b")),
+		base64.RawURLEncoding.EncodeToString([]byte("1714566600000000|review|1; DROP TABLE users")),
+	} {
+		_, err = ParsePageCursor(invalid)
+		assert.Equal(t, InvalidCursorError, err, invalid)
+	}
+}
+
+func TestReadPageParams(t *testing.T) {
+	cursor, limit, err := readPageParams(httptest.NewRequest("GET", "/feed", nil))
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+	assert.Equal(t, uint64(pageLimitDefault), limit)
+	next := pageCursor{CreatedAt: time.Now().UTC().Truncate(time.Microsecond), Kind: feedKindReview, ID: "9b2a4c1e-3f55-4e8e-9a8a-1c2d3e4f5a6b"}
+	cursor, limit, err = readPageParams(httptest.NewRequest("GET", "/feed?limit=5&cursor="+next.String(), nil))
+	assert.Nil(t, err)
+	assert.Equal(t, &next, cursor)
+	assert.Equal(t, uint64(5), limit)
+	for _, l := range []string{"0", "-1", "101", "ten"} {
+		_, _, err = readPageParams(httptest.NewRequest("GET", "/feed?limit="+l, nil))
+		assert.Equal(t, InvalidPageLimitError, err, l)
+	}
+}
+
+func TestFeedQuery(t *testing.T) {
+	sql, args, err := feedQuery(squirrel.Eq{"reviews.user_id": "a"}, squirrel.Eq{"review_reply.user_id": "a"}, nil, 21)
+	assert.Nil(t, err)
+	assert.Contains(t, sql, "UNION ALL")
+	assert.True(t, strings.HasSuffix(sql, "ORDER BY created_at DESC, kind DESC, id DESC LIMIT $3"), sql)
+	assert.Equal(t, []interface{}{"a", "a", uint64(21)}, args)
+	cursor := pageCursor{CreatedAt: time.Now(), Kind: feedKindReview, ID: "9b2a4c1e-3f55-4e8e-9a8a-1c2d3e4f5a6b"}
+	sql, args, err = feedQuery(squirrel.Eq{"reviews.user_id": "a"}, squirrel.Eq{"review_reply.user_id": "a"}, &cursor, 21)
+	assert.Nil(t, err)
+	assert.Contains(t, sql, "WHERE (created_at, kind, id) < ($5, $6, $7)")
+	assert.Len(t, args, 8)
+}
@@ -3,8 +3,13 @@ package core
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/Masterminds/squirrel"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,13 +21,13 @@ import (
 	"github.com/qedus/osmpbf"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
-	"golang.org/x/crypto/bcrypt"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	_ "persephone/docs"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -63,7 +68,7 @@ func (s Server) LogError(err error, httpCode int) {
 	if s.Writer != nil {
 		s.Writer.WriteHeader(httpCode)
 		s.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
-		errMessageJSON, _ := json.MarshalIndent(ErrorResponse{Error: err.Error(), RequestID: reqID}, "", "    ")
+		errMessageJSON, _ := json.MarshalIndent(NewErrorResponse(err, reqID), "", "    ")
 		s.Writer.Write(errMessageJSON)
 	}
 }
@@ -136,19 +141,39 @@ func (s Server) GetUniqueUUID(tableName string, dbIDField string) (uuid.UUID, er
 	}
 }
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
+// GenerateSecureToken returns a random URL safe token that is sent to the user, and its SHA-256 hash that is stored
+// in the database. Plain tokens are never stored, look them up with HashToken.
+func GenerateSecureToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
 	}
-	return string(hashedPassword), nil
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of the token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ContainsPattern returns the ILIKE pattern matching the values that contain the text, with the wildcards of the text
+// escaped.
+func ContainsPattern(text string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text) + "%"
 }
 
 func (s Server) GetJWTData() (JWTFields, error) {
 	// find Authorization header
 	header := s.Request.Header.Get("Authorization")
 	if header == "" {
-		return JWTFields{}, NoAuthorizationHeaderError
+		// the cookie session mode, see SessionCookieMode.
+		token, ok := sessionCookieToken(s.Request)
+		if !ok {
+			return JWTFields{}, NoAuthorizationHeaderError
+		}
+		header = "Bearer " + token
 	}
 	jwtTok := header[len("Bearer "):]
 	token, err := jwt.Parse(jwtTok, func(token *jwt.Token) (interface{}, error) {
@@ -181,12 +206,94 @@ func (s Server) GetJWTData() (JWTFields, error) {
 			fields.Role = value.(string)
 		case JWTStatusKey:
 			fields.Status = value.(string)
+		case JWTVersionKey:
+			fields.Version = int64(value.(float64))
+		case JWTSessionKey:
+			fields.SessionID = value.(string)
+		case JWTActorKey:
+			act, ok := value.(map[string]interface{})
+			if !ok {
+				return JWTFields{}, InvalidJWTGeneral
+			}
+			if fields.ActorID, ok = act[JWTActorSubjectKey].(string); !ok || fields.ActorID == "" {
+				return JWTFields{}, InvalidJWTGeneral
+			}
 		}
 	}
 	fields.Token = jwtTok
 	return fields, nil
 }
 
+// CheckTokenRevoked returns TokenRevokedError if the token version in jwtContents does not match with the
+// users.token_version, which is bumped every time all sessions of the user must be ended, e.g. after a password reset.
+//
+// WAITING_LOGIN tokens of the users with 2FA are rejected with TwoFactorRequiredError, they are only accepted by
+// UserLoginTwoFactorHandler. Impersonation tokens are rejected once the actor is not an admin anymore.
+func (s Server) CheckTokenRevoked(jwtContents JWTFields) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserTokenVersionDBField, UserTOTPEnabledDBField, UserBannedDBField, UserBannedUntilDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return UserDoesNotExistError
+	}
+	var tokenVersion int64
+	var totpEnabled bool
+	var banned bool
+	var bannedUntil *time.Time
+	if err = rows.Scan(&tokenVersion, &totpEnabled, &banned, &bannedUntil); err != nil {
+		return err
+	}
+	rows.Close()
+	if tokenVersion != jwtContents.Version {
+		return TokenRevokedError
+	}
+	if IsBanActive(banned, bannedUntil, time.Now()) {
+		return s.banError(jwtContents.UUID, bannedUntil)
+	}
+	if totpEnabled && jwtContents.Status == tokenStatusWaitingLogin {
+		return TwoFactorRequiredError
+	}
+	if jwtContents.Impersonated() {
+		if err = s.checkImpersonationActor(jwtContents.ActorID); err != nil {
+			return err
+		}
+	}
+	return s.CheckSession(jwtContents)
+}
+
+// TokenErrorStatus returns the HTTP status code for an error returned by CheckTokenRevoked.
+func TokenErrorStatus(err error) int {
+	var banned UserBannedError
+	if errors.As(err, &banned) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// SignToken signs a new JWT for the user with the given role, status, version and lifetime.
+func SignToken(uid string, role string, status string, version int64, duration time.Duration) (string, error) {
+	return SignSessionToken(uid, role, status, version, "", duration)
+}
+
+// SignSessionToken signs a new JWT of the session sessionID, see StartSession. The token is rejected once the session
+// is revoked.
+func SignSessionToken(uid string, role string, status string, version int64, sessionID string, duration time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		JWTUUIDKey:    uid,
+		JWTExpiresKey: time.Now().Add(duration).Unix(),
+		JWTRoleKey:    role,
+		JWTStatusKey:  status,
+		JWTVersionKey: version,
+	}
+	if sessionID != "" {
+		claims[JWTSessionKey] = sessionID
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(JWT_ENCRYPT_KEY))
+}
+
 // ExecuteSQL godoc
 //
 // sqlBuilder takes Squirrel's InsertBuilder, CaseBuilder or any builder that has a method that follows the:
@@ -364,6 +471,7 @@ func FetchPlaces(db *pgxpool.Pool) {
 		RestaurantHouseNumberDBField,
 		RestaurantLatitudeDBField,
 		RestaurantLongitudeDBField,
+		RestaurantDietsDBField,
 	)
 	// Process nodes and append to the restaurants slice
 	for _, node := range nodes {
@@ -379,7 +487,23 @@ func FetchPlaces(db *pgxpool.Pool) {
 			log.Printf("error scheduling cron for fetching places: %v", err)
 			return
 		}
-		if rowsQ.Next() {
+		exists := rowsQ.Next()
+		rowsQ.Close()
+		if exists {
+			// the diet tags were not imported before, they are refreshed on the known places.
+			if diets := PlaceDiets(node.Tags); len(diets) > 0 {
+				sql, args, err = stmtBuilder.Update(RestaurantsTable).
+					Set(RestaurantDietsDBField, diets).
+					Where(squirrel.Eq{RestaurantNameDBField: name}).
+					ToSql()
+				if err != nil {
+					log.Printf("error scheduling cron for fetching places: %v", err)
+					return
+				}
+				if _, err = db.Exec(context.Background(), sql, args...); err != nil {
+					log.Printf("error updating the diets of %s: %v", name, err)
+				}
+			}
 			continue
 		} else {
 			if name == "" {
@@ -408,6 +532,7 @@ func FetchPlaces(db *pgxpool.Pool) {
 				houseNumber,
 				latitude,
 				longitude,
+				PlaceDiets(node.Tags),
 			})
 		}
 	}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// impersonationTokenDuration is the lifetime of the impersonation tokens, they can not be refreshed.
+const impersonationTokenDuration = 15 * time.Minute
+
+const (
+	auditActionImpersonate          = "users.impersonate"
+	auditActionImpersonationRequest = "impersonation.request"
+)
+
+// SignImpersonationToken signs an ACTIVE token of the user for the admin actorID. The actor is in the act claim
+// (RFC 8693), the token has no session so it is not listed in the sessions of the user and the logout does not end it.
+// It ends when it expires, when the actor is not an admin anymore, or when the token version of the user is bumped,
+// such as by the forced logout.
+func SignImpersonationToken(uid string, role string, version int64, actorID string, duration time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		JWTUUIDKey:    uid,
+		JWTExpiresKey: time.Now().Add(duration).Unix(),
+		JWTRoleKey:    role,
+		JWTStatusKey:  tokenStatusActive,
+		JWTVersionKey: version,
+		JWTActorKey:   map[string]string{JWTActorSubjectKey: actorID},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(JWT_ENCRYPT_KEY))
+}
+
+// checkImpersonationActor returns ImpersonationRevokedError if the actor of an impersonation token is not an active
+// admin anymore, so demoting or banning the admin ends the impersonation too.
+func (s Server) checkImpersonationActor(actorID string) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserRoleDBField, UserTOTPEnabledDBField, UserBannedDBField, UserBannedUntilDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: actorID, UserDeletedAtDBField: nil}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return ImpersonationRevokedError
+	}
+	var role string
+	var totpEnabled, banned bool
+	var bannedUntil *time.Time
+	if err = rows.Scan(&role, &totpEnabled, &banned, &bannedUntil); err != nil {
+		return err
+	}
+	if TokenRole(role, totpEnabled) != roleAdmin || IsBanActive(banned, bannedUntil, time.Now()) {
+		return ImpersonationRevokedError
+	}
+	return nil
+}
+
+// RejectImpersonation rejects the impersonation tokens, it guards the changes of the credentials and the account
+// that support staff must not do for the users. Use it after JWTWhitelist.
+func RejectImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server := r.Context().Value(ServerKeyString).(*Server)
+		jwtContents, err := server.GetJWTData()
+		if err != nil {
+			server.LogError(err, http.StatusBadRequest)
+			return
+		}
+		if jwtContents.Impersonated() {
+			server.LogError(ImpersonationNotAllowedError, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminImpersonateRequest is the reason of an impersonation, kept in the audit log.
+//
+// swagger:model AdminImpersonateRequest
+type AdminImpersonateRequest struct {
+	Reason string `json:"reason" validate:"required,min=3,max=500"`
+}
+
+// AdminImpersonateResponse is a token to use the app as the user.
+//
+// swagger:model AdminImpersonateResponse
+type AdminImpersonateResponse struct {
+	UserID    string    `json:"userId"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// AdminUserImpersonateHandler issues a token to use the app as a user.
+//
+//	@Summary					Impersonate User
+//	@Description				Issues a token of the user that expires in 15 minutes and can not be refreshed. The token names the admin in the act claim. It can not change the password, the email, the username, the phone number, the 2FA, the identities, the API keys or the sessions, and can not delete the user. Admins can not impersonate themselves or other admins. The token has no session, so the logout does not end it. It is revoked once the admin is not an admin anymore, or by the forced logout of the user. Issuing the token and every request made with it are recorded in the audit log.
+//	@Tags						Admin
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN users, not impersonation tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						id				path		string						true	"User ID"
+//	@Param						body			body		AdminImpersonateRequest		true	"Reason"
+//	@Success					200				{object}	AdminImpersonateResponse	"Impersonation token"
+//	@Failure					400				{object}	ErrorResponse				"Reason is missing"
+//	@Failure					403				{object}	ErrorResponse				"User is the admin, or another admin"
+//	@Failure					404				{object}	ErrorResponse				"User does not exist"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/admin/users/{id}/impersonate [post]
+func AdminUserImpersonateHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	var req AdminImpersonateRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if uid == jwtContents.UUID {
+		s.LogError(CannotImpersonateError, http.StatusForbidden)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserRoleDBField, UserTOTPEnabledDBField, UserTokenVersionDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: uid, UserDeletedAtDBField: nil}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var role string
+	var totpEnabled bool
+	var version int64
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&role, &totpEnabled, &version)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.LogError(UserDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	if strings.ToUpper(role) == roleAdmin {
+		s.LogError(CannotImpersonateError, http.StatusForbidden)
+		return
+	}
+	expiresAt := time.Now().Add(impersonationTokenDuration)
+	// the token is issued only if the audit record is written.
+	if err = s.RecordAudit(r, jwtContents.UUID, uid, auditActionImpersonate, map[string]interface{}{"reason": req.Reason, "expiresAt": expiresAt}); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	token, err := SignImpersonationToken(uid, TokenRole(role, totpEnabled), version, jwtContents.UUID, impersonationTokenDuration)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(AdminImpersonateResponse{UserID: uid, Token: token, ExpiresAt: expiresAt}, http.StatusOK)
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func jwtDataOf(token string) (JWTFields, error) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return Server{Request: r}.GetJWTData()
+}
+
+func TestImpersonationToken(t *testing.T) {
+	token, err := SignImpersonationToken("user", roleUser, 3, "admin", time.Minute)
+	assert.Nil(t, err)
+	fields, err := jwtDataOf(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "user", fields.UUID)
+	assert.Equal(t, "admin", fields.ActorID)
+	assert.Equal(t, tokenStatusActive, fields.Status)
+	assert.Equal(t, int64(3), fields.Version)
+	assert.Empty(t, fields.SessionID)
+	assert.True(t, fields.Impersonated())
+	token, err = SignToken("user", roleUser, tokenStatusActive, 3, time.Minute)
+	assert.Nil(t, err)
+	fields, err = jwtDataOf(token)
+	assert.Nil(t, err)
+	assert.False(t, fields.Impersonated())
+	// an act claim without the actor is not ignored.
+	for _, act := range []interface{}{"admin", map[string]string{}, map[string]string{JWTActorSubjectKey: ""}} {
+		token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			JWTUUIDKey:    "user",
+			JWTExpiresKey: time.Now().Add(time.Minute).Unix(),
+			JWTActorKey:   act,
+		}).SignedString([]byte(JWT_ENCRYPT_KEY))
+		assert.Nil(t, err)
+		_, err = jwtDataOf(token)
+		assert.Equal(t, InvalidJWTGeneral, err, act)
+	}
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Mailer sends plain text emails. Handlers should never talk to an SMTP server directly, use the Mailer assigned to
+// the Server by AssignMailer instead, so tests can swap it with a LogMailer.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// SMTPMailer sends emails through an SMTP server with PLAIN auth.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) Send(to string, subject string, body string) error {
+	auth := smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	msg := strings.Join([]string{
+		fmt.Sprintf("From: %s", m.From),
+		fmt.Sprintf("To: %s", to),
+		fmt.Sprintf("Subject: %s", subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(fmt.Sprintf("%s:%s", m.Host, m.Port), auth, m.From, []string{to}, []byte(msg))
+}
+
+// LogMailer does not send anything, it prints the email to the standard logger. Used in development and tests.
+type LogMailer struct{}
+
+func (m LogMailer) Send(to string, subject string, body string) error {
+	log.Printf("mail to %s, subject: %s\n%s", to, subject, body)
+	return nil
+}
+
+// NewMailer returns an SMTPMailer if SMTP_HOST is set in the environment (or in the .env file), LogMailer otherwise.
+//
+// Environment variables:
+//
+//	SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM
+func NewMailer() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return LogMailer{}
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+}
+
+// AppBaseURL is the base URL used while building links that are sent to users, such as password reset links.
+func AppBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:3000"
+}
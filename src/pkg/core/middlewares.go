@@ -49,6 +49,61 @@ func AssignDB(db *pgxpool.Pool) func(next http.Handler) http.Handler {
 	}
 }
 
+func AssignMailer(mailer Mailer) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			server.Mailer = mailer
+			r = r.WithContext(context.WithValue(r.Context(), ServerKeyString, server))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func AssignOIDCProviders(providers OIDCProviders) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			server.OIDCProviders = providers
+			r = r.WithContext(context.WithValue(r.Context(), ServerKeyString, server))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func AssignSMSSender(sender SMSSender) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			server.SMSSender = sender
+			r = r.WithContext(context.WithValue(r.Context(), ServerKeyString, server))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func AssignStorage(storage ObjectStorage) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			server.Storage = storage
+			r = r.WithContext(context.WithValue(r.Context(), ServerKeyString, server))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func AssignNotificationHub(hub *NotificationHub) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			server.Notifications = hub
+			r = r.WithContext(context.WithValue(r.Context(), ServerKeyString, server))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func AssignTracer(endpoint string, group string, spanName string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		exporter, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint("http://localhost:14268/api/traces")))
@@ -133,6 +188,10 @@ func JWTWhitelist(tokenStatus []string, userRole []string) func(next http.Handle
 				server.LogError(err, http.StatusInternalServerError)
 				return
 			}
+			if err = server.CheckTokenRevoked(jwtContents); err != nil {
+				server.LogError(err, TokenErrorStatus(err))
+				return
+			}
 			if !TokenStatusWhitelist(jwtContents, tokenStatus) {
 				server.LogError(UserNotAllowedError, http.StatusForbidden)
 				return
@@ -141,6 +200,14 @@ func JWTWhitelist(tokenStatus []string, userRole []string) func(next http.Handle
 				server.LogError(UserNotAllowedError, http.StatusForbidden)
 				return
 			}
+			// every request made with an impersonation token is audited, the request is not served without the record.
+			if jwtContents.Impersonated() {
+				details := map[string]interface{}{"method": r.Method, "path": r.URL.Path}
+				if err = server.RecordAudit(r, jwtContents.ActorID, jwtContents.UUID, auditActionImpersonationRequest, details); err != nil {
+					server.LogError(err, http.StatusInternalServerError)
+					return
+				}
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -0,0 +1,460 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// moderatorRoles can use the moderation endpoints.
+var moderatorRoles = []string{roleAdmin, roleModerator}
+
+func NewModerationHandler() http.Handler {
+	r := chi.NewRouter()
+	var reputationTracer = AssignTracer("/users/{id}/reputation", "MODERATION", "/users/{id}/reputation")
+	var reviewTracer = AssignTracer("/reviews/{id}/remove", "MODERATION", "/reviews/{id}/remove")
+	var reportTracer = AssignTracer("/reports/{id}", "MODERATION", "/reports/{id}")
+	var banTracer = AssignTracer("/users/{id}/bans", "MODERATION_BAN", "/users/{id}/bans")
+	var spamTracer = AssignTracer("/spam", "MODERATION_SPAM", "/spam")
+	r.With(reputationTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Post("/users/{id}/reputation", ModeratorReputationAdjustHandler)
+	r.With(reviewTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Post("/reviews/{id}/remove", ModeratorReviewRemoveHandler)
+	r.With(reportTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Put("/reports/{id}", ModeratorReportStatusHandler)
+	r.With(banTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Route("/users/{id}/bans", func(r chi.Router) {
+		r.Get("/", ModeratorBansHandler)
+		r.Post("/", ModeratorBanHandler)
+		r.Post("/lift", ModeratorBanLiftHandler)
+	})
+	r.With(spamTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Get("/spam", ModeratorSpamQueueHandler)
+	r.With(spamTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Get("/users/{id}/spam", ModeratorUserSpamHandler)
+	r.With(spamTracer, JWTWhitelist([]string{tokenStatusActive}, moderatorRoles)).Put("/users/{id}/spam", ModeratorSpamOverrideHandler)
+	return r
+}
+
+// ModeratorReputationAdjustRequest adds points to the reputation of a user, or deducts them if negative.
+//
+// swagger:model ModeratorReputationAdjustRequest
+type ModeratorReputationAdjustRequest struct {
+	Points int64  `json:"points" validate:"required,min=-1000,max=1000"`
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// ModeratorReputationResponse is the reputation of the user after the moderation action.
+//
+// swagger:model ModeratorReputationResponse
+type ModeratorReputationResponse struct {
+	UserID     string `json:"userId"`
+	Reputation int64  `json:"reputation"`
+}
+
+// ModeratorReputationAdjustHandler adjusts the reputation of a user.
+//
+//	@Summary					Adjust Reputation
+//	@Description				Adds the points to the reputation of the user, or deducts them if negative. The adjustment is recorded in the ledger with the reason and the moderator.
+//	@Tags						Moderation
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string								true	"JWT token"
+//	@Param						id				path		string								true	"User ID"
+//	@Param						body			body		ModeratorReputationAdjustRequest	true	"Points and reason"
+//	@Success					200				{object}	ModeratorReputationResponse			"New reputation"
+//	@Failure					400				{object}	ErrorResponse						"Invalid request"
+//	@Failure					404				{object}	ErrorResponse						"User does not exist"
+//	@Failure					500				{object}	ErrorResponse						"Internal server error"
+//	@Router						/api/moderation/users/{id}/reputation [post]
+func ModeratorReputationAdjustHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid := chi.URLParam(r, "id")
+	if _, err = uuid.Parse(uid); err != nil {
+		s.LogError(UserDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	var req ModeratorReputationAdjustRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	reputation, err := RecordReputationEvent(to, tx, s.StmtBuilder, ReputationEvent{
+		UserID:    uid,
+		EventType: reputationEventAdjustment,
+		Points:    req.Points,
+		ActorID:   &jwtContents.UUID,
+		Reason:    req.Reason,
+	})
+	if err == pgx.ErrNoRows {
+		s.LogError(UserDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = CreateNotification(to, tx, s.StmtBuilder, NotificationEvent{
+		UserID:  uid,
+		Type:    notificationTypeModeration,
+		Message: fmt.Sprintf("A moderator adjusted your reputation by %+d: %s", req.Points, req.Reason),
+	}); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(ModeratorReputationResponse{UserID: uid, Reputation: reputation}, http.StatusOK)
+}
+
+// ModeratorReviewRemoveRequest is the reason a review is removed, it is recorded in the ledger of the author.
+//
+// swagger:model ModeratorReviewRemoveRequest
+type ModeratorReviewRemoveRequest struct {
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// RemoveReview deletes the review with its replies and their report links, and deducts
+// reputationPointsReviewRemoved from the author, who is notified. The points of the votes of the review are taken
+// back by the next SyncReputation. Returns the author and the new reputation.
+func RemoveReview(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, reviewID string, actorID string, reason string) (string, int64, error) {
+	sql, args, err := stmtBuilder.Select(ReviewUserIDDBField, ReviewTitleDBField).
+		From(ReviewsTable).
+		Where(squirrel.Eq{ReviewIDDBField: reviewID}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return "", 0, err
+	}
+	var authorID, title string
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&authorID, &title); err == pgx.ErrNoRows {
+		return "", 0, ReviewDoesNotExistError
+	} else if err != nil {
+		return "", 0, err
+	}
+	replies := stmtBuilder.Select(ReviewReplyIDDBField).From(ReviewReplyTable).Where(squirrel.Eq{ReviewReplyReviewIDDBField: reviewID})
+	repliesSQL, repliesArgs, err := replies.ToSql()
+	if err != nil {
+		return "", 0, err
+	}
+	deletes := []squirrel.DeleteBuilder{
+		stmtBuilder.Delete(ReviewReplyReportTableName).Where(squirrel.Expr(fmt.Sprintf("%s IN (%s)", ReviewReplyReportReplyIDDBField, repliesSQL), repliesArgs...)),
+		stmtBuilder.Delete(ReviewReplyTable).Where(squirrel.Eq{ReviewReplyReviewIDDBField: reviewID}),
+		stmtBuilder.Delete(ReviewReportTableName).Where(squirrel.Eq{ReviewReportReviewIDDBField: reviewID}),
+		stmtBuilder.Delete(ReviewsTable).Where(squirrel.Eq{ReviewIDDBField: reviewID}),
+	}
+	for _, d := range deletes {
+		sql, args, err = d.ToSql()
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err = tx.Exec(ctx, sql, args...); err != nil {
+			return "", 0, err
+		}
+	}
+	reputation, err := RecordReputationEvent(ctx, tx, stmtBuilder, ReputationEvent{
+		UserID:    authorID,
+		EventType: reputationEventReviewRemoved,
+		Points:    reputationPointsReviewRemoved,
+		SourceID:  &reviewID,
+		ActorID:   &actorID,
+		Reason:    reason,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	err = CreateNotification(ctx, tx, stmtBuilder, NotificationEvent{
+		UserID:   authorID,
+		Type:     notificationTypeModeration,
+		SourceID: &reviewID,
+		Message:  fmt.Sprintf("A moderator removed your review \"%s\": %s", title, reason),
+	})
+	return authorID, reputation, err
+}
+
+// ModeratorReviewRemoveHandler removes a review.
+//
+//	@Summary					Remove Review
+//	@Description				Deletes the review with its replies, and deducts 20 points from the author. The points the votes of the review earned are taken back by the periodic reputation sync.
+//	@Tags						Moderation
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						id				path		string							true	"Review ID"
+//	@Param						body			body		ModeratorReviewRemoveRequest	true	"Reason"
+//	@Success					200				{object}	ModeratorReputationResponse		"Reputation of the author"
+//	@Failure					400				{object}	ErrorResponse					"Invalid request"
+//	@Failure					404				{object}	ErrorResponse					"Review does not exist"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/moderation/reviews/{id}/remove [post]
+func ModeratorReviewRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	reviewID := chi.URLParam(r, "id")
+	if _, err = uuid.Parse(reviewID); err != nil {
+		s.LogError(ReviewDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	var req ModeratorReviewRemoveRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	authorID, reputation, err := RemoveReview(to, tx, s.StmtBuilder, reviewID, jwtContents.UUID, req.Reason)
+	if err == ReviewDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(ModeratorReputationResponse{UserID: authorID, Reputation: reputation}, http.StatusOK)
+}
+
+// ModeratorReportStatusRequest is the new status of a report.
+//
+// swagger:model ModeratorReportStatusRequest
+type ModeratorReportStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=PENDING CONFIRMED REJECTED"`
+}
+
+// ModeratorReportStatusResponse is the report after its status is changed.
+//
+// swagger:model ModeratorReportStatusResponse
+type ModeratorReportStatusResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// reportParties returns the reporters and the reported users of the report. A report is linked to a user, a review or
+// a reply; only the user reports record their reporter.
+func reportParties(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, reportID string) (reporters []string, reported []string, err error) {
+	queries := []struct {
+		reporter bool
+		query    squirrel.SelectBuilder
+	}{
+		{true, stmtBuilder.Select(UserReportReporterIDDBField).From(UserReportTableName).Where(squirrel.Eq{UserReportReportIDDBField: reportID})},
+		{false, stmtBuilder.Select(UserReportUserIDDBField).From(UserReportTableName).Where(squirrel.Eq{UserReportReportIDDBField: reportID})},
+		{false, stmtBuilder.Select(fmt.Sprintf("%s.%s", ReviewsTable, ReviewUserIDDBField)).
+			From(ReviewReportTableName).
+			Join(fmt.Sprintf("%s on %s.%s = %s.%s", ReviewsTable, ReviewsTable, ReviewIDDBField, ReviewReportTableName, ReviewReportReviewIDDBField)).
+			Where(squirrel.Eq{fmt.Sprintf("%s.%s", ReviewReportTableName, ReviewReportReportIDDBField): reportID})},
+		{false, stmtBuilder.Select(fmt.Sprintf("%s.%s", ReviewReplyTable, ReviewReplyUserIDDBField)).
+			From(ReviewReplyReportTableName).
+			Join(fmt.Sprintf("%s on %s.%s = %s.%s", ReviewReplyTable, ReviewReplyTable, ReviewReplyIDDBField, ReviewReplyReportTableName, ReviewReplyReportReplyIDDBField)).
+			Where(squirrel.Eq{fmt.Sprintf("%s.%s", ReviewReplyReportTableName, ReviewReplyReportReportIDDBField): reportID})},
+	}
+	for _, q := range queries {
+		sql, args, err := q.query.ToSql()
+		if err != nil {
+			return nil, nil, err
+		}
+		rows, err := tx.Query(ctx, sql, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var uid string
+			if err = rows.Scan(&uid); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			if q.reporter {
+				reporters = append(reporters, uid)
+			} else {
+				reported = append(reported, uid)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return reporters, reported, nil
+}
+
+// SetReportStatus changes the status of the report. Confirming it awards reputationPointsReportConfirmed to the
+// reporters and deducts reputationPointsReportUpheld from the reported users; changing the status of a confirmed
+// report takes them back. The reporters are notified of the confirmed and rejected reports, the reported users of
+// the confirmed ones.
+func SetReportStatus(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, reportID string, status string, actorID string) error {
+	sql, args, err := stmtBuilder.Select(ReportStatusDBField).
+		From(ReportTableName).
+		Where(squirrel.Eq{ReportIDDBField: reportID}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	var previous string
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&previous); err == pgx.ErrNoRows {
+		return ReportDoesNotExistError
+	} else if err != nil {
+		return err
+	}
+	if previous == status {
+		return nil
+	}
+	sql, args, err = stmtBuilder.Update(ReportTableName).Set(ReportStatusDBField, status).Where(squirrel.Eq{ReportIDDBField: reportID}).ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+	var sign int64
+	var reason string
+	switch {
+	case status == reportStatusConfirmed:
+		sign, reason = 1, "report confirmed"
+	case previous == reportStatusConfirmed:
+		sign, reason = -1, fmt.Sprintf("confirmed report changed to %s", status)
+	case status == reportStatusPending:
+		return nil
+	}
+	reporters, reported, err := reportParties(ctx, tx, stmtBuilder, reportID)
+	if err != nil {
+		return err
+	}
+	events := make([]ReputationEvent, 0, len(reporters)+len(reported))
+	notifications := make([]NotificationEvent, 0, len(reporters)+len(reported))
+	for _, uid := range reporters {
+		if sign != 0 {
+			events = append(events, ReputationEvent{UserID: uid, EventType: reputationEventReportConfirmed, Points: sign * reputationPointsReportConfirmed})
+		}
+		if status != reportStatusPending {
+			notifications = append(notifications, NotificationEvent{UserID: uid, Message: fmt.Sprintf("Your report was %s", strings.ToLower(status))})
+		}
+	}
+	for _, uid := range reported {
+		if sign != 0 {
+			events = append(events, ReputationEvent{UserID: uid, EventType: reputationEventReportUpheld, Points: sign * reputationPointsReportUpheld})
+		}
+		if status == reportStatusConfirmed {
+			notifications = append(notifications, NotificationEvent{UserID: uid, Message: "A moderator upheld a report about your content"})
+		}
+	}
+	for _, event := range events {
+		event.SourceID, event.ActorID, event.Reason = &reportID, &actorID, reason
+		if _, err = RecordReputationEvent(ctx, tx, stmtBuilder, event); err != nil {
+			return err
+		}
+	}
+	for _, notification := range notifications {
+		notification.Type, notification.SourceID = notificationTypeModeration, &reportID
+		if err = CreateNotification(ctx, tx, stmtBuilder, notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModeratorReportStatusHandler changes the status of a report.
+//
+//	@Summary					Set Report Status
+//	@Description				Changes the status of a report. Confirming a report awards 5 points to the reporter and deducts 25 points from the reported user, or the author of the reported review or reply. Changing the status of a confirmed report takes them back.
+//	@Tags						Moderation
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						id				path		string							true	"Report ID"
+//	@Param						body			body		ModeratorReportStatusRequest	true	"New status"
+//	@Success					200				{object}	ModeratorReportStatusResponse	"Report"
+//	@Failure					400				{object}	ErrorResponse					"Invalid status"
+//	@Failure					404				{object}	ErrorResponse					"Report does not exist"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/moderation/reports/{id} [put]
+func ModeratorReportStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	reportID := chi.URLParam(r, "id")
+	if _, err = uuid.Parse(reportID); err != nil {
+		s.LogError(ReportDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	var req ModeratorReportStatusRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	if err = SetReportStatus(to, tx, s.StmtBuilder, reportID, req.Status, jwtContents.UUID); err == ReportDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(ModeratorReportStatusResponse{ID: reportID, Status: req.Status}, http.StatusOK)
+}
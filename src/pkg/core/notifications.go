@@ -0,0 +1,700 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	NotificationTableName        = "notifications"
+	NotificationIDDBField        = "id"
+	NotificationUserIDDBField    = "user_id"
+	NotificationTypeDBField      = "type"
+	NotificationActorIDDBField   = "actor_id"
+	NotificationReviewIDDBField  = "review_id"
+	NotificationSourceIDDBField  = "source_id"
+	NotificationMessageDBField   = "message"
+	NotificationCreatedAtDBField = "created_at"
+	NotificationReadAtDBField    = "read_at"
+)
+
+// types of the notifications, the users can turn each of them off.
+const (
+	notificationTypeReply      = "reply"
+	notificationTypeVote       = "vote"
+	notificationTypeModeration = "moderation"
+)
+
+var notificationTypes = []string{notificationTypeReply, notificationTypeVote, notificationTypeModeration}
+
+const (
+	// notificationChannel is the channel the notify_notification trigger publishes the new notifications on.
+	notificationChannel = "notifications"
+	// notificationReplyWindow is how old the replies NotifyNewReplies notifies can be.
+	notificationReplyWindow = time.Hour
+	// notificationRetention is how long the read notifications are kept.
+	notificationRetention = 90 * 24 * time.Hour
+	// notificationStreamHeartbeat is the interval of the comments sent on idle streams, so that proxies do not close them.
+	notificationStreamHeartbeat = 25 * time.Second
+	// notificationStreamBuffer is the count of notifications a slow stream can lag behind, the next ones are dropped.
+	notificationStreamBuffer = 16
+)
+
+func NewNotificationHandler() http.Handler {
+	r := chi.NewRouter()
+	var notificationsTracer = AssignTracer("/notifications", "NOTIFICATION", "/notifications")
+	var preferencesTracer = AssignTracer("/notifications/preferences", "NOTIFICATION", "/notifications/preferences")
+	var streamTracer = AssignTracer("/notifications/stream", "NOTIFICATION_STREAM", "/notifications/stream")
+	r.With(notificationsTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/", NotificationsHandler)
+	r.With(notificationsTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Post("/read", NotificationsReadHandler)
+	r.With(preferencesTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/preferences", NotificationPreferencesHandler)
+	r.With(preferencesTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Put("/preferences", NotificationPreferencesUpdateHandler)
+	r.With(streamTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/stream", NotificationStreamHandler)
+	return r
+}
+
+// Notification is a notification of the user. ActorUsername is the user who caused it, it is not set for the
+// notifications of the moderators.
+//
+// swagger:model Notification
+type Notification struct {
+	ID            string     `json:"id"`
+	Type          string     `json:"type"`
+	ActorUsername *string    `json:"actorUsername,omitempty"`
+	ReviewID      *string    `json:"reviewId,omitempty"`
+	SourceID      *string    `json:"sourceId,omitempty"`
+	Message       string     `json:"message"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ReadAt        *time.Time `json:"readAt,omitempty"`
+}
+
+// NotificationEvent is a new notification, see CreateNotification.
+type NotificationEvent struct {
+	UserID   string
+	Type     string
+	ActorID  *string
+	ReviewID *string
+	SourceID *string
+	Message  string
+}
+
+// NotificationUnreadResponse counts the unread notifications, in total and by type.
+//
+// swagger:model NotificationUnreadResponse
+type NotificationUnreadResponse struct {
+	UnreadCount  int64            `json:"unreadCount"`
+	UnreadCounts map[string]int64 `json:"unreadCounts"`
+}
+
+// NotificationsResponse is a page of the notifications with the unread counts, NextCursor is empty on the last page.
+//
+// swagger:model NotificationsResponse
+type NotificationsResponse struct {
+	Notifications []Notification `json:"notifications"`
+	NotificationUnreadResponse
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// NotificationReadRequest marks the notifications with the ids read, or every notification if all is set.
+//
+// swagger:model NotificationReadRequest
+type NotificationReadRequest struct {
+	IDs []string `json:"ids" validate:"max=100,dive,uuid"`
+	All bool     `json:"all"`
+}
+
+// NotificationPreferencesRequest sets the types of the notifications the user does not receive.
+//
+// swagger:model NotificationPreferencesRequest
+type NotificationPreferencesRequest struct {
+	// One of reply, vote and moderation.
+	DisabledTypes []string `json:"disabledTypes" validate:"dive,oneof=reply vote moderation"`
+}
+
+// NotificationPreferencesResponse lists the types of the notifications the user turned off.
+//
+// swagger:model NotificationPreferencesResponse
+type NotificationPreferencesResponse struct {
+	DisabledTypes []string `json:"disabledTypes"`
+	// Types is every type of notification.
+	Types []string `json:"types"`
+}
+
+// CreateNotification adds the notification, unless the user turned its type off or is deleted. It is published to
+// the streams of the user by the notify_notification trigger once the transaction is committed.
+func CreateNotification(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, event NotificationEvent) error {
+	// the select is built with question placeholders, the insert numbers them.
+	sql, args, err := stmtBuilder.Insert(NotificationTableName).
+		Columns(
+			NotificationUserIDDBField,
+			NotificationTypeDBField,
+			NotificationActorIDDBField,
+			NotificationReviewIDDBField,
+			NotificationSourceIDDBField,
+			NotificationMessageDBField).
+		Select(squirrel.Select(UserIDDBField).
+			Column("?", event.Type).
+			Column("?::uuid", event.ActorID).
+			Column("?::uuid", event.ReviewID).
+			Column("?::uuid", event.SourceID).
+			Column("?", event.Message).
+			From(UserTableName).
+			Where(squirrel.Eq{UserIDDBField: event.UserID, UserDeletedAtDBField: nil}).
+			Where(fmt.Sprintf("? <> ALL(%s)", UserNotificationDisabledTypesDBField), event.Type)).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// NotifyNewReplies notifies the authors of the reviews of the replies of the other users. It is scheduled in main.go,
+// the unique notifications_reply index keeps a reply from being notified twice.
+func NotifyNewReplies(db *pgxpool.Pool) {
+	to, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	sql := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s, %[7]s)
+SELECT r.%[10]s, $1::text, rr.%[13]s, r.%[11]s, rr.%[14]s, format('New reply to your review "%%s"', r.%[12]s)
+FROM %[9]s rr JOIN %[8]s r ON r.%[11]s = rr.%[15]s JOIN %[17]s u ON u.%[18]s = r.%[10]s
+WHERE rr.%[16]s > $2 AND rr.%[13]s <> r.%[10]s AND u.%[19]s IS NULL AND $1::text <> ALL(u.%[20]s)
+ON CONFLICT (%[2]s, %[3]s, %[6]s) WHERE %[3]s = 'reply' DO NOTHING`,
+		NotificationTableName, NotificationUserIDDBField, NotificationTypeDBField, NotificationActorIDDBField,
+		NotificationReviewIDDBField, NotificationSourceIDDBField, NotificationMessageDBField,
+		ReviewsTable, ReviewReplyTable, ReviewUserIDDBField, ReviewIDDBField, ReviewTitleDBField,
+		ReviewReplyUserIDDBField, ReviewReplyIDDBField, ReviewReplyReviewIDDBField, ReviewReplyCreatedAtDBField,
+		UserTableName, UserIDDBField, UserDeletedAtDBField, UserNotificationDisabledTypesDBField)
+	if _, err := db.Exec(to, sql, notificationTypeReply, time.Now().Add(-notificationReplyWindow)); err != nil {
+		log.Printf("error notifying replies: %v", err)
+	}
+	prune := fmt.Sprintf("DELETE FROM %s WHERE %s < $1", NotificationTableName, NotificationReadAtDBField)
+	if _, err := db.Exec(to, prune, time.Now().Add(-notificationRetention)); err != nil {
+		log.Printf("error pruning notifications: %v", err)
+	}
+}
+
+// notifyVoteEvents notifies the users of the vote events that earned them reputation since the time, see
+// SyncReputation.
+func notifyVoteEvents(ctx context.Context, db *pgxpool.Pool, since time.Time) error {
+	sql := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s)
+SELECT e.%[8]s, $1::text, r.%[14]s, e.%[11]s,
+CASE e.%[9]s WHEN $2 THEN format('Your review "%%s" earned %%s reputation from new votes', r.%[15]s, e.%[10]s)
+ELSE format('Your reply earned %%s reputation from new votes', e.%[10]s) END
+FROM %[7]s e
+LEFT JOIN %[16]s rr ON e.%[9]s = $3 AND rr.%[17]s = e.%[11]s
+JOIN %[13]s r ON r.%[14]s = COALESCE(rr.%[18]s, e.%[11]s)
+JOIN %[19]s u ON u.%[20]s = e.%[8]s
+WHERE e.%[9]s IN ($2, $3) AND e.%[10]s > 0 AND e.%[12]s >= $4 AND u.%[21]s IS NULL AND $1::text <> ALL(u.%[22]s)`,
+		NotificationTableName, NotificationUserIDDBField, NotificationTypeDBField, NotificationReviewIDDBField,
+		NotificationSourceIDDBField, NotificationMessageDBField,
+		ReputationEventTableName, ReputationEventUserIDDBField, ReputationEventTypeDBField, ReputationEventPointsDBField,
+		ReputationEventSourceIDDBField, ReputationEventCreatedAtDBField,
+		ReviewsTable, ReviewIDDBField, ReviewTitleDBField,
+		ReviewReplyTable, ReviewReplyIDDBField, ReviewReplyReviewIDDBField,
+		UserTableName, UserIDDBField, UserDeletedAtDBField, UserNotificationDisabledTypesDBField)
+	_, err := db.Exec(ctx, sql, notificationTypeVote, reputationEventReviewVotes, reputationEventReplyVotes, since)
+	return err
+}
+
+// notificationsQuery selects the notifications with the username of the actor, in the order of Notification.
+func notificationsQuery(stmtBuilder squirrel.StatementBuilderType) squirrel.SelectBuilder {
+	return stmtBuilder.
+		Select(
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationIDDBField),
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationTypeDBField),
+			fmt.Sprintf("actor.%s", UserUsernameDBField),
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationReviewIDDBField),
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationSourceIDDBField),
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationMessageDBField),
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationCreatedAtDBField),
+			fmt.Sprintf("%s.%s", NotificationTableName, NotificationReadAtDBField)).
+		From(NotificationTableName).
+		LeftJoin(fmt.Sprintf("%s actor on actor.%s = %s.%s", UserTableName, UserIDDBField, NotificationTableName, NotificationActorIDDBField))
+}
+
+func queryNotifications(ctx context.Context, db *pgxpool.Pool, query squirrel.SelectBuilder) ([]Notification, error) {
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := make([]Notification, 0)
+	for rows.Next() {
+		var n Notification
+		if err = rows.Scan(&n.ID, &n.Type, &n.ActorUsername, &n.ReviewID, &n.SourceID, &n.Message, &n.CreatedAt, &n.ReadAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// GetNotifications returns a page of the notifications of the user, latest first, only the unread ones if unread is
+// set.
+func (s Server) GetNotifications(uid string, unread bool, cursor *pageCursor, limit uint64) (NotificationsResponse, error) {
+	query := notificationsQuery(s.StmtBuilder).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", NotificationTableName, NotificationUserIDDBField): uid}).
+		OrderBy(
+			fmt.Sprintf("%s.%s DESC", NotificationTableName, NotificationCreatedAtDBField),
+			fmt.Sprintf("%s.%s DESC", NotificationTableName, NotificationIDDBField)).
+		Limit(limit + 1)
+	if unread {
+		query = query.Where(squirrel.Eq{fmt.Sprintf("%s.%s", NotificationTableName, NotificationReadAtDBField): nil})
+	}
+	if cursor != nil {
+		query = query.Where(fmt.Sprintf("(%[1]s.%[2]s, %[1]s.%[3]s) < (?, ?)", NotificationTableName, NotificationCreatedAtDBField, NotificationIDDBField), cursor.CreatedAt, cursor.ID)
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	notifications, err := queryNotifications(to, s.DB, query)
+	if err != nil {
+		return NotificationsResponse{}, err
+	}
+	response := NotificationsResponse{Notifications: notifications}
+	if uint64(len(notifications)) > limit {
+		response.Notifications = notifications[:limit]
+		last := response.Notifications[limit-1]
+		response.NextCursor = pageCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+	}
+	response.NotificationUnreadResponse, err = s.GetUnreadNotificationCounts(uid)
+	if err != nil {
+		return NotificationsResponse{}, err
+	}
+	return response, nil
+}
+
+// GetUnreadNotificationCounts counts the unread notifications of the user, every type is in the counts.
+func (s Server) GetUnreadNotificationCounts(uid string) (NotificationUnreadResponse, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(NotificationTypeDBField, "COUNT(*)").
+		From(NotificationTableName).
+		Where(squirrel.Eq{NotificationUserIDDBField: uid, NotificationReadAtDBField: nil}).
+		GroupBy(NotificationTypeDBField))
+	if err != nil {
+		return NotificationUnreadResponse{}, err
+	}
+	defer rows.Close()
+	response := NotificationUnreadResponse{UnreadCounts: make(map[string]int64, len(notificationTypes))}
+	for _, t := range notificationTypes {
+		response.UnreadCounts[t] = 0
+	}
+	for rows.Next() {
+		var t string
+		var count int64
+		if err = rows.Scan(&t, &count); err != nil {
+			return NotificationUnreadResponse{}, err
+		}
+		response.UnreadCounts[t] = count
+		response.UnreadCount += count
+	}
+	return response, rows.Err()
+}
+
+// NotificationHub pushes the notifications to the streams of the users connected to this instance. It LISTENs to
+// notificationChannel, so the notifications created by any instance, or by the crons, reach every stream.
+type NotificationHub struct {
+	db          *pgxpool.Pool
+	mu          sync.Mutex
+	subscribers map[string]map[chan Notification]struct{}
+}
+
+func NewNotificationHub(db *pgxpool.Pool) *NotificationHub {
+	return &NotificationHub{db: db, subscribers: make(map[string]map[chan Notification]struct{})}
+}
+
+// Subscribe returns the channel the notifications of the user are sent to, and the function that closes it.
+func (h *NotificationHub) Subscribe(uid string) (<-chan Notification, func()) {
+	ch := make(chan Notification, notificationStreamBuffer)
+	h.mu.Lock()
+	if h.subscribers[uid] == nil {
+		h.subscribers[uid] = make(map[chan Notification]struct{})
+	}
+	h.subscribers[uid][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers[uid], ch)
+		if len(h.subscribers[uid]) == 0 {
+			delete(h.subscribers, uid)
+		}
+		h.mu.Unlock()
+		close(ch)
+	}
+}
+
+func (h *NotificationHub) subscribed(uid string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[uid]) > 0
+}
+
+// publish sends the notification to the streams of its user, a stream whose buffer is full misses it.
+func (h *NotificationHub) publish(uid string, n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[uid] {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// notificationPayload is the payload of the notify_notification trigger.
+type notificationPayload struct {
+	UserID string `json:"userId"`
+	ID     string `json:"id"`
+}
+
+func (h *NotificationHub) dispatch(ctx context.Context, payload string) error {
+	var p notificationPayload
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		return err
+	}
+	if !h.subscribed(p.UserID) {
+		return nil
+	}
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	notifications, err := queryNotifications(ctx, h.db, notificationsQuery(stmtBuilder).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", NotificationTableName, NotificationIDDBField): p.ID}))
+	if err != nil {
+		return err
+	}
+	for _, n := range notifications {
+		h.publish(p.UserID, n)
+	}
+	return nil
+}
+
+// listen holds a connection of the pool to LISTEN to notificationChannel until the context is done or the
+// connection fails.
+func (h *NotificationHub) listen(ctx context.Context) error {
+	conn, err := h.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err = conn.Exec(ctx, "LISTEN "+notificationChannel); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			// the connection is returned to the pool without the LISTEN, or closed if it is broken.
+			unlisten, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if _, uerr := conn.Exec(unlisten, "UNLISTEN *"); uerr != nil {
+				conn.Conn().Close(unlisten)
+			}
+			cancel()
+			return err
+		}
+		dispatch, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if err = h.dispatch(dispatch, notification.Payload); err != nil {
+			log.Printf("error dispatching notification: %v", err)
+		}
+		cancel()
+	}
+}
+
+// Run listens to notificationChannel until the context is done, it reconnects when the connection fails. The
+// notifications created while it reconnects are not pushed, the clients fetch them with GET /api/notifications.
+func (h *NotificationHub) Run(ctx context.Context) {
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("error listening to notifications: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// NotificationsHandler lists the notifications of the user.
+//
+//	@Summary					List notifications
+//	@Description				Lists the notifications of the user, latest first, with the count of the unread notifications in total and by type. Pass nextCursor as cursor to get the next page.
+//	@Tags						Notification
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Param						unread			query		bool					false	"Only the unread notifications"
+//	@Param						cursor			query		string					false	"Cursor of the page"
+//	@Param						limit			query		int						false	"Page size, 20 by default, at most 100"
+//	@Success					200				{object}	NotificationsResponse	"Notifications"
+//	@Failure					400				{object}	ErrorResponse			"Invalid cursor or limit"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/notifications [get]
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	cursor, limit, err := readPageParams(r)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	response, err := s.GetNotifications(jwtContents.UUID, r.URL.Query().Get("unread") == "true", cursor, limit)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
+
+// NotificationsReadHandler marks notifications read.
+//
+//	@Summary					Mark notifications read
+//	@Description				Marks the notifications with the ids read, at most 100, or every notification if all is set. Returns the unread counts.
+//	@Tags						Notification
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						body			body		NotificationReadRequest		true	"Notifications"
+//	@Success					200				{object}	NotificationUnreadResponse	"Unread counts"
+//	@Failure					400				{object}	ErrorResponse				"Invalid request"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/notifications/read [post]
+func NotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req NotificationReadRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if !req.All && len(req.IDs) == 0 {
+		s.LogError(MissingNotificationIDsError, http.StatusBadRequest)
+		return
+	}
+	update := s.StmtBuilder.Update(NotificationTableName).
+		Set(NotificationReadAtDBField, time.Now()).
+		Where(squirrel.Eq{NotificationUserIDDBField: jwtContents.UUID, NotificationReadAtDBField: nil})
+	if !req.All {
+		update = update.Where(squirrel.Eq{NotificationIDDBField: req.IDs})
+	}
+	if _, err = s.ExecuteSQL(update); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	counts, err := s.GetUnreadNotificationCounts(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(counts, http.StatusOK)
+}
+
+// NotificationPreferencesHandler returns the notification preferences of the user.
+//
+//	@Summary					Get notification preferences
+//	@Description				Lists the types of the notifications the user turned off.
+//	@Tags						Notification
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Success					200				{object}	NotificationPreferencesResponse	"Preferences"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/notifications/preferences [get]
+func NotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserNotificationDisabledTypesDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var disabledTypes []string
+	if rows.Next() {
+		err = rows.Scan(&disabledTypes)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if disabledTypes == nil {
+		disabledTypes = []string{}
+	}
+	s.WriteResponse(NotificationPreferencesResponse{DisabledTypes: disabledTypes, Types: notificationTypes}, http.StatusOK)
+}
+
+// NotificationPreferencesUpdateHandler replaces the notification preferences of the user.
+//
+//	@Summary					Update notification preferences
+//	@Description				Replaces the types of the notifications the user turned off. Send an empty list to receive every notification.
+//	@Tags						Notification
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						body			body		NotificationPreferencesRequest	true	"Disabled types"
+//	@Success					200				{object}	NotificationPreferencesResponse	"Preferences"
+//	@Failure					400				{object}	ErrorResponse					"Unknown type"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/notifications/preferences [put]
+func NotificationPreferencesUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req NotificationPreferencesRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	disabledTypes := make([]string, 0, len(notificationTypes))
+	for _, t := range notificationTypes {
+		for _, disabled := range req.DisabledTypes {
+			if disabled == t {
+				disabledTypes = append(disabledTypes, t)
+				break
+			}
+		}
+	}
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserNotificationDisabledTypesDBField, disabledTypes).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(NotificationPreferencesResponse{DisabledTypes: disabledTypes, Types: notificationTypes}, http.StatusOK)
+}
+
+// writeServerSentEvent writes an event of the stream, the data is JSON encoded.
+func writeServerSentEvent(w http.ResponseWriter, id string, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		if _, err = fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+	return err
+}
+
+// NotificationStreamHandler streams the new notifications of the user.
+//
+//	@Summary					Stream notifications
+//	@Description				Streams the new notifications of the user as Server-Sent Events. The stream starts with an unread event with the unread counts, then sends a notification event for every new notification. Notifications created while the client reconnects are not replayed, list them with GET /api/notifications. The stream is closed when the token expires or is revoked, reconnect with a new token.
+//	@Tags						Notification
+//	@Produce					text/event-stream
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string			true	"JWT token"
+//	@Success					200				{object}	Notification	"Notification events"
+//	@Failure					500				{object}	ErrorResponse	"Internal server error"
+//	@Router						/api/notifications/stream [get]
+func NotificationStreamHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.LogError(StreamingUnsupportedError, http.StatusInternalServerError)
+		return
+	}
+	// subscribe before the counts, a notification created in between is counted and sent.
+	notifications, unsubscribe := s.Notifications.Subscribe(jwtContents.UUID)
+	defer unsubscribe()
+	counts, err := s.GetUnreadNotificationCounts(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err = writeServerSentEvent(w, "", "unread", counts); err != nil {
+		return
+	}
+	flusher.Flush()
+	// the stream ends when the token expires, and the token is checked again on every heartbeat so a logout, a
+	// revocation or a ban ends it too. The client reconnects with a new token.
+	expiry := time.NewTimer(time.Until(time.Unix(int64(jwtContents.Expires), 0)))
+	defer expiry.Stop()
+	heartbeat := time.NewTicker(notificationStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-expiry.C:
+			return
+		case n := <-notifications:
+			if err = writeServerSentEvent(w, n.ID, "notification", n); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err = s.CheckTokenRevoked(jwtContents); err != nil {
+				return
+			}
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
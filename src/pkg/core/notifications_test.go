@@ -0,0 +1,44 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotificationHub(t *testing.T) {
+	hub := NewNotificationHub(nil)
+	assert.False(t, hub.subscribed("alice"))
+	first, unsubscribeFirst := hub.Subscribe("alice")
+	second, unsubscribeSecond := hub.Subscribe("alice")
+	assert.True(t, hub.subscribed("alice"))
+	hub.publish("alice", Notification{ID: "1"})
+	hub.publish("bob", Notification{ID: "2"})
+	assert.Equal(t, "1", (<-first).ID)
+	assert.Equal(t, "1", (<-second).ID)
+	// a full stream misses the next notifications instead of blocking the others.
+	for i := 0; i < notificationStreamBuffer+1; i++ {
+		hub.publish("alice", Notification{ID: "3"})
+	}
+	assert.Len(t, first, notificationStreamBuffer)
+	unsubscribeFirst()
+	assert.True(t, hub.subscribed("alice"))
+	unsubscribeSecond()
+	assert.False(t, hub.subscribed("alice"))
+	_, open := <-second
+	for open {
+		_, open = <-second
+	}
+	hub.publish("alice", Notification{ID: "4"})
+}
+
+func TestWriteServerSentEvent(t *testing.T) {
+	w := httptest.NewRecorder()
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Nil(t, writeServerSentEvent(w, "42", "notification", Notification{ID: "42", Type: notificationTypeReply, Message: "New reply", CreatedAt: createdAt}))
+	assert.Equal(t, "id: 42\nevent: notification\ndata: {\"id\":\"42\",\"type\":\"reply\",\"message\":\"New reply\",\"createdAt\":\"2024-05-01T12:00:00Z\"}\n\n", w.Body.String())
+	w = httptest.NewRecorder()
+	assert.Nil(t, writeServerSentEvent(w, "", "unread", NotificationUnreadResponse{UnreadCount: 0, UnreadCounts: map[string]int64{}}))
+	assert.Equal(t, "event: unread\ndata: {\"unreadCount\":0,\"unreadCounts\":{}}\n\n", w.Body.String())
+}
@@ -0,0 +1,372 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCProvider is an OpenID Connect relying party for a single identity provider. It supports the authorization
+// code flow with PKCE, provider metadata is discovered from the issuer on first use and cached.
+type OIDCProvider struct {
+	// Name is used in the routes, e.g. /api/user/oidc/{Name}/login.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// HTTPClient is used for discovery, JWKS and token requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	discovery *OIDCDiscovery
+	keys      map[string]interface{}
+	// keysFetchedAt is when the JWKS was last fetched, used to limit the refetches on unknown kids.
+	keysFetchedAt time.Time
+}
+
+// oidcJWKSRefetchInterval is the minimum time between two JWKS fetches of a provider, so that tokens with unknown
+// kids can not make us fetch the JWKS on every request.
+const oidcJWKSRefetchInterval = time.Minute
+
+// OIDCDiscovery is the subset of the provider metadata we use.
+//
+// See https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCTokenResponse is the response of the token endpoint.
+type OIDCTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// OIDCIDTokenClaims are the claims of a verified ID token.
+type OIDCIDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return OIDCProviderError(p.Name, fmt.Sprintf("GET %s returned %d", endpoint, resp.StatusCode))
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// Discover returns the provider metadata, fetched once from {Issuer}/.well-known/openid-configuration.
+func (p *OIDCProvider) Discover(ctx context.Context) (*OIDCDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery OIDCDiscovery
+	if err := p.getJSON(ctx, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.Issuer {
+		return nil, OIDCProviderError(p.Name, fmt.Sprintf("discovered issuer %s does not match %s", discovery.Issuer, p.Issuer))
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, OIDCProviderError(p.Name, "discovery document is missing endpoints")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// NewPKCEVerifier returns a new PKCE code verifier and its S256 code challenge.
+func NewPKCEVerifier() (string, string, error) {
+	verifier, _, err := GenerateSecureToken()
+	if err != nil {
+		return "", "", err
+	}
+	return verifier, PKCEChallenge(verifier), nil
+}
+
+// PKCEChallenge returns the S256 code challenge of the verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint the user is sent to.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeVerifier string) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", PKCEChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for tokens at the token endpoint.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string) (OIDCTokenResponse, error) {
+	var tokenResp OIDCTokenResponse
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return tokenResp, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.ClientID)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResp, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return tokenResp, err
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokenResp); err != nil {
+		return tokenResp, err
+	}
+	if resp.StatusCode != http.StatusOK || tokenResp.Error != "" {
+		return tokenResp, OIDCProviderError(p.Name, fmt.Sprintf("token request failed: %s %s", tokenResp.Error, tokenResp.ErrorDescription))
+	}
+	if tokenResp.IDToken == "" {
+		return tokenResp, OIDCProviderError(p.Name, "token response has no id_token")
+	}
+	return tokenResp, nil
+}
+
+// VerifyIDToken verifies the signature of the ID token with the JWKS of the provider, and checks the issuer, audience,
+// expiration time and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (OIDCIDTokenClaims, error) {
+	var claims OIDCIDTokenClaims
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return claims, err
+	}
+	_, err = jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, discovery.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return claims, err
+	}
+	if claims.ExpiresAt == nil {
+		return claims, InvalidJWTTokenNoExpirationTimeError
+	}
+	if claims.Subject == "" {
+		return claims, InvalidOIDCIDTokenError("no subject")
+	}
+	if claims.Nonce != nonce {
+		return claims, InvalidOIDCIDTokenError("nonce does not match")
+	}
+	return claims, nil
+}
+
+// publicKey returns the key with the kid from the JWKS. JWKS is fetched again when the kid is unknown, since
+// providers rotate their keys, at most once per oidcJWKSRefetchInterval.
+func (p *OIDCProvider) publicKey(ctx context.Context, jwksURI string, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.cachedPublicKey(kid)
+	refetch := time.Since(p.keysFetchedAt) >= oidcJWKSRefetchInterval
+	if !ok && refetch {
+		// claimed before fetching, so that concurrent requests do not fetch as well.
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !refetch {
+		return nil, InvalidOIDCIDTokenError(fmt.Sprintf("unknown signing key %q", kid))
+	}
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		parsed, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = parsed
+	}
+	p.mu.Lock()
+	p.keys = keys
+	key, ok = p.cachedPublicKey(kid)
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	return nil, InvalidOIDCIDTokenError(fmt.Sprintf("unknown signing key %q", kid))
+}
+
+// cachedPublicKey returns the key with the kid from the cached JWKS, p.mu must be held.
+func (p *OIDCProvider) cachedPublicKey(kid string) (interface{}, bool) {
+	if key, ok := p.keys[kid]; ok {
+		return key, true
+	}
+	// providers with a single key may omit the kid.
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// OIDCProviders maps the provider names to the providers.
+type OIDCProviders map[string]*OIDCProvider
+
+// NewOIDCProviders reads the providers from the environment (or the .env file).
+//
+// Environment variables:
+//
+//	OIDC_PROVIDERS=google,gitlab
+//	OIDC_GOOGLE_ISSUER, OIDC_GOOGLE_CLIENT_ID, OIDC_GOOGLE_CLIENT_SECRET
+//	OIDC_GOOGLE_REDIRECT_URL (default {APP_BASE_URL}/api/user/oidc/google/callback)
+//	OIDC_GOOGLE_SCOPES (default "openid email profile")
+func NewOIDCProviders() OIDCProviders {
+	providers := make(OIDCProviders)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := fmt.Sprintf("OIDC_%s_", strings.ToUpper(name))
+		provider := &OIDCProvider{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+			HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+		}
+		if provider.RedirectURL == "" {
+			provider.RedirectURL = fmt.Sprintf("%s/api/user/oidc/%s/callback", AppBaseURL(), name)
+		}
+		providers[name] = provider
+	}
+	return providers
+}
@@ -0,0 +1,344 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockOIDCProvider is an in-process OpenID Connect provider. Every authorization request is approved for Subject
+// without a login page, the authorization endpoint redirects straight to the redirect_uri with a code.
+type mockOIDCProvider struct {
+	Server   *httptest.Server
+	Key      *rsa.PrivateKey
+	KeyID    string
+	ClientID string
+	Subject  string
+	Email    string
+	// TokenTTL is the lifetime of the issued ID tokens, negative values issue expired tokens.
+	TokenTTL time.Duration
+
+	mu    sync.Mutex
+	codes map[string]mockOIDCAuthorization
+	// jwksRequests is the number of JWKS requests served.
+	jwksRequests int
+}
+
+type mockOIDCAuthorization struct {
+	Nonce         string
+	Challenge     string
+	RedirectURI   string
+	ClientID      string
+	Subject       string
+	Email         string
+	IssuedAtEpoch int64
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockOIDCProvider{
+		Key:      key,
+		KeyID:    "mock-key",
+		ClientID: "persephone-test",
+		Subject:  "mock-subject",
+		Email:    "mock@example.com",
+		TokenTTL: time.Minute,
+		codes:    make(map[string]mockOIDCAuthorization),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", mock.discovery)
+	mux.HandleFunc("/authorize", mock.authorize)
+	mux.HandleFunc("/token", mock.token)
+	mux.HandleFunc("/jwks", mock.jwks)
+	mock.Server = httptest.NewServer(mux)
+	return mock
+}
+
+func (m *mockOIDCProvider) Provider(redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		Name:        "mock",
+		Issuer:      m.Server.URL,
+		ClientID:    m.ClientID,
+		RedirectURL: redirectURL,
+		HTTPClient:  m.Server.Client(),
+	}
+}
+
+func (m *mockOIDCProvider) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (m *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	m.writeJSON(w, http.StatusOK, OIDCDiscovery{
+		Issuer:                m.Server.URL,
+		AuthorizationEndpoint: m.Server.URL + "/authorize",
+		TokenEndpoint:         m.Server.URL + "/token",
+		JWKSURI:               m.Server.URL + "/jwks",
+	})
+}
+
+func (m *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("response_type") != "code" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	code, _, _ := GenerateSecureToken()
+	m.mu.Lock()
+	m.codes[code] = mockOIDCAuthorization{
+		Nonce:       query.Get("nonce"),
+		Challenge:   query.Get("code_challenge"),
+		RedirectURI: query.Get("redirect_uri"),
+		ClientID:    query.Get("client_id"),
+		Subject:     m.Subject,
+		Email:       m.Email,
+	}
+	m.mu.Unlock()
+	redirect, _ := url.Parse(query.Get("redirect_uri"))
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		m.writeJSON(w, http.StatusBadRequest, OIDCTokenResponse{Error: "invalid_request"})
+		return
+	}
+	m.mu.Lock()
+	auth, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || auth.ClientID != r.PostForm.Get("client_id") || auth.RedirectURI != r.PostForm.Get("redirect_uri") {
+		m.writeJSON(w, http.StatusBadRequest, OIDCTokenResponse{Error: "invalid_grant"})
+		return
+	}
+	if PKCEChallenge(r.PostForm.Get("code_verifier")) != auth.Challenge {
+		m.writeJSON(w, http.StatusBadRequest, OIDCTokenResponse{Error: "invalid_grant", ErrorDescription: "PKCE verification failed"})
+		return
+	}
+	idToken, err := m.SignIDToken(m.Key, auth.Subject, auth.Email, auth.Nonce, m.ClientID)
+	if err != nil {
+		m.writeJSON(w, http.StatusInternalServerError, OIDCTokenResponse{Error: "server_error"})
+		return
+	}
+	m.writeJSON(w, http.StatusOK, OIDCTokenResponse{AccessToken: "mock-access-token", TokenType: "Bearer", IDToken: idToken, ExpiresIn: 60})
+}
+
+func (m *mockOIDCProvider) SignIDToken(key *rsa.PrivateKey, subject string, email string, nonce string, audience string) (string, error) {
+	now := time.Now()
+	claims := OIDCIDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.Server.URL,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.TokenTTL)),
+		},
+		Nonce:         nonce,
+		Email:         email,
+		EmailVerified: true,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.KeyID
+	return token.SignedString(key)
+}
+
+func (m *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	m.jwksRequests++
+	m.mu.Unlock()
+	m.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []jsonWebKey{{
+			Kid: m.KeyID,
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(m.Key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.Key.E)).Bytes()),
+		}},
+	})
+}
+
+// Authorize follows the authorization URL like a browser would, and returns the code and state from the redirect.
+func (m *mockOIDCProvider) Authorize(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	defer mock.Server.Close()
+	provider := mock.Provider("http://localhost:3000/api/user/oidc/mock/callback")
+	ctx := context.Background()
+	verifier, _, err := NewPKCEVerifier()
+	assert.Nil(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	assert.Nil(t, err)
+	code, state := mock.Authorize(t, authURL)
+	assert.Equal(t, "state-1", state)
+	tokenResp, err := provider.Exchange(ctx, code, verifier)
+	assert.Nil(t, err)
+	claims, err := provider.VerifyIDToken(ctx, tokenResp.IDToken, "nonce-1")
+	assert.Nil(t, err)
+	assert.Equal(t, mock.Subject, claims.Subject)
+	assert.Equal(t, mock.Email, claims.Email)
+	// codes are single use.
+	_, err = provider.Exchange(ctx, code, verifier)
+	assert.NotNil(t, err)
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	defer mock.Server.Close()
+	provider := mock.Provider("http://localhost:3000/callback")
+	verifier, _, err := NewPKCEVerifier()
+	assert.Nil(t, err)
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	assert.Nil(t, err)
+	code, _ := mock.Authorize(t, authURL)
+	_, err = provider.Exchange(context.Background(), code, verifier+"x")
+	assert.NotNil(t, err)
+}
+
+func TestOIDCVerifyIDToken(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	defer mock.Server.Close()
+	provider := mock.Provider("http://localhost:3000/callback")
+	ctx := context.Background()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	valid, err := mock.SignIDToken(mock.Key, mock.Subject, mock.Email, "nonce", mock.ClientID)
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(ctx, valid, "nonce")
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(ctx, valid, "another-nonce")
+	assert.NotNil(t, err)
+	forged, err := mock.SignIDToken(otherKey, mock.Subject, mock.Email, "nonce", mock.ClientID)
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(ctx, forged, "nonce")
+	assert.NotNil(t, err)
+	otherAudience, err := mock.SignIDToken(mock.Key, mock.Subject, mock.Email, "nonce", "another-client")
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(ctx, otherAudience, "nonce")
+	assert.NotNil(t, err)
+	mock.TokenTTL = -5 * time.Minute
+	expired, err := mock.SignIDToken(mock.Key, mock.Subject, mock.Email, "nonce", mock.ClientID)
+	assert.Nil(t, err)
+	_, err = provider.VerifyIDToken(ctx, expired, "nonce")
+	assert.NotNil(t, err)
+}
+
+func (m *mockOIDCProvider) JWKSRequests() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksRequests
+}
+
+func TestOIDCJWKSRefetchInterval(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	defer mock.Server.Close()
+	mock.KeyID = ""
+	provider := mock.Provider("http://localhost:3000/callback")
+	ctx := context.Background()
+	token, err := mock.SignIDToken(mock.Key, mock.Subject, mock.Email, "nonce", mock.ClientID)
+	assert.Nil(t, err)
+	// the single key of a provider that omits the kid is cached.
+	for i := 0; i < 3; i++ {
+		_, err = provider.VerifyIDToken(ctx, token, "nonce")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, mock.JWKSRequests())
+	// unknown kids do not refetch the JWKS until the interval passed.
+	mock.Key, err = rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	mock.KeyID = "rotated-key"
+	rotated, err := mock.SignIDToken(mock.Key, mock.Subject, mock.Email, "nonce", mock.ClientID)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = provider.VerifyIDToken(ctx, rotated, "nonce")
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, 1, mock.JWKSRequests())
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-oidcJWKSRefetchInterval)
+	provider.mu.Unlock()
+	_, err = provider.VerifyIDToken(ctx, rotated, "nonce")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, mock.JWKSRequests())
+}
+
+func TestOIDCDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	defer mock.Server.Close()
+	provider := mock.Provider("http://localhost:3000/callback")
+	provider.Issuer = fmt.Sprintf("%s/", mock.Server.URL)
+	_, err := provider.Discover(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestOIDCBindingCookie(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/user/oidc/mock/login", nil)
+	w := httptest.NewRecorder()
+	bindingHash, err := SetOIDCBindingCookie(w, r)
+	assert.Nil(t, err)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, oidcBindingCookieName, cookies[0].Name)
+	assert.Equal(t, oidcBindingCookiePath, cookies[0].Path)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	// the callback without the cookie is not bound to any flow.
+	callback := httptest.NewRequest("GET", "/api/user/oidc/mock/callback", nil)
+	_, ok := oidcBindingHash(callback)
+	assert.False(t, ok)
+	callback.AddCookie(cookies[0])
+	callbackHash, ok := oidcBindingHash(callback)
+	assert.True(t, ok)
+	assert.Equal(t, bindingHash, callbackHash)
+	// another flow started in the same browser keeps the cookie.
+	r.AddCookie(cookies[0])
+	again, err := SetOIDCBindingCookie(httptest.NewRecorder(), r)
+	assert.Nil(t, err)
+	assert.Equal(t, bindingHash, again)
+}
+
+func TestConsumeOIDCState(t *testing.T) {
+	sql, args, err := consumeOIDCState(squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar), "state", "mock", "binding").ToSql()
+	assert.Nil(t, err)
+	assert.Contains(t, sql, "binding_hash = $1")
+	assert.Contains(t, sql, "RETURNING code_verifier, nonce, user_id")
+	assert.Equal(t, "binding", args[0])
+	assert.Contains(t, args, HashToken("state"))
+}
@@ -0,0 +1,469 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"time"
+)
+
+const (
+	OIDCStateTableName           = "oidc_login_states"
+	OIDCStateHashDBField         = "state_hash"
+	OIDCStateProviderDBField     = "provider"
+	OIDCStateCodeVerifierDBField = "code_verifier"
+	OIDCStateNonceDBField        = "nonce"
+	OIDCStateUserIDDBField       = "user_id"
+	OIDCStateBindingHashDBField  = "binding_hash"
+	OIDCStateCreatedAtDBField    = "created_at"
+	OIDCStateExpiresAtDBField    = "expires_at"
+)
+
+// The flows are bound to the browser that starts them. The hash of the binding cookie is stored with the state, and the
+// callback is accepted only from a browser with the cookie; otherwise the callback URL of a flow started by someone
+// else, sent to the user, would log the user in to that account or link that identity to the user.
+const (
+	oidcBindingCookieName = "persephone_oidc"
+	oidcBindingCookiePath = "/api/user/oidc"
+)
+
+const (
+	UserIdentityTableName          = "user_identities"
+	UserIdentityIDDBField          = "id"
+	UserIdentityUserIDDBField      = "user_id"
+	UserIdentityProviderDBField    = "provider"
+	UserIdentitySubjectDBField     = "subject"
+	UserIdentityEmailDBField       = "email"
+	UserIdentityCreatedAtDBField   = "created_at"
+	UserIdentityLastLoginAtDBField = "last_login_at"
+)
+
+// OIDCAuthorizationResponse contains the URL of the identity provider the user must be sent to.
+//
+// swagger:model OIDCAuthorizationResponse
+type OIDCAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
+
+// UserIdentity is an external identity linked to the user.
+type UserIdentity struct {
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+// UserIdentitiesResponse lists the external identities linked to the user.
+//
+// swagger:model UserIdentitiesResponse
+type UserIdentitiesResponse struct {
+	Identities []UserIdentity `json:"identities"`
+}
+
+// UserIdentityUnlinkResponse represents the response of a successful unlink.
+//
+// swagger:model UserIdentityUnlinkResponse
+type UserIdentityUnlinkResponse struct {
+	Success bool `json:"success"`
+}
+
+func (s Server) oidcProvider() (*OIDCProvider, error) {
+	name := chi.URLParam(s.Request, "provider")
+	provider, ok := s.OIDCProviders[name]
+	if !ok {
+		return nil, OIDCProviderNotFoundError(name)
+	}
+	return provider, nil
+}
+
+// oidcBindingHash returns the hash of the binding cookie of the request.
+func oidcBindingHash(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(oidcBindingCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", false
+	}
+	return HashToken(cookie.Value), true
+}
+
+// SetOIDCBindingCookie sets the binding cookie and returns its hash. The cookie of the request is kept if there is one,
+// so the flows started together in the browser are all completed.
+func SetOIDCBindingCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	var binding string
+	if cookie, err := r.Cookie(oidcBindingCookieName); err == nil && cookie.Value != "" {
+		binding = cookie.Value
+	} else if binding, _, err = GenerateSecureToken(); err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBindingCookieName,
+		Value:    binding,
+		Path:     oidcBindingCookiePath,
+		MaxAge:   int(oidcStateDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return HashToken(binding), nil
+}
+
+// consumeOIDCState deletes the state of the provider if it is not expired and it is bound to the browser, and returns
+// its code verifier, nonce and user id. States are single use.
+func consumeOIDCState(stmtBuilder squirrel.StatementBuilderType, state string, provider string, bindingHash string) squirrel.DeleteBuilder {
+	return stmtBuilder.Delete(OIDCStateTableName).
+		Where(squirrel.Eq{
+			OIDCStateHashDBField:        HashToken(state),
+			OIDCStateProviderDBField:    provider,
+			OIDCStateBindingHashDBField: bindingHash,
+		}).
+		Where(squirrel.Gt{OIDCStateExpiresAtDBField: time.Now()}).
+		Suffix(fmt.Sprintf("RETURNING %s, %s, %s", OIDCStateCodeVerifierDBField, OIDCStateNonceDBField, OIDCStateUserIDDBField))
+}
+
+// startOIDCFlow stores a new state for the provider, bound to the browser with the binding cookie, and returns the
+// authorization URL. uid is empty for logins, and the id of the user the identity will be linked to otherwise.
+func (s Server) startOIDCFlow(w http.ResponseWriter, r *http.Request, provider *OIDCProvider, uid string) (string, error) {
+	bindingHash, err := SetOIDCBindingCookie(w, r)
+	if err != nil {
+		return "", err
+	}
+	state, stateHash, err := GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, _, err := GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, _, err := NewPKCEVerifier()
+	if err != nil {
+		return "", err
+	}
+	// expired states are never consumed, clean them while we are here.
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Delete(OIDCStateTableName).Where(squirrel.Lt{OIDCStateExpiresAtDBField: time.Now()})); err != nil {
+		return "", err
+	}
+	var userID interface{}
+	if uid != "" {
+		userID = uid
+	}
+	insertQuery := s.StmtBuilder.Insert(OIDCStateTableName).
+		Columns(OIDCStateHashDBField, OIDCStateProviderDBField, OIDCStateCodeVerifierDBField, OIDCStateNonceDBField, OIDCStateUserIDDBField, OIDCStateBindingHashDBField, OIDCStateExpiresAtDBField).
+		Values(stateHash, provider.Name, verifier, nonce, userID, bindingHash, time.Now().Add(oidcStateDuration))
+	if _, err = s.ExecuteSQL(insertQuery); err != nil {
+		return "", err
+	}
+	to, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return provider.AuthCodeURL(to, state, nonce, verifier)
+}
+
+// UserOIDCLoginHandler starts a login with an external identity provider.
+//
+//	@Summary		Start OIDC login
+//	@Description	Returns the authorization URL of the identity provider, and sets the persephone_oidc cookie that binds the login to the browser. After the user authorizes, the provider redirects to /api/user/oidc/{provider}/callback. Only identities linked to an account can log in.
+//	@Tags			User
+//	@Produce		json
+//	@Param			provider	path		string						true	"Provider name"
+//	@Success		200			{object}	OIDCAuthorizationResponse	"Authorization URL"
+//	@Failure		404			{object}	ErrorResponse				"Unknown provider"
+//	@Failure		502			{object}	ErrorResponse				"Identity provider is not reachable"
+//	@Router			/api/user/oidc/{provider}/login [get]
+func UserOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	provider, err := s.oidcProvider()
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	authURL, err := s.startOIDCFlow(w, r, provider, "")
+	if err != nil {
+		s.LogError(err, http.StatusBadGateway)
+		return
+	}
+	s.WriteResponse(OIDCAuthorizationResponse{AuthorizationURL: authURL}, http.StatusOK)
+}
+
+// UserOIDCLinkHandler starts linking an external identity to the logged-in user.
+//
+//	@Summary					Start OIDC account linking
+//	@Description				Returns the authorization URL of the identity provider, and sets the persephone_oidc cookie that binds the linking to the browser. The identity returned to the callback is linked to the logged-in user.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						provider		path		string						true	"Provider name"
+//	@Success					200				{object}	OIDCAuthorizationResponse	"Authorization URL"
+//	@Failure					404				{object}	ErrorResponse				"Unknown provider"
+//	@Failure					502				{object}	ErrorResponse				"Identity provider is not reachable"
+//	@Router						/api/user/oidc/{provider}/link [post]
+func UserOIDCLinkHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	provider, err := s.oidcProvider()
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	authURL, err := s.startOIDCFlow(w, r, provider, jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusBadGateway)
+		return
+	}
+	s.WriteResponse(OIDCAuthorizationResponse{AuthorizationURL: authURL}, http.StatusOK)
+}
+
+// UserOIDCCallbackHandler completes the login or the account linking started by UserOIDCLoginHandler or
+// UserOIDCLinkHandler.
+//
+//	@Summary		OIDC callback
+//	@Description	Requires the persephone_oidc cookie set when the flow started. Exchanges the authorization code, verifies the ID token, and either logs the user in (same response as /api/user/login) or links the identity to the user that started the linking.
+//	@Tags			User
+//	@Produce		json
+//	@Param			provider	path		string						true	"Provider name"
+//	@Param			code		query		string						true	"Authorization code"
+//	@Param			state		query		string						true	"State"
+//	@Success		200			{object}	UserLoginResponse			"Successful login"
+//	@Success		201			{object}	UserIdentitiesResponse		"Identity is linked"
+//	@Success		202			{object}	UserLoginTwoFactorResponse	"User has 2FA enabled, exchange the login token at /api/user/login/2fa"
+//	@Failure		400			{object}	ErrorResponse				"Invalid or expired state, the flow is started in another browser, or the provider returned an error"
+//	@Failure		401			{object}	ErrorResponse				"Invalid ID token"
+//	@Failure		404			{object}	ErrorResponse				"Unknown provider, or the identity is not linked to any account"
+//	@Failure		409			{object}	ErrorResponse				"Identity is linked to another account"
+//	@Failure		502			{object}	ErrorResponse				"Identity provider is not reachable"
+//	@Router			/api/user/oidc/{provider}/callback [get]
+func UserOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	provider, err := s.oidcProvider()
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		s.LogError(OIDCProviderError(provider.Name, fmt.Sprintf("%s %s", query.Get("error"), query.Get("error_description"))), http.StatusBadRequest)
+		return
+	}
+	// the flow must be completed in the browser that started it.
+	bindingHash, ok := oidcBindingHash(r)
+	if !ok {
+		s.LogError(InvalidOIDCStateError, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(consumeOIDCState(s.StmtBuilder, query.Get("state"), provider.Name, bindingHash))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		s.LogError(InvalidOIDCStateError, http.StatusBadRequest)
+		return
+	}
+	var verifier, nonce string
+	var linkUserID *string
+	if err = rows.Scan(&verifier, &nonce, &linkUserID); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	to, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tokenResp, err := provider.Exchange(to, query.Get("code"), verifier)
+	if err != nil {
+		s.LogError(err, http.StatusBadGateway)
+		return
+	}
+	claims, err := provider.VerifyIDToken(to, tokenResp.IDToken, nonce)
+	if err != nil {
+		s.LogError(err, http.StatusUnauthorized)
+		return
+	}
+	rows, err = s.QuerySQL(s.StmtBuilder.Select(UserIdentityUserIDDBField).
+		From(UserIdentityTableName).
+		Where(squirrel.Eq{UserIdentityProviderDBField: provider.Name, UserIdentitySubjectDBField: claims.Subject}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var uid string
+	if rows.Next() {
+		err = rows.Scan(&uid)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if linkUserID != nil {
+		if uid != "" && uid != *linkUserID {
+			s.LogError(OIDCIdentityLinkedToAnotherUserError, http.StatusConflict)
+			return
+		}
+		if uid == "" {
+			insertQuery := s.StmtBuilder.Insert(UserIdentityTableName).
+				Columns(UserIdentityUserIDDBField, UserIdentityProviderDBField, UserIdentitySubjectDBField, UserIdentityEmailDBField).
+				Values(*linkUserID, provider.Name, claims.Subject, claims.Email).
+				Suffix(fmt.Sprintf("ON CONFLICT (%s, %s) DO NOTHING", UserIdentityUserIDDBField, UserIdentityProviderDBField))
+			res, err := s.ExecuteSQL(insertQuery)
+			if err != nil {
+				s.LogError(err, http.StatusInternalServerError)
+				return
+			}
+			if res.RowsAffected() == 0 {
+				s.LogError(OIDCProviderAlreadyLinkedError(provider.Name), http.StatusConflict)
+				return
+			}
+		}
+		identities, err := s.GetUserIdentities(*linkUserID)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		s.WriteResponse(UserIdentitiesResponse{Identities: identities}, http.StatusCreated)
+		return
+	}
+	if uid == "" {
+		s.LogError(OIDCIdentityNotLinkedError(provider.Name), http.StatusNotFound)
+		return
+	}
+	updateQuery := s.StmtBuilder.Update(UserIdentityTableName).
+		Set(UserIdentityLastLoginAtDBField, time.Now()).
+		Set(UserIdentityEmailDBField, claims.Email).
+		Where(squirrel.Eq{UserIdentityProviderDBField: provider.Name, UserIdentitySubjectDBField: claims.Subject})
+	if _, err = s.ExecuteSQL(updateQuery); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows, err = s.QuerySQL(s.StmtBuilder.Select(UserTokenVersionDBField, UserRoleDBField, UserTOTPEnabledDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var user UserDB
+	if rows.Next() {
+		err = rows.Scan(&user.TokenVersion, &user.Role, &user.TOTPEnabled)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.CheckUserBan(uid); err != nil {
+		s.LogError(err, TokenErrorStatus(err))
+		return
+	}
+	// the identity provider replaces the password, not the second factor.
+	if user.TOTPEnabled {
+		loginToken, err := SignToken(uid, TokenRole(user.Role, true), tokenStatusWaitingLogin, user.TokenVersion, tokenDurationTwoFactor)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		s.WriteResponse(UserLoginTwoFactorResponse{TwoFactorRequired: true, LoginToken: loginToken}, http.StatusAccepted)
+		return
+	}
+	tokenToSend, err := s.IssueSessionToken(w, r, uid, TokenRole(user.Role, false), user.TokenVersion, loginMethodOIDC)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenToSend))
+	GetUser(r)
+}
+
+// GetUserIdentities returns the external identities linked to the user.
+func (s Server) GetUserIdentities(uid string) ([]UserIdentity, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserIdentityProviderDBField, UserIdentityEmailDBField, UserIdentityCreatedAtDBField, UserIdentityLastLoginAtDBField).
+		From(UserIdentityTableName).
+		Where(squirrel.Eq{UserIdentityUserIDDBField: uid}).
+		OrderBy(UserIdentityCreatedAtDBField))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	identities := make([]UserIdentity, 0)
+	for rows.Next() {
+		var identity UserIdentity
+		if err = rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// UserIdentitiesHandler lists the external identities linked to the user.
+//
+//	@Summary					List linked identities
+//	@Description				Lists the external identity providers linked to the logged-in user.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Success					200				{object}	UserIdentitiesResponse	"Linked identities"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/user/oidc [get]
+func UserIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	identities, err := s.GetUserIdentities(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserIdentitiesResponse{Identities: identities}, http.StatusOK)
+}
+
+// UserOIDCUnlinkHandler unlinks the identity of the provider from the user.
+//
+//	@Summary					Unlink identity
+//	@Description				Unlinks the external identity of the provider from the logged-in user.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						provider		path		string						true	"Provider name"
+//	@Success					200				{object}	UserIdentityUnlinkResponse	"Identity is unlinked"
+//	@Failure					404				{object}	ErrorResponse				"Identity of the provider is not linked"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/oidc/{provider} [delete]
+func UserOIDCUnlinkHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	name := chi.URLParam(r, "provider")
+	res, err := s.ExecuteSQL(s.StmtBuilder.Delete(UserIdentityTableName).Where(squirrel.Eq{UserIdentityUserIDDBField: jwtContents.UUID, UserIdentityProviderDBField: name}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(OIDCIdentityNotLinkedError(name), http.StatusNotFound)
+		return
+	}
+	s.WriteResponse(UserIdentityUnlinkResponse{Success: true}, http.StatusOK)
+}
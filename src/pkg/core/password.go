@@ -0,0 +1,503 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	PasswordResetTokenTableName        = "password_reset_tokens"
+	PasswordResetTokenIDDBField        = "id"
+	PasswordResetTokenUserIDDBField    = "user_id"
+	PasswordResetTokenHashDBField      = "token_hash"
+	PasswordResetTokenCreatedAtDBField = "created_at"
+	PasswordResetTokenExpiresAtDBField = "expires_at"
+	PasswordResetTokenUsedAtDBField    = "used_at"
+)
+
+const (
+	PasswordHistoryTableName        = "password_history"
+	PasswordHistoryUserIDDBField    = "user_id"
+	PasswordHistoryHashDBField      = "password_hash"
+	PasswordHistoryCreatedAtDBField = "created_at"
+)
+
+// UserPasswordForgotRequest represents the data required for requesting a password reset email.
+//
+// swagger:model UserPasswordForgotRequest
+type UserPasswordForgotRequest struct {
+	// Email of the user.
+	//
+	// required: true
+	// format: email
+	Email string `json:"email" validate:"required,email" binding:"required"`
+}
+
+// UserPasswordForgotResponse is always returned with success set to true, even if there is no user with the
+// requested email, so the endpoint can not be used to find out registered emails.
+//
+// swagger:model UserPasswordForgotResponse
+type UserPasswordForgotResponse struct {
+	Success bool `json:"success"`
+}
+
+// a new reset link is sent passwordResetResendInterval after the last one, so the requests can not flood the mailbox
+// or keep cancelling the link the user is about to use. An IP can request passwordForgotIPLimit links in
+// passwordForgotIPWindow.
+const (
+	passwordResetResendInterval = time.Minute
+	passwordForgotIPLimit       = 10
+	passwordForgotIPWindow      = time.Hour
+)
+
+var passwordForgotLimiter = NewRateLimiter(passwordForgotIPLimit, passwordForgotIPWindow)
+
+// UserPasswordForgotHandler sends a single use password reset link to the email of the user.
+//
+//	@Summary		Request password reset
+//	@Description	Sends a password reset link to the email if a user with the email exists. Response is the same whether the email exists or not, the link is sent in background. A new link is sent a minute after the last one at the earliest. Limited to 10 requests an hour per IP, per /64 for IPv6.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		UserPasswordForgotRequest	true	"Email of the account"
+//	@Success		200		{object}	UserPasswordForgotResponse	"Reset link is sent if the email exists"
+//	@Failure		400		{object}	ErrorResponse				"Bad request"
+//	@Failure		429		{object}	ErrorResponse				"Too many requests"
+//	@Router			/api/user/password/forgot [post]
+func UserPasswordForgotHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	if ok, retryAt := passwordForgotLimiter.Allow(RateLimitKey(r), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(retryAt).Seconds())+1))
+		s.LogError(PasswordForgotRateLimitedError(retryAt), http.StatusTooManyRequests)
+		return
+	}
+	var req UserPasswordForgotRequest
+	if err := s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err := s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	// the user is looked up in background too, so the response time does not tell whether the email exists.
+	go func(s Server, email string) {
+		if err := s.requestPasswordReset(email); err != nil {
+			s.Logger.Error(err.Error())
+		}
+	}(*s, req.Email)
+	s.WriteResponse(UserPasswordForgotResponse{Success: true}, http.StatusOK)
+}
+
+// requestPasswordReset sends a reset link to the user with the email, unless there is no such user or a link is just
+// sent to the user.
+func (s Server) requestPasswordReset(email string) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: email}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return rows.Err()
+	}
+	var uid string
+	if err = rows.Scan(&uid); err != nil {
+		return err
+	}
+	rows.Close()
+	throttled, err := s.passwordResetThrottled(uid)
+	if err != nil || throttled {
+		return err
+	}
+	return s.SendPasswordResetLink(uid, email)
+}
+
+// passwordResetThrottled reports whether a reset link is sent to the user in the last passwordResetResendInterval.
+func (s Server) passwordResetThrottled(uid string) (bool, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select("1").
+		From(PasswordResetTokenTableName).
+		Where(squirrel.Eq{PasswordResetTokenUserIDDBField: uid}).
+		Where(squirrel.Gt{PasswordResetTokenCreatedAtDBField: time.Now().Add(-passwordResetResendInterval)}).
+		Limit(1))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// SendPasswordResetLink emails a single use password reset link to the user, the previous links are invalidated. The
+// email is sent in background.
+func (s Server) SendPasswordResetLink(uid string, email string) error {
+	// only the latest requested link is valid.
+	invalidateQuery := s.StmtBuilder.Update(PasswordResetTokenTableName).
+		Set(PasswordResetTokenUsedAtDBField, time.Now()).
+		Where(squirrel.Eq{PasswordResetTokenUserIDDBField: uid, PasswordResetTokenUsedAtDBField: nil})
+	if _, err := s.ExecuteSQL(invalidateQuery); err != nil {
+		return err
+	}
+	token, tokenHash, err := GenerateSecureToken()
+	if err != nil {
+		return err
+	}
+	insertQuery := s.StmtBuilder.Insert(PasswordResetTokenTableName).
+		Columns(PasswordResetTokenUserIDDBField, PasswordResetTokenHashDBField, PasswordResetTokenExpiresAtDBField).
+		Values(uid, tokenHash, time.Now().Add(passwordResetTokenDuration))
+	if _, err = s.ExecuteSQL(insertQuery); err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/reset-password?token=%s", AppBaseURL(), token)
+	body := fmt.Sprintf("Someone requested a password reset for your account. If it was you, follow the link below in %s to set a new password:\n\n%s\n\nIf it was not you, you can ignore this email.", passwordResetTokenDuration, link)
+	go func(mailer Mailer, to string) {
+		if err := mailer.Send(to, "Reset your password", body); err != nil {
+			s.Logger.Error(err.Error())
+		}
+	}(s.Mailer, email)
+	return nil
+}
+
+// UserPasswordResetRequest represents the data required for resetting the password with the emailed token.
+//
+// swagger:model UserPasswordResetRequest
+type UserPasswordResetRequest struct {
+	// Token from the password reset link.
+	//
+	// required: true
+	Token string `json:"token" validate:"required" binding:"required"`
+
+	// New password of the user.
+	//
+	// required: true
+	Password string `json:"password" validate:"passwordSpec" binding:"required"`
+}
+
+// UserPasswordResetResponse represents the response of a successful password reset.
+//
+// swagger:model UserPasswordResetResponse
+type UserPasswordResetResponse struct {
+	Success bool `json:"success"`
+}
+
+// UserPasswordResetHandler sets a new password with a password reset token and ends every session of the user.
+//
+//	@Summary		Reset password
+//	@Description	Sets a new password with the token sent by /api/user/password/forgot. Tokens are single use, and all existing session and refresh tokens are revoked.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		UserPasswordResetRequest	true	"Reset token and the new password"
+//	@Success		200		{object}	UserPasswordResetResponse	"Password is reset"
+//	@Failure		400		{object}	ErrorResponse				"Bad request, invalid or breached password, or invalid/expired token"
+//	@Failure		500		{object}	ErrorResponse				"Internal server error"
+//	@Router			/api/user/password/reset [post]
+func UserPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	var req UserPasswordResetRequest
+	if err := s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err := s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err := CheckPasswordNotBreached(req.Password); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	passwordHashed, err := HashPassword(req.Password)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	// mark the token as used in the same statement we look it up, so the same token can not be used twice concurrently.
+	sql, args, err := s.StmtBuilder.Update(PasswordResetTokenTableName).
+		Set(PasswordResetTokenUsedAtDBField, time.Now()).
+		Where(squirrel.Eq{PasswordResetTokenHashDBField: HashToken(req.Token), PasswordResetTokenUsedAtDBField: nil}).
+		Where(squirrel.Gt{PasswordResetTokenExpiresAtDBField: time.Now()}).
+		Suffix(fmt.Sprintf("RETURNING %s", PasswordResetTokenUserIDDBField)).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows, err := tx.Query(to, sql, args...)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var uid string
+	if rows.Next() {
+		err = rows.Scan(&uid)
+	} else {
+		err = InvalidPasswordResetTokenError
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.RecordPasswordHistory(to, tx, uid); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// bumping the token version revokes every session and refresh token issued before, no new refresh token is issued,
+	// the user logs in with the new password.
+	sql, args, err = s.StmtBuilder.Update(UserTableName).
+		Set(UserPasswordDBField, passwordHashed).
+		Set(UserPasswordResetRequiredDBField, false).
+		Set(UserTokenVersionDBField, squirrel.Expr(fmt.Sprintf("%s + 1", UserTokenVersionDBField))).
+		Set(UserSessionTokenDBField, "").
+		Set(UserRefreshTokenDBField, "").
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.RevokeSessions(to, tx, uid, ""); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserPasswordResetResponse{Success: true}, http.StatusOK)
+}
+
+// RecordPasswordHistory copies the current password hash of the user into the password history, and removes the
+// history entries older than the last passwordHistoryLength ones. Must be called before the password is updated.
+func (s Server) RecordPasswordHistory(ctx context.Context, tx pgx.Tx, uid string) error {
+	sql, args, err := s.StmtBuilder.Insert(PasswordHistoryTableName).
+		Columns(PasswordHistoryUserIDDBField, PasswordHistoryHashDBField).
+		Select(s.StmtBuilder.Select(UserIDDBField, UserPasswordDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid})).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+	keep := s.StmtBuilder.Select(PasswordHistoryCreatedAtDBField).
+		From(PasswordHistoryTableName).
+		Where(squirrel.Eq{PasswordHistoryUserIDDBField: uid}).
+		OrderBy(fmt.Sprintf("%s DESC", PasswordHistoryCreatedAtDBField)).
+		Offset(passwordHistoryLength - 1).
+		Limit(1)
+	keepSQL, keepArgs, err := keep.ToSql()
+	if err != nil {
+		return err
+	}
+	sql, args, err = s.StmtBuilder.Delete(PasswordHistoryTableName).
+		Where(squirrel.Eq{PasswordHistoryUserIDDBField: uid}).
+		Where(squirrel.Expr(fmt.Sprintf("%s < (%s)", PasswordHistoryCreatedAtDBField, keepSQL), keepArgs...)).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// IsPasswordReused reports whether the password matches the current password of the user, or one of the last
+// passwordHistoryLength passwords.
+func (s Server) IsPasswordReused(uid string, currentHash string, password string) (bool, error) {
+	hashes := []string{currentHash}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(PasswordHistoryHashDBField).
+		From(PasswordHistoryTableName).
+		Where(squirrel.Eq{PasswordHistoryUserIDDBField: uid}).
+		OrderBy(fmt.Sprintf("%s DESC", PasswordHistoryCreatedAtDBField)).
+		Limit(passwordHistoryLength))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return false, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	for _, hash := range hashes {
+		if reused, _, _ := VerifyPassword(hash, password); reused {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// UserPasswordChangeRequest represents the data required for changing the password of a logged-in user.
+//
+// swagger:model UserPasswordChangeRequest
+type UserPasswordChangeRequest struct {
+	// Current password of the user.
+	//
+	// required: true
+	CurrentPassword string `json:"currentPassword" validate:"required" binding:"required"`
+
+	// New password of the user, must not be one of the recently used passwords.
+	//
+	// required: true
+	NewPassword string `json:"newPassword" validate:"passwordSpec" binding:"required"`
+}
+
+type UserPasswordChangeResponse GetUserDataResponse
+
+// UserPasswordChangeHandler changes the password of the logged-in user.
+//
+// Session token used in the request is replaced with a new one that has the same expiration date, every other
+// session and refresh token of the user is revoked.
+//
+//	@Summary					Change password
+//	@Description				Changes the password of the user. Requires the current password, and the new password must not be one of the last used passwords. Returns a new session token and refresh token, all other sessions are ended.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						body			body		UserPasswordChangeRequest	true	"Current and new password"
+//	@Success					200				{object}	UserPasswordChangeResponse	"Password changed, contains the new tokens"
+//	@Failure					400				{object}	ErrorResponse				"Bad request, new password is invalid, breached or reused"
+//	@Failure					401				{object}	ErrorResponse				"Current password is wrong"
+//	@Failure					403				{object}	ErrorResponse				"Impersonation token"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/password [post]
+func UserPasswordChangeHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserPasswordChangeRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = CheckPasswordNotBreached(req.NewPassword); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserPasswordDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		s.LogError(UserDoesNotExistError, http.StatusBadRequest)
+		return
+	}
+	var currentHash string
+	if err = rows.Scan(&currentHash); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	if ok, _, _ := VerifyPassword(currentHash, req.CurrentPassword); !ok {
+		s.LogError(WrongCurrentPasswordError, http.StatusUnauthorized)
+		return
+	}
+	reused, err := s.IsPasswordReused(jwtContents.UUID, currentHash, req.NewPassword)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if reused {
+		s.LogError(PasswordReusedError(passwordHistoryLength), http.StatusBadRequest)
+		return
+	}
+	passwordHashed, err := HashPassword(req.NewPassword)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	if err = s.RecordPasswordHistory(to, tx, jwtContents.UUID); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	sql, args, err := s.StmtBuilder.Update(UserTableName).
+		Set(UserPasswordDBField, passwordHashed).
+		Set(UserTokenVersionDBField, squirrel.Expr(fmt.Sprintf("%s + 1", UserTokenVersionDBField))).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}).
+		Suffix(fmt.Sprintf("RETURNING %s", UserTokenVersionDBField)).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var tokenVersion int64
+	if err = tx.QueryRow(to, sql, args...).Scan(&tokenVersion); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// every other device is logged out.
+	if err = s.RevokeSessions(to, tx, jwtContents.UUID, jwtContents.SessionID); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// current session keeps its expiration date, it is only re-signed with the new token version.
+	sessionToken, err := SignSessionToken(jwtContents.UUID, jwtContents.Role, jwtContents.Status, tokenVersion, jwtContents.SessionID, time.Until(time.Unix(int64(jwtContents.Expires), 0)))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	sql, args, err = s.StmtBuilder.Update(UserTableName).
+		Set(UserSessionTokenDBField, sessionToken).
+		Set(UserRefreshTokenDBField, "").
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", sessionToken))
+	GetUser(r)
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Password hashes are stored in the PHC string format, the algorithm and its parameters are encoded in the hash, so
+// the hashes of the older algorithms and parameters can still be verified:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//	$2a$10$<salt and key>    (bcrypt, before argon2id)
+//
+// Hashes that are not produced with the current algorithm and parameters are rehashed after a successful login,
+// see VerifyPassword.
+
+// Argon2Params are the argon2id parameters of the new password hashes.
+type Argon2Params struct {
+	// Memory in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the second recommended option of RFC 9106, with 64 MiB of memory.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// passwordHashParams are the parameters HashPassword uses, see Argon2ParamsFromEnv.
+var passwordHashParams = Argon2ParamsFromEnv()
+
+// Argon2ParamsFromEnv returns DefaultArgon2Params, overridden by the environment variables (or the .env file) that
+// are set. Raising them rehashes every password on the next login of the users.
+//
+// Environment variables:
+//
+//	ARGON2_MEMORY_KIB, ARGON2_ITERATIONS, ARGON2_PARALLELISM
+func Argon2ParamsFromEnv() Argon2Params {
+	params := DefaultArgon2Params
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KIB"), 10, 32); err == nil && v > 0 {
+		params.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_ITERATIONS"), 10, 32); err == nil && v > 0 {
+		params.Iterations = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8); err == nil && v > 0 {
+		params.Parallelism = uint8(v)
+	}
+	return params
+}
+
+// HashPassword hashes the password with argon2id and the current parameters.
+func HashPassword(password string) (string, error) {
+	return HashPasswordArgon2id(password, passwordHashParams)
+}
+
+// HashPasswordArgon2id hashes the password with argon2id and the given parameters, and returns the encoded hash.
+func HashPasswordArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether the password matches the encoded hash, and whether the hash should be replaced with
+// HashPassword(password) since it is not produced with the current algorithm and parameters. A mismatch is not an
+// error, errors are returned only for hashes that can not be decoded.
+func VerifyPassword(encodedHash string, password string) (bool, bool, error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2idHash(encodedHash)
+		if err != nil {
+			return false, false, err
+		}
+		otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+			return false, false, nil
+		}
+		return true, params != passwordHashParams, nil
+	case strings.HasPrefix(encodedHash, "$2a$"), strings.HasPrefix(encodedHash, "$2b$"), strings.HasPrefix(encodedHash, "$2y$"):
+		if err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		return true, true, nil
+	default:
+		return false, false, UnknownPasswordHashError
+	}
+}
+
+func decodeArgon2idHash(encodedHash string) (Argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, UnknownPasswordHashError
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	if version != argon2.Version {
+		return Argon2Params{}, nil, nil, UnknownPasswordHashError
+	}
+	var params Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// RehashPassword replaces the password hash of the user with HashPassword(password), if the hash is still oldHash.
+func (s Server) RehashPassword(uid string, oldHash string, password string) error {
+	newHash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserPasswordDBField, newHash).
+		Where(squirrel.Eq{UserIDDBField: uid, UserPasswordDBField: oldHash}))
+	return err
+}
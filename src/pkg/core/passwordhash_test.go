@@ -0,0 +1,63 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	hash, err := HashPassword(TestPassword)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$"))
+	ok, needsRehash, err := VerifyPassword(hash, TestPassword)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+	ok, _, err = VerifyPassword(hash, TestPassword+"x")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	// salts are random.
+	otherHash, err := HashPassword(TestPassword)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestVerifyPasswordLongPassword(t *testing.T) {
+	// bcrypt ignores everything after the 72nd byte, argon2id does not.
+	long := strings.Repeat("a", 72)
+	hash, err := HashPassword(long + "1")
+	assert.Nil(t, err)
+	ok, _, err := VerifyPassword(hash, long+"2")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestVerifyPasswordOutdatedHashes(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(TestPassword), bcrypt.MinCost)
+	assert.Nil(t, err)
+	ok, needsRehash, err := VerifyPassword(string(bcryptHash), TestPassword)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+	ok, _, err = VerifyPassword(string(bcryptHash), TestPassword+"x")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	weaker := passwordHashParams
+	weaker.Iterations = 1
+	weaker.Memory = 8 * 1024
+	weakHash, err := HashPasswordArgon2id(TestPassword, weaker)
+	assert.Nil(t, err)
+	ok, needsRehash, err = VerifyPassword(weakHash, TestPassword)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+}
+
+func TestVerifyPasswordUnknownHash(t *testing.T) {
+	_, _, err := VerifyPassword("plaintext", TestPassword)
+	assert.Equal(t, UnknownPasswordHashError, err)
+	_, _, err = VerifyPassword("$argon2id$v=19$m=1,t=1,p=1$salt", TestPassword)
+	assert.NotNil(t, err)
+}
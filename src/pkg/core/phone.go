@@ -0,0 +1,404 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5/pgconn"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	PhoneCodeTableName          = "phone_verification_codes"
+	PhoneCodeIDDBField          = "id"
+	PhoneCodeUserIDDBField      = "user_id"
+	PhoneCodePhoneNumberDBField = "phone_number"
+	PhoneCodeHashDBField        = "code_hash"
+	PhoneCodeAttemptsDBField    = "attempts"
+	PhoneCodeCreatedAtDBField   = "created_at"
+	PhoneCodeExpiresAtDBField   = "expires_at"
+	PhoneCodeUsedAtDBField      = "used_at"
+)
+
+const (
+	phoneCodeDigits   = 6
+	phoneCodeDuration = 10 * time.Minute
+	// a new code can be requested phoneCodeResendInterval after the last one, and at most phoneCodeMaxPerHour codes
+	// are sent to a user or to a phone number in an hour.
+	phoneCodeResendInterval = time.Minute
+	phoneCodeMaxPerHour     = 5
+	// after phoneCodeMaxAttempts wrong guesses the code is burned, and a new one must be requested.
+	phoneCodeMaxAttempts = 5
+)
+
+// phoneNumberVerifiedIndex is the unique index of the verified phone numbers. The unverified numbers are not unique,
+// the number of a user that did not verify it is removed when another user verifies it.
+const phoneNumberVerifiedIndex = "users_phone_number_verified"
+
+// IsPhoneNumberTaken reports whether a user other than uid verified the phone number, uid is empty at the signup.
+func (s Server) IsPhoneNumberTaken(phoneNumber string, uid string) (bool, error) {
+	query := s.StmtBuilder.Select(UserIDDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserPhoneNumberDBField: phoneNumber, UserPhoneVerifiedDBField: true})
+	if uid != "" {
+		query = query.Where(squirrel.NotEq{UserIDDBField: uid})
+	}
+	rows, err := s.QuerySQL(query)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// IsPhoneNumberConflict reports whether the error is the violation of the uniqueness of the verified phone numbers.
+func IsPhoneNumberConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == phoneNumberVerifiedIndex
+}
+
+// GeneratePhoneCode returns a random numeric code of phoneCodeDigits digits.
+func GeneratePhoneCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < phoneCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", phoneCodeDigits, n), nil
+}
+
+// HashPhoneCode returns the keyed hash of the code that is stored. Codes are short, a plain hash of them would be
+// reversed by trying every code, so they are hashed with the server key and the user id.
+func HashPhoneCode(uid string, code string) string {
+	mac := hmac.New(sha256.New, []byte(JWT_ENCRYPT_KEY))
+	mac.Write([]byte(uid + ":" + strings.TrimSpace(code)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// reservePhoneCodeAttempt counts an attempt of the unused code and returns its hash, it returns no rows once the code
+// has phoneCodeMaxAttempts attempts.
+func reservePhoneCodeAttempt(stmtBuilder squirrel.StatementBuilderType, codeID string) squirrel.UpdateBuilder {
+	return stmtBuilder.Update(PhoneCodeTableName).
+		Set(PhoneCodeAttemptsDBField, squirrel.Expr(PhoneCodeAttemptsDBField+" + 1")).
+		Where(squirrel.Eq{PhoneCodeIDDBField: codeID, PhoneCodeUsedAtDBField: nil}).
+		Where(squirrel.Lt{PhoneCodeAttemptsDBField: phoneCodeMaxAttempts}).
+		Suffix("RETURNING " + PhoneCodeHashDBField)
+}
+
+// UserPhoneVerificationSendResponse represents the response of a sent verification code.
+//
+// swagger:model UserPhoneVerificationSendResponse
+type UserPhoneVerificationSendResponse struct {
+	Success bool `json:"success"`
+	// ExpiresAt is the expiry of the sent code.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// UserPhoneVerificationRequest contains the code sent to the phone number of the user.
+//
+// swagger:model UserPhoneVerificationRequest
+type UserPhoneVerificationRequest struct {
+	// required: true
+	Code string `json:"code" validate:"required,len=6,numeric" binding:"required"`
+}
+
+// UserPhoneVerificationSendHandler sends a verification code to the phone number of the user.
+//
+//	@Summary					Send phone verification code
+//	@Description				Sends a one-time code to the phone number of the logged-in user. Codes expire in 10 minutes, a new code can be requested after a minute, and at most 5 codes are sent to a user or a phone number in an hour. Requesting a new code invalidates the previous one.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: Anyone that already logged in once.
+//	@Param						Authorization	header		string								true	"JWT token"
+//	@Success					200				{object}	UserPhoneVerificationSendResponse	"Code is sent"
+//	@Failure					400				{object}	ErrorResponse						"Phone number is already verified, or the user has none"
+//	@Failure					403				{object}	ErrorResponse						"Impersonation token"
+//	@Failure					429				{object}	ErrorResponse						"Too many codes are requested"
+//	@Failure					502				{object}	ErrorResponse						"SMS could not be sent"
+//	@Router						/api/user/phone/verify/send [post]
+func UserPhoneVerificationSendHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserPhoneNumberDBField, UserPhoneVerifiedDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var user UserDB
+	if rows.Next() {
+		err = rows.Scan(&user.PhoneNumber, &user.PhoneVerified)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if user.PhoneVerified {
+		s.LogError(PhoneAlreadyVerifiedError, http.StatusBadRequest)
+		return
+	}
+	// the number is removed if another user verified it.
+	if user.PhoneNumber == "" {
+		s.LogError(PhoneNumberRequiredError, http.StatusBadRequest)
+		return
+	}
+	// codes of the last hour, sent to the user or to the phone number.
+	rows, err = s.QuerySQL(s.StmtBuilder.Select("COUNT(*)", fmt.Sprintf("COALESCE(MAX(%s), 'epoch')", PhoneCodeCreatedAtDBField)).
+		From(PhoneCodeTableName).
+		Where(squirrel.Or{squirrel.Eq{PhoneCodeUserIDDBField: jwtContents.UUID}, squirrel.Eq{PhoneCodePhoneNumberDBField: user.PhoneNumber}}).
+		Where(squirrel.Gt{PhoneCodeCreatedAtDBField: time.Now().Add(-time.Hour)}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var sentCount int
+	var lastSentAt time.Time
+	if rows.Next() {
+		err = rows.Scan(&sentCount, &lastSentAt)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if time.Since(lastSentAt) < phoneCodeResendInterval {
+		s.LogError(PhoneCodeRateLimitedError(lastSentAt.Add(phoneCodeResendInterval)), http.StatusTooManyRequests)
+		return
+	}
+	if sentCount >= phoneCodeMaxPerHour {
+		s.LogError(PhoneCodeRateLimitedError(lastSentAt.Add(time.Hour)), http.StatusTooManyRequests)
+		return
+	}
+	code, err := GeneratePhoneCode()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(phoneCodeDuration)
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	// only the last sent code is valid.
+	sql, args, err := s.StmtBuilder.Update(PhoneCodeTableName).
+		Set(PhoneCodeExpiresAtDBField, time.Now()).
+		Where(squirrel.Eq{PhoneCodeUserIDDBField: jwtContents.UUID, PhoneCodeUsedAtDBField: nil}).
+		Where(squirrel.Gt{PhoneCodeExpiresAtDBField: time.Now()}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	sql, args, err = s.StmtBuilder.Insert(PhoneCodeTableName).
+		Columns(PhoneCodeUserIDDBField, PhoneCodePhoneNumberDBField, PhoneCodeHashDBField, PhoneCodeExpiresAtDBField).
+		Values(jwtContents.UUID, user.PhoneNumber, HashPhoneCode(jwtContents.UUID, code), expiresAt).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = s.SMSSender.Send(user.PhoneNumber, fmt.Sprintf("Your Persephone verification code is %s. It expires in %d minutes.", code, int(phoneCodeDuration.Minutes()))); err != nil {
+		s.LogError(err, http.StatusBadGateway)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserPhoneVerificationSendResponse{Success: true, ExpiresAt: expiresAt}, http.StatusOK)
+}
+
+// UserPhoneVerificationHandler verifies the phone number of the user with the code sent by
+// UserPhoneVerificationSendHandler.
+//
+//	@Summary					Verify phone number
+//	@Description				Verifies the phone number of the logged-in user with the last code sent to it. A code is burned after 5 wrong guesses. Phone verification is tracked separately from the email verification.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: Anyone that already logged in once.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						body			body		UserPhoneVerificationRequest	true	"Code sent to the phone number"
+//	@Success					200				{object}	GetUserDataResponse				"Phone number is verified"
+//	@Failure					400				{object}	ErrorResponse					"Invalid or expired code, or the number is verified by another user"
+//	@Failure					403				{object}	ErrorResponse					"Impersonation token"
+//	@Failure					429				{object}	ErrorResponse					"Too many wrong codes, request a new one"
+//	@Router						/api/user/phone/verify [post]
+func UserPhoneVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserPhoneVerificationRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	// the code must be sent to the current phone number of the user.
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(
+		fmt.Sprintf("%s.%s", PhoneCodeTableName, PhoneCodeIDDBField),
+		fmt.Sprintf("%s.%s", PhoneCodeTableName, PhoneCodePhoneNumberDBField)).
+		From(PhoneCodeTableName).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s AND %s.%s = %s.%s",
+			UserTableName,
+			UserTableName, UserIDDBField, PhoneCodeTableName, PhoneCodeUserIDDBField,
+			UserTableName, UserPhoneNumberDBField, PhoneCodeTableName, PhoneCodePhoneNumberDBField)).
+		Where(squirrel.Eq{
+			fmt.Sprintf("%s.%s", PhoneCodeTableName, PhoneCodeUserIDDBField): jwtContents.UUID,
+			fmt.Sprintf("%s.%s", PhoneCodeTableName, PhoneCodeUsedAtDBField): nil,
+		}).
+		Where(squirrel.Gt{fmt.Sprintf("%s.%s", PhoneCodeTableName, PhoneCodeExpiresAtDBField): time.Now()}).
+		OrderBy(fmt.Sprintf("%s.%s DESC", PhoneCodeTableName, PhoneCodeCreatedAtDBField)).
+		Limit(1))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var codeID, phoneNumber string
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&codeID, &phoneNumber)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.LogError(InvalidPhoneCodeError, http.StatusBadRequest)
+		return
+	}
+	// the attempt is counted before the code is compared, so concurrent guesses can not exceed phoneCodeMaxAttempts.
+	rows, err = s.QuerySQL(reservePhoneCodeAttempt(s.StmtBuilder, codeID))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var codeHash string
+	found = rows.Next()
+	if found {
+		err = rows.Scan(&codeHash)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.LogError(PhoneCodeAttemptsExceededError, http.StatusTooManyRequests)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(HashPhoneCode(jwtContents.UUID, req.Code))) != 1 {
+		s.LogError(InvalidPhoneCodeError, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	// consume the code atomically, so a code can not verify twice.
+	sql, args, err := s.StmtBuilder.Update(PhoneCodeTableName).
+		Set(PhoneCodeUsedAtDBField, time.Now()).
+		Where(squirrel.Eq{PhoneCodeIDDBField: codeID, PhoneCodeUsedAtDBField: nil}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	res, err := tx.Exec(to, sql, args...)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(InvalidPhoneCodeError, http.StatusBadRequest)
+		return
+	}
+	// the number is taken away from the other users that did not verify it.
+	sql, args, err = s.StmtBuilder.Update(UserTableName).
+		Set(UserPhoneNumberDBField, "").
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserPhoneNumberDBField: phoneNumber, UserPhoneVerifiedDBField: false}).
+		Where(squirrel.NotEq{UserIDDBField: jwtContents.UUID}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	sql, args, err = s.StmtBuilder.Update(UserTableName).
+		Set(UserPhoneVerifiedDBField, true).
+		Set(UserPhoneVerifiedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID, UserPhoneNumberDBField: phoneNumber}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res, err = tx.Exec(to, sql, args...); IsPhoneNumberConflict(err) {
+		// another user verified the number in the meantime.
+		s.LogError(PhoneNumberAlreadyExistsError, http.StatusBadRequest)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(InvalidPhoneCodeError, http.StatusBadRequest)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	GetUser(r)
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGeneratePhoneCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := GeneratePhoneCode()
+		assert.Nil(t, err)
+		assert.Len(t, code, phoneCodeDigits)
+		for _, c := range code {
+			assert.True(t, c >= '0' && c <= '9')
+		}
+	}
+}
+
+func TestHashPhoneCode(t *testing.T) {
+	assert.Equal(t, HashPhoneCode("user", "123456"), HashPhoneCode("user", " 123456 "))
+	assert.NotEqual(t, HashPhoneCode("user", "123456"), HashPhoneCode("another-user", "123456"))
+	assert.NotEqual(t, HashPhoneCode("user", "123456"), HashToken("123456"))
+}
+
+func TestMemorySMSSender(t *testing.T) {
+	sender := NewMemorySMSSender()
+	_, ok := sender.Last(TestPhone)
+	assert.False(t, ok)
+	assert.Nil(t, sender.Send(TestPhone, "first"))
+	assert.Nil(t, sender.Send(TestPhone, "second"))
+	last, ok := sender.Last(TestPhone)
+	assert.True(t, ok)
+	assert.Equal(t, "second", last)
+}
+
+func TestReservePhoneCodeAttempt(t *testing.T) {
+	sql, args, err := reservePhoneCodeAttempt(squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar), "code").ToSql()
+	assert.Nil(t, err)
+	assert.Equal(t, "UPDATE phone_verification_codes SET attempts = attempts + 1 WHERE id = $1 AND used_at IS NULL AND attempts < $2 RETURNING code_hash", sql)
+	assert.Equal(t, []interface{}{"code", phoneCodeMaxAttempts}, args)
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func NewPlaceHandler() http.Handler {
+	r := chi.NewRouter()
+	var placeEditTracer = AssignTracer("/places", "PLACE_EDIT", "/places")
+	// editing the data of the places is a reputation privilege, see reputation.go.
+	r.With(placeEditTracer, JWTWhitelist([]string{tokenStatusActive}, nil), ReputationWhitelist(privilegeEditPlaces)).Patch("/{id}", PlacePatchHandler)
+	return r
+}
+
+const (
+	placeNameMaxLength = 100
+	placeTextMaxLength = 255
+)
+
+// PlacePatchRequest is a JSON Merge Patch of the place. Absent members are not changed, null removes every member
+// except the name.
+//
+// swagger:model PlacePatchRequest
+type PlacePatchRequest struct {
+	Name         PatchField[string] `json:"name" swaggertype:"string"`
+	Cuisine      PatchField[string] `json:"cuisine" swaggertype:"string"`
+	OpeningHours PatchField[string] `json:"openingHours" swaggertype:"string"`
+	Phone        PatchField[string] `json:"phone" swaggertype:"string"`
+	Website      PatchField[string] `json:"website" swaggertype:"string"`
+}
+
+// Place is the editable data of a place, the members without a value are null.
+//
+// swagger:model Place
+type Place struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Cuisine      *string `json:"cuisine"`
+	OpeningHours *string `json:"openingHours"`
+	Phone        *string `json:"phone"`
+	Website      *string `json:"website"`
+}
+
+var placeColumns = []string{
+	RestaurantIDDBField,
+	RestaurantNameDBField,
+	RestaurantCuisineDBField,
+	RestaurantOpeningHoursDBField,
+	RestaurantPhoneDBField,
+	RestaurantWebsiteDBField,
+}
+
+// IsWebsiteURL reports whether the text is an absolute http or https URL.
+func IsWebsiteURL(text string) bool {
+	u, err := url.Parse(text)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// placePatchChanges returns the columns to write for the patch, the members that are set are written even if they do
+// not change.
+func placePatchChanges(patch PlacePatchRequest) (map[string]interface{}, error) {
+	changes := make(map[string]interface{})
+	texts := []struct {
+		name      string
+		column    string
+		field     PatchField[string]
+		maxLength int
+		nullable  bool
+	}{
+		{"name", RestaurantNameDBField, patch.Name, placeNameMaxLength, false},
+		{"cuisine", RestaurantCuisineDBField, patch.Cuisine, placeTextMaxLength, true},
+		{"openingHours", RestaurantOpeningHoursDBField, patch.OpeningHours, placeTextMaxLength, true},
+		{"phone", RestaurantPhoneDBField, patch.Phone, placeTextMaxLength, true},
+		{"website", RestaurantWebsiteDBField, patch.Website, placeTextMaxLength, true},
+	}
+	for _, text := range texts {
+		if !text.field.Set {
+			continue
+		}
+		value := strings.TrimSpace(text.field.Value)
+		// an empty text is removed, same as null.
+		if text.field.Null || value == "" {
+			if !text.nullable {
+				return nil, FieldNotNullableError(text.name)
+			}
+			changes[text.column] = nil
+			continue
+		}
+		if !ValidateDisplayText(value, text.maxLength, false) {
+			return nil, InvalidDisplayTextError(text.name, text.maxLength)
+		}
+		if text.column == RestaurantWebsiteDBField && !IsWebsiteURL(value) {
+			return nil, InvalidWebsiteError
+		}
+		changes[text.column] = value
+	}
+	return changes, nil
+}
+
+// PlacePatchHandler edits the data of a place.
+//
+//	@Summary					Edit place
+//	@Description				Edits the name, cuisine, opening hours, phone and website of a place with a merge patch. Requires the edit_places reputation privilege, moderators, admins and editors can always edit.
+//	@Tags						Place
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens with the edit_places privilege.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						id				path		string				true	"Place id"
+//	@Param						body			body		PlacePatchRequest	true	"Merge patch of the place"
+//	@Success					200				{object}	Place				"Updated place"
+//	@Failure					400				{object}	ErrorResponse		"Invalid value"
+//	@Failure					403				{object}	ErrorResponse		"Reputation does not unlock the privilege"
+//	@Failure					404				{object}	ErrorResponse		"Place does not exist"
+//	@Failure					415				{object}	ErrorResponse		"Content type is not a merge patch"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/places/{id} [patch]
+func PlacePatchHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	id := chi.URLParam(r, "id")
+	if _, err := uuid.Parse(id); err != nil {
+		s.LogError(PlaceDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	var patch PlacePatchRequest
+	if err := decodeMergePatch(r, &patch); err != nil {
+		if err == UnsupportedMergePatchMediaTypeError {
+			s.LogError(err, http.StatusUnsupportedMediaType)
+			return
+		}
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	changes, err := placePatchChanges(patch)
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var query StmtBuilders = s.StmtBuilder.Select(placeColumns...).From(RestaurantsTable).Where(squirrel.Eq{RestaurantIDDBField: id})
+	if len(changes) > 0 {
+		query = s.StmtBuilder.Update(RestaurantsTable).
+			SetMap(changes).
+			Where(squirrel.Eq{RestaurantIDDBField: id}).
+			Suffix(fmt.Sprintf("RETURNING %s", strings.Join(placeColumns, ", ")))
+	}
+	rows, err := s.QuerySQL(query)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var place Place
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&place.ID, &place.Name, &place.Cuisine, &place.OpeningHours, &place.Phone, &place.Website)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		s.LogError(PlaceDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	s.WriteResponse(place, http.StatusOK)
+}
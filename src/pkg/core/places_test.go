@@ -0,0 +1,35 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestIsWebsiteURL(t *testing.T) {
+	assert.True(t, IsWebsiteURL("https://example.com/menu"))
+	assert.True(t, IsWebsiteURL("http://example.com"))
+	assert.False(t, IsWebsiteURL("javascript:alert(1)"))
+	assert.False(t, IsWebsiteURL("example.com"))
+	assert.False(t, IsWebsiteURL("ftp://example.com"))
+}
+
+func TestPlacePatchChanges(t *testing.T) {
+	changes, err := placePatchChanges(PlacePatchRequest{
+		Name:    PatchField[string]{Set: true, Value: " Kebapçı "},
+		Cuisine: PatchField[string]{Set: true, Null: true},
+		Phone:   PatchField[string]{Set: true, Value: ""},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		RestaurantNameDBField:    "Kebapçı",
+		RestaurantCuisineDBField: nil,
+		RestaurantPhoneDBField:   nil,
+	}, changes)
+	_, err = placePatchChanges(PlacePatchRequest{Name: PatchField[string]{Set: true, Null: true}})
+	assert.Equal(t, FieldNotNullableError("name"), err)
+	_, err = placePatchChanges(PlacePatchRequest{Name: PatchField[string]{Set: true, Value: strings.Repeat("a", placeNameMaxLength+1)}})
+	assert.NotNil(t, err)
+	_, err = placePatchChanges(PlacePatchRequest{Website: PatchField[string]{Set: true, Value: "javascript:alert(1)"}})
+	assert.Equal(t, InvalidWebsiteError, err)
+}
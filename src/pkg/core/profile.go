@@ -0,0 +1,340 @@
+package core
+
+import (
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"sort"
+	"time"
+)
+
+func NewProfileHandler() http.Handler {
+	r := chi.NewRouter()
+	var profileTracer = AssignTracer("/users", "USER_PROFILE", "/users")
+	var followTracer = AssignTracer("/users/follow", "USER_FOLLOW", "/users/follow")
+	r.With(profileTracer).Get("/{username}", PublicProfileHandler)
+	r.With(followTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Put("/{username}/follow", FollowUserHandler)
+	r.With(followTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Delete("/{username}/follow", UnfollowUserHandler)
+	r.With(followTracer).Get("/{username}/followers", FollowersHandler)
+	r.With(followTracer).Get("/{username}/following", FollowingHandler)
+	return r
+}
+
+// fields of the public profile that the user can hide, username is always public.
+const (
+	profileFieldReputation    = "reputation"
+	profileFieldJoinDate      = "joinDate"
+	profileFieldLocation      = "location"
+	profileFieldReviewCount   = "reviewCount"
+	profileFieldRecentReviews = "recentReviews"
+)
+
+var profileFields = []string{
+	profileFieldReputation,
+	profileFieldJoinDate,
+	profileFieldLocation,
+	profileFieldReviewCount,
+	profileFieldRecentReviews,
+}
+
+// profileRecentReviewsLimit is the count of the latest reviews shown in the public profile.
+const profileRecentReviewsLimit = 5
+
+// PublicUserProfile is the public projection of a user, it is the only type the public profile is written from.
+// Do not add private columns (email, phone number, tokens, IPs...) to it, or to publicProfileQuery.
+//
+// Hidden fields are omitted, HiddenFields lists them so clients can tell a hidden field from an empty one.
+//
+// swagger:model PublicUserProfile
+type PublicUserProfile struct {
+	Username      string                 `json:"username"`
+	Reputation    *int64                 `json:"reputation,omitempty"`
+	Badges        []UserBadge            `json:"badges,omitempty"`
+	JoinedAt      *time.Time             `json:"joinedAt,omitempty"`
+	Location      *PublicProfileLocation `json:"location,omitempty"`
+	ReviewCount   *int64                 `json:"reviewCount,omitempty"`
+	RecentReviews []PublicProfileReview  `json:"recentReviews,omitempty"`
+	HiddenFields  []string               `json:"hiddenFields"`
+}
+
+// PublicProfileLocation is the location of the user at city level.
+type PublicProfileLocation struct {
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Country string `json:"country"`
+}
+
+// PublicProfileReview is a review in the public profile.
+type PublicProfileReview struct {
+	ID           string    `json:"id"`
+	PlaceID      string    `json:"placeId"`
+	PlaceName    string    `json:"placeName"`
+	Title        string    `json:"title"`
+	Text         string    `json:"text"`
+	HelpfulCount int64     `json:"helpfulCount"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// publicProfileRow is the row of publicProfileQuery, id is used only to look up the reviews and never written.
+type publicProfileRow struct {
+	id           string
+	username     string
+	reputation   int64
+	createdAt    time.Time
+	hiddenFields []string
+	city         string
+	state        string
+	country      string
+	reviewCount  int64
+}
+
+func (s Server) publicProfileQuery() squirrel.SelectBuilder {
+	return s.StmtBuilder.
+		Select(
+			fmt.Sprintf("%s.%s", UserTableName, UserIDDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserUsernameDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserReputationDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserCreatedAtDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserProfileHiddenFieldsDBField),
+			fmt.Sprintf("%s.%s", CityTable, CityNameDBField),
+			fmt.Sprintf("%s.%s", StateTable, StateNameDBField),
+			fmt.Sprintf("%s.%s", CountryTable, CountryNameDBField),
+			fmt.Sprintf("(SELECT COUNT(*) FROM %s WHERE %s.%s = %s.%s)", ReviewsTable, ReviewsTable, ReviewUserIDDBField, UserTableName, UserIDDBField)).
+		From(UserTableName).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", StateTable, StateTable, StateIDDBField, UserTableName, UserStateDBField)).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", CityTable, CityTable, CityIDDBField, UserTableName, UserCityDBField)).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", CountryTable, CountryTable, CountryIDDBField, UserTableName, UserCountryDBField))
+}
+
+// GetPublicProfile returns the public profile of the user with the username, without the fields the user hid.
+func (s Server) GetPublicProfile(username string) (PublicUserProfile, error) {
+	// deleted accounts have no profile, neither within the grace period nor after the anonymization.
+	rows, err := s.QuerySQL(s.publicProfileQuery().Where(squirrel.Eq{
+		fmt.Sprintf("%s.%s", UserTableName, UserUsernameDBField):  username,
+		fmt.Sprintf("%s.%s", UserTableName, UserDeletedAtDBField): nil,
+	}))
+	if err != nil {
+		return PublicUserProfile{}, err
+	}
+	var row publicProfileRow
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&row.id, &row.username, &row.reputation, &row.createdAt, &row.hiddenFields, &row.city, &row.state, &row.country, &row.reviewCount)
+	}
+	rows.Close()
+	if err != nil {
+		return PublicUserProfile{}, err
+	}
+	if !found {
+		return PublicUserProfile{}, UserDoesNotExistError
+	}
+	hidden := make(map[string]bool, len(row.hiddenFields))
+	for _, field := range row.hiddenFields {
+		hidden[field] = true
+	}
+	profile := PublicUserProfile{Username: row.username, HiddenFields: row.hiddenFields}
+	if profile.HiddenFields == nil {
+		profile.HiddenFields = []string{}
+	}
+	// badges are hidden with the reputation that earned them.
+	if !hidden[profileFieldReputation] {
+		profile.Reputation = &row.reputation
+		profile.Badges, err = s.GetUserBadges(row.id)
+		if err != nil {
+			return PublicUserProfile{}, err
+		}
+	}
+	if !hidden[profileFieldJoinDate] {
+		profile.JoinedAt = &row.createdAt
+	}
+	if !hidden[profileFieldLocation] {
+		profile.Location = &PublicProfileLocation{City: row.city, State: row.state, Country: row.country}
+	}
+	if !hidden[profileFieldReviewCount] {
+		profile.ReviewCount = &row.reviewCount
+	}
+	if !hidden[profileFieldRecentReviews] {
+		profile.RecentReviews, err = s.getRecentReviews(row.id)
+		if err != nil {
+			return PublicUserProfile{}, err
+		}
+	}
+	return profile, nil
+}
+
+func (s Server) getRecentReviews(uid string) ([]PublicProfileReview, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.
+		Select(
+			fmt.Sprintf("%s.%s", ReviewsTable, ReviewIDDBField),
+			fmt.Sprintf("%s.%s", ReviewsTable, ReviewPlaceIDDBField),
+			fmt.Sprintf("%s.%s", RestaurantsTable, RestaurantNameDBField),
+			fmt.Sprintf("%s.%s", ReviewsTable, ReviewTitleDBField),
+			fmt.Sprintf("%s.%s", ReviewsTable, ReviewTextDBField),
+			fmt.Sprintf("COALESCE(%s.%s, 0)", ReviewsTable, ReviewHelpfulCountDBField),
+			fmt.Sprintf("%s.%s", ReviewsTable, ReviewCreatedAtDBField)).
+		From(ReviewsTable).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", RestaurantsTable, RestaurantsTable, RestaurantIDDBField, ReviewsTable, ReviewPlaceIDDBField)).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", ReviewsTable, ReviewUserIDDBField): uid}).
+		OrderBy(fmt.Sprintf("%s.%s DESC", ReviewsTable, ReviewCreatedAtDBField)).
+		Limit(profileRecentReviewsLimit))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reviews := make([]PublicProfileReview, 0)
+	for rows.Next() {
+		var review PublicProfileReview
+		if err = rows.Scan(&review.ID, &review.PlaceID, &review.PlaceName, &review.Title, &review.Text, &review.HelpfulCount, &review.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+// PublicProfileHandler returns the public profile of a user.
+//
+//	@Summary		Get public profile
+//	@Description	Returns the public projection of the user, without the fields the user hid. Private fields such as the email and the phone number are never returned.
+//	@Tags			User
+//	@Produce		json
+//	@Param			username	path		string				true	"Username"
+//	@Success		200			{object}	PublicUserProfile	"Public profile"
+//	@Failure		404			{object}	ErrorResponse		"User does not exist"
+//	@Failure		500			{object}	ErrorResponse		"Internal server error"
+//	@Router			/api/users/{username} [get]
+func PublicProfileHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	profile, err := s.GetPublicProfile(chi.URLParam(r, "username"))
+	if err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(profile, http.StatusOK)
+}
+
+// UserProfilePrivacyRequest sets the hidden fields of the public profile, fields that are not listed are public.
+//
+// swagger:model UserProfilePrivacyRequest
+type UserProfilePrivacyRequest struct {
+	// One of reputation, joinDate, location, reviewCount and recentReviews.
+	HiddenFields []string `json:"hiddenFields" validate:"dive,oneof=reputation joinDate location reviewCount recentReviews"`
+}
+
+// UserProfilePrivacyResponse lists the hidden fields of the public profile.
+//
+// swagger:model UserProfilePrivacyResponse
+type UserProfilePrivacyResponse struct {
+	HiddenFields []string `json:"hiddenFields"`
+	// Fields is every field that can be hidden.
+	Fields []string `json:"fields"`
+}
+
+// NormalizeProfileHiddenFields removes the duplicates and sorts the hidden fields in the order of profileFields.
+func NormalizeProfileHiddenFields(fields []string) []string {
+	order := make(map[string]int, len(profileFields))
+	for i, field := range profileFields {
+		order[field] = i
+	}
+	seen := make(map[string]bool, len(fields))
+	normalized := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if _, ok := order[field]; !ok || seen[field] {
+			continue
+		}
+		seen[field] = true
+		normalized = append(normalized, field)
+	}
+	sort.Slice(normalized, func(i, j int) bool {
+		return order[normalized[i]] < order[normalized[j]]
+	})
+	return normalized
+}
+
+// UserProfilePrivacyHandler returns the privacy settings of the public profile.
+//
+//	@Summary					Get profile privacy
+//	@Description				Lists the fields the user hid from the public profile.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Success					200				{object}	UserProfilePrivacyResponse	"Privacy settings"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/profile/privacy [get]
+func UserProfilePrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserProfileHiddenFieldsDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var hiddenFields []string
+	if rows.Next() {
+		err = rows.Scan(&hiddenFields)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserProfilePrivacyResponse{HiddenFields: NormalizeProfileHiddenFields(hiddenFields), Fields: profileFields}, http.StatusOK)
+}
+
+// UserProfilePrivacyUpdateHandler replaces the hidden fields of the public profile.
+//
+//	@Summary					Update profile privacy
+//	@Description				Replaces the fields the user hid from the public profile. Send an empty list to make the whole profile public.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						body			body		UserProfilePrivacyRequest	true	"Hidden fields"
+//	@Success					200				{object}	UserProfilePrivacyResponse	"Privacy settings"
+//	@Failure					400				{object}	ErrorResponse				"Unknown field"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/profile/privacy [put]
+func UserProfilePrivacyUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserProfilePrivacyRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	hiddenFields := NormalizeProfileHiddenFields(req.HiddenFields)
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserProfileHiddenFieldsDBField, hiddenFields).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserProfilePrivacyResponse{HiddenFields: hiddenFields, Fields: profileFields}, http.StatusOK)
+}
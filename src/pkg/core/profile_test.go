@@ -0,0 +1,34 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeProfileHiddenFields(t *testing.T) {
+	assert.Equal(t, []string{}, NormalizeProfileHiddenFields(nil))
+	assert.Equal(t,
+		[]string{profileFieldReputation, profileFieldLocation, profileFieldRecentReviews},
+		NormalizeProfileHiddenFields([]string{profileFieldRecentReviews, profileFieldLocation, "email", profileFieldReputation, profileFieldLocation}))
+}
+
+// TestPublicUserProfileFields fails when a field is added to the public projection, check that it is public before
+// adding it here.
+func TestPublicUserProfileFields(t *testing.T) {
+	var fields []string
+	profileType := reflect.TypeOf(PublicUserProfile{})
+	for i := 0; i < profileType.NumField(); i++ {
+		fields = append(fields, strings.Split(profileType.Field(i).Tag.Get("json"), ",")[0])
+	}
+	assert.Equal(t, []string{"username", "reputation", "badges", "joinedAt", "location", "reviewCount", "recentReviews", "hiddenFields"}, fields)
+	reputation := int64(3)
+	joinedAt := time.Now()
+	body, err := json.Marshal(PublicUserProfile{Username: TestUsername, Reputation: &reputation, JoinedAt: &joinedAt, HiddenFields: []string{profileFieldLocation}})
+	assert.Nil(t, err)
+	assert.NotContains(t, string(body), `"location":`)
+	assert.Contains(t, string(body), `"hiddenFields":["location"]`)
+}
@@ -0,0 +1,421 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/exp/slices"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Reputation is the sum of the points in the reputation_events ledger, users.reputation is a cache of it. Rows of the
+// ledger are never updated or deleted: a change is recorded as a new row with the difference, so every point of a user
+// can be traced back to an event.
+//
+// The moderation actions record their events when they happen (see moderation.go). Votes are not stored per user,
+// only as counters on the reviews and replies, so SyncReputation records their points from the counters periodically.
+
+const (
+	ReputationEventTableName        = "reputation_events"
+	ReputationEventIDDBField        = "id"
+	ReputationEventUserIDDBField    = "user_id"
+	ReputationEventTypeDBField      = "event_type"
+	ReputationEventPointsDBField    = "points"
+	ReputationEventSourceIDDBField  = "source_id"
+	ReputationEventActorIDDBField   = "actor_id"
+	ReputationEventReasonDBField    = "reason"
+	ReputationEventCreatedAtDBField = "created_at"
+)
+
+const (
+	UserBadgeTableName        = "user_badges"
+	UserBadgeUserIDDBField    = "user_id"
+	UserBadgeBadgeDBField     = "badge"
+	UserBadgeAwardedAtDBField = "awarded_at"
+)
+
+// types of the reputation events, source_id is the id of the review, reply or report of the event.
+const (
+	reputationEventReviewVotes     = "review_votes"
+	reputationEventReplyVotes      = "reply_votes"
+	reputationEventReviewRemoved   = "review_removed"
+	reputationEventReportConfirmed = "report_confirmed"
+	reputationEventReportUpheld    = "report_upheld"
+	reputationEventAdjustment      = "moderator_adjustment"
+)
+
+const (
+	reputationPointsReviewHelpful = 10
+	reputationPointsReviewDislike = -2
+	reputationPointsReplyHelpful  = 5
+	reputationPointsReplyDislike  = -1
+	// reputationPointsReviewRemoved is deducted in addition to the points of the votes of the removed review.
+	reputationPointsReviewRemoved = -20
+	// reputationPointsReportConfirmed is awarded to the reporter, reputationPointsReportUpheld deducted from the
+	// author of the reported user, review or reply.
+	reputationPointsReportConfirmed = 5
+	reputationPointsReportUpheld    = -25
+	// reputationEventsLimit is the count of the latest events shown to the user.
+	reputationEventsLimit = 50
+)
+
+// privileges unlocked by reputation, moderators, admins and editors have every privilege regardless of it.
+const (
+	// privilegeEditPlaces users can edit the data of the places, see PlacePatchHandler.
+	privilegeEditPlaces = "edit_places"
+	// privilegeTrusted users are exempt from the automated spam checks.
+	privilegeTrusted = "trusted"
+)
+
+// ReputationThreshold is the reputation a privilege or a badge requires.
+type ReputationThreshold struct {
+	Name      string `json:"name"`
+	Threshold int64  `json:"threshold"`
+}
+
+var reputationPrivileges = []ReputationThreshold{
+	{privilegeEditPlaces, 500},
+	{privilegeTrusted, 1000},
+}
+
+// reputationBadges are granted once the reputation reaches the threshold, and kept if it drops afterwards.
+var reputationBadges = []ReputationThreshold{
+	{"contributor", 100},
+	{"trusted_reviewer", 1000},
+	{"expert", 5000},
+}
+
+var privilegeBypassRoles = []string{roleAdmin, roleModerator, roleEditor}
+
+// HasReputationPrivilege reports whether the reputation unlocks the privilege.
+func HasReputationPrivilege(reputation int64, privilege string) bool {
+	for _, p := range reputationPrivileges {
+		if p.Name == privilege {
+			return reputation >= p.Threshold
+		}
+	}
+	return false
+}
+
+// EarnedBadges returns the badges the reputation earns.
+func EarnedBadges(reputation int64) []string {
+	badges := make([]string, 0)
+	for _, badge := range reputationBadges {
+		if reputation >= badge.Threshold {
+			badges = append(badges, badge.Name)
+		}
+	}
+	return badges
+}
+
+// ReputationWhitelist accepts the requests of the users whose reputation unlocks the privilege, and of the
+// privilegeBypassRoles. It must be mounted after JWTWhitelist.
+func ReputationWhitelist(privilege string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server := r.Context().Value(ServerKeyString).(*Server)
+			jwtContents, err := server.GetJWTData()
+			if err != nil {
+				server.LogError(err, http.StatusBadRequest)
+				return
+			}
+			if slices.Contains(privilegeBypassRoles, jwtContents.Role) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rows, err := server.QuerySQL(server.StmtBuilder.Select(UserReputationDBField).
+				From(UserTableName).
+				Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+			if err != nil {
+				server.LogError(err, http.StatusInternalServerError)
+				return
+			}
+			var reputation int64
+			if rows.Next() {
+				err = rows.Scan(&reputation)
+			}
+			rows.Close()
+			if err != nil {
+				server.LogError(err, http.StatusInternalServerError)
+				return
+			}
+			if !HasReputationPrivilege(reputation, privilege) {
+				server.LogError(ReputationPrivilegeRequiredError(privilege), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReputationEvent is a row of the ledger. SourceID and ActorID are optional.
+type ReputationEvent struct {
+	UserID    string
+	EventType string
+	Points    int64
+	SourceID  *string
+	ActorID   *string
+	Reason    string
+}
+
+// RecordReputationEvent adds the event to the ledger, updates the reputation of the user and grants the badges it
+// earns. Returns the new reputation.
+func RecordReputationEvent(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, event ReputationEvent) (int64, error) {
+	// the user is updated first, so an unknown user is reported as pgx.ErrNoRows.
+	sql, args, err := stmtBuilder.Update(UserTableName).
+		Set(UserReputationDBField, squirrel.Expr(fmt.Sprintf("%s + ?", UserReputationDBField), event.Points)).
+		Where(squirrel.Eq{UserIDDBField: event.UserID}).
+		Suffix(fmt.Sprintf("RETURNING %s", UserReputationDBField)).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var reputation int64
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&reputation); err != nil {
+		return 0, err
+	}
+	sql, args, err = stmtBuilder.Insert(ReputationEventTableName).
+		Columns(
+			ReputationEventUserIDDBField,
+			ReputationEventTypeDBField,
+			ReputationEventPointsDBField,
+			ReputationEventSourceIDDBField,
+			ReputationEventActorIDDBField,
+			ReputationEventReasonDBField).
+		Values(event.UserID, event.EventType, event.Points, event.SourceID, event.ActorID, event.Reason).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return 0, err
+	}
+	badges := EarnedBadges(reputation)
+	if len(badges) == 0 {
+		return reputation, nil
+	}
+	insert := stmtBuilder.Insert(UserBadgeTableName).Columns(UserBadgeUserIDDBField, UserBadgeBadgeDBField)
+	for _, badge := range badges {
+		insert = insert.Values(event.UserID, badge)
+	}
+	sql, args, err = insert.Suffix(fmt.Sprintf("ON CONFLICT (%s, %s) DO NOTHING", UserBadgeUserIDDBField, UserBadgeBadgeDBField)).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return reputation, err
+}
+
+// voteEventsQuery records the difference between the points of the votes of every review (or reply) and the points
+// already in the ledger for it. The points of deleted reviews are taken back the same way.
+func voteEventsQuery(eventType string, table string, idField string, userIDField string, helpfulField string, dislikeField string, helpfulPoints int64, dislikePoints int64) (string, []interface{}) {
+	sql := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, %[3]s, %[4]s, %[5]s, %[6]s)
+SELECT COALESCE(d.user_id, c.user_id), ?, COALESCE(d.points, 0) - COALESCE(c.points, 0), COALESCE(d.source_id, c.source_id), ?
+FROM (SELECT %[9]s AS user_id, %[8]s AS source_id, COALESCE(%[10]s, 0)::INTEGER * ? + COALESCE(%[11]s, 0)::INTEGER * ? AS points FROM %[7]s) d
+FULL JOIN (SELECT %[2]s AS user_id, %[5]s AS source_id, SUM(%[4]s) AS points FROM %[1]s WHERE %[3]s = ? GROUP BY %[2]s, %[5]s) c
+ON d.user_id = c.user_id AND d.source_id = c.source_id
+WHERE COALESCE(d.points, 0) <> COALESCE(c.points, 0)`,
+		ReputationEventTableName, ReputationEventUserIDDBField, ReputationEventTypeDBField, ReputationEventPointsDBField,
+		ReputationEventSourceIDDBField, ReputationEventReasonDBField,
+		table, idField, userIDField, helpfulField, dislikeField)
+	sql, _ = squirrel.Dollar.ReplacePlaceholders(sql)
+	return sql, []interface{}{eventType, "votes changed", helpfulPoints, dislikePoints, eventType}
+}
+
+// SyncReputation records the points of the votes, notifies the users of the votes that earned them reputation,
+// recomputes users.reputation from the ledger and grants the badges.
+// It is scheduled in main.go; a reputation that drifted from the ledger, by a concurrent event, is fixed by the next
+// run.
+func SyncReputation(db *pgxpool.Pool) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	// the time of the database, the vote events of this run are created after it.
+	var start time.Time
+	if err := db.QueryRow(to, "SELECT NOW()").Scan(&start); err != nil {
+		log.Printf("error syncing reputation: %v", err)
+		return
+	}
+	for _, q := range []struct {
+		eventType, table, idField, userIDField, helpfulField, dislikeField string
+		helpfulPoints, dislikePoints                                       int64
+	}{
+		{reputationEventReviewVotes, ReviewsTable, ReviewIDDBField, ReviewUserIDDBField, ReviewHelpfulCountDBField, ReviewDislikeCountDBField, reputationPointsReviewHelpful, reputationPointsReviewDislike},
+		{reputationEventReplyVotes, ReviewReplyTable, ReviewReplyIDDBField, ReviewReplyUserIDDBField, ReviewReplyHelpfulCountDBField, ReviewReplyDislikeCountDBField, reputationPointsReplyHelpful, reputationPointsReplyDislike},
+	} {
+		sql, args := voteEventsQuery(q.eventType, q.table, q.idField, q.userIDField, q.helpfulField, q.dislikeField, q.helpfulPoints, q.dislikePoints)
+		if _, err := db.Exec(to, sql, args...); err != nil {
+			log.Printf("error syncing reputation of %s: %v", q.eventType, err)
+			return
+		}
+	}
+	if err := notifyVoteEvents(to, db, start); err != nil {
+		log.Printf("error notifying votes: %v", err)
+	}
+	recompute := fmt.Sprintf(`UPDATE %[1]s SET %[2]s = t.points
+FROM (SELECT %[1]s.%[3]s AS id, COALESCE(SUM(%[4]s.%[5]s), 0) AS points FROM %[1]s
+LEFT JOIN %[4]s ON %[4]s.%[6]s = %[1]s.%[3]s GROUP BY %[1]s.%[3]s) t
+WHERE %[1]s.%[3]s = t.id AND %[1]s.%[2]s <> t.points`,
+		UserTableName, UserReputationDBField, UserIDDBField,
+		ReputationEventTableName, ReputationEventPointsDBField, ReputationEventUserIDDBField)
+	res, err := db.Exec(to, recompute)
+	if err != nil {
+		log.Printf("error recomputing reputation: %v", err)
+		return
+	}
+	if res.RowsAffected() > 0 {
+		log.Printf("recomputed the reputation of %d users", res.RowsAffected())
+	}
+	for _, badge := range reputationBadges {
+		sql, args, err := stmtBuilder.Insert(UserBadgeTableName).
+			Columns(UserBadgeUserIDDBField, UserBadgeBadgeDBField).
+			Select(stmtBuilder.Select(UserIDDBField).
+				Column(squirrel.Expr("?", badge.Name)).
+				From(UserTableName).
+				Where(squirrel.GtOrEq{UserReputationDBField: badge.Threshold}).
+				Where(squirrel.Eq{UserAnonymizedAtDBField: nil})).
+			Suffix(fmt.Sprintf("ON CONFLICT (%s, %s) DO NOTHING", UserBadgeUserIDDBField, UserBadgeBadgeDBField)).
+			ToSql()
+		if err != nil {
+			log.Printf("error granting badges: %v", err)
+			return
+		}
+		if _, err = db.Exec(to, sql, args...); err != nil {
+			log.Printf("error granting badge %s: %v", badge.Name, err)
+			return
+		}
+	}
+}
+
+// UserBadge is a badge of the user.
+type UserBadge struct {
+	Name      string    `json:"name"`
+	AwardedAt time.Time `json:"awardedAt"`
+}
+
+// GetUserBadges returns the badges of the user, in the order they are awarded.
+func (s Server) GetUserBadges(uid string) ([]UserBadge, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserBadgeBadgeDBField, UserBadgeAwardedAtDBField).
+		From(UserBadgeTableName).
+		Where(squirrel.Eq{UserBadgeUserIDDBField: uid}).
+		OrderBy(UserBadgeAwardedAtDBField, UserBadgeBadgeDBField))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	badges := make([]UserBadge, 0)
+	for rows.Next() {
+		var badge UserBadge
+		if err = rows.Scan(&badge.Name, &badge.AwardedAt); err != nil {
+			return nil, err
+		}
+		badges = append(badges, badge)
+	}
+	return badges, rows.Err()
+}
+
+// UserReputationPrivilege is a privilege and whether the user has it.
+type UserReputationPrivilege struct {
+	Name      string `json:"name"`
+	Threshold int64  `json:"threshold"`
+	Unlocked  bool   `json:"unlocked"`
+}
+
+// UserReputationEvent is an entry of the ledger.
+type UserReputationEvent struct {
+	EventType string    `json:"eventType"`
+	Points    int64     `json:"points"`
+	SourceID  *string   `json:"sourceId"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserReputationResponse is the reputation of the user with the privileges, the badges and the latest events.
+//
+// swagger:model UserReputationResponse
+type UserReputationResponse struct {
+	Reputation int64                     `json:"reputation"`
+	Privileges []UserReputationPrivilege `json:"privileges"`
+	Badges     []UserBadge               `json:"badges"`
+	Events     []UserReputationEvent     `json:"events"`
+}
+
+// UserReputationHandler returns the reputation of the user.
+//
+//	@Summary					Get Reputation
+//	@Description				Returns the reputation of the user, the privileges it unlocks, the badges and the latest 50 events of the ledger. Points of the votes are added periodically.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Success					200				{object}	UserReputationResponse	"Reputation of the user"
+//	@Failure					400				{object}	ErrorResponse			"Invalid token"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/user/reputation [get]
+func UserReputationHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserReputationDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var response UserReputationResponse
+	if rows.Next() {
+		err = rows.Scan(&response.Reputation)
+	} else {
+		err = UUIDDoesNotExistError(jwtContents.UUID)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	for _, privilege := range reputationPrivileges {
+		response.Privileges = append(response.Privileges, UserReputationPrivilege{
+			Name:      privilege.Name,
+			Threshold: privilege.Threshold,
+			Unlocked:  response.Reputation >= privilege.Threshold,
+		})
+	}
+	if response.Badges, err = s.GetUserBadges(jwtContents.UUID); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows, err = s.QuerySQL(s.StmtBuilder.Select(
+		ReputationEventTypeDBField,
+		ReputationEventPointsDBField,
+		ReputationEventSourceIDDBField,
+		ReputationEventReasonDBField,
+		ReputationEventCreatedAtDBField).
+		From(ReputationEventTableName).
+		Where(squirrel.Eq{ReputationEventUserIDDBField: jwtContents.UUID}).
+		OrderBy(fmt.Sprintf("%s DESC", ReputationEventCreatedAtDBField)).
+		Limit(reputationEventsLimit))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	response.Events = make([]UserReputationEvent, 0)
+	for rows.Next() {
+		var event UserReputationEvent
+		if err = rows.Scan(&event.EventType, &event.Points, &event.SourceID, &event.Reason, &event.CreatedAt); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		response.Events = append(response.Events, event)
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
@@ -0,0 +1,25 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHasReputationPrivilege(t *testing.T) {
+	assert.False(t, HasReputationPrivilege(499, privilegeEditPlaces))
+	assert.True(t, HasReputationPrivilege(500, privilegeEditPlaces))
+	assert.False(t, HasReputationPrivilege(0, privilegeTrusted))
+	assert.False(t, HasReputationPrivilege(999, privilegeTrusted))
+	assert.True(t, HasReputationPrivilege(1000, privilegeTrusted))
+	assert.False(t, HasReputationPrivilege(1_000_000, "unknown"))
+}
+
+func TestEarnedBadges(t *testing.T) {
+	assert.Equal(t, []string{}, EarnedBadges(-20))
+	assert.Equal(t, []string{"contributor"}, EarnedBadges(100))
+	assert.Equal(t, []string{"contributor", "trusted_reviewer", "expert"}, EarnedBadges(5000))
+}
+
+func TestReputationPrivilegeRequiredError(t *testing.T) {
+	assert.Equal(t, "edit_places requires a reputation of at least 500", ReputationPrivilegeRequiredError(privilegeEditPlaces).Error())
+}
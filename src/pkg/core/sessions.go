@@ -0,0 +1,523 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	LoginHistoryTableName          = "login_history"
+	LoginHistoryIDDBField          = "id"
+	LoginHistoryUserIDDBField      = "user_id"
+	LoginHistoryIPDBField          = "ip"
+	LoginHistoryUserAgentDBField   = "user_agent"
+	LoginHistoryBrowserDBField     = "browser"
+	LoginHistoryOSDBField          = "os"
+	LoginHistoryDeviceTypeDBField  = "device_type"
+	LoginHistoryDeviceHashDBField  = "device_hash"
+	LoginHistoryCountryDBField     = "country"
+	LoginHistoryMethodDBField      = "method"
+	LoginHistoryCreatedAtDBField   = "created_at"
+	LoginHistoryLastSeenAtDBField  = "last_seen_at"
+	LoginHistoryExpiresAtDBField   = "expires_at"
+	LoginHistoryRevokedAtDBField   = "revoked_at"
+	loginHistoryUserAgentMaxLength = 512
+)
+
+// login methods stored in the login history.
+const (
+	loginMethodSignup    = "signup"
+	loginMethodPassword  = "password"
+	loginMethodToken     = "token"
+	loginMethodTwoFactor = "two_factor"
+	loginMethodOIDC      = "oidc"
+)
+
+// sessionLastSeenInterval is how often the last seen time of a session is updated, to not write on every request.
+const sessionLastSeenInterval = 5 * time.Minute
+
+// A device is the browser that keeps the device cookie, a random id set at its first login. The clients that do not
+// keep the cookie, and the first login of a browser, are told apart by the families of the user agent and the subnet
+// of the IP, see spamSubnet; so a login is from a new device if neither the device cookie nor the user agent families
+// in the subnet are seen before. Browser updates and the IP changes of a browser with the cookie are the same device.
+const (
+	deviceCookieName     = "persephone_device"
+	deviceCookieDuration = 400 * 24 * time.Hour
+	deviceIDMaxLength    = 64
+)
+
+// UserAgent is the parsed user agent of a device, only the families are kept so a device is the same after updates.
+type UserAgent struct {
+	Browser    string `json:"browser"`
+	OS         string `json:"os"`
+	DeviceType string `json:"deviceType"`
+}
+
+// ParseUserAgent parses the browser, operating system and device type families from a User-Agent header. Unknown
+// values are "Other".
+func ParseUserAgent(ua string) UserAgent {
+	lower := strings.ToLower(ua)
+	contains := func(subs ...string) bool {
+		for _, sub := range subs {
+			if strings.Contains(lower, sub) {
+				return true
+			}
+		}
+		return false
+	}
+	parsed := UserAgent{Browser: "Other", OS: "Other", DeviceType: "desktop"}
+	// order matters, most browsers mention the engines of the others.
+	switch {
+	case contains("bot", "spider", "crawler"):
+		parsed.Browser = "Bot"
+	case contains("curl/"):
+		parsed.Browser = "curl"
+	case contains("okhttp"):
+		parsed.Browser = "OkHttp"
+	case contains("edg/", "edga/", "edgios/"):
+		parsed.Browser = "Edge"
+	case contains("opr/", "opera"):
+		parsed.Browser = "Opera"
+	case contains("samsungbrowser"):
+		parsed.Browser = "Samsung Internet"
+	case contains("firefox/", "fxios/"):
+		parsed.Browser = "Firefox"
+	case contains("chrome/", "crios/", "chromium/"):
+		parsed.Browser = "Chrome"
+	case contains("safari/"):
+		parsed.Browser = "Safari"
+	}
+	switch {
+	case contains("iphone", "ipad", "ipod"):
+		parsed.OS = "iOS"
+	case contains("android"):
+		parsed.OS = "Android"
+	case contains("windows"):
+		parsed.OS = "Windows"
+	case contains("mac os x", "macintosh"):
+		parsed.OS = "macOS"
+	case contains("cros"):
+		parsed.OS = "ChromeOS"
+	case contains("linux"):
+		parsed.OS = "Linux"
+	}
+	switch {
+	case parsed.Browser == "Bot":
+		parsed.DeviceType = "bot"
+	case contains("ipad", "tablet") || (parsed.OS == "Android" && !contains("mobile")):
+		parsed.DeviceType = "tablet"
+	case contains("mobile", "iphone", "ipod") || parsed.OS == "Android":
+		parsed.DeviceType = "mobile"
+	}
+	return parsed
+}
+
+// DeviceHash identifies a device without the device cookie in the login history, by the user agent families and the
+// subnet of the IP.
+func (ua UserAgent) DeviceHash(ip net.IP) string {
+	return HashToken(strings.Join([]string{ua.Browser, ua.OS, ua.DeviceType, spamSubnet(ip)}, "|"))
+}
+
+// deviceCookieHash returns the hash of the device id in the device cookie of the request, which identifies the device
+// in the login history.
+func deviceCookieHash(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(deviceCookieName)
+	if err != nil || cookie.Value == "" || len(cookie.Value) > deviceIDMaxLength {
+		return "", false
+	}
+	return HashToken(cookie.Value), true
+}
+
+// SetDeviceCookie sets a new device id in the device cookie and returns its hash.
+func SetDeviceCookie(w http.ResponseWriter) (string, error) {
+	deviceID, deviceHash, err := GenerateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceCookieName,
+		Value:    deviceID,
+		Path:     "/api",
+		MaxAge:   int(deviceCookieDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return deviceHash, nil
+}
+
+func (ua UserAgent) String() string {
+	return fmt.Sprintf("%s on %s (%s)", ua.Browser, ua.OS, ua.DeviceType)
+}
+
+// ClientIP returns the IP of the client. middleware.RealIP already replaced RemoteAddr with X-Real-IP or
+// X-Forwarded-For if the request came through a proxy, do not read these headers by hand.
+func ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// RequestCountry returns the ISO 3166-1 alpha-2 country of the client, set by the CDN or the reverse proxy in the
+// header named by COUNTRY_HEADER in the environment (or in the .env file), CF-IPCountry by default. Returns "" if the
+// country is not known.
+func RequestCountry(r *http.Request) string {
+	header := os.Getenv("COUNTRY_HEADER")
+	if header == "" {
+		header = "CF-IPCountry"
+	}
+	country := strings.ToUpper(strings.TrimSpace(r.Header.Get(header)))
+	// XX and T1 are unknown and Tor for Cloudflare.
+	if len(country) != 2 || country == "XX" || country == "T1" {
+		return ""
+	}
+	return country
+}
+
+// StartSession records a login of the user to the login history and returns the id of the new session, which is
+// put in the session token with SignSessionToken. The device cookie is set if the request has none. The user is emailed
+// if the login came from a device or a country that is not seen before.
+func (s Server) StartSession(w http.ResponseWriter, r *http.Request, uid string, method string) (string, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > loginHistoryUserAgentMaxLength {
+		userAgent = userAgent[:loginHistoryUserAgentMaxLength]
+	}
+	parsed := ParseUserAgent(userAgent)
+	ip := ClientIP(r)
+	country := RequestCountry(r)
+	// the login without the cookie is recorded with the user agent families, so the next login of the browser, with
+	// the cookie, is not a new device either.
+	deviceHash := parsed.DeviceHash(ip)
+	knownHashes := []string{deviceHash}
+	if cookieHash, ok := deviceCookieHash(r); ok {
+		deviceHash = cookieHash
+		knownHashes = append(knownHashes, cookieHash)
+	} else if _, err := SetDeviceCookie(w); err != nil {
+		return "", err
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select("COUNT(*)").
+		Column(fmt.Sprintf("COUNT(*) FILTER (WHERE %s = ANY(?))", LoginHistoryDeviceHashDBField), knownHashes).
+		Column(fmt.Sprintf("COUNT(*) FILTER (WHERE %s = ?)", LoginHistoryCountryDBField), country).
+		From(LoginHistoryTableName).
+		Where(squirrel.Eq{LoginHistoryUserIDDBField: uid}))
+	if err != nil {
+		return "", err
+	}
+	var logins, deviceLogins, countryLogins int
+	if rows.Next() {
+		err = rows.Scan(&logins, &deviceLogins, &countryLogins)
+	}
+	rows.Close()
+	if err != nil {
+		return "", err
+	}
+	var ipValue interface{}
+	if ip != nil {
+		ipValue = ip.String()
+	}
+	now := time.Now()
+	rows, err = s.QuerySQL(s.StmtBuilder.Insert(LoginHistoryTableName).
+		Columns(
+			LoginHistoryUserIDDBField,
+			LoginHistoryIPDBField,
+			LoginHistoryUserAgentDBField,
+			LoginHistoryBrowserDBField,
+			LoginHistoryOSDBField,
+			LoginHistoryDeviceTypeDBField,
+			LoginHistoryDeviceHashDBField,
+			LoginHistoryCountryDBField,
+			LoginHistoryMethodDBField,
+			LoginHistoryExpiresAtDBField).
+		Values(uid, ipValue, userAgent, parsed.Browser, parsed.OS, parsed.DeviceType, deviceHash, country, method, now.Add(tokenDurationActive)).
+		Suffix(fmt.Sprintf("RETURNING %s", LoginHistoryIDDBField)))
+	if err != nil {
+		return "", err
+	}
+	var sessionID string
+	if rows.Next() {
+		err = rows.Scan(&sessionID)
+	}
+	rows.Close()
+	if err != nil {
+		return "", err
+	}
+	// logging in within the grace period restores a deleted account, see deletion.go.
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserLastLoginIPDBField, ipValue).
+		Set(UserLastLoginAtDBField, now).
+		Set(UserDeletedAtDBField, nil).
+		Where(squirrel.Eq{UserIDDBField: uid, UserAnonymizedAtDBField: nil})); err != nil {
+		return "", err
+	}
+	// the first login is not an alert, every device and country is new then.
+	if logins > 0 && (deviceLogins == 0 || (country != "" && countryLogins == 0)) {
+		s.sendNewLoginEmail(uid, parsed, ip, country, deviceLogins == 0, now)
+	}
+	return sessionID, nil
+}
+
+func (s Server) sendNewLoginEmail(uid string, ua UserAgent, ip net.IP, country string, newDevice bool, at time.Time) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserEmailDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		s.Logger.Error(err.Error())
+		return
+	}
+	var email string
+	if rows.Next() {
+		err = rows.Scan(&email)
+	}
+	rows.Close()
+	if err != nil || email == "" {
+		return
+	}
+	reason := "a new device"
+	if !newDevice {
+		reason = "a new country"
+	}
+	if country == "" {
+		country = "unknown"
+	}
+	body := fmt.Sprintf("Your account is logged in from %s.\n\nDevice: %s\nIP: %s\nCountry: %s\nTime: %s\n\nIf it was not you, change your password and revoke the session from your account settings at %s.",
+		reason, ua, ip, country, at.UTC().Format(time.RFC1123), AppBaseURL())
+	go func(mailer Mailer, to string) {
+		if err := mailer.Send(to, "New login to your account", body); err != nil {
+			s.Logger.Error(err.Error())
+		}
+	}(s.Mailer, email)
+}
+
+// ExtendSession extends the session by tokenDurationActive, for the logins with a session token.
+func (s Server) ExtendSession(sessionID string) error {
+	_, err := s.ExecuteSQL(s.StmtBuilder.Update(LoginHistoryTableName).
+		Set(LoginHistoryExpiresAtDBField, time.Now().Add(tokenDurationActive)).
+		Set(LoginHistoryLastSeenAtDBField, time.Now()).
+		Where(squirrel.Eq{LoginHistoryIDDBField: sessionID, LoginHistoryRevokedAtDBField: nil}))
+	return err
+}
+
+// IssueSessionToken starts a session for the user and returns an ACTIVE token of it.
+func (s Server) IssueSessionToken(w http.ResponseWriter, r *http.Request, uid string, role string, version int64, method string) (string, error) {
+	sessionID, err := s.StartSession(w, r, uid, method)
+	if err != nil {
+		return "", err
+	}
+	return SignSessionToken(uid, role, tokenStatusActive, version, sessionID, tokenDurationActive)
+}
+
+// SignRefreshToken signs a REFRESH token of the session. Logging in with it continues the session, so it stops
+// working once the session is revoked.
+func SignRefreshToken(uid string, role string, version int64, sessionID string) (string, error) {
+	return SignSessionToken(uid, role, tokenStatusRefresh, version, sessionID, tokenDurationRefresh)
+}
+
+// CheckSession returns SessionRevokedError if the session of the token is revoked, and updates its last seen time.
+// Tokens without a session, such as the signup tokens, are only checked with the token version.
+func (s Server) CheckSession(jwtContents JWTFields) error {
+	if jwtContents.SessionID == "" {
+		return nil
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(LoginHistoryRevokedAtDBField, LoginHistoryLastSeenAtDBField).
+		From(LoginHistoryTableName).
+		Where(squirrel.Eq{LoginHistoryIDDBField: jwtContents.SessionID, LoginHistoryUserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		return err
+	}
+	var revokedAt *time.Time
+	var lastSeenAt time.Time
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&revokedAt, &lastSeenAt)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	if !found || revokedAt != nil {
+		return SessionRevokedError
+	}
+	if time.Since(lastSeenAt) > sessionLastSeenInterval {
+		_, err = s.ExecuteSQL(s.StmtBuilder.Update(LoginHistoryTableName).
+			Set(LoginHistoryLastSeenAtDBField, time.Now()).
+			Where(squirrel.Eq{LoginHistoryIDDBField: jwtContents.SessionID}))
+	}
+	return err
+}
+
+// RevokeSessions revokes every active session of the user except exceptSessionID, in the transaction. Call it with
+// every token version bump, so the sessions list matches the tokens that are still valid.
+func (s Server) RevokeSessions(ctx context.Context, tx pgx.Tx, uid string, exceptSessionID string) error {
+	query := s.StmtBuilder.Update(LoginHistoryTableName).
+		Set(LoginHistoryRevokedAtDBField, time.Now()).
+		Where(squirrel.Eq{LoginHistoryUserIDDBField: uid, LoginHistoryRevokedAtDBField: nil})
+	if exceptSessionID != "" {
+		query = query.Where(squirrel.NotEq{LoginHistoryIDDBField: exceptSessionID})
+	}
+	sql, args, err := query.ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// ForceLogout revokes every token and session of the user, in the transaction. The user has to log in again.
+func (s Server) ForceLogout(ctx context.Context, tx pgx.Tx, uid string) error {
+	// bumping the token version revokes every session and refresh token issued before.
+	sql, args, err := s.StmtBuilder.Update(UserTableName).
+		Set(UserTokenVersionDBField, squirrel.Expr(fmt.Sprintf("%s + 1", UserTokenVersionDBField))).
+		Set(UserSessionTokenDBField, "").
+		Set(UserRefreshTokenDBField, "").
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return UserDoesNotExistError
+	}
+	return s.RevokeSessions(ctx, tx, uid, "")
+}
+
+// UserSession is an active session of the user.
+type UserSession struct {
+	ID string `json:"id"`
+	UserAgent
+	IP         string    `json:"ip"`
+	Country    string    `json:"country"`
+	Method     string    `json:"method"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current is true for the session of the token the list is requested with.
+	Current bool `json:"current"`
+}
+
+// UserSessionsResponse lists the active sessions of the user.
+//
+// swagger:model UserSessionsResponse
+type UserSessionsResponse struct {
+	Sessions []UserSession `json:"sessions"`
+}
+
+// UserSessionRevokeResponse represents the response of a successful session revoke.
+//
+// swagger:model UserSessionRevokeResponse
+type UserSessionRevokeResponse struct {
+	Success bool `json:"success"`
+}
+
+// UserSessionsHandler lists the active sessions of the user.
+//
+//	@Summary					List sessions
+//	@Description				Lists the devices the logged-in user has an active session on, most recently seen first.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string					true	"JWT token"
+//	@Success					200				{object}	UserSessionsResponse	"Active sessions"
+//	@Failure					500				{object}	ErrorResponse			"Internal server error"
+//	@Router						/api/user/sessions [get]
+func UserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(
+		LoginHistoryIDDBField,
+		LoginHistoryBrowserDBField,
+		LoginHistoryOSDBField,
+		LoginHistoryDeviceTypeDBField,
+		fmt.Sprintf("COALESCE(HOST(%s), '')", LoginHistoryIPDBField),
+		LoginHistoryCountryDBField,
+		LoginHistoryMethodDBField,
+		LoginHistoryCreatedAtDBField,
+		LoginHistoryLastSeenAtDBField,
+		LoginHistoryExpiresAtDBField).
+		From(LoginHistoryTableName).
+		Where(squirrel.Eq{LoginHistoryUserIDDBField: jwtContents.UUID, LoginHistoryRevokedAtDBField: nil}).
+		Where(squirrel.Gt{LoginHistoryExpiresAtDBField: time.Now()}).
+		OrderBy(LoginHistoryLastSeenAtDBField + " DESC"))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	sessions := make([]UserSession, 0)
+	for rows.Next() {
+		var session UserSession
+		if err = rows.Scan(&session.ID, &session.Browser, &session.OS, &session.DeviceType, &session.IP, &session.Country, &session.Method, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		session.Current = session.ID == jwtContents.SessionID
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserSessionsResponse{Sessions: sessions}, http.StatusOK)
+}
+
+// UserSessionRevokeHandler revokes a session of the user.
+//
+//	@Summary					Revoke session
+//	@Description				Logs the device of the session out. Revoking the current session logs the caller out.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Param						id				path		string						true	"Session id"
+//	@Success					200				{object}	UserSessionRevokeResponse	"Session is revoked"
+//	@Failure					403				{object}	ErrorResponse				"Impersonation token"
+//	@Failure					404				{object}	ErrorResponse				"Session does not exist or is already revoked"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/sessions/{id} [delete]
+func UserSessionRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if _, err = uuid.Parse(id); err != nil {
+		s.LogError(SessionDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	res, err := s.ExecuteSQL(s.StmtBuilder.Update(LoginHistoryTableName).
+		Set(LoginHistoryRevokedAtDBField, time.Now()).
+		Where(squirrel.Eq{LoginHistoryIDDBField: id, LoginHistoryUserIDDBField: jwtContents.UUID, LoginHistoryRevokedAtDBField: nil}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(SessionDoesNotExistError, http.StatusNotFound)
+		return
+	}
+	s.WriteResponse(UserSessionRevokeResponse{Success: true}, http.StatusOK)
+}
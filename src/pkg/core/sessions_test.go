@@ -0,0 +1,81 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	cases := map[string]UserAgent{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36":                         {Browser: "Chrome", OS: "Windows", DeviceType: "desktop"},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91":       {Browser: "Edge", OS: "Windows", DeviceType: "desktop"},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15":                      {Browser: "Safari", OS: "macOS", DeviceType: "desktop"},
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                                  {Browser: "Firefox", OS: "Linux", DeviceType: "desktop"},
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1": {Browser: "Safari", OS: "iOS", DeviceType: "mobile"},
+		"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1":  {Browser: "Chrome", OS: "iOS", DeviceType: "tablet"},
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36":              {Browser: "Chrome", OS: "Android", DeviceType: "mobile"},
+		"Mozilla/5.0 (Linux; Android 13; SM-X200) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36":      {Browser: "Samsung Internet", OS: "Android", DeviceType: "tablet"},
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                                {Browser: "Bot", OS: "Other", DeviceType: "bot"},
+		"curl/8.4.0": {Browser: "curl", OS: "Other", DeviceType: "desktop"},
+		"":           {Browser: "Other", OS: "Other", DeviceType: "desktop"},
+	}
+	for ua, expected := range cases {
+		assert.Equal(t, expected, ParseUserAgent(ua), ua)
+	}
+	// browser updates are the same device, the same browser in another subnet is not.
+	firefox := ParseUserAgent("Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0")
+	ip := net.ParseIP("203.0.113.7")
+	assert.Equal(t, firefox.DeviceHash(ip), ParseUserAgent("Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0").DeviceHash(ip))
+	assert.Equal(t, firefox.DeviceHash(ip), firefox.DeviceHash(net.ParseIP("203.0.113.200")))
+	assert.NotEqual(t, firefox.DeviceHash(ip), firefox.DeviceHash(net.ParseIP("198.51.100.7")))
+}
+
+func TestDeviceCookie(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/user/login", nil)
+	_, ok := deviceCookieHash(r)
+	assert.False(t, ok)
+	w := httptest.NewRecorder()
+	deviceHash, err := SetDeviceCookie(w)
+	assert.Nil(t, err)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, deviceCookieName, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	r.AddCookie(cookies[0])
+	cookieHash, ok := deviceCookieHash(r)
+	assert.True(t, ok)
+	assert.Equal(t, deviceHash, cookieHash)
+	// ids that are not issued by SetDeviceCookie are ignored if they are too long.
+	r = httptest.NewRequest("POST", "/api/user/login", nil)
+	r.AddCookie(&http.Cookie{Name: deviceCookieName, Value: strings.Repeat("a", deviceIDMaxLength+1)})
+	_, ok = deviceCookieHash(r)
+	assert.False(t, ok)
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:54321"
+	assert.Equal(t, "203.0.113.7", ClientIP(r).String())
+	// middleware.RealIP sets RemoteAddr without a port.
+	r.RemoteAddr = "2001:db8::1"
+	assert.Equal(t, "2001:db8::1", ClientIP(r).String())
+	r.RemoteAddr = ""
+	assert.Nil(t, ClientIP(r))
+}
+
+func TestRequestCountry(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.Equal(t, "", RequestCountry(r))
+	r.Header.Set("CF-IPCountry", "tr")
+	assert.Equal(t, "TR", RequestCountry(r))
+	r.Header.Set("CF-IPCountry", "XX")
+	assert.Equal(t, "", RequestCountry(r))
+	t.Setenv("COUNTRY_HEADER", "X-Country")
+	r.Header.Set("X-Country", "DE")
+	assert.Equal(t, "DE", RequestCountry(r))
+}
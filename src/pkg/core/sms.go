@@ -0,0 +1,114 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// SMSSender sends plain text SMS messages. Handlers should never talk to an SMS gateway directly, use the SMSSender
+// assigned to the Server by AssignSMSSender instead, so tests can swap it with a MemorySMSSender.
+type SMSSender interface {
+	Send(to string, body string) error
+}
+
+// HTTPSMSSender posts the messages as {"to": ..., "body": ...} JSON to an SMS gateway, with the token as a bearer token.
+type HTTPSMSSender struct {
+	URL        string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func (m HTTPSMSSender) Send(to string, body string) error {
+	payload, err := json.Marshal(map[string]string{"to": to, "body": body})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.Token))
+	}
+	client := m.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sms gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// LogSMSSender does not send anything, it prints the message to the standard logger. Used in development.
+type LogSMSSender struct{}
+
+func (m LogSMSSender) Send(to string, body string) error {
+	log.Printf("sms to %s\n%s", to, body)
+	return nil
+}
+
+// MemorySMSSender keeps the sent messages in memory, so tests can read the codes that are sent.
+type MemorySMSSender struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func NewMemorySMSSender() *MemorySMSSender {
+	return &MemorySMSSender{messages: make(map[string][]string)}
+}
+
+func (m *MemorySMSSender) Send(to string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[to] = append(m.messages[to], body)
+	return nil
+}
+
+// Last returns the last message sent to the number, and false if nothing is sent.
+func (m *MemorySMSSender) Last(to string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	messages := m.messages[to]
+	if len(messages) == 0 {
+		return "", false
+	}
+	return messages[len(messages)-1], true
+}
+
+// memorySMSSender is shared by every handler when SMS_SENDER is memory, tests read the sent codes from it.
+var memorySMSSender = NewMemorySMSSender()
+
+// NewSMSSender returns the SMSSender selected by SMS_SENDER in the environment (or in the .env file):
+//
+//	http: HTTPSMSSender with SMS_GATEWAY_URL and SMS_GATEWAY_TOKEN, default if SMS_GATEWAY_URL is set
+//	memory: a MemorySMSSender shared by every handler
+//	log: LogSMSSender, default otherwise
+func NewSMSSender() SMSSender {
+	url := os.Getenv("SMS_GATEWAY_URL")
+	switch os.Getenv("SMS_SENDER") {
+	case "memory":
+		return memorySMSSender
+	case "log":
+		return LogSMSSender{}
+	}
+	if url == "" {
+		return LogSMSSender{}
+	}
+	return HTTPSMSSender{URL: url, Token: os.Getenv("SMS_GATEWAY_TOKEN")}
+}
@@ -0,0 +1,803 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/exp/slices"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Users are scored for spam after the signup, and after every review and reply they write. Reviews and replies are
+// created by another service, ScoreNewContent scores them here. Every check is kept in spam_checks with its score and
+// the reasons; the score of the user is the highest score of the checks of the last spamScoreWindow, and
+// users.possible_spammer is set once it reaches spamScoreThreshold. Moderators can override the flag either way,
+// trusted users and the privilegeBypassRoles are never flagged automatically.
+
+const (
+	SpamCheckTableName        = "spam_checks"
+	SpamCheckIDDBField        = "id"
+	SpamCheckUserIDDBField    = "user_id"
+	SpamCheckSourceDBField    = "source"
+	SpamCheckSourceIDDBField  = "source_id"
+	SpamCheckScoreDBField     = "score"
+	SpamCheckReasonsDBField   = "reasons"
+	SpamCheckCreatedAtDBField = "created_at"
+)
+
+// sources of the spam checks, source_id is the id of the review or the reply.
+const (
+	spamSourceSignup = "signup"
+	spamSourceReview = "review"
+	spamSourceReply  = "reply"
+)
+
+// reasons of the spam checks, the score of a check is the sum of the weights of its reasons.
+const (
+	spamReasonDisposableEmail   = "disposable_email_domain"
+	spamReasonNewEmailDomain    = "new_email_domain"
+	spamReasonIPVelocity        = "signup_velocity_ip"
+	spamReasonSubnetVelocity    = "signup_velocity_subnet"
+	spamReasonDuplicateText     = "duplicate_text"
+	spamReasonNearDuplicateText = "near_duplicate_text"
+	spamReasonLinkDensity       = "link_density"
+)
+
+var spamReasonWeights = map[string]int{
+	spamReasonDisposableEmail:   40,
+	spamReasonNewEmailDomain:    10,
+	spamReasonIPVelocity:        30,
+	spamReasonSubnetVelocity:    20,
+	spamReasonDuplicateText:     40,
+	spamReasonNearDuplicateText: 25,
+	spamReasonLinkDensity:       30,
+}
+
+const (
+	spamScoreThreshold = 50
+	spamScoreMax       = 100
+	// spamScoreWindow is the time a check counts for the score of the user.
+	spamScoreWindow = 30 * 24 * time.Hour
+	// more than spamIPSignups other signups from the IP in the last hour, or spamSubnetSignups from its subnet in the
+	// last day, are suspicious. Subnets are /24 for IPv4 and /48 for IPv6.
+	spamIPSignups           = 3
+	spamSubnetSignups       = 10
+	spamIPv4SubnetBits      = 24
+	spamIPv6SubnetBits      = 48
+	spamSubnetSignupsWindow = 24 * time.Hour
+	// texts are compared to the reviews and the replies of the last spamTextWindow, at most spamTextCandidates of
+	// each. Texts shorter than spamMinDuplicateWords words are not compared, short reviews are alike.
+	spamTextWindow        = 7 * 24 * time.Hour
+	spamTextCandidates    = 500
+	spamMinDuplicateWords = 8
+	// spamNearDuplicateSimilarity is the Jaccard similarity of the word trigrams of near duplicate texts.
+	spamNearDuplicateSimilarity = 0.8
+	// texts with more than spamMaxLinks links, or a link per less than spamWordsPerLink words, have a high link density.
+	spamMaxLinks     = 2
+	spamWordsPerLink = 10
+	// spamContentBatchSize is the count of reviews and of replies scored in one run of ScoreNewContent.
+	spamContentBatchSize = 100
+	spamQueueLimit       = 100
+	spamChecksLimit      = 50
+)
+
+// defaultDisposableEmailDomains are the most common disposable email providers, DISPOSABLE_EMAIL_DOMAINS adds more.
+var defaultDisposableEmailDomains = []string{
+	"10minutemail.com",
+	"discard.email",
+	"dispostable.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"guerrillamail.net",
+	"mailinator.com",
+	"maildrop.cc",
+	"mintemail.com",
+	"mohmal.com",
+	"sharklasers.com",
+	"temp-mail.org",
+	"tempmail.com",
+	"throwawaymail.com",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+var (
+	disposableEmailDomainsOnce sync.Once
+	disposableEmailDomains     map[string]bool
+)
+
+// DisposableEmailDomains returns the defaultDisposableEmailDomains and the domains listed one per line in the file at
+// DISPOSABLE_EMAIL_DOMAINS in the environment (or in the .env file), it is read once.
+func DisposableEmailDomains() map[string]bool {
+	disposableEmailDomainsOnce.Do(func() {
+		disposableEmailDomains = make(map[string]bool, len(defaultDisposableEmailDomains))
+		for _, domain := range defaultDisposableEmailDomains {
+			disposableEmailDomains[domain] = true
+		}
+		path := os.Getenv("DISPOSABLE_EMAIL_DOMAINS")
+		if path == "" {
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("could not open the disposable email domains: %v", err)
+			return
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if domain := strings.ToLower(strings.TrimSpace(scanner.Text())); domain != "" && !strings.HasPrefix(domain, "#") {
+				disposableEmailDomains[domain] = true
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			log.Printf("could not read the disposable email domains: %v", err)
+		}
+	})
+	return disposableEmailDomains
+}
+
+// EmailDomain returns the lowercase domain of the email.
+func EmailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// IsDisposableEmailDomain reports whether the domain, or a domain it is a subdomain of, is disposable.
+func IsDisposableEmailDomain(domain string) bool {
+	domains := DisposableEmailDomains()
+	for {
+		if domains[domain] {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+var spamLinkRegex = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// HasHighLinkDensity reports whether the text has more than spamMaxLinks links, or a link per less than
+// spamWordsPerLink words.
+func HasHighLinkDensity(text string) bool {
+	links := len(spamLinkRegex.FindAllStringIndex(text, -1))
+	if links == 0 {
+		return false
+	}
+	return links > spamMaxLinks || len(strings.Fields(text)) < links*spamWordsPerLink
+}
+
+// spamTextWords returns the lowercase words of the text, punctuation and links are separators.
+func spamTextWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func spamTextShingles(words []string) map[string]bool {
+	shingles := make(map[string]bool)
+	if len(words) > 0 && len(words) < 3 {
+		shingles[strings.Join(words, " ")] = true
+		return shingles
+	}
+	for i := 0; i+3 <= len(words); i++ {
+		shingles[strings.Join(words[i:i+3], " ")] = true
+	}
+	return shingles
+}
+
+// TextSimilarity returns the Jaccard similarity of the word trigrams of the texts, from 0 for unrelated texts to 1 for
+// texts with the same words in the same order, regardless of the case and the punctuation.
+func TextSimilarity(a string, b string) float64 {
+	shinglesA, shinglesB := spamTextShingles(spamTextWords(a)), spamTextShingles(spamTextWords(b))
+	common := 0
+	for shingle := range shinglesA {
+		if shinglesB[shingle] {
+			common++
+		}
+	}
+	union := len(shinglesA) + len(shinglesB) - common
+	if union == 0 {
+		return 0
+	}
+	return float64(common) / float64(union)
+}
+
+// DuplicateTextReason returns spamReasonDuplicateText if one of the candidates has the same words as the text,
+// spamReasonNearDuplicateText if one is a near duplicate of it, and "" otherwise.
+func DuplicateTextReason(text string, candidates []string) string {
+	words := spamTextWords(text)
+	if len(words) < spamMinDuplicateWords {
+		return ""
+	}
+	normalized := strings.Join(words, " ")
+	reason := ""
+	for _, candidate := range candidates {
+		if strings.Join(spamTextWords(candidate), " ") == normalized {
+			return spamReasonDuplicateText
+		}
+		if reason == "" && TextSimilarity(text, candidate) >= spamNearDuplicateSimilarity {
+			reason = spamReasonNearDuplicateText
+		}
+	}
+	return reason
+}
+
+// SpamScore returns the score of the reasons, at most spamScoreMax.
+func SpamScore(reasons []string) int {
+	score := 0
+	for _, reason := range reasons {
+		score += spamReasonWeights[reason]
+	}
+	if score > spamScoreMax {
+		return spamScoreMax
+	}
+	return score
+}
+
+// IsSpamFlagged returns the possible_spammer flag of a user: the override of the moderators if any, otherwise whether
+// the score reaches spamScoreThreshold. Trusted users and the privilegeBypassRoles are not flagged automatically.
+func IsSpamFlagged(score int, override *bool, role string, reputation int64) bool {
+	if override != nil {
+		return *override
+	}
+	if slices.Contains(privilegeBypassRoles, strings.ToUpper(role)) || HasReputationPrivilege(reputation, privilegeTrusted) {
+		return false
+	}
+	return score >= spamScoreThreshold
+}
+
+// spamSubnet returns the subnet signups are counted in for the IP.
+func spamSubnet(ip net.IP) string {
+	addr, _ := netip.AddrFromSlice(ip)
+	addr = addr.Unmap()
+	bits := spamIPv6SubnetBits
+	if addr.Is4() {
+		bits = spamIPv4SubnetBits
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// signupSpamReasons returns the reasons of the signup of the user.
+func signupSpamReasons(ctx context.Context, db *pgxpool.Pool, stmtBuilder squirrel.StatementBuilderType, uid string) ([]string, error) {
+	sql, args, err := stmtBuilder.Select(UserEmailDBField, UserSignupIPDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var email string
+	var ip net.IP
+	if err = db.QueryRow(ctx, sql, args...).Scan(&email, &ip); err == pgx.ErrNoRows {
+		return nil, UserDoesNotExistError
+	} else if err != nil {
+		return nil, err
+	}
+	var reasons []string
+	domain := EmailDomain(email)
+	if IsDisposableEmailDomain(domain) {
+		reasons = append(reasons, spamReasonDisposableEmail)
+	} else {
+		sql, args, err = stmtBuilder.Select("COUNT(*)").
+			From(UserTableName).
+			Where(squirrel.NotEq{UserIDDBField: uid}).
+			Where(squirrel.Expr(fmt.Sprintf("LOWER(split_part(%s, '@', 2)) = ?", UserEmailDBField), domain)).
+			ToSql()
+		if err != nil {
+			return nil, err
+		}
+		var domainUsers int
+		if err = db.QueryRow(ctx, sql, args...).Scan(&domainUsers); err != nil {
+			return nil, err
+		}
+		if domainUsers == 0 {
+			reasons = append(reasons, spamReasonNewEmailDomain)
+		}
+	}
+	if ip == nil {
+		return reasons, nil
+	}
+	now := time.Now()
+	sql, args, err = stmtBuilder.Select().
+		Column(fmt.Sprintf("COUNT(*) FILTER (WHERE %s = ? AND %s > ?)", UserSignupIPDBField, UserCreatedAtDBField), ip.String(), now.Add(-time.Hour)).
+		Column("COUNT(*)").
+		From(UserTableName).
+		Where(squirrel.NotEq{UserIDDBField: uid}).
+		Where(squirrel.Gt{UserCreatedAtDBField: now.Add(-spamSubnetSignupsWindow)}).
+		Where(squirrel.Expr(fmt.Sprintf("%s <<= ?::cidr", UserSignupIPDBField), spamSubnet(ip))).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var ipSignups, subnetSignups int
+	if err = db.QueryRow(ctx, sql, args...).Scan(&ipSignups, &subnetSignups); err != nil {
+		return nil, err
+	}
+	if ipSignups > spamIPSignups {
+		reasons = append(reasons, spamReasonIPVelocity)
+	}
+	if subnetSignups > spamSubnetSignups {
+		reasons = append(reasons, spamReasonSubnetVelocity)
+	}
+	return reasons, nil
+}
+
+// recentTexts returns the texts of the reviews and the replies of the last spamTextWindow, except the source.
+func recentTexts(ctx context.Context, db *pgxpool.Pool, stmtBuilder squirrel.StatementBuilderType, sourceID string) ([]string, error) {
+	since := time.Now().Add(-spamTextWindow)
+	queries := []squirrel.SelectBuilder{
+		stmtBuilder.Select(fmt.Sprintf("%s || ' ' || %s", ReviewTitleDBField, ReviewTextDBField)).
+			From(ReviewsTable).
+			Where(squirrel.NotEq{ReviewIDDBField: sourceID}).
+			Where(squirrel.Gt{ReviewCreatedAtDBField: since}).
+			OrderBy(fmt.Sprintf("%s DESC", ReviewCreatedAtDBField)).
+			Limit(spamTextCandidates),
+		stmtBuilder.Select(ReviewReplyTextDBField).
+			From(ReviewReplyTable).
+			Where(squirrel.NotEq{ReviewReplyIDDBField: sourceID}).
+			Where(squirrel.Gt{ReviewReplyCreatedAtDBField: since}).
+			OrderBy(fmt.Sprintf("%s DESC", ReviewReplyCreatedAtDBField)).
+			Limit(spamTextCandidates),
+	}
+	var texts []string
+	for _, query := range queries {
+		sql, args, err := query.ToSql()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := db.Query(ctx, sql, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var text string
+			if err = rows.Scan(&text); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			texts = append(texts, text)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return texts, nil
+}
+
+// SpamCheck is a check of a user, Source is signup, review or reply.
+//
+// swagger:model SpamCheck
+type SpamCheck struct {
+	ID        int64     `json:"id"`
+	Source    string    `json:"source"`
+	SourceID  *string   `json:"sourceId"`
+	Score     int       `json:"score"`
+	Reasons   []string  `json:"reasons"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// updateSpamFlag sets the score of the user to the highest score of the checks of the last spamScoreWindow, and
+// possible_spammer with IsSpamFlagged.
+func updateSpamFlag(ctx context.Context, tx pgx.Tx, stmtBuilder squirrel.StatementBuilderType, uid string) error {
+	sql, args, err := stmtBuilder.Select(UserRoleDBField, UserReputationDBField, UserSpamOverrideDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		Suffix("FOR UPDATE").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	var role string
+	var reputation int64
+	var override *bool
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&role, &reputation, &override); err == pgx.ErrNoRows {
+		return UserDoesNotExistError
+	} else if err != nil {
+		return err
+	}
+	sql, args, err = stmtBuilder.Select(SpamCheckScoreDBField, SpamCheckReasonsDBField).
+		From(SpamCheckTableName).
+		Where(squirrel.Eq{SpamCheckUserIDDBField: uid}).
+		Where(squirrel.Gt{SpamCheckCreatedAtDBField: time.Now().Add(-spamScoreWindow)}).
+		OrderBy(fmt.Sprintf("%s DESC", SpamCheckScoreDBField), fmt.Sprintf("%s DESC", SpamCheckCreatedAtDBField)).
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	score, reasons := 0, []string{}
+	if err = tx.QueryRow(ctx, sql, args...).Scan(&score, &reasons); err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+	sql, args, err = stmtBuilder.Update(UserTableName).
+		Set(UserSpamScoreDBField, score).
+		Set(UserSpamReasonsDBField, reasons).
+		Set(UserSpamScoredAtDBField, time.Now()).
+		Set(UserPossibleSpammerDBField, IsSpamFlagged(score, override, role, reputation)).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, sql, args...)
+	return err
+}
+
+func recordSpamCheck(ctx context.Context, db *pgxpool.Pool, stmtBuilder squirrel.StatementBuilderType, uid string, source string, sourceID *string, reasons []string) error {
+	if reasons == nil {
+		reasons = []string{}
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	sql, args, err := stmtBuilder.Insert(SpamCheckTableName).
+		Columns(SpamCheckUserIDDBField, SpamCheckSourceDBField, SpamCheckSourceIDDBField, SpamCheckScoreDBField, SpamCheckReasonsDBField).
+		Values(uid, source, sourceID, SpamScore(reasons), reasons).
+		ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return err
+	}
+	if err = updateSpamFlag(ctx, tx, stmtBuilder, uid); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// CheckSignupSpam scores the signup of the user, UserSignupHandler calls it once the user is created.
+func CheckSignupSpam(db *pgxpool.Pool, uid string) error {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reasons, err := signupSpamReasons(to, db, stmtBuilder, uid)
+	if err != nil {
+		return err
+	}
+	return recordSpamCheck(to, db, stmtBuilder, uid, spamSourceSignup, nil, reasons)
+}
+
+// CheckContentSpam scores the review or the reply of the user, source is spamSourceReview or spamSourceReply.
+// ScoreNewContent calls it for the reviews and the replies that are not scored yet.
+func CheckContentSpam(db *pgxpool.Pool, uid string, source string, sourceID string, text string) error {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var reasons []string
+	candidates, err := recentTexts(to, db, stmtBuilder, sourceID)
+	if err != nil {
+		return err
+	}
+	if reason := DuplicateTextReason(text, candidates); reason != "" {
+		reasons = append(reasons, reason)
+	}
+	if HasHighLinkDensity(text) {
+		reasons = append(reasons, spamReasonLinkDensity)
+	}
+	return recordSpamCheck(to, db, stmtBuilder, uid, source, &sourceID, reasons)
+}
+
+// ScoreNewContent scores the reviews and the replies of the last day that are not scored yet, whoever created them.
+// It is scheduled in main.go.
+func ScoreNewContent(db *pgxpool.Pool) {
+	stmtBuilder := squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
+	to, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	unscored := func(table string, idField string) string {
+		return fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.%s = %s.%s)", SpamCheckTableName, SpamCheckTableName, SpamCheckSourceIDDBField, table, idField)
+	}
+	since := time.Now().Add(-24 * time.Hour)
+	queries := []struct {
+		source string
+		query  squirrel.SelectBuilder
+	}{
+		{spamSourceReview, stmtBuilder.Select(ReviewIDDBField, ReviewUserIDDBField, fmt.Sprintf("%s || ' ' || %s", ReviewTitleDBField, ReviewTextDBField)).
+			From(ReviewsTable).
+			Where(squirrel.Gt{ReviewCreatedAtDBField: since}).
+			Where(unscored(ReviewsTable, ReviewIDDBField)).
+			OrderBy(ReviewCreatedAtDBField).
+			Limit(spamContentBatchSize)},
+		{spamSourceReply, stmtBuilder.Select(ReviewReplyIDDBField, ReviewReplyUserIDDBField, ReviewReplyTextDBField).
+			From(ReviewReplyTable).
+			Where(squirrel.Gt{ReviewReplyCreatedAtDBField: since}).
+			Where(unscored(ReviewReplyTable, ReviewReplyIDDBField)).
+			OrderBy(ReviewReplyCreatedAtDBField).
+			Limit(spamContentBatchSize)},
+	}
+	type content struct{ id, uid, text string }
+	for _, q := range queries {
+		sql, args, err := q.query.ToSql()
+		if err != nil {
+			log.Printf("error scoring new %ss: %v", q.source, err)
+			continue
+		}
+		rows, err := db.Query(to, sql, args...)
+		if err != nil {
+			log.Printf("error scoring new %ss: %v", q.source, err)
+			continue
+		}
+		var contents []content
+		for rows.Next() {
+			var c content
+			if err = rows.Scan(&c.id, &c.uid, &c.text); err != nil {
+				break
+			}
+			contents = append(contents, c)
+		}
+		rows.Close()
+		if err != nil {
+			log.Printf("error scoring new %ss: %v", q.source, err)
+			continue
+		}
+		for _, c := range contents {
+			if err = CheckContentSpam(db, c.uid, q.source, c.id, c.text); err != nil {
+				log.Printf("error scoring %s %s: %v", q.source, c.id, err)
+			}
+		}
+	}
+}
+
+// UserSpamStatus is the spam score of a user. Override is the flag set by a moderator, null if the flag is automatic.
+//
+// swagger:model UserSpamStatus
+type UserSpamStatus struct {
+	UserID          string     `json:"userId"`
+	Username        string     `json:"username"`
+	Score           int        `json:"score"`
+	Reasons         []string   `json:"reasons"`
+	PossibleSpammer bool       `json:"possibleSpammer"`
+	Override        *bool      `json:"override"`
+	OverrideBy      *string    `json:"overrideBy"`
+	ScoredAt        *time.Time `json:"scoredAt"`
+}
+
+var userSpamStatusColumns = []string{
+	UserIDDBField,
+	UserUsernameDBField,
+	UserSpamScoreDBField,
+	UserSpamReasonsDBField,
+	UserPossibleSpammerDBField,
+	UserSpamOverrideDBField,
+	UserSpamOverrideByDBField,
+	UserSpamScoredAtDBField,
+}
+
+func scanUserSpamStatus(rows pgx.Rows) (UserSpamStatus, error) {
+	var status UserSpamStatus
+	err := rows.Scan(&status.UserID, &status.Username, &status.Score, &status.Reasons, &status.PossibleSpammer, &status.Override, &status.OverrideBy, &status.ScoredAt)
+	return status, err
+}
+
+// SpamQueueResponse is the flagged users, the highest scores first.
+//
+// swagger:model SpamQueueResponse
+type SpamQueueResponse struct {
+	Users []UserSpamStatus `json:"users"`
+}
+
+// ModeratorSpamQueueHandler returns the users flagged as possible spammers.
+//
+//	@Summary					Get Spam Queue
+//	@Description				Returns the 100 flagged users with the highest spam scores, for the moderators to review.
+//	@Tags						Moderation
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Success					200				{object}	SpamQueueResponse	"Flagged users"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/moderation/spam [get]
+func ModeratorSpamQueueHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(userSpamStatusColumns...).
+		From(UserTableName).
+		Where(squirrel.Eq{UserPossibleSpammerDBField: true}).
+		OrderBy(fmt.Sprintf("%s DESC", UserSpamScoreDBField), fmt.Sprintf("%s DESC", UserSpamScoredAtDBField)).
+		Limit(spamQueueLimit))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	response := SpamQueueResponse{Users: make([]UserSpamStatus, 0)}
+	for rows.Next() {
+		status, err := scanUserSpamStatus(rows)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		response.Users = append(response.Users, status)
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
+
+// UserSpamResponse is the spam score of a user with the latest checks.
+//
+// swagger:model UserSpamResponse
+type UserSpamResponse struct {
+	UserSpamStatus
+	Checks []SpamCheck `json:"checks"`
+}
+
+func (s Server) getUserSpam(uid string) (UserSpamResponse, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(userSpamStatusColumns...).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return UserSpamResponse{}, err
+	}
+	var response UserSpamResponse
+	if rows.Next() {
+		response.UserSpamStatus, err = scanUserSpamStatus(rows)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		return UserSpamResponse{}, err
+	}
+	rows, err = s.QuerySQL(s.StmtBuilder.Select(
+		SpamCheckIDDBField,
+		SpamCheckSourceDBField,
+		SpamCheckSourceIDDBField,
+		SpamCheckScoreDBField,
+		SpamCheckReasonsDBField,
+		SpamCheckCreatedAtDBField).
+		From(SpamCheckTableName).
+		Where(squirrel.Eq{SpamCheckUserIDDBField: uid}).
+		OrderBy(fmt.Sprintf("%s DESC", SpamCheckCreatedAtDBField)).
+		Limit(spamChecksLimit))
+	if err != nil {
+		return UserSpamResponse{}, err
+	}
+	defer rows.Close()
+	response.Checks = make([]SpamCheck, 0)
+	for rows.Next() {
+		var check SpamCheck
+		if err = rows.Scan(&check.ID, &check.Source, &check.SourceID, &check.Score, &check.Reasons, &check.CreatedAt); err != nil {
+			return UserSpamResponse{}, err
+		}
+		response.Checks = append(response.Checks, check)
+	}
+	return response, rows.Err()
+}
+
+// ModeratorUserSpamHandler returns the spam score of a user.
+//
+//	@Summary					Get Spam Score
+//	@Description				Returns the spam score of the user, the reasons, the override of the moderators and the latest 50 checks.
+//	@Tags						Moderation
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						id				path		string				true	"User ID"
+//	@Success					200				{object}	UserSpamResponse	"Spam score"
+//	@Failure					404				{object}	ErrorResponse		"User does not exist"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/moderation/users/{id}/spam [get]
+func ModeratorUserSpamHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	response, err := s.getUserSpam(uid)
+	if err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
+
+// ModeratorSpamOverrideRequest sets the possible_spammer flag of a user, null returns it to the automatic scoring.
+//
+// swagger:model ModeratorSpamOverrideRequest
+type ModeratorSpamOverrideRequest struct {
+	PossibleSpammer *bool `json:"possibleSpammer"`
+}
+
+// ModeratorSpamOverrideHandler overrides the possible_spammer flag of a user.
+//
+//	@Summary					Override Spam Flag
+//	@Description				Sets the possible_spammer flag of the user regardless of the score, or returns it to the automatic scoring if possibleSpammer is null.
+//	@Tags						Moderation
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens of ADMIN and MODERATOR users.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						id				path		string							true	"User ID"
+//	@Param						body			body		ModeratorSpamOverrideRequest	true	"Flag"
+//	@Success					200				{object}	UserSpamResponse				"Spam score"
+//	@Failure					400				{object}	ErrorResponse					"Invalid request"
+//	@Failure					404				{object}	ErrorResponse					"User does not exist"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/moderation/users/{id}/spam [put]
+func ModeratorSpamOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	uid, err := moderatedUserID(r)
+	if err != nil {
+		s.LogError(err, http.StatusNotFound)
+		return
+	}
+	var req ModeratorSpamOverrideRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	update := s.StmtBuilder.Update(UserTableName).
+		Set(UserSpamOverrideDBField, req.PossibleSpammer).
+		Where(squirrel.Eq{UserIDDBField: uid})
+	if req.PossibleSpammer == nil {
+		update = update.Set(UserSpamOverrideByDBField, nil).Set(UserSpamOverrideAtDBField, nil)
+	} else {
+		update = update.Set(UserSpamOverrideByDBField, jwtContents.UUID).Set(UserSpamOverrideAtDBField, time.Now())
+	}
+	sql, args, err := update.ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = updateSpamFlag(to, tx, s.StmtBuilder, uid); err == UserDoesNotExistError {
+		s.LogError(err, http.StatusNotFound)
+		return
+	} else if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	response, err := s.getUserSpam(uid)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestIsDisposableEmailDomain(t *testing.T) {
+	assert.Equal(t, "mailinator.com", EmailDomain("Zort@MAILINATOR.com"))
+	assert.True(t, IsDisposableEmailDomain("mailinator.com"))
+	assert.True(t, IsDisposableEmailDomain("eu.mailinator.com"))
+	assert.False(t, IsDisposableEmailDomain("hotmail.com"))
+	assert.False(t, IsDisposableEmailDomain("notmailinator.com"))
+}
+
+func TestHasHighLinkDensity(t *testing.T) {
+	assert.False(t, HasHighLinkDensity("Great kebab, the ayran was cold and the staff were friendly."))
+	assert.False(t, HasHighLinkDensity("Great kebab, the menu is at https://example.com if you want to check the prices first."))
+	assert.True(t, HasHighLinkDensity("cheap pills https://example.com"))
+	assert.True(t, HasHighLinkDensity(
+		"a long review about the food with many words in it, the links are www.a.com www.b.com and http://c.com too, "+
+			"which is more than two links for a single review so it is spam regardless of the length of it"))
+}
+
+func TestDuplicateTextReason(t *testing.T) {
+	text := "The lahmacun was crispy and the service was fast, we will come back next week."
+	assert.Equal(t, spamReasonDuplicateText, DuplicateTextReason(text, []string{"unrelated", "the LAHMACUN was crispy, and the service was fast! We will come back next week"}))
+	assert.Equal(t, spamReasonNearDuplicateText, DuplicateTextReason(text, []string{"The lahmacun was crispy and the service was fast, we will come back next week. Again."}))
+	assert.Equal(t, "", DuplicateTextReason(text, []string{"The pide was soggy and the waiter forgot our order, we will not come back."}))
+	// short texts are alike, they are not compared.
+	assert.Equal(t, "", DuplicateTextReason("Great place!", []string{"great place"}))
+	assert.Equal(t, 1.0, TextSimilarity("a b c d", "A, b. C d!"))
+	assert.Equal(t, 0.0, TextSimilarity("", ""))
+}
+
+func TestSpamScore(t *testing.T) {
+	assert.Equal(t, 0, SpamScore(nil))
+	assert.Equal(t, 70, SpamScore([]string{spamReasonDisposableEmail, spamReasonIPVelocity}))
+	assert.Equal(t, spamScoreMax, SpamScore([]string{spamReasonDisposableEmail, spamReasonIPVelocity, spamReasonDuplicateText, spamReasonLinkDensity}))
+}
+
+func TestIsSpamFlagged(t *testing.T) {
+	yes, no := true, false
+	assert.True(t, IsSpamFlagged(spamScoreThreshold, nil, roleUser, 0))
+	assert.False(t, IsSpamFlagged(spamScoreThreshold-1, nil, roleUser, 0))
+	assert.False(t, IsSpamFlagged(spamScoreMax, nil, roleModerator, 0))
+	assert.False(t, IsSpamFlagged(spamScoreMax, nil, "moderator", 0))
+	assert.False(t, IsSpamFlagged(spamScoreMax, nil, roleUser, 1000))
+	assert.False(t, IsSpamFlagged(spamScoreMax, &no, roleUser, 0))
+	assert.True(t, IsSpamFlagged(0, &yes, roleModerator, 0))
+}
+
+func TestSpamSubnet(t *testing.T) {
+	assert.Equal(t, "192.168.1.0/24", spamSubnet(net.ParseIP("192.168.1.42")))
+	assert.Equal(t, "2001:db8:1::/48", spamSubnet(net.ParseIP("2001:db8:1:2::1")))
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectStorage stores the files uploaded by users, like avatars. Handlers should never write to the disk or to a
+// bucket directly, use the ObjectStorage assigned to the Server by AssignStorage instead.
+type ObjectStorage interface {
+	// Put stores the object under the key, replacing it if it exists.
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	// Delete removes the object, removing an object that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL of the object.
+	URL(key string) string
+}
+
+// localStoragePath is the path LocalStorage objects are served at, see HandlerFunc.
+const localStoragePath = "/api/files"
+
+// LocalStorage stores the objects as files in Dir, they are served by the server at BaseURL.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func (l LocalStorage) path(key string) (string, error) {
+	path := filepath.Join(l.Dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(l.Dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return path, nil
+}
+
+func (l LocalStorage) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// written to a temporary file first, so a half written object is never served.
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (l LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l LocalStorage) URL(key string) string {
+	return l.BaseURL + "/" + key
+}
+
+// ServeHTTP serves the stored files, directories are not listed.
+func (l LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServer(http.Dir(l.Dir)).ServeHTTP(w, r)
+}
+
+// S3Storage stores the objects in a bucket of an S3 compatible service (AWS S3, MinIO...), requests are signed with
+// AWS Signature Version 4. Buckets are addressed path style, Endpoint/Bucket/key, which every S3 compatible service
+// supports. The objects must be publicly readable, with a bucket policy, or served by a CDN at PublicURL.
+type S3Storage struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is the base URL of the objects, defaults to Endpoint/Bucket.
+	PublicURL  string
+	HTTPClient *http.Client
+}
+
+func (s3 S3Storage) objectURL(key string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(s3.Endpoint, "/"), s3.Bucket, key)
+}
+
+func (s3 S3Storage) do(ctx context.Context, method string, key string, contentType string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, s3.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s3.Sign(req, data, time.Now())
+	client := s3.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("object storage returned %s for %s %s", resp.Status, method, key)
+	}
+	return nil
+}
+
+func (s3 S3Storage) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	return s3.do(ctx, http.MethodPut, key, contentType, data)
+}
+
+func (s3 S3Storage) Delete(ctx context.Context, key string) error {
+	// S3 returns 204 for the keys that do not exist too.
+	return s3.do(ctx, http.MethodDelete, key, "", nil)
+}
+
+func (s3 S3Storage) URL(key string) string {
+	if s3.PublicURL != "" {
+		return strings.TrimRight(s3.PublicURL, "/") + "/" + key
+	}
+	return s3.objectURL(key)
+}
+
+// Sign adds the AWS Signature Version 4 Authorization header to the request, every header already set on the request
+// is signed.
+func (s3 S3Storage) Sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256.Sum256(payload)
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s3.Region)
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalRequestHash[:])}, "\n")
+	key := []byte("AWS4" + s3.SecretKey)
+	for _, part := range []string{date, s3.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3URIEncode encodes the path as S3 expects in the canonical request, every byte except the unreserved characters
+// and the slashes is percent encoded.
+func s3URIEncode(path string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			encoded.WriteByte(c)
+			continue
+		}
+		encoded.WriteString(fmt.Sprintf("%%%02X", c))
+	}
+	return encoded.String()
+}
+
+// NewObjectStorage returns the ObjectStorage selected by STORAGE_BACKEND in the environment (or in the .env file):
+//
+//	s3: S3Storage with S3_ENDPOINT, S3_REGION (us-east-1 by default), S3_BUCKET, S3_ACCESS_KEY, S3_SECRET_KEY and
+//	S3_PUBLIC_URL, default if S3_ENDPOINT is set
+//	local: LocalStorage in STORAGE_DIR (persephone-storage in the temporary directory by default), default otherwise
+func NewObjectStorage() ObjectStorage {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if backend := os.Getenv("STORAGE_BACKEND"); backend == "s3" || (backend == "" && endpoint != "") {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return S3Storage{
+			Endpoint:  endpoint,
+			Region:    region,
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			PublicURL: os.Getenv("S3_PUBLIC_URL"),
+		}
+	}
+	dir := os.Getenv("STORAGE_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "persephone-storage")
+	}
+	return LocalStorage{Dir: dir, BaseURL: AppBaseURL() + localStoragePath}
+}
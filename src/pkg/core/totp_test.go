@@ -0,0 +1,64 @@
+package core
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestHOTPRFC6238Vectors checks the SHA1 test vectors from RFC 6238 Appendix B.
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		assert.Equal(t, expected, hotp(key, uint64(TOTPStep(time.Unix(unix, 0))), 8))
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	assert.Nil(t, err)
+	now := time.Now()
+	code := hotp(key, uint64(TOTPStep(now)), totpDigits)
+	step, ok := ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+	// previous period is still accepted for clock drift, but not the one before.
+	_, ok = ValidateTOTP(secret, code, now.Add(totpPeriod*time.Second))
+	assert.True(t, ok)
+	_, ok = ValidateTOTP(secret, code, now.Add(3*totpPeriod*time.Second))
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(10)
+	assert.Nil(t, err)
+	assert.Len(t, codes, 10)
+	for i, code := range codes {
+		assert.Len(t, code, 11)
+		assert.Equal(t, hashes[i], HashRecoveryCode(" "+code+" "))
+	}
+}
+
+func TestReserveTwoFactorAttempt(t *testing.T) {
+	now := time.Now()
+	sql, args, err := reserveTwoFactorAttempt(squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar), "user", now).ToSql()
+	assert.Nil(t, err)
+	assert.Equal(t, "UPDATE users SET totp_failed_attempts = CASE WHEN totp_failed_attempts + 1 >= $1 THEN 0 ELSE totp_failed_attempts + 1 END, "+
+		"totp_locked_until = CASE WHEN totp_failed_attempts + 1 >= $2 THEN $3::timestamptz END "+
+		"WHERE id = $4 AND totp_secret IS NOT NULL AND (totp_locked_until IS NULL OR totp_locked_until <= $5) "+
+		"RETURNING totp_secret, totp_last_used_step", sql)
+	assert.Equal(t, []interface{}{twoFactorMaxAttempts, twoFactorMaxAttempts, now.Add(twoFactorLockDuration), "user", now}, args)
+}
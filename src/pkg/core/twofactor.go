@@ -0,0 +1,711 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/exp/slices"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	RecoveryCodeTableName        = "recovery_codes"
+	RecoveryCodeIDDBField        = "id"
+	RecoveryCodeUserIDDBField    = "user_id"
+	RecoveryCodeHashDBField      = "code_hash"
+	RecoveryCodeCreatedAtDBField = "created_at"
+	RecoveryCodeUsedAtDBField    = "used_at"
+)
+
+// twoFactorRequiredRoles must enable 2FA, they can not disable it and they get their role in the token only after
+// logging in with 2FA.
+var twoFactorRequiredRoles = []string{roleAdmin, roleModerator}
+
+// TwoFactorRequired reports whether the role must use two factor authentication.
+func TwoFactorRequired(role string) bool {
+	return slices.Contains(twoFactorRequiredRoles, strings.ToUpper(role))
+}
+
+// TokenRole returns the role that is put in the issued tokens. Users with a role that requires 2FA are treated as
+// regular users until they enable it.
+func TokenRole(role string, totpEnabled bool) string {
+	role = strings.ToUpper(role)
+	if role == "" || (TwoFactorRequired(role) && !totpEnabled) {
+		return roleUser
+	}
+	return role
+}
+
+// GetUserRoleAndTwoFactor returns the role of the user and whether the user has 2FA enabled.
+func (s Server) GetUserRoleAndTwoFactor(uid string) (string, bool, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserRoleDBField, UserTOTPEnabledDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", false, UserDoesNotExistError
+	}
+	var role string
+	var totpEnabled bool
+	err = rows.Scan(&role, &totpEnabled)
+	return role, totpEnabled, err
+}
+
+// VerifySecondFactor checks either the TOTP code or the recovery code of the user. Used TOTP steps and recovery codes
+// can not be used again. After twoFactorMaxAttempts wrong codes in a row, verification is locked for
+// twoFactorLockDuration.
+//
+// Use TwoFactorErrorStatus to get the HTTP status code of the returned error.
+func (s Server) VerifySecondFactor(uid string, code string, recoveryCode string) error {
+	// the attempt is counted before the code is checked, so concurrent guesses can not exceed twoFactorMaxAttempts.
+	rows, err := s.QuerySQL(reserveTwoFactorAttempt(s.StmtBuilder, uid, time.Now()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		rows.Close()
+		return s.twoFactorUnavailable(uid)
+	}
+	var user UserDB
+	if err = rows.Scan(&user.TOTPSecret, &user.TOTPLastUsedStep); err != nil {
+		return err
+	}
+	rows.Close()
+	verified := false
+	if code != "" {
+		step, ok := ValidateTOTP(*user.TOTPSecret, code, time.Now())
+		if ok && step > user.TOTPLastUsedStep {
+			// conditional update, so the same code can not be used by two concurrent requests.
+			res, err := s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+				Set(UserTOTPLastUsedStepDBField, step).
+				Where(squirrel.Eq{UserIDDBField: uid}).
+				Where(squirrel.Lt{UserTOTPLastUsedStepDBField: step}))
+			if err != nil {
+				return err
+			}
+			verified = res.RowsAffected() == 1
+		}
+	} else if recoveryCode != "" {
+		res, err := s.ExecuteSQL(s.StmtBuilder.Update(RecoveryCodeTableName).
+			Set(RecoveryCodeUsedAtDBField, time.Now()).
+			Where(squirrel.Eq{
+				RecoveryCodeUserIDDBField: uid,
+				RecoveryCodeHashDBField:   HashRecoveryCode(recoveryCode),
+				RecoveryCodeUsedAtDBField: nil,
+			}))
+		if err != nil {
+			return err
+		}
+		verified = res.RowsAffected() == 1
+	}
+	if !verified {
+		return InvalidTwoFactorCodeError
+	}
+	_, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserTOTPFailedAttemptsDBField, 0).
+		Set(UserTOTPLockedUntilDBField, nil).
+		Where(squirrel.Eq{UserIDDBField: uid}))
+	return err
+}
+
+// reserveTwoFactorAttempt counts an attempt of the user that is not locked and returns the TOTP secret and the last
+// used step. The attempt that reaches twoFactorMaxAttempts locks the verification until the lock duration passes,
+// VerifySecondFactor lifts the lock if the code of that attempt is right.
+func reserveTwoFactorAttempt(stmtBuilder squirrel.StatementBuilderType, uid string, now time.Time) squirrel.UpdateBuilder {
+	lastAttempt := fmt.Sprintf("%s + 1 >= ?", UserTOTPFailedAttemptsDBField)
+	return stmtBuilder.Update(UserTableName).
+		Set(UserTOTPFailedAttemptsDBField, squirrel.Expr(fmt.Sprintf("CASE WHEN %s THEN 0 ELSE %s + 1 END", lastAttempt, UserTOTPFailedAttemptsDBField), twoFactorMaxAttempts)).
+		Set(UserTOTPLockedUntilDBField, squirrel.Expr(fmt.Sprintf("CASE WHEN %s THEN ?::timestamptz END", lastAttempt), twoFactorMaxAttempts, now.Add(twoFactorLockDuration))).
+		Where(squirrel.Eq{UserIDDBField: uid}).
+		Where(squirrel.NotEq{UserTOTPSecretDBField: nil}).
+		Where(squirrel.Or{squirrel.Eq{UserTOTPLockedUntilDBField: nil}, squirrel.LtOrEq{UserTOTPLockedUntilDBField: now}}).
+		Suffix(fmt.Sprintf("RETURNING %s, %s", UserTOTPSecretDBField, UserTOTPLastUsedStepDBField))
+}
+
+// twoFactorUnavailable returns why no attempt of the user could be counted.
+func (s Server) twoFactorUnavailable(uid string) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserTOTPSecretDBField, UserTOTPLockedUntilDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: uid}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return UserDoesNotExistError
+	}
+	var user UserDB
+	if err = rows.Scan(&user.TOTPSecret, &user.TOTPLockedUntil); err != nil {
+		return err
+	}
+	if user.TOTPSecret == nil {
+		return TwoFactorNotEnrolledError
+	}
+	if user.TOTPLockedUntil != nil {
+		return TwoFactorLockedError(*user.TOTPLockedUntil)
+	}
+	return InvalidTwoFactorCodeError
+}
+
+// TwoFactorErrorStatus returns the HTTP status code for an error returned by VerifySecondFactor.
+func TwoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, InvalidTwoFactorCodeError):
+		return http.StatusUnauthorized
+	case errors.Is(err, TwoFactorNotEnrolledError), errors.Is(err, UserDoesNotExistError):
+		return http.StatusBadRequest
+	case errors.Is(err, errTwoFactorLocked):
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// ReplaceRecoveryCodes deletes every recovery code of the user and returns the new ones. Codes are only stored hashed,
+// returned codes must be shown to the user once.
+func (s Server) ReplaceRecoveryCodes(ctx context.Context, tx pgx.Tx, uid string) ([]string, error) {
+	sql, args, err := s.StmtBuilder.Delete(RecoveryCodeTableName).Where(squirrel.Eq{RecoveryCodeUserIDDBField: uid}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	insert := s.StmtBuilder.Insert(RecoveryCodeTableName).Columns(RecoveryCodeUserIDDBField, RecoveryCodeHashDBField)
+	for _, hash := range hashes {
+		insert = insert.Values(uid, hash)
+	}
+	sql, args, err = insert.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, sql, args...); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UserLoginTwoFactorRequest represents the second step of the login for users with 2FA. Either the code from the
+// authenticator app or one of the recovery codes is required.
+//
+// swagger:model UserLoginTwoFactorRequest
+type UserLoginTwoFactorRequest struct {
+	// 6 digit code from the authenticator app.
+	Code string `json:"code" validate:"required_without=RecoveryCode"`
+
+	// One of the recovery codes, each recovery code can be used once.
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// UserLoginTwoFactorResponse is returned by UserLoginHandler instead of the user data if the user has 2FA enabled.
+//
+// swagger:model UserLoginTwoFactorResponse
+type UserLoginTwoFactorResponse struct {
+	TwoFactorRequired bool `json:"twoFactorRequired"`
+	// LoginToken is a short-lived WAITING_LOGIN token, send it as a Bearer token to /api/user/login/2fa.
+	LoginToken string `json:"loginToken"`
+}
+
+// UserLoginTwoFactorHandler exchanges the WAITING_LOGIN token issued by UserLoginHandler for an active session token.
+//
+//	@Summary					Login with 2FA
+//	@Description				Second step of the login for users with 2FA enabled. Verifies the TOTP code or a recovery code, and returns the user data with an active session token.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: WAITING_LOGIN token returned by /api/user/login.
+//	@Param						Authorization	header		string						true	"Login token"
+//	@Param						session			query		string						false	"cookie to use the cookie session mode, see /api/user/login"
+//	@Param						body			body		UserLoginTwoFactorRequest	true	"TOTP code or recovery code"
+//	@Success					200				{object}	UserLoginResponse			"Successful login"
+//	@Failure					400				{object}	ErrorResponse				"Bad request, or the token is not a login token"
+//	@Failure					401				{object}	ErrorResponse				"Invalid code or revoked token"
+//	@Failure					429				{object}	ErrorResponse				"Too many wrong codes"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/login/2fa [post]
+func UserLoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if jwtContents.Status != tokenStatusWaitingLogin {
+		s.LogError(TwoFactorNotLoginTokenError, http.StatusBadRequest)
+		return
+	}
+	var req UserLoginTwoFactorRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserTokenVersionDBField, UserRoleDBField, UserTOTPEnabledDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		s.LogError(UserDoesNotExistError, http.StatusUnauthorized)
+		return
+	}
+	var user UserDB
+	if err = rows.Scan(&user.TokenVersion, &user.Role, &user.TOTPEnabled); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	if user.TokenVersion != jwtContents.Version {
+		s.LogError(TokenRevokedError, http.StatusUnauthorized)
+		return
+	}
+	if !user.TOTPEnabled {
+		s.LogError(TwoFactorNotEnabledError, http.StatusBadRequest)
+		return
+	}
+	if err = s.VerifySecondFactor(jwtContents.UUID, req.Code, req.RecoveryCode); err != nil {
+		s.LogError(err, TwoFactorErrorStatus(err))
+		return
+	}
+	// the user may be banned after the password step.
+	if err = s.CheckUserBan(jwtContents.UUID); err != nil {
+		s.LogError(err, TokenErrorStatus(err))
+		return
+	}
+	tokenToSend, err := s.IssueSessionToken(w, r, jwtContents.UUID, TokenRole(user.Role, true), user.TokenVersion, loginMethodTwoFactor)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenToSend))
+	GetUser(r)
+}
+
+// UserTwoFactorEnrollResponse contains the new TOTP secret. 2FA is not enabled until a code is confirmed.
+//
+// swagger:model UserTwoFactorEnrollResponse
+type UserTwoFactorEnrollResponse struct {
+	// Secret is the base32 encoded TOTP secret, for manual entry.
+	Secret string `json:"secret"`
+	// OTPAuthURI is the otpauth:// URI of the secret, to be rendered as a QR code.
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+// UserTwoFactorEnrollHandler creates a new TOTP secret for the user.
+//
+//	@Summary					Enroll 2FA
+//	@Description				Creates a new TOTP secret and returns its otpauth URI. 2FA is enabled after a code from the authenticator app is confirmed at /api/user/2fa/confirm.
+//	@Tags						User
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string						true	"JWT token"
+//	@Success					200				{object}	UserTwoFactorEnrollResponse	"New TOTP secret"
+//	@Failure					400				{object}	ErrorResponse				"2FA is already enabled"
+//	@Failure					500				{object}	ErrorResponse				"Internal server error"
+//	@Router						/api/user/2fa/enroll [post]
+func UserTwoFactorEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserEmailDBField, UserTOTPEnabledDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		s.LogError(UserDoesNotExistError, http.StatusBadRequest)
+		return
+	}
+	var user UserDB
+	if err = rows.Scan(&user.Email, &user.TOTPEnabled); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	if user.TOTPEnabled {
+		s.LogError(TwoFactorAlreadyEnabledError, http.StatusBadRequest)
+		return
+	}
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	updateQuery := s.StmtBuilder.Update(UserTableName).
+		Set(UserTOTPSecretDBField, secret).
+		Set(UserTOTPLastUsedStepDBField, 0).
+		Set(UserTOTPFailedAttemptsDBField, 0).
+		Set(UserTOTPLockedUntilDBField, nil).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})
+	if _, err = s.ExecuteSQL(updateQuery); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserTwoFactorEnrollResponse{Secret: secret, OTPAuthURI: TOTPURI(user.Email, secret)}, http.StatusOK)
+}
+
+// UserTwoFactorCodeRequest contains a code from the authenticator app.
+//
+// swagger:model UserTwoFactorCodeRequest
+type UserTwoFactorCodeRequest struct {
+	// 6 digit code from the authenticator app.
+	//
+	// required: true
+	Code string `json:"code" validate:"required,len=6,numeric" binding:"required"`
+}
+
+// UserTwoFactorConfirmResponse contains the recovery codes, and the new tokens since every other session is ended
+// while enabling 2FA.
+//
+// swagger:model UserTwoFactorConfirmResponse
+type UserTwoFactorConfirmResponse struct {
+	// RecoveryCodes are shown only once.
+	RecoveryCodes []string `json:"recoveryCodes"`
+	SessionToken  string   `json:"sessionToken"`
+	RefreshToken  string   `json:"refreshToken"`
+}
+
+// UserTwoFactorConfirmHandler enables 2FA after the first code from the authenticator app is verified.
+//
+//	@Summary					Confirm 2FA
+//	@Description				Verifies a code for the enrolled TOTP secret and enables 2FA. Returns the recovery codes once. All other sessions are ended, and new session and refresh tokens are returned.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						body			body		UserTwoFactorCodeRequest		true	"Code from the authenticator app"
+//	@Success					200				{object}	UserTwoFactorConfirmResponse	"2FA is enabled"
+//	@Failure					400				{object}	ErrorResponse					"2FA is already enabled or not enrolled"
+//	@Failure					401				{object}	ErrorResponse					"Invalid code"
+//	@Failure					429				{object}	ErrorResponse					"Too many wrong codes"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/user/2fa/confirm [post]
+func UserTwoFactorConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserTwoFactorCodeRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	role, totpEnabled, err := s.GetUserRoleAndTwoFactor(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if totpEnabled {
+		s.LogError(TwoFactorAlreadyEnabledError, http.StatusBadRequest)
+		return
+	}
+	if err = s.VerifySecondFactor(jwtContents.UUID, req.Code, ""); err != nil {
+		s.LogError(err, TwoFactorErrorStatus(err))
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	sql, args, err := s.StmtBuilder.Update(UserTableName).
+		Set(UserTOTPEnabledDBField, true).
+		Set(UserTokenVersionDBField, squirrel.Expr(fmt.Sprintf("%s + 1", UserTokenVersionDBField))).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}).
+		Suffix(fmt.Sprintf("RETURNING %s", UserTokenVersionDBField)).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var tokenVersion int64
+	if err = tx.QueryRow(to, sql, args...).Scan(&tokenVersion); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	codes, err := s.ReplaceRecoveryCodes(to, tx, jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// the token version bump ended every session, the caller continues in a new one.
+	if err = s.RevokeSessions(to, tx, jwtContents.UUID, ""); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// the session is started after the commit, StartSession updates the user row locked by the transaction.
+	var resp UserTwoFactorConfirmResponse
+	resp.RecoveryCodes = codes
+	sessionID, err := s.StartSession(w, r, jwtContents.UUID, loginMethodTwoFactor)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	resp.SessionToken, err = SignSessionToken(jwtContents.UUID, TokenRole(role, true), tokenStatusActive, tokenVersion, sessionID, tokenDurationActive)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	resp.RefreshToken, err = SignRefreshToken(jwtContents.UUID, TokenRole(role, true), tokenVersion, sessionID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserSessionTokenDBField, resp.SessionToken).
+		Set(UserRefreshTokenDBField, "").
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(resp, http.StatusOK)
+}
+
+// UserTwoFactorDisableRequest represents the data required for disabling 2FA.
+//
+// swagger:model UserTwoFactorDisableRequest
+type UserTwoFactorDisableRequest struct {
+	// Current password of the user.
+	//
+	// required: true
+	Password string `json:"password" validate:"required" binding:"required"`
+
+	// 6 digit code from the authenticator app.
+	Code string `json:"code" validate:"required_without=RecoveryCode"`
+
+	// One of the recovery codes, if the authenticator app is lost.
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
+
+// UserTwoFactorDisableResponse represents the response of a successful 2FA disable.
+//
+// swagger:model UserTwoFactorDisableResponse
+type UserTwoFactorDisableResponse struct {
+	Success bool `json:"success"`
+}
+
+// UserTwoFactorDisableHandler disables 2FA and deletes the TOTP secret and recovery codes.
+//
+//	@Summary					Disable 2FA
+//	@Description				Disables 2FA after verifying the password and a TOTP or recovery code. Not allowed for ADMIN and MODERATOR roles.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string							true	"JWT token"
+//	@Param						body			body		UserTwoFactorDisableRequest		true	"Password and code"
+//	@Success					200				{object}	UserTwoFactorDisableResponse	"2FA is disabled"
+//	@Failure					400				{object}	ErrorResponse					"2FA is not enabled"
+//	@Failure					401				{object}	ErrorResponse					"Wrong password or invalid code"
+//	@Failure					403				{object}	ErrorResponse					"2FA is required for the role of the user"
+//	@Failure					429				{object}	ErrorResponse					"Too many wrong codes"
+//	@Failure					500				{object}	ErrorResponse					"Internal server error"
+//	@Router						/api/user/2fa/disable [post]
+func UserTwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserTwoFactorDisableRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserPasswordDBField, UserRoleDBField, UserTOTPEnabledDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		s.LogError(UserDoesNotExistError, http.StatusBadRequest)
+		return
+	}
+	var user UserDB
+	if err = rows.Scan(&user.Password, &user.Role, &user.TOTPEnabled); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	rows.Close()
+	if TwoFactorRequired(user.Role) {
+		s.LogError(TwoFactorRequiredForRoleError(strings.ToUpper(user.Role)), http.StatusForbidden)
+		return
+	}
+	if !user.TOTPEnabled {
+		s.LogError(TwoFactorNotEnabledError, http.StatusBadRequest)
+		return
+	}
+	if ok, _, _ := VerifyPassword(user.Password, req.Password); !ok {
+		s.LogError(WrongCurrentPasswordError, http.StatusUnauthorized)
+		return
+	}
+	if err = s.VerifySecondFactor(jwtContents.UUID, req.Code, req.RecoveryCode); err != nil {
+		s.LogError(err, TwoFactorErrorStatus(err))
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	sql, args, err := s.StmtBuilder.Update(UserTableName).
+		Set(UserTOTPEnabledDBField, false).
+		Set(UserTOTPSecretDBField, nil).
+		Set(UserTOTPLastUsedStepDBField, 0).
+		Set(UserUpdatedAtDBField, time.Now()).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	sql, args, err = s.StmtBuilder.Delete(RecoveryCodeTableName).Where(squirrel.Eq{RecoveryCodeUserIDDBField: jwtContents.UUID}).ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = tx.Exec(to, sql, args...); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserTwoFactorDisableResponse{Success: true}, http.StatusOK)
+}
+
+// UserTwoFactorRecoveryCodesResponse contains the new recovery codes.
+//
+// swagger:model UserTwoFactorRecoveryCodesResponse
+type UserTwoFactorRecoveryCodesResponse struct {
+	// RecoveryCodes are shown only once, old recovery codes are not valid anymore.
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// UserTwoFactorRecoveryCodesHandler replaces the recovery codes of the user.
+//
+//	@Summary					Regenerate recovery codes
+//	@Description				Verifies a TOTP code, deletes the old recovery codes and returns new ones.
+//	@Tags						User
+//	@Accept						json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string								true	"JWT token"
+//	@Param						body			body		UserTwoFactorCodeRequest			true	"Code from the authenticator app"
+//	@Success					200				{object}	UserTwoFactorRecoveryCodesResponse	"New recovery codes"
+//	@Failure					400				{object}	ErrorResponse						"2FA is not enabled"
+//	@Failure					401				{object}	ErrorResponse						"Invalid code"
+//	@Failure					429				{object}	ErrorResponse						"Too many wrong codes"
+//	@Failure					500				{object}	ErrorResponse						"Internal server error"
+//	@Router						/api/user/2fa/recovery-codes [post]
+func UserTwoFactorRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	var req UserTwoFactorCodeRequest
+	if err = s.Bind(&req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	if err = s.Validator.Struct(req); err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	_, totpEnabled, err := s.GetUserRoleAndTwoFactor(jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if !totpEnabled {
+		s.LogError(TwoFactorNotEnabledError, http.StatusBadRequest)
+		return
+	}
+	if err = s.VerifySecondFactor(jwtContents.UUID, req.Code, ""); err != nil {
+		s.LogError(err, TwoFactorErrorStatus(err))
+		return
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	codes, err := s.ReplaceRecoveryCodes(to, tx, jwtContents.UUID)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	s.WriteResponse(UserTwoFactorRecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+}
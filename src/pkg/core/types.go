@@ -2,6 +2,7 @@
 package core
 
 import (
+	"context"
 	"github.com/Masterminds/squirrel"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -25,14 +26,20 @@ var HandlerFunc = func() http.Handler {
 	router.Use(middleware.RealIP)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
-	router.Use(cors.Handler(cors.Options{
+	corsOptions := cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
+	}
+	// the session cookie is sent cross-origin only from the listed web apps, never from any origin.
+	if origins := SessionCookieOrigins(); len(origins) > 0 {
+		corsOptions.AllowedOrigins = origins
+		corsOptions.AllowCredentials = true
+	}
+	router.Use(cors.Handler(corsOptions))
 	// please do not fiddle with middleware order.
 	router.Use(AssignServer)
 	router.Use(AssignWriter)
@@ -40,6 +47,7 @@ var HandlerFunc = func() http.Handler {
 	router.Use(AssignLogger)
 	router.Use(AssignValidator)
 	router.Use(AssignQueryBuilder)
+	router.Use(CSRFProtect)
 
 	//
 	// POSTGRES CONNECTION POOL INITIALIZATION
@@ -49,10 +57,27 @@ var HandlerFunc = func() http.Handler {
 		panic(err)
 	}
 	router.Use(AssignDB(db))
+	router.Use(AssignMailer(NewMailer()))
+	router.Use(AssignOIDCProviders(NewOIDCProviders()))
+	router.Use(AssignSMSSender(NewSMSSender()))
+	storage := NewObjectStorage()
+	router.Use(AssignStorage(storage))
+	notifications := NewNotificationHub(db)
+	go notifications.Run(context.Background())
+	router.Use(AssignNotificationHub(notifications))
 	// MOUNT YOUR ROUTERS HERE.
 	router.Route("/api", func(r chi.Router) {
 		r.Mount("/user", NewUserHandler())
+		r.Mount("/users", NewProfileHandler())
 		r.Mount("/world", NewCityHandler())
+		r.Mount("/moderation", NewModerationHandler())
+		r.Mount("/notifications", NewNotificationHandler())
+		r.Mount("/admin", NewAdminHandler())
+		r.Mount("/places", NewPlaceHandler())
+		// objects of S3Storage are served by the bucket or the CDN.
+		if local, ok := storage.(LocalStorage); ok {
+			r.Handle("/files/*", http.StripPrefix(localStoragePath, local))
+		}
 		r.Get("/swagger/*", httpSwagger.Handler(
 			httpSwagger.URL("http://localhost:3000/api/swagger/doc.json"),
 		))
@@ -127,6 +152,18 @@ type Server struct {
 	Writer      http.ResponseWriter
 	Request     *http.Request
 	StmtBuilder squirrel.StatementBuilderType
+	// Mailer is used to send emails to users, see NewMailer.
+	Mailer Mailer
+	// OIDCProviders are the external identity providers users can log in with, see NewOIDCProviders.
+	OIDCProviders OIDCProviders
+	// SMSSender is used to send SMS messages to users, see NewSMSSender.
+	SMSSender SMSSender
+	// Storage stores the files uploaded by users, see NewObjectStorage.
+	Storage ObjectStorage
+	// Notifications pushes the new notifications to the streams of the users, see NotificationHub.
+	Notifications *NotificationHub
+	// APIKey is the API key the request is authenticated with, nil for the requests with a JWT. See ScopeWhitelist.
+	APIKey *APIKeyAuth
 }
 
 type serverKey string
@@ -141,6 +178,19 @@ type JWTFields struct {
 	Role    string  `json:"role"`
 	Token   string  `json:"token"`
 	Status  string  `json:"status"`
+	// Version is the token version of the user at the time the token is issued, tokens with a version that is not
+	// equal to users.token_version are revoked.
+	Version int64 `json:"ver"`
+	// SessionID is the id of the login history entry of the session, only set in the ACTIVE tokens issued by a login.
+	SessionID string `json:"sid"`
+	// ActorID is the id of the admin using the token of the user, only set in the impersonation tokens. See
+	// SignImpersonationToken.
+	ActorID string `json:"act"`
+}
+
+// Impersonated reports whether the token is used by an admin on behalf of the user.
+func (f JWTFields) Impersonated() bool {
+	return f.ActorID != ""
 }
 
 const (
@@ -148,12 +198,20 @@ const (
 	JWTExpiresKey = "exp"
 	JWTRoleKey    = "role"
 	JWTStatusKey  = "status"
+	JWTVersionKey = "ver"
+	JWTSessionKey = "sid"
+	JWTActorKey   = "act"
+	// JWTActorSubjectKey is the key of the actor id in the act claim.
+	JWTActorSubjectKey = "sub"
 )
 
 const (
 	tokenDurationLogin   = 30 * time.Minute
 	tokenDurationSession = 24 * time.Hour
 	tokenDurationRefresh = 7 * 24 * time.Hour
+	tokenDurationActive  = 30 * 24 * time.Hour
+	// tokenDurationTwoFactor is the lifetime of the WAITING_LOGIN token issued after the password check of a user with 2FA.
+	tokenDurationTwoFactor = 5 * time.Minute
 )
 
 const (
@@ -180,6 +238,24 @@ const (
 	DefaultDB  = "persephone"
 )
 
+const (
+	passwordResetTokenDuration = 30 * time.Minute
+	// passwordHistoryLength is the count of the last used passwords that can not be used again while changing password.
+	passwordHistoryLength = 5
+)
+
+const (
+	// oidcStateDuration is the time the user has to complete the login at the identity provider.
+	oidcStateDuration = 10 * time.Minute
+)
+
+const (
+	recoveryCodeCount = 10
+	// after twoFactorMaxAttempts wrong codes in a row, 2FA verification is locked for twoFactorLockDuration.
+	twoFactorMaxAttempts  = 5
+	twoFactorLockDuration = 15 * time.Minute
+)
+
 const (
 	AllowedUserEmailUpdateInterval = time.Hour * 24 * 7
 	AllowedUsernameUpdateInterval  = time.Hour * 24 * 90
@@ -267,6 +343,63 @@ const (
 	RestaurantStateDBField        = "state"
 	RestaurantLatitudeDBField     = "latitude"
 	RestaurantLongitudeDBField    = "longitude"
+	// RestaurantDietsDBField lists the diet:* tags of the place that are yes, only or limited, see PlaceDiets.
+	RestaurantDietsDBField = "diets"
+)
+
+const (
+	ReportTableName     = "reports"
+	ReportIDDBField     = "id"
+	ReportReasonDBField = "report_reason"
+	ReportStatusDBField = "status"
+)
+
+// statuses of the reports, a confirmed report costs reputation to the reported user, see reputation.go.
+const (
+	reportStatusPending   = "PENDING"
+	reportStatusConfirmed = "CONFIRMED"
+	reportStatusRejected  = "REJECTED"
+)
+
+const (
+	ReviewReportTableName            = "review_reports"
+	ReviewReportReviewIDDBField      = "review_id"
+	ReviewReportReportIDDBField      = "report_id"
+	ReviewReplyReportTableName       = "review_reply_reports"
+	ReviewReplyReportReplyIDDBField  = "review_reply_id"
+	ReviewReplyReportReportIDDBField = "report_id"
+)
+
+const (
+	UserReportTableName         = "user_reports"
+	UserReportUserIDDBField     = "user_id"
+	UserReportReporterIDDBField = "reporter_id"
+	UserReportReportIDDBField   = "report_id"
+)
+
+const (
+	ReviewsTable              = "reviews"
+	ReviewIDDBField           = "id"
+	ReviewUserIDDBField       = "user_id"
+	ReviewPlaceIDDBField      = "place_id"
+	ReviewTextDBField         = "review_text"
+	ReviewTitleDBField        = "review_title"
+	ReviewCreatedAtDBField    = "created_at"
+	ReviewUpdatedAtDBField    = "updated_at"
+	ReviewHelpfulCountDBField = "helpful_count"
+	ReviewDislikeCountDBField = "dislike_count"
+)
+
+const (
+	ReviewReplyTable               = "review_reply"
+	ReviewReplyIDDBField           = "id"
+	ReviewReplyTextDBField         = "reply_text"
+	ReviewReplyHelpfulCountDBField = "helpful_count"
+	ReviewReplyDislikeCountDBField = "dislike_count"
+	ReviewReplyCreatedAtDBField    = "created_at"
+	ReviewReplyUpdatedAtDBField    = "updated_at"
+	ReviewReplyUserIDDBField       = "user_id"
+	ReviewReplyReviewIDDBField     = "review_id"
 )
 
 type StmtBuilders interface {
@@ -1,14 +1,13 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"github.com/Masterminds/squirrel"
 	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/joho/godotenv/autoload"
-	"golang.org/x/crypto/bcrypt"
 	"net"
 	"net/http"
 	"strings"
@@ -20,12 +19,78 @@ func NewUserHandler() http.Handler {
 	var signUpTracer = AssignTracer("/signup", "USER_CRUD", "/signup")
 	var loginTracer = AssignTracer("/login", "USER_CRUD", "/login")
 	var updateTracer = AssignTracer("/update", "USER_CRUD", "/update")
+	var patchTracer = AssignTracer("/", "USER_CRUD", "/")
 	var deleteTracer = AssignTracer("/delete", "USER_CRUD", "/delete")
+	var passwordForgotTracer = AssignTracer("/password/forgot", "USER_CRUD", "/password/forgot")
+	var passwordResetTracer = AssignTracer("/password/reset", "USER_CRUD", "/password/reset")
+	var passwordChangeTracer = AssignTracer("/password", "USER_CRUD", "/password")
+	var loginTwoFactorTracer = AssignTracer("/login/2fa", "USER_CRUD", "/login/2fa")
+	var twoFactorTracer = AssignTracer("/2fa", "USER_2FA", "/2fa")
+	var oidcTracer = AssignTracer("/oidc", "USER_OIDC", "/oidc")
+	var apiKeyTracer = AssignTracer("/api-keys", "USER_API_KEY", "/api-keys")
+	var phoneVerificationTracer = AssignTracer("/phone/verify", "USER_CRUD", "/phone/verify")
+	var sessionsTracer = AssignTracer("/sessions", "USER_SESSION", "/sessions")
+	var profilePrivacyTracer = AssignTracer("/profile/privacy", "USER_PROFILE", "/profile/privacy")
+	var exportTracer = AssignTracer("/export", "USER_EXPORT", "/export")
+	var avatarTracer = AssignTracer("/avatar", "USER_AVATAR", "/avatar")
+	var reputationTracer = AssignTracer("/reputation", "USER_REPUTATION", "/reputation")
+	var feedTracer = AssignTracer("/feed", "USER_FOLLOW", "/feed")
+	var dietaryProfileTracer = AssignTracer("/dietary-profile", "USER_PROFILE", "/dietary-profile")
+	var recommendationsTracer = AssignTracer("/places/recommended", "USER_RECOMMENDATION", "/places/recommended")
+	var usernameAvailableTracer = AssignTracer("/username-available", "USER_CRUD", "/username-available")
+	var emailChangeTracer = AssignTracer("/email", "USER_CRUD", "/email")
 	// declare routers with tracers wrapped around them
 	r.With(signUpTracer).Post("/signup", UserSignupHandler)
 	r.With(loginTracer).Post("/login", UserLoginHandler)
-	r.With(updateTracer, JWTWhitelist(nil, nil)).Post("/update", UserUpdateHandler)
-	r.With(deleteTracer, JWTWhitelist(nil, nil)).Delete("/delete", UserDeleteHandler)
+	r.With(loginTracer).Post("/logout", UserLogoutHandler)
+	r.With(updateTracer, JWTWhitelist(nil, nil), RejectImpersonation).Post("/update", UserUpdateHandler)
+	r.With(patchTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Patch("/", UserPatchHandler)
+	r.With(deleteTracer, JWTWhitelist(nil, nil), RejectImpersonation).Delete("/delete", UserDeleteHandler)
+	r.With(passwordForgotTracer).Post("/password/forgot", UserPasswordForgotHandler)
+	r.With(passwordResetTracer).Post("/password/reset", UserPasswordResetHandler)
+	r.With(passwordChangeTracer, JWTWhitelist([]string{tokenStatusActive}, nil), RejectImpersonation).Post("/password", UserPasswordChangeHandler)
+	// login token is checked by the handler, JWTWhitelist rejects WAITING_LOGIN tokens of the users with 2FA.
+	r.With(loginTwoFactorTracer).Post("/login/2fa", UserLoginTwoFactorHandler)
+	r.With(twoFactorTracer, JWTWhitelist([]string{tokenStatusActive}, nil), RejectImpersonation).Route("/2fa", func(r chi.Router) {
+		r.Post("/enroll", UserTwoFactorEnrollHandler)
+		r.Post("/confirm", UserTwoFactorConfirmHandler)
+		r.Post("/disable", UserTwoFactorDisableHandler)
+		r.Post("/recovery-codes", UserTwoFactorRecoveryCodesHandler)
+	})
+	r.With(oidcTracer).Route("/oidc", func(r chi.Router) {
+		r.Get("/{provider}/login", UserOIDCLoginHandler)
+		r.Get("/{provider}/callback", UserOIDCCallbackHandler)
+		r.With(JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/", UserIdentitiesHandler)
+		r.With(JWTWhitelist([]string{tokenStatusActive}, nil), RejectImpersonation).Post("/{provider}/link", UserOIDCLinkHandler)
+		r.With(JWTWhitelist([]string{tokenStatusActive}, nil), RejectImpersonation).Delete("/{provider}", UserOIDCUnlinkHandler)
+	})
+	r.With(phoneVerificationTracer, JWTWhitelist(nil, nil), RejectImpersonation).Post("/phone/verify/send", UserPhoneVerificationSendHandler)
+	r.With(phoneVerificationTracer, JWTWhitelist(nil, nil), RejectImpersonation).Post("/phone/verify", UserPhoneVerificationHandler)
+	r.With(apiKeyTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Route("/api-keys", func(r chi.Router) {
+		r.With(RejectImpersonation).Post("/", UserAPIKeyCreateHandler)
+		r.Get("/", UserAPIKeysHandler)
+		r.With(RejectImpersonation).Delete("/{id}", UserAPIKeyRevokeHandler)
+	})
+	r.With(sessionsTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Route("/sessions", func(r chi.Router) {
+		r.Get("/", UserSessionsHandler)
+		r.With(RejectImpersonation).Delete("/{id}", UserSessionRevokeHandler)
+	})
+	r.With(profilePrivacyTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/profile/privacy", UserProfilePrivacyHandler)
+	r.With(profilePrivacyTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Put("/profile/privacy", UserProfilePrivacyUpdateHandler)
+	r.With(exportTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Post("/export", UserDataExportRequestHandler)
+	r.With(exportTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/export", UserDataExportsHandler)
+	// the emailed token is checked by the handler.
+	r.With(exportTracer).Get("/export/download", UserDataExportDownloadHandler)
+	r.With(avatarTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Put("/avatar", UserAvatarUploadHandler)
+	r.With(avatarTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Delete("/avatar", UserAvatarDeleteHandler)
+	r.With(reputationTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/reputation", UserReputationHandler)
+	r.With(feedTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/feed", UserFeedHandler)
+	r.With(dietaryProfileTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Get("/dietary-profile", UserDietaryProfileHandler)
+	r.With(dietaryProfileTracer, JWTWhitelist([]string{tokenStatusActive}, nil)).Put("/dietary-profile", UserDietaryProfileUpdateHandler)
+	r.With(recommendationsTracer, ScopeWhitelist(scopePlacesRead, []string{tokenStatusActive}, nil)).Get("/places/recommended", UserPlaceRecommendationsHandler)
+	r.With(usernameAvailableTracer).Get("/username-available", UsernameAvailabilityHandler)
+	r.With(emailChangeTracer).Post("/email/confirm", UserEmailChangeConfirmHandler)
+	r.With(emailChangeTracer).Post("/email/revert", UserEmailChangeRevertHandler)
 
 	return r
 }
@@ -52,7 +117,8 @@ type UserDB struct {
 	Role                  string     `db:"role"`
 	PlaceID               *uuid.UUID `db:"place_id"`
 	Banned                bool       `db:"banned"`
-	Reputation            int16      `db:"reputation"`
+	BannedUntil           *time.Time `db:"banned_until"`
+	Reputation            int32      `db:"reputation"`
 	SessionToken          string     `db:"session_token"`
 	RefreshToken          string     `db:"refresh_token"`
 	City                  uint32     `db:"city"`
@@ -62,32 +128,93 @@ type UserDB struct {
 	LastLoginIP           net.IP     `db:"last_login_ip"`
 	LastLoginAt           time.Time  `db:"last_login_at"`
 	PossibleSpammer       bool       `db:"possible_spammer"`
+	TokenVersion          int64      `db:"token_version"`
+	TOTPSecret            *string    `db:"totp_secret"`
+	TOTPEnabled           bool       `db:"totp_enabled"`
+	TOTPLastUsedStep      int64      `db:"totp_last_used_step"`
+	TOTPFailedAttempts    int16      `db:"totp_failed_attempts"`
+	TOTPLockedUntil       *time.Time `db:"totp_locked_until"`
+	PhoneVerified         bool       `db:"phone_verified"`
+	PhoneVerifiedAt       *time.Time `db:"phone_verified_at"`
+	ProfileHiddenFields   []string   `db:"profile_hidden_fields"`
+	DeletedAt             *time.Time `db:"deleted_at"`
+	AnonymizedAt          *time.Time `db:"anonymized_at"`
+	DisplayName           *string    `db:"display_name"`
+	Bio                   *string    `db:"bio"`
+	AvatarKey             *string    `db:"avatar_key"`
+	SignupIP              net.IP     `db:"signup_ip"`
+	SpamScore             int16      `db:"spam_score"`
+	SpamReasons           []string   `db:"spam_reasons"`
+	SpamScoredAt          *time.Time `db:"spam_scored_at"`
+	SpamOverride          *bool      `db:"spam_override"`
+	SpamOverrideBy        *string    `db:"spam_override_by"`
+	SpamOverrideAt        *time.Time `db:"spam_override_at"`
+	// NotificationDisabledTypes are the types of the notifications the user does not receive, see notifications.go.
+	NotificationDisabledTypes []string `db:"notification_disabled_types"`
+	// dietary profile, see dietary.go.
+	DietRestrictions  []string `db:"diet_restrictions"`
+	FavouriteCuisines []string `db:"favourite_cuisines"`
+	Allergens         []string `db:"allergens"`
+	PreferredLanguage *string  `db:"preferred_language"`
+	DistanceUnit      string   `db:"distance_unit"`
+	// PasswordResetRequired is set by the admins, the user can not log in with the password until it is reset.
+	PasswordResetRequired bool `db:"password_reset_required"`
 }
 
 const (
-	UserTableName                    = "users"
-	UserIDDBField                    = "id"
-	UserEmailDBField                 = "email"
-	UserEmailLastUpdatedAtDBField    = "email_last_updated_at"
-	UserUsernameDBField              = "username"
-	UserUsernameLastUpdatedAtDBField = "username_last_updated_at"
-	UserPasswordDBField              = "password"
-	UserCreatedAtDBField             = "created_at"
-	UserUpdatedAtDBField             = "updated_at"
-	UserPhoneNumberDBField           = "phone_number"
-	UserRoleDBField                  = "role"
-	UserPlaceIDDBField               = "place_id"
-	UserBannedDBField                = "banned"
-	UserReputationDBField            = "reputation"
-	UserSessionTokenDBField          = "session_token"
-	UserRefreshTokenDBField          = "refresh_token"
-	UserCityDBField                  = "city"
-	UserCountryDBField               = "country"
-	UserStateDBField                 = "state"
-	UserVerifiedDBField              = "verified"
-	UserLastLoginIPDBField           = "last_login_ip"
-	UserLastLoginAtDBField           = "last_login_at"
-	UserPossibleSpammerDBField       = "possible_spammer"
+	UserTableName                        = "users"
+	UserIDDBField                        = "id"
+	UserEmailDBField                     = "email"
+	UserEmailLastUpdatedAtDBField        = "email_last_updated_at"
+	UserUsernameDBField                  = "username"
+	UserUsernameLastUpdatedAtDBField     = "username_last_updated_at"
+	UserPasswordDBField                  = "password"
+	UserCreatedAtDBField                 = "created_at"
+	UserUpdatedAtDBField                 = "updated_at"
+	UserPhoneNumberDBField               = "phone_number"
+	UserRoleDBField                      = "role"
+	UserPlaceIDDBField                   = "place_id"
+	UserBannedDBField                    = "banned"
+	UserBannedUntilDBField               = "banned_until"
+	UserReputationDBField                = "reputation"
+	UserSessionTokenDBField              = "session_token"
+	UserRefreshTokenDBField              = "refresh_token"
+	UserCityDBField                      = "city"
+	UserCountryDBField                   = "country"
+	UserStateDBField                     = "state"
+	UserVerifiedDBField                  = "verified"
+	UserLastLoginIPDBField               = "last_login_ip"
+	UserLastLoginAtDBField               = "last_login_at"
+	UserPossibleSpammerDBField           = "possible_spammer"
+	UserTokenVersionDBField              = "token_version"
+	UserTOTPSecretDBField                = "totp_secret"
+	UserTOTPEnabledDBField               = "totp_enabled"
+	UserTOTPLastUsedStepDBField          = "totp_last_used_step"
+	UserTOTPFailedAttemptsDBField        = "totp_failed_attempts"
+	UserTOTPLockedUntilDBField           = "totp_locked_until"
+	UserPhoneVerifiedDBField             = "phone_verified"
+	UserPhoneVerifiedAtDBField           = "phone_verified_at"
+	UserProfileHiddenFieldsDBField       = "profile_hidden_fields"
+	UserDeletedAtDBField                 = "deleted_at"
+	UserAnonymizedAtDBField              = "anonymized_at"
+	UserUsernameCaseDuplicateDBField     = "username_case_duplicate"
+	UserDisplayNameDBField               = "display_name"
+	UserBioDBField                       = "bio"
+	UserAvatarKeyDBField                 = "avatar_key"
+	UserSignupIPDBField                  = "signup_ip"
+	UserSpamScoreDBField                 = "spam_score"
+	UserSpamReasonsDBField               = "spam_reasons"
+	UserSpamScoredAtDBField              = "spam_scored_at"
+	UserSpamOverrideDBField              = "spam_override"
+	UserSpamOverrideByDBField            = "spam_override_by"
+	UserSpamOverrideAtDBField            = "spam_override_at"
+	UserNotificationDisabledTypesDBField = "notification_disabled_types"
+	UserDietRestrictionsDBField          = "diet_restrictions"
+	UserFavouriteCuisinesDBField         = "favourite_cuisines"
+	UserAllergensDBField                 = "allergens"
+	UserPreferredLanguageDBField         = "preferred_language"
+	UserDistanceUnitDBField              = "distance_unit"
+	UserPasswordResetRequiredDBField     = "password_reset_required"
 )
 
 // UserSignupRequest represents the data required for user signup.
@@ -159,6 +286,10 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 			s.LogError(err, http.StatusBadRequest)
 			return
 		}
+		if err = CheckPasswordNotBreached(signUpForm.Password); err != nil {
+			s.LogError(err, http.StatusBadRequest)
+			return
+		}
 		// check if user exists
 		rows, err := s.QuerySQL(s.StmtBuilder.Select("*").From("users").Where(squirrel.Eq{"email": signUpForm.Email}))
 		defer rows.Close()
@@ -170,22 +301,26 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 			s.LogError(EmailAlreadyExistsError, http.StatusBadRequest)
 			return
 		} else {
-			rows, err = s.QuerySQL(s.StmtBuilder.Select("*").From("users").Where(squirrel.Eq{"username": signUpForm.Username}))
+			if IsReservedUsername(signUpForm.Username) {
+				s.LogError(UsernameReservedError, http.StatusBadRequest)
+				return
+			}
+			taken, err := s.IsUsernameTaken(signUpForm.Username, "")
 			if err != nil {
 				s.LogError(err, http.StatusInternalServerError)
 				return
 			}
-			if rows.Next() {
+			if taken {
 				s.LogError(UsernameAlreadyExistsError, http.StatusBadRequest)
 				return
 			} else {
-				// do the same check for phone number
-				rows, err = s.QuerySQL(s.StmtBuilder.Select("*").From("users").Where(squirrel.Eq{"phone_number": signUpForm.PhoneNum}))
+				// do the same check for phone number, only the verified numbers are taken.
+				taken, err = s.IsPhoneNumberTaken(signUpForm.PhoneNum, "")
 				if err != nil {
 					s.LogError(err, http.StatusInternalServerError)
 					return
 				}
-				if rows.Next() {
+				if taken {
 					s.LogError(PhoneNumberAlreadyExistsError, http.StatusBadRequest)
 					return
 				}
@@ -206,7 +341,7 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 	userData.Username = signUpForm.Username
 	userData.PhoneNumber = signUpForm.PhoneNum
 	userData.Banned = false
-	userData.Role = "user"
+	userData.Role = roleUser
 	userData.City = signUpForm.City
 	userData.Country = signUpForm.Country
 	userData.State = signUpForm.State
@@ -216,39 +351,9 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	userData.Verified = false
-	tokenLoginInterface := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		JWTUUIDKey:    userData.ID,
-		JWTExpiresKey: time.Now().Add(tokenDurationLogin).Unix(),
-		JWTRoleKey:    roleUser,
-		JWTStatusKey:  tokenStatusWaitingLogin,
-	})
-	loginToken, err := tokenLoginInterface.SignedString([]byte(JWT_ENCRYPT_KEY))
-	if err != nil {
-		s.LogError(err, http.StatusInternalServerError)
-		return
-	}
-	refreshTokenInterface := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"uuid":   userData.ID,
-		"exp":    time.Now().Add(tokenDurationRefresh).Unix(),
-		"role":   roleUser,
-		"status": tokenStatusRefresh,
-	})
-	refreshToken, err := refreshTokenInterface.SignedString([]byte(JWT_ENCRYPT_KEY))
-	userData.SessionToken = loginToken
-	userData.RefreshToken = refreshToken
 	// no place id at register
-	loggedInIP := r.Header.Get("X-Forwarded-For")
-	if loggedInIP == "" {
-		loggedInIP = r.RemoteAddr
-		if loggedInIP == "" {
-			loggedInIP = "unknown"
-		}
-	}
-	if loggedInIP != "unknown" {
-		userData.LastLoginIP = net.ParseIP(loggedInIP)
-	} else {
-		userData.LastLoginIP = nil
-	}
+	// middleware.RealIP already resolved the client IP behind the proxies, nil if it is not known.
+	userData.LastLoginIP = ClientIP(r)
 
 	// insert the user
 	user := s.StmtBuilder.Insert("users").
@@ -265,7 +370,8 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 			UserCityDBField,
 			UserCountryDBField,
 			UserStateDBField,
-			UserLastLoginIPDBField).
+			UserLastLoginIPDBField,
+			UserSignupIPDBField).
 		Values(
 			userData.ID,
 			userData.Email,
@@ -280,6 +386,7 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 			userData.Country,
 			userData.State,
 			userData.LastLoginIP,
+			userData.LastLoginIP,
 		)
 	if _, err = s.ExecuteSQL(user); err != nil {
 		if signUpForm.Test {
@@ -297,6 +404,7 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 				Set(UserCountryDBField, userData.Country).
 				Set(UserStateDBField, userData.State).
 				Set(UserLastLoginIPDBField, userData.LastLoginIP).
+				Set(UserSignupIPDBField, userData.LastLoginIP).
 				Set(UserLastLoginAtDBField, userData.LastLoginAt).
 				Set(UserIDDBField, userData.ID)
 			userQuery := user.Where(squirrel.Eq{UserEmailDBField: userData.Email})
@@ -324,11 +432,36 @@ func UserSignupHandler(w http.ResponseWriter, r *http.Request) {
 				s.LogError(err, http.StatusInternalServerError)
 				return
 			}
+		} else if IsUsernameConflict(err) {
+			// a concurrent signup took the username in another case after the check.
+			s.LogError(UsernameAlreadyExistsError, http.StatusBadRequest)
+			return
 		} else {
 			s.LogError(err, http.StatusInternalServerError)
 			return
 		}
 	}
+	// the signup succeeded even if it can not be scored, the reviews of the user are scored anyway.
+	if err = CheckSignupSpam(s.DB, userData.ID.String()); err != nil {
+		s.Logger.Error(fmt.Sprintf("could not score the signup of %s for spam: %v", userData.ID, err))
+	}
+	// the signup is the first session of the user, logging in with its token continues the session.
+	sessionID, err := s.StartSession(w, r, userData.ID.String(), loginMethodSignup)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	loginToken, err := SignSessionToken(userData.ID.String(), roleUser, tokenStatusWaitingLogin, 0, sessionID, tokenDurationLogin)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).
+		Set(UserSessionTokenDBField, loginToken).
+		Where(squirrel.Eq{UserIDDBField: userData.ID})); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
 	r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", loginToken))
 	GetUser(r)
 }
@@ -357,7 +490,7 @@ type UserLoginResponse GetUserDataResponse
 // UserLoginHandler handles the HTTP request for user login.
 //
 //	@Summary					Handle user login
-//	@Description				Handles the HTTP request for user login.
+//	@Description				Handles the HTTP request for user login. With session=cookie the session token is set in an HttpOnly cookie with a CSRF cookie instead of the body, the next requests are authenticated with the cookie and the unsafe ones must send the CSRF cookie in the X-CSRF-Token header. Logging in with the cookie and no body refreshes the cookies.
 //	@Tags						User
 //	@Accept						json
 //	@Produce					json
@@ -365,23 +498,59 @@ type UserLoginResponse GetUserDataResponse
 //	@securityDefinitions.apikey	ApiKeyAuth
 //	@in							header
 //	@name						Authorization
-//	@description				Bearer {JWT} | Whitelist: None. Body is not required if Authorization header is set.
+//	@description				Bearer {JWT} or the session cookie | Whitelist: None. Body is not required if Authorization header or the session cookie is set.
 //
+//	@Param						session	query		string				false	"cookie to use the cookie session mode"
 //	@Param						body	body		UserLoginRequest	true	"Login form data"
-//	@Success					200		{object}	UserLoginResponse	"Successful login"
-//	@Failure					400		{object}	ErrorResponse		"Bad request or unauthorized"
-//	@Failure					500		{object}	ErrorResponse		"Internal server error"
+//	@Success					200		{object}	UserLoginResponse			"Successful login"
+//	@Success					202		{object}	UserLoginTwoFactorResponse	"Password is correct, user has 2FA enabled. Exchange the login token at /api/user/login/2fa"
+//	@Failure					400		{object}	ErrorResponse				"Bad request or unauthorized"
+//	@Failure					401		{object}	ErrorResponse				"Wrong password, or the token is revoked or does not belong to a session"
+//	@Failure					403		{object}	ErrorResponse				"User is banned, or an admin requires a password reset"
+//	@Failure					500		{object}	ErrorResponse				"Internal server error"
 //	@Router						/api/user/login [post]
 func UserLoginHandler(w http.ResponseWriter, r *http.Request) {
 	s := r.Context().Value(ServerKeyString).(*Server)
 	var uid string
-	if r.Header.Get("Authorization") != "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+	var tokenVersion int64
+	var role string
+	var totpEnabled bool
+	var sessionID string
+	method := loginMethodPassword
+	// the session cookie is used only without a login form, a stale cookie must not block the password login.
+	_, hasSessionCookie := sessionCookieToken(r)
+	if (r.Header.Get("Authorization") != "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")) || (hasSessionCookie && r.ContentLength == 0) {
 		jwtContents, err := s.GetJWTData()
 		if err != nil {
 			s.LogError(err, http.StatusBadRequest)
 			return
 		}
+		// also rejects the WAITING_LOGIN tokens of users with 2FA, they must be exchanged at /login/2fa.
+		if err = s.CheckTokenRevoked(jwtContents); err != nil {
+			s.LogError(err, TokenErrorStatus(err))
+			return
+		}
+		// impersonation tokens expire, they are not exchanged for the tokens of the user.
+		if jwtContents.Impersonated() {
+			s.LogError(ImpersonationNotAllowedError, http.StatusForbidden)
+			return
+		}
+		// only the tokens of a session are exchanged, so a revoked device can not log in again. The shared refresh
+		// tokens issued before the sessions have none.
+		if jwtContents.SessionID == "" {
+			s.LogError(SessionTokenRequiredError, http.StatusUnauthorized)
+			return
+		}
 		uid = jwtContents.UUID
+		tokenVersion = jwtContents.Version
+		role, totpEnabled, err = s.GetUserRoleAndTwoFactor(uid)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		// logging in with the token of a session continues the session, not to list the device again.
+		sessionID = jwtContents.SessionID
+		method = loginMethodToken
 	} else {
 		var signInForm UserLoginRequest
 		if err := s.Bind(&signInForm); err != nil {
@@ -395,7 +564,7 @@ func UserLoginHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
-		sqlBuilder := s.StmtBuilder.Select(UserPasswordDBField, UserIDDBField).From("users")
+		sqlBuilder := s.StmtBuilder.Select(UserPasswordDBField, UserIDDBField, UserTokenVersionDBField, UserRoleDBField, UserTOTPEnabledDBField, UserPasswordResetRequiredDBField).From("users")
 		if signInForm.Email != "" {
 			sqlBuilder = sqlBuilder.Where(squirrel.Eq{UserEmailDBField: signInForm.Email})
 		} else if signInForm.Username != "" {
@@ -408,32 +577,67 @@ func UserLoginHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		var i = 0
+		var passwordResetRequired bool
 		for rows.Next() {
 			i++
 			var password string
-			err = rows.Scan(&password, &uid)
+			err = rows.Scan(&password, &uid, &tokenVersion, &role, &totpEnabled, &passwordResetRequired)
 			if err != nil {
 				s.LogError(err, http.StatusUnauthorized)
 				return
 			}
-			err = bcrypt.CompareHashAndPassword([]byte(password), []byte(signInForm.Password))
+			ok, needsRehash, err := VerifyPassword(password, signInForm.Password)
 			if err != nil {
 				s.LogError(err, http.StatusUnauthorized)
 				return
 			}
+			if !ok {
+				s.LogError(WrongPasswordError, http.StatusUnauthorized)
+				return
+			}
+			if needsRehash {
+				// the password is known only now, upgrade the hash to the current algorithm and parameters. login must
+				// not fail if this does not work, the old hash is still valid.
+				if err = s.RehashPassword(uid, password, signInForm.Password); err != nil {
+					s.Logger.Error(fmt.Sprintf("could not rehash the password of %s: %v", uid, err))
+				}
+			}
 		}
 		if i == 0 {
 			s.LogError(UserDoesNotExistError, http.StatusUnauthorized)
 			return
 		}
+		// checked after the password, not to tell who is banned to anyone who knows the username.
+		if err = s.CheckUserBan(uid); err != nil {
+			s.LogError(err, TokenErrorStatus(err))
+			return
+		}
+		if passwordResetRequired {
+			s.LogError(PasswordResetRequiredError, http.StatusForbidden)
+			return
+		}
+		if totpEnabled {
+			// password is correct, but the session is not active until the second factor is verified.
+			loginToken, err := SignToken(uid, TokenRole(role, totpEnabled), tokenStatusWaitingLogin, tokenVersion, tokenDurationTwoFactor)
+			if err != nil {
+				s.LogError(err, http.StatusInternalServerError)
+				return
+			}
+			s.WriteResponse(UserLoginTwoFactorResponse{TwoFactorRequired: true, LoginToken: loginToken}, http.StatusAccepted)
+			return
+		}
+	}
+	var tokenToSend string
+	var err error
+	if sessionID != "" {
+		if err = s.ExtendSession(sessionID); err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		tokenToSend, err = SignSessionToken(uid, TokenRole(role, totpEnabled), tokenStatusActive, tokenVersion, sessionID, tokenDurationActive)
+	} else {
+		tokenToSend, err = s.IssueSessionToken(w, r, uid, TokenRole(role, totpEnabled), tokenVersion, method)
 	}
-	tokenAuth := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		JWTUUIDKey:    uid,
-		JWTExpiresKey: time.Now().Add(time.Hour * 24 * 30).Unix(),
-		JWTRoleKey:    roleUser,
-		JWTStatusKey:  tokenStatusActive,
-	})
-	tokenToSend, err := tokenAuth.SignedString([]byte(JWT_ENCRYPT_KEY))
 	if err != nil {
 		s.LogError(err, http.StatusInternalServerError)
 		return
@@ -469,7 +673,7 @@ type UserUpdateResponse GetUserDataResponse
 // UserUpdateHandler handles the user update request.
 //
 //	@Summary					Update User
-//	@Description				Handles the request to update a user's email or username.
+//	@Description				Handles the request to update a user's email or username. A new email is held as pending until the link sent to it is confirmed, the old email gets a link to revert the change.
 //	@Tags						User
 //	@securityDefinitions.apikey	ApiKeyAuth
 //	@in							header
@@ -480,6 +684,8 @@ type UserUpdateResponse GetUserDataResponse
 //	@Success					200					{object}	UserUpdateResponse	"Updated user data"
 //	@Failure					400					{object}	ErrorResponse		"Bad request, may occur if the request is invalid, or user cant update username or email for now"
 //	@Failure					401					{object}	ErrorResponse		"Unauthorized, may occur if the JWT token is invalid or expired"
+//	@Failure					403					{object}	ErrorResponse		"Impersonation token"
+//	@Failure					429					{object}	ErrorResponse		"An email change is just requested"
 //	@Failure					500					{object}	ErrorResponse		"Internal server error"
 //	@Router						/api/user/update [post]
 func UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -487,6 +693,7 @@ func UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	jwtContents, err := s.GetJWTData()
 	if err != nil {
 		s.LogError(err, http.StatusBadRequest)
+		return
 	}
 	var req UserUpdateRequest
 	if err := s.Bind(&req); err != nil {
@@ -511,25 +718,49 @@ func UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		From("users").
 		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})
 	rows, err := s.QuerySQL(userQuery)
-	defer rows.Close()
 	if err != nil {
 		s.LogError(err, http.StatusInternalServerError)
 		return
 	}
-	err = rows.Scan(&user.EmailLastUpdatedAt, &user.UsernameLastUpdatedAt, &user.Email, &user.Username, &user.SessionToken)
+	if rows.Next() {
+		err = rows.Scan(&user.EmailLastUpdatedAt, &user.UsernameLastUpdatedAt, &user.Email, &user.Username, &user.SessionToken)
+	} else {
+		err = UUIDDoesNotExistError(jwtContents.UUID)
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	// only the changed columns are written. A new email is held as pending until it is confirmed, except in tests.
+	changes := make(map[string]interface{})
+	var pendingEmail string
 	if req.Email != "" {
 		if !req.Test {
 			if req.Email == user.Email {
 				s.LogError(EmailIsSameWithRequestedError, http.StatusBadRequest)
 				return
 			}
-			if time.Now().Sub(user.EmailLastUpdatedAt) < AllowedUsernameUpdateInterval {
+			if time.Now().Sub(user.EmailLastUpdatedAt) < AllowedUserEmailUpdateInterval {
 				s.LogError(UpdatedRecentlyError("email", user.EmailLastUpdatedAt, AllowedUserEmailUpdateInterval), http.StatusBadRequest)
 				return
 			}
 		}
-		user.Email = req.Email
-		user.EmailLastUpdatedAt = time.Now()
+		if req.Email != user.Email {
+			if req.Test {
+				changes[UserEmailDBField] = req.Email
+				changes[UserEmailLastUpdatedAtDBField] = time.Now()
+			} else {
+				if taken, err := s.IsUserFieldTaken(UserEmailDBField, req.Email, jwtContents.UUID); err != nil {
+					s.LogError(err, http.StatusInternalServerError)
+					return
+				} else if taken {
+					s.LogError(EmailAlreadyExistsError, http.StatusBadRequest)
+					return
+				}
+				pendingEmail = req.Email
+			}
+		}
 	}
 	if req.Username != "" {
 		if !req.Test {
@@ -541,19 +772,37 @@ func UserUpdateHandler(w http.ResponseWriter, r *http.Request) {
 				s.LogError(UpdatedRecentlyError("username", user.UsernameLastUpdatedAt, AllowedUsernameUpdateInterval), http.StatusBadRequest)
 				return
 			}
+			if err = s.Validator.Var(req.Username, "usernameSpec"); err != nil {
+				s.LogError(err, http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Username != user.Username {
+			// reserved usernames and the usernames of the other users are rejected in the test mode as well.
+			if status, err := s.CheckUsernameChange(req.Username, jwtContents.UUID); err != nil {
+				s.LogError(err, status)
+				return
+			}
+			setUsernameChange(changes, req.Username, time.Now())
+		}
+	}
+	if pendingEmail != "" {
+		if status, err := s.RequestEmailChange(jwtContents.UUID, user.Email, pendingEmail); err != nil {
+			s.LogError(err, status)
+			return
+		}
+	}
+	if len(changes) > 0 {
+		changes[UserUpdatedAtDBField] = time.Now()
+		updateQuery := s.StmtBuilder.Update("users").SetMap(changes).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})
+		if _, err := s.ExecuteSQL(updateQuery); IsUsernameConflict(err) {
+			// a concurrent change took the username in another case after the check.
+			s.LogError(UsernameAlreadyExistsError, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
 		}
-		user.Username = req.Username
-		user.UsernameLastUpdatedAt = time.Now()
-	}
-	updateQuery := s.StmtBuilder.Update("users").SetMap(map[string]interface{}{
-		UserEmailDBField:                 user.Email,
-		UserUsernameDBField:              user.Username,
-		UserEmailLastUpdatedAtDBField:    user.EmailLastUpdatedAt,
-		UserUsernameLastUpdatedAtDBField: user.UsernameLastUpdatedAt,
-	}).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})
-	if _, err := s.ExecuteSQL(updateQuery); err != nil {
-		s.LogError(err, http.StatusInternalServerError)
-		return
 	}
 	GetUser(r)
 
@@ -571,9 +820,21 @@ type GetUserDataResponse struct {
 		// Email of the user.
 		Email string `json:"email"`
 
+		// New email of the pending email change, null if there is none. It becomes the email once it is confirmed.
+		PendingEmail *string `json:"pendingEmail"`
+
 		// Username of the user.
 		Username string `json:"username"`
 
+		// Display name of the user, empty if not set.
+		DisplayName string `json:"displayName"`
+
+		// Bio of the user, empty if not set.
+		Bio string `json:"bio"`
+
+		// URLs of the avatar thumbnails, null if the user has no avatar.
+		Avatar *AvatarURLs `json:"avatar"`
+
 		// Timestamp indicating when the user was created.
 		CreatedAt time.Time `json:"createdAt"`
 
@@ -583,6 +844,9 @@ type GetUserDataResponse struct {
 		// Phone number of the user.
 		PhoneNumber string `json:"phoneNumber"`
 
+		// Flag indicating if the phone number is verified with a code sent to it.
+		PhoneVerified bool `json:"phoneVerified"`
+
 		// Role of the user.
 		Role string `json:"role"`
 
@@ -620,7 +884,7 @@ type GetUserDataResponse struct {
 	// Session token for the user.
 	SessionToken string `json:"sessionToken"`
 
-	// Refresh token for the user.
+	// Refresh token of the session, logging in with it continues the session. Empty for the tokens without a session.
 	RefreshToken string `json:"refreshToken"`
 }
 
@@ -636,14 +900,20 @@ func GetUser(r *http.Request) {
 		Select(
 			fmt.Sprintf("%s.%s", UserTableName, UserIDDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserEmailDBField),
+			fmt.Sprintf("(SELECT %s FROM %s WHERE %s = %s.%s AND %s IS NULL AND %s IS NULL AND %s IS NULL AND %s > NOW())",
+				EmailChangeNewEmailDBField, EmailChangeTableName, EmailChangeUserIDDBField, UserTableName, UserIDDBField,
+				EmailChangeConfirmedAtDBField, EmailChangeRevertedAtDBField, EmailChangeCancelledAtDBField, EmailChangeConfirmExpiresAtDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserUsernameDBField),
+			fmt.Sprintf("COALESCE(%s.%s, '')", UserTableName, UserDisplayNameDBField),
+			fmt.Sprintf("COALESCE(%s.%s, '')", UserTableName, UserBioDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserAvatarKeyDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserCreatedAtDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserUpdatedAtDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserPhoneNumberDBField),
+			fmt.Sprintf("%s.%s", UserTableName, UserPhoneVerifiedDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserRoleDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserBannedDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserReputationDBField),
-			fmt.Sprintf("%s.%s", UserTableName, UserRefreshTokenDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserVerifiedDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserEmailLastUpdatedAtDBField),
 			fmt.Sprintf("%s.%s", UserTableName, UserUsernameLastUpdatedAtDBField),
@@ -665,18 +935,23 @@ func GetUser(r *http.Request) {
 	}
 
 	var response GetUserDataResponse
+	var avatarKey *string
 	if user.Next() {
 		err = user.Scan(
 			&response.User.ID,
 			&response.User.Email,
+			&response.User.PendingEmail,
 			&response.User.Username,
+			&response.User.DisplayName,
+			&response.User.Bio,
+			&avatarKey,
 			&response.User.CreatedAt,
 			&response.User.UpdatedAt,
 			&response.User.PhoneNumber,
+			&response.User.PhoneVerified,
 			&response.User.Role,
 			&response.User.Banned,
 			&response.User.Reputation,
-			&response.RefreshToken,
 			&response.User.Verified,
 			&response.User.EmailLastUpdatedAt,
 			&response.User.UsernameLastUpdatedAt,
@@ -692,7 +967,25 @@ func GetUser(r *http.Request) {
 		s.LogError(UUIDDoesNotExistError(jwtContents.UUID), http.StatusBadRequest)
 		return
 	}
+	response.User.Avatar = s.AvatarURLs(avatarKey)
+	// the refresh token belongs to the session of the token, revoking the session revokes it as well.
+	if jwtContents.SessionID != "" {
+		response.RefreshToken, err = SignRefreshToken(jwtContents.UUID, jwtContents.Role, jwtContents.Version, jwtContents.SessionID)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
 	response.SessionToken = strings.Replace(r.Header.Get("Authorization"), "Bearer ", "", -1)
+	if response.SessionToken == "" {
+		response.SessionToken, _ = sessionCookieToken(r)
+	}
+	// the tokens are not readable by the web app in the cookie session mode.
+	if SessionCookieMode(r) {
+		SetSessionCookies(s.Writer, response.SessionToken)
+		response.SessionToken = ""
+		response.RefreshToken = ""
+	}
 	if s.WriteResponse(response, http.StatusOK) != nil {
 		s.LogError(err, http.StatusInternalServerError)
 		return
@@ -707,14 +1000,19 @@ type UserDeleteResponse struct {
 	// Success indicates if the user was deleted successfully.
 	// Required: true
 	Success bool `json:"success"`
+
+	// RestorableUntil is the end of the grace period, logging in before it restores the account.
+	RestorableUntil time.Time `json:"restorableUntil"`
 }
 
 // UserDeleteHandler deletes a user based on the provided JWT token.
 //
-// This endpoint deletes the user associated with the provided JWT token.
+// This endpoint deletes the user associated with the provided JWT token. The account is only marked as deleted and
+// every session is ended, logging in within the grace period restores it. After the grace period
+// AnonymizeDeletedUsers removes the personal data, see deletion.go.
 //
 //	@Summary					Delete User
-//	@Description				Deletes a user based on the provided JWT token.
+//	@Description				Deletes a user based on the provided JWT token. Logging in within the grace period restores the account.
 //	@Tags						User
 //	@securityDefinitions.apikey	ApiKeyAuth
 //	@in							header
@@ -722,6 +1020,7 @@ type UserDeleteResponse struct {
 //	@description				Bearer {JWT} | Whitelist: Anyone that already logged in once.
 //	@Success					200	{object}	UserDeleteResponse	"User successfully deleted."
 //	@Failure					400	{object}	ErrorResponse		"Bad request, may occur if the JWT token is invalid or expired"
+//	@Failure					403	{object}	ErrorResponse		"Impersonation token"
 //	@Failure					500	{object}	ErrorResponse		"Internal server error"
 //	@Router						/api/user/delete [delete]
 func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
@@ -731,12 +1030,45 @@ func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		s.LogError(err, http.StatusBadRequest)
 		return
 	}
-	deleteQuery := s.StmtBuilder.Delete(UserTableName).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})
-	if _, err = s.ExecuteSQL(deleteQuery); err != nil {
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.DB.Begin(to)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(to)
+	deletedAt := time.Now()
+	// bumping the token version ends every session, logging in is the only way back in.
+	sql, args, err := s.StmtBuilder.Update(UserTableName).
+		Set(UserDeletedAtDBField, deletedAt).
+		Set(UserTokenVersionDBField, squirrel.Expr(fmt.Sprintf("%s + 1", UserTokenVersionDBField))).
+		Set(UserSessionTokenDBField, "").
+		Set(UserRefreshTokenDBField, "").
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID, UserDeletedAtDBField: nil}).
+		ToSql()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	res, err := tx.Exec(to, sql, args...)
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		s.LogError(UserDoesNotExistError, http.StatusBadRequest)
+		return
+	}
+	if err = s.RevokeSessions(to, tx, jwtContents.UUID, ""); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	if err = tx.Commit(to); err != nil {
 		s.LogError(err, http.StatusInternalServerError)
 		return
 	}
-	if err = s.WriteResponse(UserDeleteResponse{Success: true}, http.StatusOK); err != nil {
+	if err = s.WriteResponse(UserDeleteResponse{Success: true, RestorableUntil: deletedAt.Add(AccountDeletionGracePeriod())}, http.StatusOK); err != nil {
 		s.LogError(err, http.StatusInternalServerError)
 		return
 	}
@@ -1,17 +1,29 @@
 package core
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+	"image"
+	"image/png"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -82,6 +94,8 @@ func (suite *UserTestSuite) DeleteAndCreateUser() {
 }
 
 func (suite *UserTestSuite) SetupSuite() {
+	// codes sent by SMS are read from memorySMSSender.
+	os.Setenv("SMS_SENDER", "memory")
 	suite.Server = httptest.NewServer(HandlerFunc())
 	db, err := GetPgPool()
 	assert.Nil(suite.T(), err)
@@ -191,6 +205,1507 @@ func (suite *UserTestSuite) TestEmailAndPasswordUpdate() {
 
 }
 
+// TestUserUpdateUsername replicates a scenario where:
+//
+// -> User tries to take a reserved username, or the username of another user in another case, with /update.
+//
+// -> Both are rejected, and the username is not changed.
+func (suite *UserTestSuite) TestUserUpdateUsername() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	otherEmail := "other_" + TestEmail
+	otherUsername := "otherPersephoneUser"
+	sql, args, err := suite.StmtBuilder.Delete(UserTableName).Where(squirrel.Or{
+		squirrel.Eq{UserEmailDBField: otherEmail},
+		squirrel.Expr(fmt.Sprintf("LOWER(%s) = LOWER(?)", UserUsernameDBField), otherUsername),
+	}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	jsonPayload, err := json.Marshal(UserSignupRequest{Email: otherEmail, Username: otherUsername, Password: TestPassword, Test: true, PhoneNum: "+905555555553", City: TestCity, Country: TestCountry, State: TestState})
+	assert.Nil(suite.T(), err)
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/signup", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	update := func(username string) int {
+		jsonPayload, err := json.Marshal(UserUpdateRequest{Username: username, Test: true})
+		assert.Nil(suite.T(), err)
+		draftReq, err := http.NewRequest("POST", suite.Server.URL+"/api/user/update", strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req.StatusCode
+	}
+	assert.Equal(suite.T(), 400, update("Admin"))
+	assert.Equal(suite.T(), 400, update(strings.ToUpper(otherUsername)))
+	var username string
+	sql, args, err = suite.StmtBuilder.Select(UserUsernameDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&username))
+	assert.Equal(suite.T(), TestUsername, username)
+	sql, args, err = suite.StmtBuilder.Delete(UserTableName).Where(squirrel.Eq{UserEmailDBField: otherEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	suite.CleanClient()
+}
+
+// TestUserPatch replicates a scenario where:
+//
+// -> User sets the display name and bio with a merge patch, absent members are not changed.
+//
+// -> Unknown members, null for non-nullable members, a city outside the state and other media types are rejected.
+//
+// -> Email can not be changed right after signup, changing the phone number resets its verification.
+func (suite *UserTestSuite) TestUserPatch() {
+	suite.DeleteAndCreateUser()
+	patch := func(contentType string, body string) (int, GetUserDataResponse) {
+		draftReq, err := http.NewRequest("PATCH", suite.Server.URL+"/api/user", strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		draftReq.Header.Set("Content-Type", contentType)
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		var resp GetUserDataResponse
+		if req.StatusCode == 200 {
+			assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+		}
+		return req.StatusCode, resp
+	}
+	status, resp := patch(MergePatchContentType, `{"displayName": "Zort", "bio": "zort\nzattiri"}`)
+	assert.Equal(suite.T(), 200, status)
+	assert.Equal(suite.T(), "Zort", resp.User.DisplayName)
+	assert.Equal(suite.T(), "zort\nzattiri", resp.User.Bio)
+	assert.Equal(suite.T(), TestUsername, resp.User.Username)
+	status, resp = patch(MergePatchContentType, `{"bio": null}`)
+	assert.Equal(suite.T(), 200, status)
+	assert.Equal(suite.T(), "Zort", resp.User.DisplayName)
+	assert.Equal(suite.T(), "", resp.User.Bio)
+	status, _ = patch(MergePatchContentType, `{"role": "ADMIN"}`)
+	assert.Equal(suite.T(), 400, status)
+	status, _ = patch(MergePatchContentType, `{"username": null}`)
+	assert.Equal(suite.T(), 400, status)
+	status, _ = patch(MergePatchContentType, `{"location": {"stateId": 65535}}`)
+	assert.Equal(suite.T(), 400, status)
+	status, _ = patch("text/plain", `{}`)
+	assert.Equal(suite.T(), 415, status)
+	status, _ = patch(MergePatchContentType, `{"email": "zattiri_`+TestEmail+`"}`)
+	assert.Equal(suite.T(), 400, status)
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sql, args, err := suite.StmtBuilder.Update(UserTableName).
+		Set(UserPhoneVerifiedDBField, true).
+		Where(squirrel.Eq{UserEmailDBField: TestEmail}).
+		ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	status, resp = patch(MergePatchContentType, `{"phoneNumber": "+905555555554"}`)
+	assert.Equal(suite.T(), 200, status)
+	assert.Equal(suite.T(), "+905555555554", resp.User.PhoneNumber)
+	assert.False(suite.T(), resp.User.PhoneVerified)
+	suite.CleanClient()
+}
+
+// TestUserAvatar replicates a scenario where:
+//
+// -> User uploads a PNG avatar, thumbnails are served and shown in the user data.
+//
+// -> Files that are not JPEG, PNG or WebP are rejected whatever their content type is.
+//
+// -> User deletes the avatar, the thumbnails are removed.
+func (suite *UserTestSuite) TestUserAvatar() {
+	suite.DeleteAndCreateUser()
+	upload := func(data []byte, contentType string) (int, UserAvatarResponse) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="avatar"; filename="avatar"`)
+		header.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(header)
+		assert.Nil(suite.T(), err)
+		_, err = part.Write(data)
+		assert.Nil(suite.T(), err)
+		assert.Nil(suite.T(), writer.Close())
+		draftReq, err := http.NewRequest("PUT", suite.Server.URL+"/api/user/avatar", &body)
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		draftReq.Header.Set("Content-Type", writer.FormDataContentType())
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		var resp UserAvatarResponse
+		if req.StatusCode == 200 {
+			assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+		}
+		return req.StatusCode, resp
+	}
+	// the URLs have the base URL of the app, the test server has another one.
+	fetch := func(rawURL string) int {
+		parsed, err := url.Parse(rawURL)
+		assert.Nil(suite.T(), err)
+		req, err := suite.Server.Client().Get(suite.Server.URL + parsed.Path)
+		assert.Nil(suite.T(), err)
+		return req.StatusCode
+	}
+	var buf bytes.Buffer
+	assert.Nil(suite.T(), png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 300, 200))))
+	status, resp := upload(buf.Bytes(), "image/png")
+	assert.Equal(suite.T(), 200, status)
+	assert.NotNil(suite.T(), resp.Avatar)
+	assert.Equal(suite.T(), 200, fetch(resp.Avatar.Small))
+	assert.Equal(suite.T(), 200, fetch(resp.Avatar.Large))
+	draftReq, err := http.NewRequest("PATCH", suite.Server.URL+"/api/user", strings.NewReader(`{}`))
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err := suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	var user GetUserDataResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&user))
+	assert.Equal(suite.T(), resp.Avatar, user.User.Avatar)
+	status, _ = upload([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"/>"), "image/png")
+	assert.Equal(suite.T(), 415, status)
+	draftReq, err = http.NewRequest("DELETE", suite.Server.URL+"/api/user/avatar", nil)
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	assert.Equal(suite.T(), 404, fetch(resp.Avatar.Small))
+	suite.CleanClient()
+}
+
+// TestUserPasswordReset replicates a scenario where:
+//
+// -> User forgets the password and requests a reset link, response does not differ for an unknown email.
+//
+// -> User resets the password with the emailed token, token can not be used again.
+//
+// -> Session token issued before the reset is revoked, no refresh token is issued, user logs in with the new password.
+func (suite *UserTestSuite) TestUserPasswordReset() {
+	suite.DeleteAndCreateUser()
+	for _, email := range []string{TestEmail, "nobody_" + TestEmail} {
+		jsonPayload, err := json.Marshal(UserPasswordForgotRequest{Email: email})
+		assert.Nil(suite.T(), err)
+		req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/password/forgot", "application/json", strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), 200, req.StatusCode)
+	}
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	var uid string
+	err = suite.DB.QueryRow(to, sql, args...).Scan(&uid)
+	assert.Nil(suite.T(), err)
+	// the link is sent in background, and no other link is sent for a minute.
+	s := Server{DB: suite.DB, StmtBuilder: suite.StmtBuilder}
+	assert.Eventually(suite.T(), func() bool {
+		throttled, err := s.passwordResetThrottled(uid)
+		return err == nil && throttled
+	}, 5*time.Second, 50*time.Millisecond)
+	// we dont have access to the mailbox, so create a token of our own.
+	token, tokenHash, err := GenerateSecureToken()
+	assert.Nil(suite.T(), err)
+	sql, args, err = suite.StmtBuilder.Insert(PasswordResetTokenTableName).
+		Columns(PasswordResetTokenUserIDDBField, PasswordResetTokenHashDBField, PasswordResetTokenExpiresAtDBField).
+		Values(uid, tokenHash, time.Now().Add(time.Minute)).
+		ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	newPassword := "456$persephoneYeni"
+	jsonPayload, err := json.Marshal(UserPasswordResetRequest{Token: token, Password: newPassword})
+	assert.Nil(suite.T(), err)
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/password/reset", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	// token is single use.
+	req, err = suite.Server.Client().Post(suite.Server.URL+"/api/user/password/reset", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	// session token from the signup is revoked.
+	draftReq, err := http.NewRequest("POST", suite.Server.URL+"/api/user/login", nil)
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	// no refresh token is issued by the reset.
+	sql, args, err = suite.StmtBuilder.Select(UserRefreshTokenDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	var refreshToken string
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&refreshToken))
+	assert.Empty(suite.T(), refreshToken)
+	jsonPayload, err = json.Marshal(UserLoginRequest{Email: TestEmail, Password: newPassword, Test: true})
+	assert.Nil(suite.T(), err)
+	req, err = suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	suite.CleanClient()
+}
+
+// TestUserPasswordChange replicates a scenario where:
+//
+// -> User logs in and tries to change the password with a wrong current password, or with the same password.
+//
+// -> User changes the password, gets a new session token, and the signup session is ended.
+func (suite *UserTestSuite) TestUserPasswordChange() {
+	suite.DeleteAndCreateUser()
+	jsonPayload, err := json.Marshal(UserLoginRequest{Email: TestEmail, Password: TestPassword, Test: true})
+	assert.Nil(suite.T(), err)
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var loginResp UserLoginResponse
+	err = json.NewDecoder(req.Body).Decode(&loginResp)
+	assert.Nil(suite.T(), err)
+	changePassword := func(payload UserPasswordChangeRequest, sessionToken string) *http.Response {
+		jsonPayload, err := json.Marshal(payload)
+		assert.Nil(suite.T(), err)
+		draftReq, err := http.NewRequest("POST", suite.Server.URL+"/api/user/password", strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", sessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	newPassword := "456$persephoneYeni"
+	req = changePassword(UserPasswordChangeRequest{CurrentPassword: newPassword, NewPassword: newPassword}, loginResp.SessionToken)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	req = changePassword(UserPasswordChangeRequest{CurrentPassword: TestPassword, NewPassword: TestPassword}, loginResp.SessionToken)
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = changePassword(UserPasswordChangeRequest{CurrentPassword: TestPassword, NewPassword: newPassword}, loginResp.SessionToken)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var changeResp UserPasswordChangeResponse
+	err = json.NewDecoder(req.Body).Decode(&changeResp)
+	assert.Nil(suite.T(), err)
+	assert.NotEqual(suite.T(), loginResp.SessionToken, changeResp.SessionToken)
+	// old password is in the history now.
+	req = changePassword(UserPasswordChangeRequest{CurrentPassword: newPassword, NewPassword: TestPassword}, changeResp.SessionToken)
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	draftReq, err := http.NewRequest("POST", suite.Server.URL+"/api/user/login", nil)
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserAPIKeys() {
+	suite.DeleteAndCreateUser()
+	send := func(method string, path string, payload interface{}, authorization string) *http.Response {
+		jsonPayload, err := json.Marshal(payload)
+		assert.Nil(suite.T(), err)
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", authorization)
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	bearer := fmt.Sprintf("Bearer %s", suite.SessionToken)
+	expiresAt := time.Now().Add(24 * time.Hour)
+	req := send("POST", "/api/user/api-keys", UserAPIKeyCreateRequest{Label: "partner", Scopes: []string{"unknown:scope"}, ExpiresAt: expiresAt}, bearer)
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = send("POST", "/api/user/api-keys", UserAPIKeyCreateRequest{Label: "partner", Scopes: []string{scopeWorldRead}, ExpiresAt: time.Now().Add(2 * apiKeyMaxLifetime)}, bearer)
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = send("POST", "/api/user/api-keys", UserAPIKeyCreateRequest{Label: "partner", Scopes: []string{scopeWorldRead}, ExpiresAt: expiresAt}, bearer)
+	assert.Equal(suite.T(), 201, req.StatusCode)
+	var worldKey UserAPIKeyCreateResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&worldKey))
+	assert.True(suite.T(), strings.HasPrefix(worldKey.Key, worldKey.Prefix))
+	req = send("POST", "/api/user/api-keys", UserAPIKeyCreateRequest{Label: "places", Scopes: []string{scopePlacesRead}, ExpiresAt: expiresAt}, bearer)
+	assert.Equal(suite.T(), 201, req.StatusCode)
+	var placesKey UserAPIKeyCreateResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&placesKey))
+	countries := GetCountriesRequest{Page: 1, PageSize: 10}
+	req = send("POST", "/api/world/getCountries", countries, APIKeyAuthorizationToken+worldKey.Key)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req = send("POST", "/api/world/getCountries", countries, APIKeyAuthorizationToken+placesKey.Key)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	req = send("GET", "/api/user/places/recommended?lat=41.01&lon=28.97", nil, APIKeyAuthorizationToken+placesKey.Key)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req = send("GET", "/api/user/places/recommended?lat=41.01&lon=28.97", nil, APIKeyAuthorizationToken+worldKey.Key)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	req = send("POST", "/api/world/getCountries", countries, APIKeyAuthorizationToken+worldKey.Key+"x")
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	// keys can not manage keys.
+	req = send("GET", "/api/user/api-keys", nil, APIKeyAuthorizationToken+worldKey.Key)
+	assert.NotEqual(suite.T(), 200, req.StatusCode)
+	req = send("GET", "/api/user/api-keys", nil, bearer)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var keys UserAPIKeysResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&keys))
+	assert.Len(suite.T(), keys.Keys, 2)
+	for _, key := range keys.Keys {
+		if key.ID == worldKey.ID {
+			assert.Equal(suite.T(), int64(2), key.UsageCount)
+			assert.NotNil(suite.T(), key.LastUsedAt)
+		}
+	}
+	req = send("DELETE", "/api/user/api-keys/"+worldKey.ID, nil, bearer)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req = send("DELETE", "/api/user/api-keys/"+worldKey.ID, nil, bearer)
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	req = send("POST", "/api/world/getCountries", countries, APIKeyAuthorizationToken+worldKey.Key)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserPhoneVerification() {
+	suite.DeleteAndCreateUser()
+	send := func(path string, payload interface{}) *http.Response {
+		jsonPayload, err := json.Marshal(payload)
+		assert.Nil(suite.T(), err)
+		draftReq, err := http.NewRequest("POST", suite.Server.URL+path, strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	req := send("/api/user/phone/verify/send", nil)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	message, ok := memorySMSSender.Last(TestPhone)
+	assert.True(suite.T(), ok)
+	code := regexp.MustCompile(`\d{6}`).FindString(message)
+	assert.Len(suite.T(), code, phoneCodeDigits)
+	// resend is rate limited.
+	req = send("/api/user/phone/verify/send", nil)
+	assert.Equal(suite.T(), 429, req.StatusCode)
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	req = send("/api/user/phone/verify", UserPhoneVerificationRequest{Code: wrongCode})
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = send("/api/user/phone/verify", UserPhoneVerificationRequest{Code: code})
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var resp GetUserDataResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+	assert.True(suite.T(), resp.User.PhoneVerified)
+	assert.False(suite.T(), resp.User.Verified)
+	// codes are single use.
+	req = send("/api/user/phone/verify", UserPhoneVerificationRequest{Code: code})
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	suite.CleanClient()
+}
+
+// TestUserPhoneRelease replicates a scenario where:
+//
+// -> Two users have the same unverified phone number, one of them verifies it.
+//
+// -> The number is removed from the other user, who can not take it back nor ask for a code.
+func (suite *UserTestSuite) TestUserPhoneRelease() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	phoneNumber := "+905555555552"
+	otherEmail := "other_" + TestEmail
+	otherUsername := "otherPersephoneUser"
+	sql, args, err := suite.StmtBuilder.Delete(UserTableName).Where(squirrel.Or{
+		squirrel.Eq{UserEmailDBField: otherEmail},
+		squirrel.Expr(fmt.Sprintf("LOWER(%s) = LOWER(?)", UserUsernameDBField), otherUsername),
+	}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	send := func(method string, path string, contentType string, body string, token string) int {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		draftReq.Header.Set("Content-Type", contentType)
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req.StatusCode
+	}
+	assert.Equal(suite.T(), 200, send("PATCH", "/api/user", MergePatchContentType, `{"phoneNumber": "`+phoneNumber+`"}`, suite.SessionToken))
+	jsonPayload, err := json.Marshal(UserSignupRequest{Email: otherEmail, Username: otherUsername, Password: TestPassword, Test: true, PhoneNum: phoneNumber, City: TestCity, Country: TestCountry, State: TestState})
+	assert.Nil(suite.T(), err)
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/signup", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var signup UserSignupResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&signup))
+	assert.Equal(suite.T(), 200, send("POST", "/api/user/phone/verify/send", "application/json", "", signup.SessionToken))
+	message, ok := memorySMSSender.Last(phoneNumber)
+	assert.True(suite.T(), ok)
+	code := regexp.MustCompile(`\d{6}`).FindString(message)
+	jsonPayload, err = json.Marshal(UserPhoneVerificationRequest{Code: code})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, send("POST", "/api/user/phone/verify", "application/json", string(jsonPayload), signup.SessionToken))
+	var userPhoneNumber string
+	sql, args, err = suite.StmtBuilder.Select(UserPhoneNumberDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&userPhoneNumber))
+	assert.Empty(suite.T(), userPhoneNumber)
+	assert.Equal(suite.T(), 400, send("POST", "/api/user/phone/verify/send", "application/json", "", suite.SessionToken))
+	assert.Equal(suite.T(), 400, send("PATCH", "/api/user", MergePatchContentType, `{"phoneNumber": "`+phoneNumber+`"}`, suite.SessionToken))
+	sql, args, err = suite.StmtBuilder.Delete(UserTableName).Where(squirrel.Eq{UserEmailDBField: otherEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	suite.CleanClient()
+}
+
+// TestUserPhoneVerificationAttempts replicates a scenario where a code is guessed concurrently, the attempts over
+// phoneCodeMaxAttempts are rejected even if they arrive together.
+func (suite *UserTestSuite) TestUserPhoneVerificationAttempts() {
+	suite.DeleteAndCreateUser()
+	send := func(path string, payload interface{}) int {
+		jsonPayload, err := json.Marshal(payload)
+		assert.Nil(suite.T(), err)
+		draftReq, err := http.NewRequest("POST", suite.Server.URL+path, strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		req.Body.Close()
+		return req.StatusCode
+	}
+	assert.Equal(suite.T(), 200, send("/api/user/phone/verify/send", nil))
+	message, ok := memorySMSSender.Last(TestPhone)
+	assert.True(suite.T(), ok)
+	code := regexp.MustCompile(`\d{6}`).FindString(message)
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	var wg sync.WaitGroup
+	statuses := make(chan int, 4*phoneCodeMaxAttempts)
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- send("/api/user/phone/verify", UserPhoneVerificationRequest{Code: wrongCode})
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	assert.Equal(suite.T(), map[int]int{400: phoneCodeMaxAttempts, 429: 3 * phoneCodeMaxAttempts}, counts)
+	// the code is burned.
+	assert.Equal(suite.T(), 429, send("/api/user/phone/verify", UserPhoneVerificationRequest{Code: code}))
+	suite.CleanClient()
+}
+
+// TestUserPasswordRehash replicates a scenario where a user with a bcrypt hash from before argon2id logs in, and the
+// hash is upgraded.
+func (suite *UserTestSuite) TestUserPasswordRehash() {
+	suite.DeleteAndCreateUser()
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(TestPassword), bcrypt.MinCost)
+	assert.Nil(suite.T(), err)
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sql, args, err := suite.StmtBuilder.Update(UserTableName).Set(UserPasswordDBField, string(bcryptHash)).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	jsonPayload, err := json.Marshal(UserLoginRequest{Email: TestEmail, Password: TestPassword, Test: true})
+	assert.Nil(suite.T(), err)
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	sql, args, err = suite.StmtBuilder.Select(UserPasswordDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	var hash string
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&hash))
+	assert.True(suite.T(), strings.HasPrefix(hash, "$argon2id$"))
+	ok, needsRehash, err := VerifyPassword(hash, TestPassword)
+	assert.Nil(suite.T(), err)
+	assert.True(suite.T(), ok)
+	assert.False(suite.T(), needsRehash)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserSessions() {
+	suite.DeleteAndCreateUser()
+	login := func(userAgent string) GetUserDataResponse {
+		jsonPayload, err := json.Marshal(UserLoginRequest{Email: TestEmail, Password: TestPassword, Test: true})
+		assert.Nil(suite.T(), err)
+		draftReq, err := http.NewRequest("POST", suite.Server.URL+"/api/user/login", strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("User-Agent", userAgent)
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), 200, req.StatusCode)
+		var user GetUserDataResponse
+		assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&user))
+		return user
+	}
+	send := func(method string, path string, token string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, nil)
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	desktop := login("Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0").SessionToken
+	phoneLogin := login("Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36")
+	phone := phoneLogin.SessionToken
+	assert.NotEmpty(suite.T(), phoneLogin.RefreshToken)
+	req := send("GET", "/api/user/sessions", desktop)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var sessions UserSessionsResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&sessions))
+	// the signup is a session as well.
+	assert.Len(suite.T(), sessions.Sessions, 3)
+	var phoneSessionID string
+	for _, session := range sessions.Sessions {
+		if session.Current {
+			assert.Equal(suite.T(), "Firefox", session.Browser)
+		} else if session.DeviceType == "mobile" {
+			phoneSessionID = session.ID
+		}
+	}
+	assert.NotEmpty(suite.T(), phoneSessionID)
+	// the refresh token continues the session it is issued for.
+	req = send("POST", "/api/user/login", phoneLogin.RefreshToken)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req = send("DELETE", "/api/user/sessions/"+phoneSessionID, desktop)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req = send("DELETE", "/api/user/sessions/"+phoneSessionID, desktop)
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	// the revoked device is logged out and can not log in again, the other one is not.
+	req = send("GET", "/api/user/sessions", phone)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	req = send("POST", "/api/user/login", phoneLogin.RefreshToken)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	req = send("GET", "/api/user/sessions", desktop)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	// tokens without a session, such as the shared refresh tokens, are not exchanged.
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	var version int64
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField, UserTokenVersionDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid, &version))
+	sharedRefreshToken, err := SignToken(uid, roleUser, tokenStatusRefresh, version, tokenDurationRefresh)
+	assert.Nil(suite.T(), err)
+	req = send("POST", "/api/user/login", sharedRefreshToken)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserPublicProfile() {
+	suite.DeleteAndCreateUser()
+	req, err := suite.Server.Client().Get(suite.Server.URL + "/api/users/" + TestUsername)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	body, err := io.ReadAll(req.Body)
+	assert.Nil(suite.T(), err)
+	// private columns never leak.
+	assert.NotContains(suite.T(), string(body), TestEmail)
+	assert.NotContains(suite.T(), string(body), TestPhone)
+	var profile PublicUserProfile
+	assert.Nil(suite.T(), json.Unmarshal(body, &profile))
+	assert.Equal(suite.T(), TestUsername, profile.Username)
+	assert.NotNil(suite.T(), profile.Location)
+	assert.NotNil(suite.T(), profile.ReviewCount)
+	jsonPayload, err := json.Marshal(UserProfilePrivacyRequest{HiddenFields: []string{profileFieldLocation, profileFieldRecentReviews}})
+	assert.Nil(suite.T(), err)
+	draftReq, err := http.NewRequest("PUT", suite.Server.URL+"/api/user/profile/privacy", strings.NewReader(string(jsonPayload)))
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req, err = suite.Server.Client().Get(suite.Server.URL + "/api/users/" + TestUsername)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	profile = PublicUserProfile{}
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&profile))
+	assert.Nil(suite.T(), profile.Location)
+	assert.Nil(suite.T(), profile.RecentReviews)
+	assert.NotNil(suite.T(), profile.Reputation)
+	assert.Equal(suite.T(), []string{profileFieldLocation, profileFieldRecentReviews}, profile.HiddenFields)
+	req, err = suite.Server.Client().Get(suite.Server.URL + "/api/users/" + TestUsername + "-does-not-exist")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserSoftDelete() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	login := func() int {
+		jsonPayload, err := json.Marshal(UserLoginRequest{Email: TestEmail, Password: TestPassword, Test: true})
+		assert.Nil(suite.T(), err)
+		req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json", strings.NewReader(string(jsonPayload)))
+		assert.Nil(suite.T(), err)
+		return req.StatusCode
+	}
+	deleteUser := func() {
+		draftReq, err := http.NewRequest("DELETE", suite.Server.URL+"/api/user/delete", nil)
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), 200, req.StatusCode)
+	}
+	deletedAt := func() (*time.Time, *time.Time) {
+		sql, args, err := suite.StmtBuilder.Select(UserDeletedAtDBField, UserAnonymizedAtDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+		assert.Nil(suite.T(), err)
+		var deleted, anonymized *time.Time
+		assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&deleted, &anonymized))
+		return deleted, anonymized
+	}
+	deleteUser()
+	deleted, _ := deletedAt()
+	assert.NotNil(suite.T(), deleted)
+	// sessions are ended and the profile is gone.
+	req, err := suite.Server.Client().Get(suite.Server.URL + "/api/users/" + TestUsername)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	// logging in within the grace period restores the account.
+	assert.Equal(suite.T(), 200, login())
+	deleted, _ = deletedAt()
+	assert.Nil(suite.T(), deleted)
+	// after the grace period the account is anonymized, and can not be logged in.
+	suite.DeleteAndCreateUser()
+	deleteUser()
+	sql, args, err := suite.StmtBuilder.Update(UserTableName).
+		Set(UserDeletedAtDBField, time.Now().Add(-AccountDeletionGracePeriod()-time.Hour)).
+		Where(squirrel.Eq{UserUsernameDBField: TestUsername}).
+		Suffix(fmt.Sprintf("RETURNING %s", UserIDDBField)).
+		ToSql()
+	assert.Nil(suite.T(), err)
+	var uid string
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid))
+	AnonymizeDeletedUsers(suite.DB)
+	email, username, _ := AnonymizedUserFields(uid)
+	sql, args, err = suite.StmtBuilder.Select(UserEmailDBField, UserUsernameDBField, UserAnonymizedAtDBField).From(UserTableName).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	var anonymizedEmail, anonymizedUsername string
+	var anonymizedAt *time.Time
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&anonymizedEmail, &anonymizedUsername, &anonymizedAt))
+	assert.Equal(suite.T(), email, anonymizedEmail)
+	assert.Equal(suite.T(), username, anonymizedUsername)
+	assert.NotNil(suite.T(), anonymizedAt)
+	assert.Equal(suite.T(), 401, login())
+	// the tombstone does not match the test user anymore, give the username back so the next test can delete it.
+	sql, args, err = suite.StmtBuilder.Update(UserTableName).Set(UserUsernameDBField, TestUsername).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserDataExport() {
+	suite.DeleteAndCreateUser()
+	suite.T().Setenv("DATA_EXPORT_DIR", suite.T().TempDir())
+	send := func(method string, path string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, nil)
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	req := send("POST", "/api/user/export")
+	assert.Equal(suite.T(), 202, req.StatusCode)
+	var export UserDataExport
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&export))
+	assert.Equal(suite.T(), dataExportStatusPending, export.Status)
+	req = send("POST", "/api/user/export")
+	assert.Equal(suite.T(), 429, req.StatusCode)
+	ProcessDataExports(suite.DB)
+	req = send("GET", "/api/user/export")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var exports UserDataExportsResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&exports))
+	assert.Len(suite.T(), exports.Exports, 1)
+	assert.Equal(suite.T(), dataExportStatusReady, exports.Exports[0].Status)
+	// the emailed token is not known to the test, replace it.
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	token, tokenHash, err := GenerateSecureToken()
+	assert.Nil(suite.T(), err)
+	sql, args, err := suite.StmtBuilder.Update(DataExportTableName).Set(DataExportTokenHashDBField, tokenHash).Where(squirrel.Eq{DataExportIDDBField: export.ID}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	req, err = suite.Server.Client().Get(suite.Server.URL + "/api/user/export/download?token=" + token + "x")
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	req, err = suite.Server.Client().Get(suite.Server.URL + "/api/user/export/download?token=" + token)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	archive, err := io.ReadAll(req.Body)
+	assert.Nil(suite.T(), err)
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	assert.Nil(suite.T(), err)
+	var profile map[string]interface{}
+	assert.Nil(suite.T(), json.Unmarshal(readZipFile(suite.T(), zr, "profile.json"), &profile))
+	assert.Equal(suite.T(), TestEmail, profile[UserEmailDBField])
+	assert.NotContains(suite.T(), profile, UserPasswordDBField)
+	for _, name := range []string{"login_history", "reviews", "review_replies", "reports", "identities", "api_keys", "reputation", "badges", "bans", "following", "notifications", "email_changes"} {
+		_, err = zr.Open(name + ".csv")
+		assert.Nil(suite.T(), err, name)
+	}
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserReputation() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid))
+	tx, err := suite.DB.Begin(to)
+	assert.Nil(suite.T(), err)
+	reputation, err := RecordReputationEvent(to, tx, suite.StmtBuilder, ReputationEvent{UserID: uid, EventType: reputationEventAdjustment, Points: 120, Reason: "test"})
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), int64(120), reputation)
+	assert.Nil(suite.T(), tx.Commit(to))
+	// the sync restores the reputation from the ledger.
+	sql, args, err = suite.StmtBuilder.Update(UserTableName).Set(UserReputationDBField, 0).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	SyncReputation(suite.DB)
+	draftReq, err := http.NewRequest("GET", suite.Server.URL+"/api/user/reputation", nil)
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err := suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var response UserReputationResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&response))
+	assert.Equal(suite.T(), int64(120), response.Reputation)
+	assert.Len(suite.T(), response.Badges, 1)
+	assert.Equal(suite.T(), "contributor", response.Badges[0].Name)
+	assert.Len(suite.T(), response.Events, 1)
+	assert.Equal(suite.T(), reputationEventAdjustment, response.Events[0].EventType)
+	for _, privilege := range response.Privileges {
+		assert.Equal(suite.T(), privilege.Threshold <= 120, privilege.Unlocked, privilege.Name)
+	}
+	// users can not moderate.
+	draftReq, err = http.NewRequest("POST", suite.Server.URL+"/api/moderation/users/"+uid+"/reputation", strings.NewReader(`{"points":1000,"reason":"test"}`))
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserBan() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid))
+	sessions := func() (*http.Response, ErrorResponse) {
+		draftReq, err := http.NewRequest("GET", suite.Server.URL+"/api/user/sessions", nil)
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		var errResp ErrorResponse
+		if req.StatusCode != 200 {
+			assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&errResp))
+		}
+		return req, errResp
+	}
+	// the user bans itself, the moderator must exist.
+	expiresAt := time.Now().Add(time.Hour)
+	tx, err := suite.DB.Begin(to)
+	assert.Nil(suite.T(), err)
+	_, err = IssueBan(to, tx, suite.StmtBuilder, uid, uid, roleAdmin, "spam", &expiresAt)
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), tx.Commit(to))
+	req, errResp := sessions()
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	assert.Equal(suite.T(), errorCodeUserSuspended, errResp.Code)
+	assert.NotNil(suite.T(), errResp.ExpiresAt)
+	req, err = suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"email":%q,"password":%q,"test":true}`, TestEmail, TestPassword)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	// the suspension is over.
+	sql, args, err = suite.StmtBuilder.Update(UserBanTableName).Set(UserBanExpiresAtDBField, time.Now()).Where(squirrel.Eq{UserBanUserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	sql, args, err = suite.StmtBuilder.Update(UserTableName).Set(UserBannedUntilDBField, time.Now()).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	LiftExpiredBans(suite.DB)
+	req, _ = sessions()
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	// permanent bans are lifted by the moderators only.
+	tx, err = suite.DB.Begin(to)
+	assert.Nil(suite.T(), err)
+	_, err = IssueBan(to, tx, suite.StmtBuilder, uid, uid, roleAdmin, "spam again", nil)
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), tx.Commit(to))
+	LiftExpiredBans(suite.DB)
+	req, errResp = sessions()
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	assert.Equal(suite.T(), errorCodeUserBanned, errResp.Code)
+	// the ban of a moderator is lifted by the admins only, whatever the case of the roles.
+	sql, args, err = suite.StmtBuilder.Update(UserTableName).Set(UserRoleDBField, "moderator").Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	tx, err = suite.DB.Begin(to)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), UserNotAllowedError, LiftBan(to, tx, suite.StmtBuilder, uid, uid, roleModerator, "appeal accepted"))
+	assert.Nil(suite.T(), tx.Rollback(to))
+	tx, err = suite.DB.Begin(to)
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), LiftBan(to, tx, suite.StmtBuilder, uid, uid, roleAdmin, "appeal accepted"))
+	assert.Equal(suite.T(), UserNotBannedError, LiftBan(to, tx, suite.StmtBuilder, uid, uid, roleAdmin, "appeal accepted"))
+	assert.Nil(suite.T(), tx.Commit(to))
+	req, _ = sessions()
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	s := Server{DB: suite.DB, StmtBuilder: suite.StmtBuilder}
+	bans, err := s.GetUserBans(uid)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), bans, 2)
+	assert.Equal(suite.T(), "appeal accepted", *bans[0].LiftReason)
+	assert.Equal(suite.T(), userBanLiftReasonExpired, *bans[1].LiftReason)
+	suite.CleanClient()
+}
+
+// TestPlaceEdit replicates a scenario where:
+//
+// -> User without enough reputation tries to edit a place, and is rejected.
+//
+// -> User earns the edit_places privilege and edits the place.
+func (suite *UserTestSuite) TestPlaceEdit() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var placeID string
+	sql, args, err := suite.StmtBuilder.Insert(RestaurantsTable).
+		Columns(RestaurantNameDBField, RestaurantCuisineDBField).
+		Values("Persephone Test Kebab", "kebab").
+		Suffix(fmt.Sprintf("RETURNING %s", RestaurantIDDBField)).
+		ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&placeID))
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"email":%q,"password":%q,"test":true}`, TestEmail, TestPassword)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var loginResp UserLoginResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&loginResp))
+	setReputation := func(reputation int64) {
+		sql, args, err := suite.StmtBuilder.Update(UserTableName).Set(UserReputationDBField, reputation).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+		assert.Nil(suite.T(), err)
+		_, err = suite.DB.Exec(to, sql, args...)
+		assert.Nil(suite.T(), err)
+	}
+	edit := func(body string) (int, Place) {
+		draftReq, err := http.NewRequest("PATCH", suite.Server.URL+"/api/places/"+placeID, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", loginResp.SessionToken))
+		draftReq.Header.Set("Content-Type", MergePatchContentType)
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		var place Place
+		if req.StatusCode == 200 {
+			assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&place))
+		}
+		return req.StatusCode, place
+	}
+	setReputation(499)
+	status, _ := edit(`{"website": "https://example.com"}`)
+	assert.Equal(suite.T(), 403, status)
+	setReputation(500)
+	status, place := edit(`{"website": "https://example.com", "cuisine": null}`)
+	assert.Equal(suite.T(), 200, status)
+	assert.Equal(suite.T(), "Persephone Test Kebab", place.Name)
+	assert.Nil(suite.T(), place.Cuisine)
+	assert.NotNil(suite.T(), place.Website)
+	status, _ = edit(`{"name": null}`)
+	assert.Equal(suite.T(), 400, status)
+	setReputation(0)
+	sql, args, err = suite.StmtBuilder.Delete(RestaurantsTable).Where(squirrel.Eq{RestaurantIDDBField: placeID}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserSpamScore() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid))
+	s := Server{DB: suite.DB, StmtBuilder: suite.StmtBuilder}
+	spam, err := s.getUserSpam(uid)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), spam.Checks, 1)
+	assert.Equal(suite.T(), spamSourceSignup, spam.Checks[0].Source)
+	// the review does not need to exist, spam_checks.source_id is not a foreign key.
+	assert.Nil(suite.T(), CheckContentSpam(suite.DB, uid, spamSourceReview, uuid.NewString(), "best kebab in town https://example.com"))
+	spam, err = s.getUserSpam(uid)
+	assert.Nil(suite.T(), err)
+	assert.Len(suite.T(), spam.Checks, 2)
+	assert.Equal(suite.T(), SpamScore([]string{spamReasonLinkDensity}), spam.Score)
+	assert.Equal(suite.T(), []string{spamReasonLinkDensity}, spam.Reasons)
+	assert.False(suite.T(), spam.PossibleSpammer)
+	// users can not override the flag.
+	draftReq, err := http.NewRequest("PUT", suite.Server.URL+"/api/moderation/users/"+uid+"/spam", strings.NewReader(`{"possibleSpammer":false}`))
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err := suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserFollow() {
+	suite.DeleteAndCreateUser()
+	do := func(method string, path string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, nil)
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	req := do("PUT", "/api/users/"+TestUsername+"/follow")
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = do("PUT", "/api/users/"+TestUsername+"-does-not-exist/follow")
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	req = do("GET", "/api/users/"+TestUsername+"/followers?limit=5")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var followers FollowListResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&followers))
+	assert.Equal(suite.T(), int64(0), followers.Count)
+	assert.Empty(suite.T(), followers.Users)
+	assert.Empty(suite.T(), followers.NextCursor)
+	req = do("GET", "/api/users/"+TestUsername+"/following?limit=500")
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	// a user who follows nobody has an empty feed.
+	req = do("GET", "/api/user/feed")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var feed FeedResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&feed))
+	assert.Empty(suite.T(), feed.Items)
+	assert.Empty(suite.T(), feed.NextCursor)
+	req = do("GET", "/api/user/feed?cursor=not-a-cursor")
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserNotifications() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid))
+	do := func(method string, path string, body string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	notify := func() {
+		tx, err := suite.DB.Begin(to)
+		assert.Nil(suite.T(), err)
+		assert.Nil(suite.T(), CreateNotification(to, tx, suite.StmtBuilder, NotificationEvent{UserID: uid, Type: notificationTypeModeration, Message: "A moderator adjusted your reputation by +5: helpful"}))
+		assert.Nil(suite.T(), tx.Commit(to))
+	}
+	notify()
+	req := do("GET", "/api/notifications?unread=true", "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var notifications NotificationsResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&notifications))
+	assert.Len(suite.T(), notifications.Notifications, 1)
+	assert.Equal(suite.T(), int64(1), notifications.UnreadCount)
+	assert.Equal(suite.T(), int64(1), notifications.UnreadCounts[notificationTypeModeration])
+	req = do("POST", "/api/notifications/read", "{}")
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = do("POST", "/api/notifications/read", fmt.Sprintf(`{"ids":[%q]}`, notifications.Notifications[0].ID))
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var unread NotificationUnreadResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&unread))
+	assert.Equal(suite.T(), int64(0), unread.UnreadCount)
+	// turned off types are not notified.
+	req = do("PUT", "/api/notifications/preferences", `{"disabledTypes":["moderation","moderation"]}`)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var preferences NotificationPreferencesResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&preferences))
+	assert.Equal(suite.T(), []string{notificationTypeModeration}, preferences.DisabledTypes)
+	notify()
+	req = do("GET", "/api/notifications", "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	notifications = NotificationsResponse{}
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&notifications))
+	assert.Len(suite.T(), notifications.Notifications, 1)
+	assert.Equal(suite.T(), int64(0), notifications.UnreadCount)
+	req = do("PUT", "/api/notifications/preferences", `{"disabledTypes":["email"]}`)
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	suite.CleanClient()
+}
+
+// TestUserNotificationStreamExpiry replicates a scenario where the token of an open stream expires, the stream is
+// closed.
+func (suite *UserTestSuite) TestUserNotificationStreamExpiry() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	var version int64
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField, UserTokenVersionDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid, &version))
+	token, err := SignToken(uid, roleUser, tokenStatusActive, version, 2*time.Second)
+	assert.Nil(suite.T(), err)
+	draftReq, err := http.NewRequestWithContext(to, "GET", suite.Server.URL+"/api/notifications/stream", nil)
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req, err := suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	// the body ends before the context of the request times out.
+	body, err := io.ReadAll(req.Body)
+	assert.Nil(suite.T(), err)
+	assert.Contains(suite.T(), string(body), "event: unread")
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserDietaryProfile() {
+	suite.DeleteAndCreateUser()
+	do := func(method string, path string, body string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	req := do("GET", "/api/user/dietary-profile", "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var profile DietaryProfile
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&profile))
+	assert.Empty(suite.T(), profile.DietRestrictions)
+	assert.Equal(suite.T(), distanceUnitKilometres, profile.DistanceUnit)
+	req = do("PUT", "/api/user/dietary-profile", `{"dietRestrictions":["vegan","halal","vegan"],"favouriteCuisines":["Turkish","ice cream"],"allergens":["peanuts"],"preferredLanguage":"tr","distanceUnit":"mi"}`)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	req = do("GET", "/api/user/dietary-profile", "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	profile = DietaryProfile{}
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&profile))
+	assert.Equal(suite.T(), []string{dietVegan, dietHalal}, profile.DietRestrictions)
+	assert.Equal(suite.T(), []string{"turkish", "ice_cream"}, profile.FavouriteCuisines)
+	assert.Equal(suite.T(), "tr", *profile.PreferredLanguage)
+	assert.Equal(suite.T(), distanceUnitMiles, profile.DistanceUnit)
+	for _, body := range []string{`{"dietRestrictions":["paleo"]}`, `{"favouriteCuisines":["döner"]}`, `{"distanceUnit":"ft"}`, `{"preferredLanguage":"not a language"}`} {
+		req = do("PUT", "/api/user/dietary-profile", body)
+		assert.Equal(suite.T(), 400, req.StatusCode, body)
+	}
+	req = do("GET", "/api/user/places/recommended?lat=41.01&lon=28.97&radius=2", "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var recommendations PlaceRecommendationsResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&recommendations))
+	assert.Equal(suite.T(), distanceUnitMiles, recommendations.DistanceUnit)
+	assert.Equal(suite.T(), []string{dietHalal, dietVegan}, recommendations.RequiredDiets)
+	assert.Equal(suite.T(), []string{"peanuts"}, recommendations.UnfilteredAllergens)
+	for _, place := range recommendations.Places {
+		assert.Subset(suite.T(), place.Diets, recommendations.RequiredDiets)
+	}
+	suite.CleanClient()
+}
+
+// TestUserEmailChange replicates a scenario where:
+//
+// -> User requests a new email, the email is not changed and the new one is pending.
+//
+// -> The new email confirms the change, then the old email reverts it and every session is revoked.
+func (suite *UserTestSuite) TestUserEmailChange() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	exec := func(query squirrel.Sqlizer) {
+		sql, args, err := query.ToSql()
+		assert.Nil(suite.T(), err)
+		_, err = suite.DB.Exec(to, sql, args...)
+		assert.Nil(suite.T(), err)
+	}
+	// skip the cooldown after signup.
+	exec(suite.StmtBuilder.Update(UserTableName).
+		Set(UserEmailLastUpdatedAtDBField, time.Now().Add(-AllowedUserEmailUpdateInterval)).
+		Where(squirrel.Eq{UserEmailDBField: TestEmail}))
+	newEmail := "zattiri_" + TestEmail
+	draftReq, err := http.NewRequest("PATCH", suite.Server.URL+"/api/user", strings.NewReader(fmt.Sprintf(`{"email": %q}`, newEmail)))
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	draftReq.Header.Set("Content-Type", MergePatchContentType)
+	req, err := suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var resp GetUserDataResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+	assert.Equal(suite.T(), TestEmail, resp.User.Email)
+	assert.Equal(suite.T(), newEmail, *resp.User.PendingEmail)
+	emailLastUpdatedAt := resp.User.EmailLastUpdatedAt
+	// the emailed tokens are not readable here, replace their hashes with known tokens.
+	exec(suite.StmtBuilder.Update(EmailChangeTableName).
+		Set(EmailChangeConfirmTokenHashDBField, HashToken("confirm")).
+		Set(EmailChangeRevertTokenHashDBField, HashToken("revert")).
+		Where(squirrel.Eq{EmailChangeOldEmailDBField: TestEmail}))
+	post := func(path string, token string) (int, UserEmailChangeResponse) {
+		req, err := suite.Server.Client().Post(suite.Server.URL+path, "application/json", strings.NewReader(fmt.Sprintf(`{"token": %q}`, token)))
+		assert.Nil(suite.T(), err)
+		var resp UserEmailChangeResponse
+		if req.StatusCode == 200 {
+			assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+		}
+		return req.StatusCode, resp
+	}
+	status, _ := post("/api/user/email/confirm", "revert")
+	assert.Equal(suite.T(), 400, status)
+	status, changed := post("/api/user/email/confirm", "confirm")
+	assert.Equal(suite.T(), 200, status)
+	assert.Equal(suite.T(), newEmail, changed.Email)
+	status, _ = post("/api/user/email/confirm", "confirm")
+	assert.Equal(suite.T(), 400, status)
+	sql, args, err := suite.StmtBuilder.Select(UserEmailDBField, UserEmailLastUpdatedAtDBField).From(UserTableName).Where(squirrel.Eq{UserEmailDBField: newEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	var email string
+	var updatedAt time.Time
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&email, &updatedAt))
+	assert.True(suite.T(), updatedAt.After(emailLastUpdatedAt))
+	status, changed = post("/api/user/email/revert", "revert")
+	assert.Equal(suite.T(), 200, status)
+	assert.Equal(suite.T(), TestEmail, changed.Email)
+	status, _ = post("/api/user/email/revert", "revert")
+	assert.Equal(suite.T(), 400, status)
+	// the sessions are revoked by the revert.
+	draftReq, err = http.NewRequest("GET", suite.Server.URL+"/api/user/sessions", nil)
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", suite.SessionToken))
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.NotEqual(suite.T(), 200, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUsernameAvailability() {
+	suite.DeleteAndCreateUser()
+	check := func(username string) (int, UsernameAvailabilityResponse) {
+		req, err := suite.Server.Client().Get(suite.Server.URL + "/api/user/username-available?u=" + url.QueryEscape(username))
+		assert.Nil(suite.T(), err)
+		var resp UsernameAvailabilityResponse
+		if req.StatusCode == 200 {
+			assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+		}
+		return req.StatusCode, resp
+	}
+	status, resp := check(strings.ToUpper(TestUsername))
+	assert.Equal(suite.T(), 200, status)
+	assert.False(suite.T(), resp.Available)
+	assert.Equal(suite.T(), usernameReasonTaken, resp.Reason)
+	assert.NotEmpty(suite.T(), resp.Suggestions)
+	for _, suggestion := range resp.Suggestions {
+		_, suggested := check(suggestion)
+		assert.True(suite.T(), suggested.Available, suggestion)
+	}
+	_, resp = check("Admin")
+	assert.Equal(suite.T(), usernameReasonReserved, resp.Reason)
+	_, resp = check("x_x")
+	assert.Equal(suite.T(), usernameReasonInvalid, resp.Reason)
+	_, resp = check("zortzattiri")
+	assert.True(suite.T(), resp.Available)
+	assert.Empty(suite.T(), resp.Suggestions)
+	status, _ = check("")
+	assert.Equal(suite.T(), 400, status)
+	for i := 0; i < usernameAvailabilityLimit && status != 429; i++ {
+		status, _ = check("zortzattiri")
+	}
+	assert.Equal(suite.T(), 429, status)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestAdminUsers() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	var version int64
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField, UserTokenVersionDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid, &version))
+	do := func(method string, path string, token string, body string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	// users can not use the admin endpoints.
+	req := do("GET", "/api/admin/users", suite.SessionToken, "")
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	adminToken, err := SignToken(uid, roleAdmin, tokenStatusActive, version, time.Hour)
+	assert.Nil(suite.T(), err)
+	req = do("GET", "/api/admin/users?verified=maybe", adminToken, "")
+	assert.Equal(suite.T(), 400, req.StatusCode)
+	req = do("GET", "/api/admin/users?limit=1&banned=false&username="+strings.ToUpper(TestUsername[1:]), adminToken, "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var users AdminUsersResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&users))
+	assert.Len(suite.T(), users.Users, 1)
+	assert.Equal(suite.T(), uid, users.Users[0].ID)
+	req = do("GET", "/api/admin/users/"+uuid.NewString(), adminToken, "")
+	assert.Equal(suite.T(), 404, req.StatusCode)
+	req = do("GET", "/api/admin/users/"+uid, adminToken, "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var detail AdminUserDetailResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&detail))
+	assert.Equal(suite.T(), TestEmail, detail.User.Email)
+	assert.Empty(suite.T(), detail.ReportsReceived)
+	req = do("PUT", "/api/admin/users/"+uid+"/role", adminToken, `{"role":"EDITOR"}`)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	// every action is in the audit log.
+	req = do("GET", "/api/admin/audit?userId="+uid, adminToken, "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var audit AuditLogResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&audit))
+	assert.Len(suite.T(), audit.Records, 1)
+	assert.Equal(suite.T(), auditActionUserView, audit.Records[0].Action)
+	keyPayload, err := json.Marshal(UserAPIKeyCreateRequest{Label: "partner", Scopes: []string{scopeWorldRead}, ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Nil(suite.T(), err)
+	req = do("POST", "/api/user/api-keys", suite.SessionToken, string(keyPayload))
+	assert.Equal(suite.T(), 201, req.StatusCode)
+	var key UserAPIKeyCreateResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&key))
+	// the forced password reset ends the sessions and the API keys, and blocks the password login.
+	req = do("POST", "/api/admin/users/"+uid+"/password-reset", adminToken, "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var user AdminUser
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&user))
+	assert.True(suite.T(), user.PasswordResetRequired)
+	req = do("GET", "/api/user/sessions", suite.SessionToken, "")
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	draftReq, err := http.NewRequest("POST", suite.Server.URL+"/api/world/getCountries", strings.NewReader(`{"page":1,"page_size":10}`))
+	assert.Nil(suite.T(), err)
+	draftReq.Header.Set("Authorization", APIKeyAuthorizationToken+key.Key)
+	req, err = suite.Server.Client().Do(draftReq)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	req, err = suite.Server.Client().Post(suite.Server.URL+"/api/user/login", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"email":%q,"password":%q,"test":true}`, TestEmail, TestPassword)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 403, req.StatusCode)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestAdminImpersonation() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var uid string
+	var version int64
+	sql, args, err := suite.StmtBuilder.Select(UserIDDBField, UserTokenVersionDBField).From(UserTableName).Where(squirrel.Eq{UserUsernameDBField: TestUsername}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&uid, &version))
+	do := func(method string, path string, token string, body string) int {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req.StatusCode
+	}
+	adminToken, err := SignToken(uid, roleAdmin, tokenStatusActive, version, time.Hour)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 400, do("POST", "/api/admin/users/"+uid+"/impersonate", adminToken, `{}`))
+	assert.Equal(suite.T(), 403, do("POST", "/api/admin/users/"+uid+"/impersonate", adminToken, `{"reason":"checking the feed"}`))
+	// the user impersonates itself, the token is rejected until the actor is an admin.
+	token, err := SignImpersonationToken(uid, roleUser, version, uid, time.Minute)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 401, do("GET", "/api/user/sessions", token, ""))
+	sql, args, err = suite.StmtBuilder.Update(UserTableName).Set(UserRoleDBField, roleAdmin).Set(UserTOTPEnabledDBField, true).Where(squirrel.Eq{UserIDDBField: uid}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, do("GET", "/api/user/sessions", token, ""))
+	assert.Equal(suite.T(), 403, do("POST", "/api/user/password", token, fmt.Sprintf(`{"currentPassword":%q,"newPassword":"An0ther-Passw0rd!"}`, TestPassword)))
+	assert.Equal(suite.T(), 403, do("DELETE", "/api/user/delete", token, ""))
+	assert.Equal(suite.T(), 403, do("PATCH", "/api/user", token, `{"email":"impersonated@example.com"}`))
+	assert.Equal(suite.T(), 403, do("PATCH", "/api/user", token, `{"username":"impersonated"}`))
+	assert.Equal(suite.T(), 403, do("PATCH", "/api/user", token, `{"phoneNumber":"+905555555554"}`))
+	assert.Equal(suite.T(), 403, do("POST", "/api/user/phone/verify/send", token, ""))
+	assert.Equal(suite.T(), 403, do("DELETE", "/api/user/sessions/"+uuid.NewString(), token, ""))
+	assert.Equal(suite.T(), 403, do("POST", "/api/user/login", token, ""))
+	// every request that passed the token checks is audited.
+	var requests int
+	sql, args, err = suite.StmtBuilder.Select("COUNT(*)").From(AuditLogTableName).Where(squirrel.Eq{
+		AuditLogActorIDDBField:      uid,
+		AuditLogTargetUserIDDBField: uid,
+		AuditLogActionDBField:       auditActionImpersonationRequest,
+	}).ToSql()
+	assert.Nil(suite.T(), err)
+	assert.Nil(suite.T(), suite.DB.QueryRow(to, sql, args...).Scan(&requests))
+	assert.Equal(suite.T(), 8, requests)
+	suite.CleanClient()
+}
+
+func (suite *UserTestSuite) TestUserCookieSession() {
+	suite.DeleteAndCreateUser()
+	req, err := suite.Server.Client().Post(suite.Server.URL+"/api/user/login?session=cookie", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"email":%q,"password":%q,"test":true}`, TestEmail, TestPassword)))
+	assert.Nil(suite.T(), err)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var resp UserLoginResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+	// the tokens are only in the cookies.
+	assert.Empty(suite.T(), resp.SessionToken)
+	assert.Empty(suite.T(), resp.RefreshToken)
+	var session, csrf string
+	for _, cookie := range req.Cookies() {
+		switch cookie.Name {
+		case sessionCookieName:
+			session = cookie.Value
+			assert.True(suite.T(), cookie.HttpOnly)
+		case csrfCookieName:
+			csrf = cookie.Value
+		}
+	}
+	assert.NotEmpty(suite.T(), session)
+	assert.Equal(suite.T(), CSRFToken(session), csrf)
+	do := func(method string, path string, csrf string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, nil)
+		assert.Nil(suite.T(), err)
+		draftReq.AddCookie(&http.Cookie{Name: sessionCookieName, Value: session})
+		if csrf != "" {
+			draftReq.Header.Set(csrfHeaderName, csrf)
+		}
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	assert.Equal(suite.T(), 200, do("GET", "/api/user/sessions", "").StatusCode)
+	assert.Equal(suite.T(), 403, do("POST", "/api/user/logout", "").StatusCode)
+	assert.Equal(suite.T(), 403, do("POST", "/api/user/logout", CSRFToken("forged")).StatusCode)
+	// logging in with the cookie refreshes the cookies of the same session.
+	req = do("POST", "/api/user/login", csrf)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	assert.Len(suite.T(), req.Cookies(), 2)
+	req = do("POST", "/api/user/logout", csrf)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	for _, cookie := range req.Cookies() {
+		assert.Equal(suite.T(), -1, cookie.MaxAge, cookie.Name)
+	}
+	req = do("GET", "/api/user/sessions", "")
+	assert.Equal(suite.T(), 401, req.StatusCode)
+	suite.CleanClient()
+}
+
+// TestUserTwoFactorLogin replicates a scenario where:
+//
+// -> Admin without 2FA logs in, and gets the USER role in the token until 2FA is enabled.
+//
+// -> Admin enables 2FA, the password login returns a WAITING_LOGIN token that is exchanged at /login/2fa.
+//
+// -> Recovery code logs in once, it is rejected when it is used again.
+func (suite *UserTestSuite) TestUserTwoFactorLogin() {
+	suite.DeleteAndCreateUser()
+	to, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sql, args, err := suite.StmtBuilder.Update(UserTableName).Set(UserRoleDBField, roleAdmin).Where(squirrel.Eq{UserEmailDBField: TestEmail}).ToSql()
+	assert.Nil(suite.T(), err)
+	_, err = suite.DB.Exec(to, sql, args...)
+	assert.Nil(suite.T(), err)
+	do := func(method string, path string, token string, body string) *http.Response {
+		draftReq, err := http.NewRequest(method, suite.Server.URL+path, strings.NewReader(body))
+		assert.Nil(suite.T(), err)
+		if token != "" {
+			draftReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		}
+		draftReq.Header.Set("Content-Type", "application/json")
+		req, err := suite.Server.Client().Do(draftReq)
+		assert.Nil(suite.T(), err)
+		return req
+	}
+	tokenContents := func(token string) JWTFields {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		jwtContents, err := Server{Request: r}.GetJWTData()
+		assert.Nil(suite.T(), err)
+		return jwtContents
+	}
+	passwordLogin := fmt.Sprintf(`{"email":%q,"password":%q,"test":true}`, TestEmail, TestPassword)
+	req := do("POST", "/api/user/login", "", passwordLogin)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var login UserLoginResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&login))
+	assert.Equal(suite.T(), roleUser, tokenContents(login.SessionToken).Role)
+	assert.Equal(suite.T(), 403, do("GET", "/api/admin/users", login.SessionToken, "").StatusCode)
+	// an ADMIN token signed before 2FA is enabled is downgraded too when it is refreshed.
+	refreshToken, err := SignRefreshToken(tokenContents(login.SessionToken).UUID, roleAdmin, tokenContents(login.SessionToken).Version, tokenContents(login.SessionToken).SessionID)
+	assert.Nil(suite.T(), err)
+	req = do("POST", "/api/user/login", refreshToken, "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var refreshed UserLoginResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&refreshed))
+	assert.Equal(suite.T(), roleUser, tokenContents(refreshed.SessionToken).Role)
+	req = do("POST", "/api/user/2fa/enroll", login.SessionToken, "")
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var enroll UserTwoFactorEnrollResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&enroll))
+	key, err := totpEncoding.DecodeString(enroll.Secret)
+	assert.Nil(suite.T(), err)
+	req = do("POST", "/api/user/2fa/confirm", login.SessionToken, fmt.Sprintf(`{"code":%q}`, hotp(key, uint64(TOTPStep(time.Now())), totpDigits)))
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	var confirm UserTwoFactorConfirmResponse
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&confirm))
+	assert.NotEmpty(suite.T(), confirm.RecoveryCodes)
+	assert.Equal(suite.T(), roleAdmin, tokenContents(confirm.SessionToken).Role)
+	// the tokens issued before 2FA is enabled are revoked.
+	assert.Equal(suite.T(), 401, do("GET", "/api/user/sessions", login.SessionToken, "").StatusCode)
+	twoFactorLogin := func() string {
+		req := do("POST", "/api/user/login", "", passwordLogin)
+		assert.Equal(suite.T(), 202, req.StatusCode)
+		var resp UserLoginTwoFactorResponse
+		assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&resp))
+		assert.True(suite.T(), resp.TwoFactorRequired)
+		assert.Equal(suite.T(), tokenStatusWaitingLogin, tokenContents(resp.LoginToken).Status)
+		return resp.LoginToken
+	}
+	loginToken := twoFactorLogin()
+	// the login token is not a session token, CheckTokenRevoked asks for the second factor.
+	assert.Equal(suite.T(), 401, do("GET", "/api/user/sessions", loginToken, "").StatusCode)
+	assert.Equal(suite.T(), 401, do("POST", "/api/user/login", loginToken, "").StatusCode)
+	recoveryCode := fmt.Sprintf(`{"recoveryCode":%q}`, confirm.RecoveryCodes[0])
+	req = do("POST", "/api/user/login/2fa", loginToken, recoveryCode)
+	assert.Equal(suite.T(), 200, req.StatusCode)
+	assert.Nil(suite.T(), json.NewDecoder(req.Body).Decode(&login))
+	assert.Equal(suite.T(), roleAdmin, tokenContents(login.SessionToken).Role)
+	assert.Equal(suite.T(), tokenStatusActive, tokenContents(login.SessionToken).Status)
+	assert.Equal(suite.T(), 200, do("GET", "/api/admin/users", login.SessionToken, "").StatusCode)
+	assert.Equal(suite.T(), 401, do("POST", "/api/user/login/2fa", twoFactorLogin(), recoveryCode).StatusCode)
+	suite.CleanClient()
+}
+
 func TestUserCRUD(t *testing.T) {
 	var testSuite = new(UserTestSuite)
 	suite.Run(t, testSuite)
@@ -0,0 +1,343 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5/pgconn"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	usernameMaxLength = 24
+	// usernameSuggestionCount is the count of the suggestions returned for an unavailable username.
+	usernameSuggestionCount = 5
+	// usernameRandomSuggestions is the count of the candidates with a random number, they are ranked last.
+	usernameRandomSuggestions = 5
+)
+
+// reasons of an unavailable username.
+const (
+	usernameReasonInvalid  = "invalid"
+	usernameReasonReserved = "reserved"
+	usernameReasonTaken    = "taken"
+)
+
+// reservedUsernames can not be used by the users, they could be mistaken for the staff or the pages of the site.
+// Compared case-insensitively.
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "moderator": true, "moderators": true, "support": true, "staff": true,
+	"system": true, "official": true, "security": true, "persephone": true, "account": true, "accounts": true,
+	"settings": true, "signup": true, "login": true, "logout": true, "register": true, "username": true,
+	"anonymous": true, "deleted": true, "nobody": true, "everyone": true, "webmaster": true, "postmaster": true,
+	"hostmaster": true, "abuse": true, "billing": true, "privacy": true, "notifications": true, "feedback": true,
+}
+
+// IsReservedUsername reports whether the username is reserved, case-insensitively.
+func IsReservedUsername(username string) bool {
+	return reservedUsernames[strings.ToLower(username)]
+}
+
+// usernameAvailabilityLimit is the count of the availability checks an IP can do in usernameAvailabilityWindow, the
+// endpoint is public and would otherwise list the usernames.
+const (
+	usernameAvailabilityLimit  = 30
+	usernameAvailabilityWindow = time.Minute
+)
+
+// rateLimiterMaxKeys is the count of the keys a RateLimiter keeps. Once it is reached the ended windows are dropped,
+// at most once a window, and then an arbitrary key if it is still full.
+const rateLimiterMaxKeys = 65536
+
+// RateLimiter counts the requests of a key in fixed windows. It is kept in memory, every instance limits on its own.
+//
+// The limiters keyed by RateLimitKey only work behind a trusted reverse proxy that sets X-Forwarded-For or X-Real-IP,
+// middleware.RealIP takes the client IP from them and a client that reaches the server directly can send any IP.
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	maxKeys   int
+	nextSweep time.Time
+	windows   map[string]rateLimitWindow
+}
+
+type rateLimitWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, maxKeys: rateLimiterMaxKeys, windows: make(map[string]rateLimitWindow)}
+}
+
+// Allow counts a request of the key at now. Returns false and the time the next request is allowed at if the key is
+// over the limit.
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.windows[key]
+	if !ok && len(l.windows) >= l.maxKeys {
+		l.evict(now)
+	}
+	if !ok || now.Sub(w.start) >= l.window {
+		w = rateLimitWindow{start: now}
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window)
+	}
+	w.count++
+	l.windows[key] = w
+	return true, time.Time{}
+}
+
+// evict makes room for a new key, l.mu must be held.
+func (l *RateLimiter) evict(now time.Time) {
+	if !now.Before(l.nextSweep) {
+		for k, old := range l.windows {
+			if now.Sub(old.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.nextSweep = now.Add(l.window)
+	}
+	for k := range l.windows {
+		if len(l.windows) < l.maxKeys {
+			break
+		}
+		delete(l.windows, k)
+	}
+}
+
+// RateLimitKey returns the key the requests of the client are limited by, the client IP or the /64 prefix of an IPv6
+// client, which usually gets a whole /64. See RateLimiter about the trusted proxy.
+func RateLimitKey(r *http.Request) string {
+	ip := ClientIP(r)
+	if ip == nil {
+		return r.RemoteAddr
+	}
+	if ip.To4() == nil {
+		return fmt.Sprintf("%s/64", ip.Mask(net.CIDRMask(64, 128)))
+	}
+	return ip.String()
+}
+
+var usernameAvailabilityLimiter = NewRateLimiter(usernameAvailabilityLimit, usernameAvailabilityWindow)
+
+// UsernameAvailabilityResponse tells whether a username can be used at signup. Reason is one of invalid, reserved
+// and taken if it can not. Suggestions are available usernames like it, the best first.
+//
+// swagger:model UsernameAvailabilityResponse
+type UsernameAvailabilityResponse struct {
+	Username    string   `json:"username"`
+	Available   bool     `json:"available"`
+	Reason      string   `json:"reason,omitempty"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// usernameSuggestionBase keeps the letters and digits of the username, so the suggestions of an invalid username are
+// valid.
+func usernameSuggestionBase(username string) string {
+	var b strings.Builder
+	for _, char := range username {
+		if (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
+			b.WriteRune(char)
+		}
+	}
+	base := b.String()
+	if len(base) > usernameMaxLength {
+		base = base[:usernameMaxLength]
+	}
+	return base
+}
+
+// UsernameCandidates returns the candidate suggestions for the username in the order they are ranked: a digit, the
+// year, a prefix, then random numbers. The base is shortened to fit a suffix. Invalid, reserved and duplicate
+// candidates are left out, the taken ones are not.
+func UsernameCandidates(username string, year int, random *rand.Rand) []string {
+	base := usernameSuggestionBase(username)
+	if base == "" {
+		return nil
+	}
+	var candidates []string
+	seen := map[string]bool{strings.ToLower(username): true}
+	add := func(prefix string, suffix string) {
+		b := base
+		if extra := len(prefix) + len(b) + len(suffix) - usernameMaxLength; extra > 0 {
+			if extra >= len(b) {
+				return
+			}
+			b = b[:len(b)-extra]
+		}
+		candidate := prefix + b + suffix
+		if seen[strings.ToLower(candidate)] || !IsValidUsername(candidate) || IsReservedUsername(candidate) {
+			return
+		}
+		seen[strings.ToLower(candidate)] = true
+		candidates = append(candidates, candidate)
+	}
+	for i := 1; i <= 9; i++ {
+		add("", strconv.Itoa(i))
+	}
+	add("", strconv.Itoa(year))
+	add("", fmt.Sprintf("%02d", year%100))
+	add("the", "")
+	add("real", "")
+	for i := 0; i < usernameRandomSuggestions; i++ {
+		add("", strconv.Itoa(100+random.Intn(900)))
+	}
+	return candidates
+}
+
+// takenUsernames returns the lower case usernames of the candidates that are used, case-insensitively.
+func (s Server) takenUsernames(candidates []string) (map[string]bool, error) {
+	lower := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		lower[i] = strings.ToLower(candidate)
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(fmt.Sprintf("LOWER(%s)", UserUsernameDBField)).
+		From(UserTableName).
+		Where(squirrel.Expr(fmt.Sprintf("LOWER(%s) = ANY(?)", UserUsernameDBField), lower)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var username string
+		if err = rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		taken[username] = true
+	}
+	return taken, rows.Err()
+}
+
+// usernameLowerIndex is the unique index of the lowercase usernames, it rejects the concurrent signups and changes
+// that IsUsernameTaken let through.
+const usernameLowerIndex = "users_username_lower_unique"
+
+// pgUniqueViolation is the SQLSTATE of the unique constraint violations.
+const pgUniqueViolation = "23505"
+
+// IsUsernameConflict reports whether the error is the violation of the case-insensitive uniqueness of the usernames.
+func IsUsernameConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == usernameLowerIndex
+}
+
+// CheckUsernameChange returns the error and its HTTP status code if the user uid can not take the username. Reserved
+// usernames and the usernames of the other users in any case are rejected, same as at the signup.
+func (s Server) CheckUsernameChange(username string, uid string) (int, error) {
+	if IsReservedUsername(username) {
+		return http.StatusBadRequest, UsernameReservedError
+	}
+	taken, err := s.IsUsernameTaken(username, uid)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if taken {
+		return http.StatusBadRequest, UsernameAlreadyExistsError
+	}
+	return 0, nil
+}
+
+// setUsernameChange adds the columns written for a new username to changes.
+func setUsernameChange(changes map[string]interface{}, username string, now time.Time) {
+	changes[UserUsernameDBField] = username
+	changes[UserUsernameLastUpdatedAtDBField] = now
+	// the new username is unique, so it is back in the unique index.
+	changes[UserUsernameCaseDuplicateDBField] = false
+}
+
+// IsUsernameTaken reports whether another user than uid has the username, case-insensitively. Deleted users keep
+// their usernames until they are anonymized.
+func (s Server) IsUsernameTaken(username string, uid string) (bool, error) {
+	query := s.StmtBuilder.Select(UserIDDBField).
+		From(UserTableName).
+		Where(squirrel.Expr(fmt.Sprintf("LOWER(%s) = LOWER(?)", UserUsernameDBField), username))
+	if uid != "" {
+		query = query.Where(squirrel.NotEq{UserIDDBField: uid})
+	}
+	rows, err := s.QuerySQL(query)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// UsernameSuggestions returns at most usernameSuggestionCount available usernames like the username.
+func (s Server) UsernameSuggestions(username string) ([]string, error) {
+	candidates := UsernameCandidates(username, time.Now().Year(), rand.New(rand.NewSource(time.Now().UnixNano())))
+	suggestions := make([]string, 0, usernameSuggestionCount)
+	if len(candidates) == 0 {
+		return suggestions, nil
+	}
+	taken, err := s.takenUsernames(candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range candidates {
+		if len(suggestions) == usernameSuggestionCount {
+			break
+		}
+		if !taken[strings.ToLower(candidate)] {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions, nil
+}
+
+// UsernameAvailabilityHandler tells whether a username can be used at signup.
+//
+//	@Summary		Check username availability
+//	@Description	Checks the username with the signup rules before the form is submitted: letters and digits only, 5 to 24 characters, not reserved, and not used by another user in any case. Returns up to 5 available suggestions if it can not be used. Limited to 30 checks a minute per IP, per /64 for IPv6.
+//	@Tags			User
+//	@Produce		json
+//	@Param			u	query		string							true	"Username"
+//	@Success		200	{object}	UsernameAvailabilityResponse	"Availability of the username"
+//	@Failure		400	{object}	ErrorResponse					"Username is missing"
+//	@Failure		429	{object}	ErrorResponse					"Too many checks"
+//	@Failure		500	{object}	ErrorResponse					"Internal server error"
+//	@Router			/api/user/username-available [get]
+func UsernameAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	if ok, retryAt := usernameAvailabilityLimiter.Allow(RateLimitKey(r), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(retryAt).Seconds())+1))
+		s.LogError(UsernameCheckRateLimitedError(retryAt), http.StatusTooManyRequests)
+		return
+	}
+	username := strings.TrimSpace(r.URL.Query().Get("u"))
+	if username == "" {
+		s.LogError(MissingUsernameError, http.StatusBadRequest)
+		return
+	}
+	response := UsernameAvailabilityResponse{Username: username, Available: true, Suggestions: []string{}}
+	if !IsValidUsername(username) {
+		response.Reason = usernameReasonInvalid
+	} else if IsReservedUsername(username) {
+		response.Reason = usernameReasonReserved
+	} else if taken, err := s.IsUsernameTaken(username, ""); err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	} else if taken {
+		response.Reason = usernameReasonTaken
+	}
+	if response.Reason != "" {
+		response.Available = false
+		suggestions, err := s.UsernameSuggestions(username)
+		if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+		response.Suggestions = suggestions
+	}
+	s.WriteResponse(response, http.StatusOK)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsReservedUsername(t *testing.T) {
+	assert.True(t, IsReservedUsername("admin"))
+	assert.True(t, IsReservedUsername("Moderator"))
+	assert.False(t, IsReservedUsername("caner"))
+}
+
+func TestUsernameCandidates(t *testing.T) {
+	candidates := UsernameCandidates("caner", 2026, rand.New(rand.NewSource(1)))
+	assert.Equal(t, []string{"caner1", "caner2", "caner3"}, candidates[:3])
+	assert.Contains(t, candidates, "caner2026")
+	assert.Contains(t, candidates, "thecaner")
+	assert.NotContains(t, candidates, "caner")
+	for _, candidate := range candidates {
+		assert.True(t, IsValidUsername(candidate), candidate)
+	}
+
+	// the base is shortened so the suffix fits in 24 characters
+	candidates = UsernameCandidates("canercezarapyapardostlar", 2026, rand.New(rand.NewSource(1)))
+	assert.Equal(t, "canercezarapyapardostla1", candidates[0])
+	for _, candidate := range candidates {
+		assert.True(t, IsValidUsername(candidate), candidate)
+	}
+
+	// invalid characters are dropped, a short base needs a longer suffix
+	candidates = UsernameCandidates("b.o_b", 2026, rand.New(rand.NewSource(1)))
+	assert.Equal(t, "bob2026", candidates[0])
+	assert.Empty(t, UsernameCandidates("ğ_ü", 2026, rand.New(rand.NewSource(1))))
+
+	// reserved candidates are left out
+	for _, candidate := range UsernameCandidates("admin", 2026, rand.New(rand.NewSource(1))) {
+		assert.False(t, IsReservedUsername(candidate), candidate)
+		assert.True(t, strings.HasPrefix(strings.ToLower(candidate), "admin") || strings.HasSuffix(candidate, "admin"))
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(2, time.Minute)
+	now := time.Now()
+	ok, _ := limiter.Allow("203.0.113.7", now)
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("203.0.113.7", now.Add(time.Second))
+	assert.True(t, ok)
+	ok, retryAt := limiter.Allow("203.0.113.7", now.Add(2*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, now.Add(time.Minute), retryAt)
+	ok, _ = limiter.Allow("203.0.113.8", now.Add(2*time.Second))
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("203.0.113.7", now.Add(time.Minute))
+	assert.True(t, ok)
+}
+
+func TestRateLimiterMaxKeys(t *testing.T) {
+	limiter := NewRateLimiter(1, time.Minute)
+	limiter.maxKeys = 2
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		ok, _ := limiter.Allow(key, now)
+		assert.True(t, ok, key)
+		assert.LessOrEqual(t, len(limiter.windows), 2)
+	}
+	// the ended windows are dropped before the running ones.
+	limiter = NewRateLimiter(1, time.Minute)
+	limiter.maxKeys = 2
+	limiter.Allow("ended", now)
+	limiter.Allow("running", now.Add(time.Minute))
+	limiter.Allow("new", now.Add(time.Minute))
+	assert.Contains(t, limiter.windows, "running")
+	assert.NotContains(t, limiter.windows, "ended")
+}
+
+func TestRateLimitKey(t *testing.T) {
+	key := func(remoteAddr string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		return RateLimitKey(r)
+	}
+	assert.Equal(t, "203.0.113.7", key("203.0.113.7:1234"))
+	assert.Equal(t, "2001:db8:1:2::/64", key("[2001:db8:1:2:aaaa::1]:1234"))
+	assert.Equal(t, key("[2001:db8:1:2::1]:1234"), key("[2001:db8:1:2:ffff::2]:1234"))
+	assert.NotEqual(t, key("[2001:db8:1:2::1]:1234"), key("[2001:db8:1:3::1]:1234"))
+}
+
+func TestIsUsernameConflict(t *testing.T) {
+	assert.True(t, IsUsernameConflict(fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: usernameLowerIndex})))
+	assert.False(t, IsUsernameConflict(&pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "users_email_key"}))
+	assert.False(t, IsUsernameConflict(errors.New("connection refused")))
+	assert.False(t, IsUsernameConflict(nil))
+}
@@ -0,0 +1,368 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MergePatchContentType is the media type of RFC 7396 JSON Merge Patch documents.
+	MergePatchContentType    = "application/merge-patch+json"
+	userDisplayNameMaxLength = 50
+	userBioMaxLength         = 280
+)
+
+// PatchField is a field of a JSON Merge Patch document. Set is false if the member is absent, so it is left as it is,
+// and Null is true if the member is null, so it is removed.
+type PatchField[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (f *PatchField[T]) UnmarshalJSON(data []byte) error {
+	f.Set = true
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		f.Null = true
+		return nil
+	}
+	return json.Unmarshal(data, &f.Value)
+}
+
+// UserPatchLocation is the location member of UserPatchRequest, merged into the current location of the user.
+type UserPatchLocation struct {
+	City    PatchField[uint32] `json:"cityId" swaggertype:"integer"`
+	State   PatchField[uint16] `json:"stateId" swaggertype:"integer"`
+	Country PatchField[uint8]  `json:"countryId" swaggertype:"integer"`
+}
+
+// UserPatchRequest is a JSON Merge Patch of the user. Absent members are not changed, null removes displayName and
+// bio. Every other member can not be null.
+//
+// swagger:model UserPatchRequest
+type UserPatchRequest struct {
+	Email       PatchField[string]            `json:"email" swaggertype:"string"`
+	Username    PatchField[string]            `json:"username" swaggertype:"string"`
+	PhoneNumber PatchField[string]            `json:"phoneNumber" swaggertype:"string"`
+	DisplayName PatchField[string]            `json:"displayName" swaggertype:"string"`
+	Bio         PatchField[string]            `json:"bio" swaggertype:"string"`
+	Location    PatchField[UserPatchLocation] `json:"location" swaggertype:"object"`
+}
+
+// ParseUserPatch decodes a merge patch of the user, unknown members are rejected.
+func ParseUserPatch(r *http.Request) (UserPatchRequest, error) {
+	var patch UserPatchRequest
+	err := decodeMergePatch(r, &patch)
+	return patch, err
+}
+
+// decodeMergePatch decodes the merge patch in the body into patch, unknown members are rejected.
+func decodeMergePatch(r *http.Request, patch interface{}) error {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != MergePatchContentType && mediaType != "application/json") {
+			return UnsupportedMergePatchMediaTypeError
+		}
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(patch)
+}
+
+// ValidateDisplayText validates the display name and the bio, control characters other than new lines in the bio are
+// not allowed.
+func ValidateDisplayText(text string, maxLength int, multiline bool) bool {
+	if !utf8.ValidString(text) || utf8.RuneCountInString(text) > maxLength {
+		return false
+	}
+	for _, char := range text {
+		if char == '\n' && multiline {
+			continue
+		}
+		if unicode.IsControl(char) {
+			return false
+		}
+	}
+	return true
+}
+
+// userPatchCurrent is the current state of the fields a merge patch can change.
+type userPatchCurrent struct {
+	Email                 string
+	Username              string
+	PhoneNumber           string
+	DisplayName           *string
+	Bio                   *string
+	City                  uint32
+	State                 uint16
+	Country               uint8
+	EmailLastUpdatedAt    time.Time
+	UsernameLastUpdatedAt time.Time
+}
+
+// ValidateLocation returns InvalidLocationError unless the city is in the state and the state is in the country.
+func (s Server) ValidateLocation(city uint32, state uint16, country uint8) error {
+	rows, err := s.QuerySQL(s.StmtBuilder.
+		Select(
+			fmt.Sprintf("%s.%s", CityTable, CityStateIDDBField),
+			fmt.Sprintf("%s.%s", CityTable, CityCountryIDDBField),
+			fmt.Sprintf("%s.%s", StateTable, StateCountryIDDBField)).
+		From(CityTable).
+		Join(fmt.Sprintf("%s on %s.%s = %s.%s", StateTable, StateTable, StateIDDBField, CityTable, CityStateIDDBField)).
+		Where(squirrel.Eq{fmt.Sprintf("%s.%s", CityTable, CityIDDBField): city}))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return InvalidLocationError
+	}
+	var cityState, cityCountry, stateCountry int64
+	if err = rows.Scan(&cityState, &cityCountry, &stateCountry); err != nil {
+		return err
+	}
+	if cityState != int64(state) || cityCountry != int64(country) || stateCountry != int64(country) {
+		return InvalidLocationError
+	}
+	return nil
+}
+
+// IsUserFieldTaken reports whether another user than uid has the value in the unique column field.
+func (s Server) IsUserFieldTaken(field string, value string, uid string) (bool, error) {
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(UserIDDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{field: value}).
+		Where(squirrel.NotEq{UserIDDBField: uid}))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+// userPatchChanges returns the columns to write for the patch, only the fields whose value changes are included. The
+// returned status is the HTTP status code of the error.
+func (s Server) userPatchChanges(uid string, patch UserPatchRequest, current userPatchCurrent) (map[string]interface{}, int, error) {
+	changes := make(map[string]interface{})
+	now := time.Now()
+	for name, field := range map[string]PatchField[string]{"email": patch.Email, "username": patch.Username, "phoneNumber": patch.PhoneNumber} {
+		if field.Null {
+			return nil, http.StatusBadRequest, FieldNotNullableError(name)
+		}
+	}
+	if patch.Email.Set && patch.Email.Value != current.Email {
+		if err := s.Validator.Var(patch.Email.Value, "emailSpec"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if now.Sub(current.EmailLastUpdatedAt) < AllowedUserEmailUpdateInterval {
+			return nil, http.StatusBadRequest, UpdatedRecentlyError("email", current.EmailLastUpdatedAt, AllowedUserEmailUpdateInterval)
+		}
+		if taken, err := s.IsUserFieldTaken(UserEmailDBField, patch.Email.Value, uid); err != nil {
+			return nil, http.StatusInternalServerError, err
+		} else if taken {
+			return nil, http.StatusBadRequest, EmailAlreadyExistsError
+		}
+		// the email is not in the changes, it is held as pending by UserPatchHandler until it is confirmed.
+	}
+	if patch.Username.Set && patch.Username.Value != current.Username {
+		if err := s.Validator.Var(patch.Username.Value, "usernameSpec"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if now.Sub(current.UsernameLastUpdatedAt) < AllowedUsernameUpdateInterval {
+			return nil, http.StatusBadRequest, UpdatedRecentlyError("username", current.UsernameLastUpdatedAt, AllowedUsernameUpdateInterval)
+		}
+		if status, err := s.CheckUsernameChange(patch.Username.Value, uid); err != nil {
+			return nil, status, err
+		}
+		setUsernameChange(changes, patch.Username.Value, now)
+	}
+	if patch.PhoneNumber.Set && patch.PhoneNumber.Value != current.PhoneNumber {
+		if err := s.Validator.Var(patch.PhoneNumber.Value, "e164"); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if taken, err := s.IsPhoneNumberTaken(patch.PhoneNumber.Value, uid); err != nil {
+			return nil, http.StatusInternalServerError, err
+		} else if taken {
+			return nil, http.StatusBadRequest, PhoneNumberAlreadyExistsError
+		}
+		// the new number is verified again with a code sent to it.
+		changes[UserPhoneNumberDBField] = patch.PhoneNumber.Value
+		changes[UserPhoneVerifiedDBField] = false
+		changes[UserPhoneVerifiedAtDBField] = nil
+	}
+	texts := []struct {
+		name      string
+		column    string
+		field     PatchField[string]
+		current   *string
+		maxLength int
+		multiline bool
+	}{
+		{"displayName", UserDisplayNameDBField, patch.DisplayName, current.DisplayName, userDisplayNameMaxLength, false},
+		{"bio", UserBioDBField, patch.Bio, current.Bio, userBioMaxLength, true},
+	}
+	for _, text := range texts {
+		if !text.field.Set {
+			continue
+		}
+		value := strings.TrimSpace(text.field.Value)
+		// an empty text is removed, same as null.
+		if text.field.Null || value == "" {
+			if text.current != nil {
+				changes[text.column] = nil
+			}
+			continue
+		}
+		if !ValidateDisplayText(value, text.maxLength, text.multiline) {
+			return nil, http.StatusBadRequest, InvalidDisplayTextError(text.name, text.maxLength)
+		}
+		if text.current == nil || *text.current != value {
+			changes[text.column] = value
+		}
+	}
+	if patch.Location.Set {
+		location := patch.Location.Value
+		if patch.Location.Null || location.City.Null || location.State.Null || location.Country.Null {
+			return nil, http.StatusBadRequest, FieldNotNullableError("location")
+		}
+		city, state, country := current.City, current.State, current.Country
+		if location.City.Set {
+			city = location.City.Value
+		}
+		if location.State.Set {
+			state = location.State.Value
+		}
+		if location.Country.Set {
+			country = location.Country.Value
+		}
+		if city != current.City || state != current.State || country != current.Country {
+			if err := s.ValidateLocation(city, state, country); err == InvalidLocationError {
+				return nil, http.StatusBadRequest, err
+			} else if err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+			if city != current.City {
+				changes[UserCityDBField] = city
+			}
+			if state != current.State {
+				changes[UserStateDBField] = state
+			}
+			if country != current.Country {
+				changes[UserCountryDBField] = country
+			}
+		}
+	}
+	return changes, http.StatusOK, nil
+}
+
+// changesAccountIdentifiers reports whether the patch changes the email, the username or the phone number of the user.
+// They identify the account at the login and the recovery, so impersonation tokens can not change them.
+func changesAccountIdentifiers(patch UserPatchRequest, current userPatchCurrent, changes map[string]interface{}) bool {
+	if patch.Email.Set && patch.Email.Value != current.Email {
+		return true
+	}
+	_, username := changes[UserUsernameDBField]
+	_, phoneNumber := changes[UserPhoneNumberDBField]
+	return username || phoneNumber
+}
+
+// UserPatchHandler updates the user with a JSON Merge Patch.
+//
+//	@Summary					Patch User
+//	@Description				Updates the email, username, phone number, display name, bio and location of the user with a JSON Merge Patch (RFC 7396). Absent members are not changed, only the changed columns are written. Email and username have cooldowns, a new email is held as pending until the link sent to it is confirmed, changing the phone number requires verifying it again.
+//	@Tags						User
+//	@Accept						application/merge-patch+json
+//	@Produce					json
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						Authorization
+//	@description				Bearer {JWT} | Whitelist: ACTIVE tokens.
+//	@Param						Authorization	header		string				true	"JWT token"
+//	@Param						body			body		UserPatchRequest	true	"Merge patch of the user"
+//	@Success					200				{object}	GetUserDataResponse	"Updated user data"
+//	@Failure					400				{object}	ErrorResponse		"Invalid value, inconsistent location, taken email, username or phone number, or cooldown"
+//	@Failure					403				{object}	ErrorResponse		"Email, username or phone number change with an impersonation token"
+//	@Failure					415				{object}	ErrorResponse		"Content type is not a merge patch"
+//	@Failure					429				{object}	ErrorResponse		"An email change is just requested"
+//	@Failure					500				{object}	ErrorResponse		"Internal server error"
+//	@Router						/api/user [patch]
+func UserPatchHandler(w http.ResponseWriter, r *http.Request) {
+	s := r.Context().Value(ServerKeyString).(*Server)
+	jwtContents, err := s.GetJWTData()
+	if err != nil {
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	patch, err := ParseUserPatch(r)
+	if err != nil {
+		if err == UnsupportedMergePatchMediaTypeError {
+			s.LogError(err, http.StatusUnsupportedMediaType)
+			return
+		}
+		s.LogError(err, http.StatusBadRequest)
+		return
+	}
+	rows, err := s.QuerySQL(s.StmtBuilder.Select(
+		UserEmailDBField,
+		UserUsernameDBField,
+		UserPhoneNumberDBField,
+		UserDisplayNameDBField,
+		UserBioDBField,
+		UserCityDBField,
+		UserStateDBField,
+		UserCountryDBField,
+		UserEmailLastUpdatedAtDBField,
+		UserUsernameLastUpdatedAtDBField).
+		From(UserTableName).
+		Where(squirrel.Eq{UserIDDBField: jwtContents.UUID}))
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	var current userPatchCurrent
+	if rows.Next() {
+		err = rows.Scan(&current.Email, &current.Username, &current.PhoneNumber, &current.DisplayName, &current.Bio,
+			&current.City, &current.State, &current.Country, &current.EmailLastUpdatedAt, &current.UsernameLastUpdatedAt)
+	} else {
+		err = UserDoesNotExistError
+	}
+	rows.Close()
+	if err != nil {
+		s.LogError(err, http.StatusInternalServerError)
+		return
+	}
+	changes, status, err := s.userPatchChanges(jwtContents.UUID, patch, current)
+	if err != nil {
+		s.LogError(err, status)
+		return
+	}
+	if jwtContents.Impersonated() && changesAccountIdentifiers(patch, current, changes) {
+		s.LogError(ImpersonationNotAllowedError, http.StatusForbidden)
+		return
+	}
+	if patch.Email.Set && patch.Email.Value != current.Email {
+		if status, err = s.RequestEmailChange(jwtContents.UUID, current.Email, patch.Email.Value); err != nil {
+			s.LogError(err, status)
+			return
+		}
+	}
+	if len(changes) > 0 {
+		changes[UserUpdatedAtDBField] = time.Now()
+		if _, err = s.ExecuteSQL(s.StmtBuilder.Update(UserTableName).SetMap(changes).Where(squirrel.Eq{UserIDDBField: jwtContents.UUID})); IsUsernameConflict(err) {
+			s.LogError(UsernameAlreadyExistsError, http.StatusBadRequest)
+			return
+		} else if err != nil {
+			s.LogError(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	GetUser(r)
+}
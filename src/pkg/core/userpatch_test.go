@@ -0,0 +1,57 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseUserPatch(t *testing.T) {
+	parse := func(contentType string, body string) (UserPatchRequest, error) {
+		r, err := http.NewRequest("PATCH", "/api/user", strings.NewReader(body))
+		assert.Nil(t, err)
+		r.Header.Set("Content-Type", contentType)
+		return ParseUserPatch(r)
+	}
+	patch, err := parse(MergePatchContentType, `{"bio": null, "displayName": "Zort", "location": {"cityId": 32}}`)
+	assert.Nil(t, err)
+	assert.False(t, patch.Email.Set)
+	assert.True(t, patch.Bio.Set)
+	assert.True(t, patch.Bio.Null)
+	assert.Equal(t, PatchField[string]{Set: true, Value: "Zort"}, patch.DisplayName)
+	assert.True(t, patch.Location.Value.City.Set)
+	assert.Equal(t, uint32(32), patch.Location.Value.City.Value)
+	assert.False(t, patch.Location.Value.State.Set)
+	_, err = parse("application/json; charset=utf-8", `{"phoneNumber": "+905555555555"}`)
+	assert.Nil(t, err)
+	_, err = parse("text/plain", `{}`)
+	assert.Equal(t, UnsupportedMergePatchMediaTypeError, err)
+	_, err = parse(MergePatchContentType, `{"role": "ADMIN"}`)
+	assert.NotNil(t, err)
+	_, err = parse(MergePatchContentType, `{"location": {"cityId": -1}}`)
+	assert.NotNil(t, err)
+}
+
+func TestValidateDisplayText(t *testing.T) {
+	assert.True(t, ValidateDisplayText("Zört Zattiri", userDisplayNameMaxLength, false))
+	assert.True(t, ValidateDisplayText(strings.Repeat("ş", userDisplayNameMaxLength), userDisplayNameMaxLength, false))
+	assert.False(t, ValidateDisplayText(strings.Repeat("ş", userDisplayNameMaxLength+1), userDisplayNameMaxLength, false))
+	assert.False(t, ValidateDisplayText("zort\nzattiri", userDisplayNameMaxLength, false))
+	assert.True(t, ValidateDisplayText("zort\nzattiri", userBioMaxLength, true))
+	assert.False(t, ValidateDisplayText("zort\x00", userBioMaxLength, true))
+	assert.False(t, ValidateDisplayText("\xff", userBioMaxLength, true))
+}
+
+func TestChangesAccountIdentifiers(t *testing.T) {
+	current := userPatchCurrent{Email: "user@example.com", Username: "user", PhoneNumber: TestPhone}
+	var patch UserPatchRequest
+	assert.False(t, changesAccountIdentifiers(patch, current, map[string]interface{}{UserBioDBField: "bio"}))
+	patch.Email = PatchField[string]{Set: true, Value: current.Email}
+	assert.False(t, changesAccountIdentifiers(patch, current, map[string]interface{}{}))
+	patch.Email.Value = "other@example.com"
+	assert.True(t, changesAccountIdentifiers(patch, current, map[string]interface{}{}))
+	patch.Email = PatchField[string]{}
+	assert.True(t, changesAccountIdentifiers(patch, current, map[string]interface{}{UserUsernameDBField: "other"}))
+	assert.True(t, changesAccountIdentifiers(patch, current, map[string]interface{}{UserPhoneNumberDBField: "+905555555554"}))
+}
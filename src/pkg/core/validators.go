@@ -40,7 +40,11 @@ func NewValidator() (*validator.Validate, error) {
 //
 // Why 5? because I want caner to be a valid username. you expected a technical explanation? :)
 func ValidateUsername(fl validator.FieldLevel) bool {
-	username := fl.Field().String()
+	return IsValidUsername(fl.Field().String())
+}
+
+// IsValidUsername is ValidateUsername for a plain string.
+func IsValidUsername(username string) bool {
 	if len(username) < 5 || len(username) > 24 {
 		return false
 	}
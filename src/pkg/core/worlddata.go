@@ -13,7 +13,7 @@ func NewCityHandler() http.Handler {
 	var getCitiesTracer = AssignTracer("/getCities", "WORLD_DATA", "GET_CITIES")
 	var getStatesTracer = AssignTracer("/getStates", "WORLD_DATA", "GET_STATES")
 	var getCountriesTracer = AssignTracer("/getCounties", "WORLD_DATA", "GET_COUNTRIES")
-	r.With(JWTWhitelist([]string{tokenStatusActive}, nil)).Route("/", func(r chi.Router) {
+	r.With(ScopeWhitelist(scopeWorldRead, []string{tokenStatusActive}, nil)).Route("/", func(r chi.Router) {
 		r.With(getCitiesTracer).Post("/getCities", GetCitiesHandler)
 		r.With(getStatesTracer).Post("/getStates", GetStatesHandler)
 		r.With(getCountriesTracer).Post("/getCountries", GetCountriesHandler)